@@ -229,6 +229,72 @@ type SpecificDeploymentExporter interface {
 	ExportDeploymentForVersion(ctx context.Context, stack Stack, version string) (*apitype.UntypedDeployment, error)
 }
 
+// DeploymentRollbacker is an interface defining an additional capability of a Backend, specifically the ability to
+// restore a stack's deployment to a previous version from its history. This isn't a requirement for all backends and
+// should be checked for dynamically.
+type DeploymentRollbacker interface {
+	// RollbackDeployment replaces the stack's current deployment with the one recorded for the given version of its
+	// history, and records the rollback as a new entry in the stack's history. Versions have the same meaning as for
+	// SpecificDeploymentExporter.
+	RollbackDeployment(ctx context.Context, stack Stack, version string) error
+}
+
+// HistoryRetention is a policy for how much of a stack's update history to keep. An update is kept if any of the
+// limits that are set keeps it; the zero value keeps the whole history.
+type HistoryRetention struct {
+	// KeepUpdates is the number of most recent updates to keep, if non-zero.
+	KeepUpdates int `json:"keepUpdates,omitempty" yaml:"keepUpdates,omitempty"`
+	// KeepFor is how long to keep updates for after they finish, if non-zero.
+	KeepFor time.Duration `json:"keepFor,omitempty" yaml:"keepFor,omitempty"`
+}
+
+// IsZero returns true if the policy keeps the whole history.
+func (r HistoryRetention) IsZero() bool {
+	return r.KeepUpdates <= 0 && r.KeepFor <= 0
+}
+
+// Keeps returns true if the policy keeps an update that finished at the given time, and has the given number of
+// updates after it.
+func (r HistoryRetention) Keeps(now, end time.Time, newer int) bool {
+	if r.IsZero() {
+		return true
+	}
+	return (r.KeepUpdates > 0 && newer < r.KeepUpdates) || (r.KeepFor > 0 && now.Sub(end) < r.KeepFor)
+}
+
+// HistoryPruner is an interface defining an additional capability of a Backend, specifically the ability to remove
+// old updates from stacks' history according to a retention policy. This isn't a requirement for all backends and
+// should be checked for dynamically.
+type HistoryPruner interface {
+	// HistoryRetention returns the retention policy the backend enforces after each update.
+	HistoryRetention(ctx context.Context) (HistoryRetention, error)
+	// SetHistoryRetention sets the retention policy the backend enforces after each update.
+	SetHistoryRetention(ctx context.Context, policy HistoryRetention) error
+	// PruneHistory removes the updates that the given policy doesn't keep from the stack's history, returning them
+	// most recent first. If dryRun is true, the updates are returned but not removed. The update whose checkpoint
+	// matches the stack's current state is never removed, and neither are any updates after it.
+	PruneHistory(ctx context.Context, stack Stack, policy HistoryRetention, dryRun bool) ([]UpdateInfo, error)
+}
+
+// HistoryImporter is an interface defining an additional capability of a Backend, specifically the ability to add
+// updates made elsewhere to a stack's history, e.g. when the stack is migrated from another backend. This isn't a
+// requirement for all backends and should be checked for dynamically.
+type HistoryImporter interface {
+	// ImportUpdate adds the given update to the stack's history, along with the deployment recorded by it. The
+	// stack's current deployment isn't changed. The update keeps its version if the stack's history is empty, and
+	// is numbered after the latest update otherwise.
+	ImportUpdate(ctx context.Context, stack Stack, update UpdateInfo, deployment *apitype.UntypedDeployment) error
+}
+
+// UpdateEventsExporter is an interface defining an additional capability of a Backend, specifically the ability to
+// export the engine events that were emitted by an update in a stack's history, so that the update can be displayed
+// again. This isn't a requirement for all backends and should be checked for dynamically.
+type UpdateEventsExporter interface {
+	// ExportUpdateEvents returns the engine events recorded for the given version of the stack's history, in the
+	// order they were emitted. Versions have the same meaning as for SpecificDeploymentExporter.
+	ExportUpdateEvents(ctx context.Context, stack Stack, version string) ([]apitype.EngineEvent, error)
+}
+
 // UpdateOperation is a complete stack update operation (preview, update, import, refresh, or destroy).
 type UpdateOperation struct {
 	Proj               *workspace.Project
@@ -0,0 +1,85 @@
+// Copyright 2016-2023, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"time"
+
+	"github.com/pulumi/pulumi/pkg/v3/backend/display"
+	"github.com/pulumi/pulumi/pkg/v3/engine"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/apitype"
+)
+
+const (
+	// maxEventsToRecord is the maximum number of events to batch up before writing them.
+	maxEventsToRecord = 50
+	// maxRecordDelay is the maximum wait time before writing all batched events.
+	maxRecordDelay = 4 * time.Second
+)
+
+// RecordEngineEvents reads the engine events of an update until the channel is closed, and passes them to write in
+// batches as they're received, for backends that save them in a stack's history. Like the service does, events are
+// stamped when they're received and written in batches rather than held until the update completes, and secret
+// values are blinded. Batches are numbered from zero. If converting or writing the events fails, the rest of the
+// events are dropped, and the error is returned once the channel is closed.
+func RecordEngineEvents(
+	events <-chan engine.Event,
+	write func(batch int, events []apitype.EngineEvent) error,
+) error {
+	var err error
+	var eventBatch []apitype.EngineEvent
+	eventIdx, batchIdx := 0, 0
+	writeBatch := func() {
+		if len(eventBatch) == 0 || err != nil {
+			return
+		}
+		err = write(batchIdx, eventBatch)
+		batchIdx++
+		eventBatch = nil
+	}
+
+	maxDelayTicker := time.NewTicker(maxRecordDelay)
+	defer maxDelayTicker.Stop()
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				// Write any lingering events.
+				writeBatch()
+				return err
+			}
+			if err != nil {
+				break
+			}
+
+			var apiEvent apitype.EngineEvent
+			if apiEvent, err = display.ConvertEngineEvent(e, false /* showSecrets */); err != nil {
+				break
+			}
+			apiEvent.Sequence = eventIdx
+			apiEvent.Timestamp = int(time.Now().Unix())
+			eventIdx++
+
+			eventBatch = append(eventBatch, apiEvent)
+			if len(eventBatch) >= maxEventsToRecord {
+				writeBatch()
+			}
+		case <-maxDelayTicker.C:
+			// If the ticker has fired, write any batched events. This sets an upper bound for the delay between the
+			// event being received and saved.
+			writeBatch()
+		}
+	}
+}
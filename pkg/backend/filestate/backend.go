@@ -24,6 +24,7 @@ import (
 	"path"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -36,6 +37,7 @@ import (
 	_ "gocloud.dev/blob/azureblob" // driver for azblob://
 	_ "gocloud.dev/blob/fileblob"  // driver for file://
 	"gocloud.dev/blob/gcsblob"     // driver for gs://
+	"gocloud.dev/blob/memblob"     // driver for mem://
 	_ "gocloud.dev/blob/s3blob"    // driver for s3://
 	"gocloud.dev/gcerrors"
 
@@ -48,6 +50,7 @@ import (
 	"github.com/pulumi/pulumi/pkg/v3/resource/edit"
 	"github.com/pulumi/pulumi/pkg/v3/resource/stack"
 	"github.com/pulumi/pulumi/pkg/v3/secrets"
+	"github.com/pulumi/pulumi/pkg/v3/util/cancel"
 	"github.com/pulumi/pulumi/pkg/v3/util/validation"
 	"github.com/pulumi/pulumi/sdk/v3/go/common/apitype"
 	"github.com/pulumi/pulumi/sdk/v3/go/common/diag"
@@ -88,6 +91,33 @@ var (
 	//
 	// This opt-out is intended to be removed in a future release.
 	PulumiFilestateLegacyLayoutEnvVar = env.SelfManagedStateLegacyLayout.Var().Name()
+
+	// PulumiFilestateLockLeaseEnvVar is the name of an environment variable
+	// that sets the number of seconds a stack lock remains valid
+	// without being renewed by the process holding it.
+	PulumiFilestateLockLeaseEnvVar = env.SelfManagedStateLockLease.Var().Name()
+
+	// PulumiFilestateJournalEnvVar is an env var that must be truthy
+	// to persist updates incrementally to a journal
+	// rather than rewriting the checkpoint after every step.
+	PulumiFilestateJournalEnvVar = env.SelfManagedStateJournal.Var().Name()
+
+	// PulumiFilestateRESTUsernameEnvVar and PulumiFilestateRESTPasswordEnvVar
+	// are the names of environment variables that hold the credentials
+	// used for basic authentication with the server of a REST backend.
+	PulumiFilestateRESTUsernameEnvVar = env.SelfManagedStateRESTUsername.Var().Name()
+	PulumiFilestateRESTPasswordEnvVar = env.SelfManagedStateRESTPassword.Var().Name()
+
+	// PulumiFilestateRESTTokenEnvVar is the name of an environment variable
+	// that holds a bearer token used to authenticate with the server of a REST backend.
+	PulumiFilestateRESTTokenEnvVar = env.SelfManagedStateRESTToken.Var().Name()
+
+	// PulumiFilestateRESTUpdateMethodEnvVar, PulumiFilestateRESTLockMethodEnvVar
+	// and PulumiFilestateRESTUnlockMethodEnvVar are the names of environment variables
+	// that override the HTTP methods a REST backend uses to write objects and to lock and unlock stacks.
+	PulumiFilestateRESTUpdateMethodEnvVar = env.SelfManagedStateRESTUpdateMethod.Var().Name()
+	PulumiFilestateRESTLockMethodEnvVar   = env.SelfManagedStateRESTLockMethod.Var().Name()
+	PulumiFilestateRESTUnlockMethodEnvVar = env.SelfManagedStateRESTUnlockMethod.Var().Name()
 )
 
 // Backend extends the base backend interface with specific information about local backends.
@@ -97,8 +127,35 @@ type Backend interface {
 
 	// Upgrade to the latest state store version.
 	Upgrade(ctx context.Context) error
+
+	// SetCompression sets whether the backend writes gzip compressed checkpoints,
+	// and converts the checkpoints, history and backups of existing stacks to match.
+	SetCompression(ctx context.Context, gzip bool) error
+
+	// SetEncryption sets whether the backend encrypts checkpoints, history and backups
+	// with the secrets managers of their stacks,
+	// and converts the files of existing stacks to match.
+	SetEncryption(ctx context.Context, encrypt bool) error
+
+	// ReencryptStack encrypts the history and backups of a stack
+	// with the secrets manager of its current checkpoint,
+	// if the backend encrypts its state.
+	ReencryptStack(ctx context.Context, stackRef backend.StackReference) error
+
+	// StackLocks returns the locks currently held on a stack.
+	StackLocks(ctx context.Context, stackRef backend.StackReference) ([]StackLock, error)
 }
 
+// Assert we implement the optional backend.SpecificDeploymentExporter, backend.DeploymentRollbacker,
+// backend.HistoryPruner, backend.HistoryImporter and backend.UpdateEventsExporter interfaces.
+var (
+	_ backend.SpecificDeploymentExporter = &localBackend{}
+	_ backend.DeploymentRollbacker       = &localBackend{}
+	_ backend.HistoryPruner              = &localBackend{}
+	_ backend.HistoryImporter            = &localBackend{}
+	_ backend.UpdateEventsExporter       = &localBackend{}
+)
+
 type localBackend struct {
 	d diag.Sink
 
@@ -113,8 +170,36 @@ type localBackend struct {
 
 	lockID string
 
+	// lockLease is how long the locks taken by this backend remain valid without being renewed.
+	lockLease time.Duration
+	// leases tracks the locks held by this backend, which are renewed in the background until they're released.
+	leases map[string]*lease
+	// held tracks the stacks locked by this backend, by lock directory, including those locked by buckets that lock
+	// stacks themselves.
+	held       map[string]bool
+	leasesLock sync.Mutex
+
 	gzip bool
 
+	// encrypt is true if the files of each stack's state are encrypted with its secrets manager.
+	encrypt bool
+	// sealingKeys and openingKeys remember the data keys that files are encrypted with, indexed by the secrets
+	// providers that encrypt them, so that the secrets manager isn't called for every file.
+	sealingKeys map[string]*dataKey
+	openingKeys map[string][]byte
+	keysLock    sync.Mutex
+
+	// historyRetention is the retention policy enforced on the history of stacks after each update.
+	historyRetention backend.HistoryRetention
+
+	// journal is true if updates are persisted incrementally to a journal,
+	// which is compacted into the stack's checkpoint when the update completes.
+	journal bool
+	// journals tracks the stacks with updates in progress in this process,
+	// whose journals must not be recovered.
+	journals     map[string]bool
+	journalsLock sync.Mutex
+
 	Getenv func(string) string // == os.Getenv
 
 	// The current project, if any.
@@ -183,6 +268,8 @@ func (r *localBackendReference) Validate() error       { return r.store.Validate
 func (r *localBackendReference) StackBasePath() string { return r.store.StackBasePath(r) }
 func (r *localBackendReference) HistoryDir() string    { return r.store.HistoryDir(r) }
 func (r *localBackendReference) BackupDir() string     { return r.store.BackupDir(r) }
+func (r *localBackendReference) TagsPath() string      { return r.store.TagsPath(r) }
+func (r *localBackendReference) JournalDir() string    { return r.store.JournalDir(r) }
 
 func IsFileStateBackendURL(urlstr string) bool {
 	u, err := url.Parse(urlstr)
@@ -198,7 +285,10 @@ const FilePathPrefix = "file://"
 // New constructs a new filestate backend,
 // using the given URL as the root for storage.
 // The URL must use one of the schemes supported by the go-cloud blob package.
-// Thes inclue: file, s3, gs, azblob.
+// Thes inclue: file, s3, gs, azblob, mem.
+// It may also be the URL of an HTTP server prefixed with "rest+",
+// such as "rest+https://example.com/state";
+// see the restserver package for the protocol spoken with such servers.
 func New(ctx context.Context, d diag.Sink, originalURL string, project *workspace.Project) (Backend, error) {
 	return newLocalBackend(ctx, d, originalURL, project, nil)
 }
@@ -249,12 +339,24 @@ func newLocalBackend(
 		}
 	}
 
-	bucket, err := blobmux.OpenBucket(ctx, u)
-	if err != nil {
-		return nil, fmt.Errorf("unable to open bucket %s: %w", u, err)
+	var bucket *blob.Bucket
+	switch {
+	case p.Scheme == memblob.Scheme:
+		bucket = openMemBucket(p)
+	case isRESTScheme(p.Scheme):
+		bucket, err = openRESTBucket(p, opts.Getenv)
+		if err != nil {
+			return nil, fmt.Errorf("unable to open bucket %s: %w", u, err)
+		}
+	default:
+		bucket, err = blobmux.OpenBucket(ctx, u)
+		if err != nil {
+			return nil, fmt.Errorf("unable to open bucket %s: %w", u, err)
+		}
 	}
 
-	if !strings.HasPrefix(u, FilePathPrefix) {
+	// The path of a REST backend is part of the URL of its server.
+	if !strings.HasPrefix(u, FilePathPrefix) && !isRESTScheme(p.Scheme) {
 		bucketSubDir := strings.TrimLeft(p.Path, "/")
 		if bucketSubDir != "" {
 			if !strings.HasSuffix(bucketSubDir, "/") {
@@ -271,7 +373,14 @@ func newLocalBackend(
 		return nil, err
 	}
 
-	gzipCompression := cmdutil.IsTruthy(opts.Getenv(PulumiFilestateGzipEnvVar))
+	lockLease := defaultLockLease
+	if v := opts.Getenv(PulumiFilestateLockLeaseEnvVar); v != "" {
+		seconds, err := strconv.Atoi(v)
+		if err != nil || seconds <= 0 {
+			return nil, fmt.Errorf("%s must be a positive number of seconds, got %q", PulumiFilestateLockLeaseEnvVar, v)
+		}
+		lockLease = time.Duration(seconds) * time.Second
+	}
 
 	wbucket := &wrappedBucket{bucket: bucket}
 	bucket = nil // prevent accidental use of unwrapped bucket
@@ -282,7 +391,13 @@ func newLocalBackend(
 		url:         u,
 		bucket:      wbucket,
 		lockID:      lockID.String(),
-		gzip:        gzipCompression,
+		lockLease:   lockLease,
+		leases:      make(map[string]*lease),
+		held:        make(map[string]bool),
+		journal:     cmdutil.IsTruthy(opts.Getenv(PulumiFilestateJournalEnvVar)),
+		journals:    make(map[string]bool),
+		sealingKeys: make(map[string]*dataKey),
+		openingKeys: make(map[string][]byte),
 		Getenv:      opts.Getenv,
 	}
 	backend.currentProject.Store(project)
@@ -295,6 +410,15 @@ func newLocalBackend(
 		return nil, err
 	}
 
+	// Compression is configured for the backend in its metadata,
+	// but the environment variable takes precedence if it's set.
+	backend.gzip = meta.Gzip
+	if v := opts.Getenv(PulumiFilestateGzipEnvVar); v != "" {
+		backend.gzip = cmdutil.IsTruthy(v)
+	}
+	backend.historyRetention = meta.History
+	backend.encrypt = meta.Encrypt
+
 	// projectMode tracks whether the current state supports project-scoped stacks.
 	// Historically, the filestate backend did not support this.
 	// To avoid breaking old stacks, we use legacy mode for existing states.
@@ -364,6 +488,12 @@ func (b *localBackend) Upgrade(ctx context.Context) error {
 	// (e.g., we can write to .pulumi/*/*" but not ".pulumi/*.")
 	// we don't leave the bucket in a completely inaccessible state.
 	meta := pulumiMeta{Version: 1}
+	if old, err := readPulumiMeta(ctx, b.bucket); err == nil && old != nil {
+		// Keep the other settings in the existing metadata file.
+		meta.Gzip = old.Gzip
+		meta.History = old.History
+		meta.Encrypt = old.Encrypt
+	}
 	if err := meta.WriteTo(ctx, b.bucket); err != nil {
 		var s strings.Builder
 		fmt.Fprintf(&s, "Could not write new state metadata file: %v\n", err)
@@ -508,6 +638,9 @@ func massageBlobPath(path string) (string, error) {
 }
 
 func Login(ctx context.Context, d diag.Sink, url string, project *workspace.Project) (Backend, error) {
+	if IsMemBackendURL(url) {
+		return nil, ErrMemBackendLogin
+	}
 	be, err := New(ctx, d, url, project)
 	if err != nil {
 		return nil, err
@@ -515,6 +648,180 @@ func Login(ctx context.Context, d diag.Sink, url string, project *workspace.Proj
 	return be, workspace.StoreAccount(be.URL(), workspace.Account{}, true)
 }
 
+// updatePulumiMeta applies the given change to the backend's metadata file. The setting being changed is named in
+// the error returned for the legacy layout, which has no metadata file to record it in.
+func (b *localBackend) updatePulumiMeta(ctx context.Context, setting string, update func(meta *pulumiMeta)) error {
+	meta, err := readPulumiMeta(ctx, b.bucket)
+	if err != nil {
+		return err
+	}
+	if meta == nil || meta.Version == 0 {
+		return fmt.Errorf("the %s setting is only supported for project-scoped stacks; "+
+			"run 'pulumi state upgrade' first", setting)
+	}
+
+	update(meta)
+	return meta.WriteTo(ctx, b.bucket)
+}
+
+func (b *localBackend) SetCompression(ctx context.Context, gzip bool) error {
+	err := b.updatePulumiMeta(ctx, "compression", func(meta *pulumiMeta) { meta.Gzip = gzip })
+	if err != nil {
+		return err
+	}
+	b.gzip = gzip
+
+	refs, err := b.store.ListReferences(ctx)
+	if err != nil {
+		return fmt.Errorf("read references: %w", err)
+	}
+
+	var converted int
+	for _, ref := range refs {
+		if err := b.convertStack(ctx, ref, gzip); err != nil {
+			b.d.Warningf(diag.Message("", "Skipping stack %q: %v"), ref, err)
+		} else {
+			converted++
+		}
+	}
+
+	format := "uncompressed"
+	if gzip {
+		format = "gzip compressed"
+	}
+	b.d.Infoerrf(diag.Message("", "Converted %d stack(s) to %s checkpoints"), converted, format)
+	return nil
+}
+
+func (b *localBackend) SetEncryption(ctx context.Context, encrypt bool) error {
+	err := b.updatePulumiMeta(ctx, "encryption", func(meta *pulumiMeta) { meta.Encrypt = encrypt })
+	if err != nil {
+		return err
+	}
+	b.encrypt = encrypt
+
+	refs, err := b.store.ListReferences(ctx)
+	if err != nil {
+		return fmt.Errorf("read references: %w", err)
+	}
+
+	var converted int
+	for _, ref := range refs {
+		if err := b.lockedEncryptStack(ctx, ref, encrypt); err != nil {
+			b.d.Warningf(diag.Message("", "Skipping stack %q: %v"), ref, err)
+		} else {
+			converted++
+		}
+	}
+
+	format := "unencrypted"
+	if encrypt {
+		format = "encrypted"
+	}
+	b.d.Infoerrf(diag.Message("", "Converted %d stack(s) to %s checkpoints"), converted, format)
+	return nil
+}
+
+func (b *localBackend) ReencryptStack(ctx context.Context, stackRef backend.StackReference) error {
+	if !b.encrypt {
+		return nil
+	}
+	ref, err := b.getReference(stackRef)
+	if err != nil {
+		return err
+	}
+	return b.lockedEncryptStack(ctx, ref, true)
+}
+
+// lockedEncryptStack encrypts or decrypts the files of a stack while holding its lock.
+func (b *localBackend) lockedEncryptStack(ctx context.Context, ref *localBackendReference, encrypt bool) error {
+	if err := b.Lock(ctx, ref); err != nil {
+		return err
+	}
+	defer b.Unlock(ctx, ref)
+	return b.encryptStack(ctx, ref, encrypt)
+}
+
+// convertStack converts the checkpoint, history and backups of a stack to be gzip compressed or not.
+func (b *localBackend) convertStack(ctx context.Context, ref *localBackendReference, gzip bool) error {
+	if err := b.Lock(ctx, ref); err != nil {
+		return err
+	}
+	defer b.Unlock(ctx, ref)
+
+	// Only the checkpoint in the format that was most recently written is used, so if there's a checkpoint in both
+	// formats, the other one is stale and can be removed.
+	plainPath := filepath.ToSlash(ref.StackBasePath()) + ".json"
+	other := plainPath + encoding.GZIPExt
+	if gzip {
+		other = plainPath
+	}
+	if b.stackPath(ctx, ref) == other {
+		if err := b.convertFile(ctx, other, gzip); err != nil {
+			return err
+		}
+	} else if err := b.bucket.Delete(ctx, other); err != nil && gcerrors.Code(err) != gcerrors.NotFound {
+		return err
+	}
+
+	for _, dir := range []string{ref.HistoryDir(), ref.BackupDir()} {
+		files, err := listBucket(ctx, b.bucket, dir)
+		if err != nil {
+			if gcerrors.Code(err) == gcerrors.NotFound {
+				continue
+			}
+			return err
+		}
+		for _, file := range files {
+			if file.IsDir {
+				continue
+			}
+			if err := b.convertFile(ctx, file.Key, gzip); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// convertFile rewrites a JSON file in the bucket to be gzip compressed or not, renaming it to match. Files that are
+// already in the requested format are left alone.
+func (b *localBackend) convertFile(ctx context.Context, key string, gzip bool) error {
+	idx := strings.LastIndex(key, ".json")
+	if idx == -1 {
+		return nil
+	}
+	base, rest := key[:idx+len(".json")], key[idx+len(".json"):]
+	if strings.HasPrefix(rest, encoding.GZIPExt) == gzip {
+		return nil
+	}
+
+	data, err := b.bucket.ReadAll(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	// Encrypted files are compressed before they're encrypted, so they're decrypted and encrypted again.
+	file, err := b.decodeStateFile(ctx, data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", key, err)
+	}
+	file.compressed = gzip
+	if data, err = b.encodeStateFile(ctx, file); err != nil {
+		return fmt.Errorf("%s: %w", key, err)
+	}
+
+	newKey := base + strings.TrimPrefix(rest, encoding.GZIPExt)
+	if gzip {
+		newKey = base + encoding.GZIPExt + rest
+	}
+
+	if err := b.bucket.WriteAll(ctx, newKey, data, nil); err != nil {
+		return err
+	}
+	return b.bucket.Delete(ctx, key)
+}
+
 func (b *localBackend) getReference(ref backend.StackReference) (*localBackendReference, error) {
 	stackRef, ok := ref.(*localBackendReference)
 	if !ok {
@@ -542,26 +849,8 @@ func (b *localBackend) SetCurrentProject(project *workspace.Project) {
 	b.currentProject.Store(project)
 }
 
-func (b *localBackend) GetPolicyPack(ctx context.Context, policyPack string,
-	d diag.Sink,
-) (backend.PolicyPack, error) {
-	return nil, fmt.Errorf("File state backend does not support resource policy")
-}
-
-func (b *localBackend) ListPolicyGroups(ctx context.Context, orgName string, _ backend.ContinuationToken) (
-	apitype.ListPolicyGroupsResponse, backend.ContinuationToken, error,
-) {
-	return apitype.ListPolicyGroupsResponse{}, nil, fmt.Errorf("File state backend does not support resource policy")
-}
-
-func (b *localBackend) ListPolicyPacks(ctx context.Context, orgName string, _ backend.ContinuationToken) (
-	apitype.ListPolicyPacksResponse, backend.ContinuationToken, error,
-) {
-	return apitype.ListPolicyPacksResponse{}, nil, fmt.Errorf("File state backend does not support resource policy")
-}
-
 func (b *localBackend) SupportsTags() bool {
-	return false
+	return true
 }
 
 func (b *localBackend) SupportsOrganizations() bool {
@@ -662,7 +951,11 @@ func (b *localBackend) CreateStack(ctx context.Context, stackRef backend.StackRe
 		return nil, err
 	}
 
-	stack := newStack(localStackRef, file, nil, b)
+	if err := b.saveStackTags(ctx, localStackRef, tags); err != nil {
+		return nil, err
+	}
+
+	stack := newStack(localStackRef, file, nil, tags, b)
 	b.d.Infof(diag.Message("", "Created stack '%s'"), stack.Ref())
 
 	return stack, nil
@@ -681,13 +974,17 @@ func (b *localBackend) GetStack(ctx context.Context, stackRef backend.StackRefer
 		return nil, nil
 	case err != nil:
 		return nil, err
-	default:
-		return newStack(localStackRef, path, snapshot, b), nil
 	}
+
+	tags, err := b.getStackTags(ctx, localStackRef)
+	if err != nil {
+		return nil, err
+	}
+	return newStack(localStackRef, path, snapshot, tags, b), nil
 }
 
 func (b *localBackend) ListStacks(
-	ctx context.Context, _ backend.ListStacksFilter, _ backend.ContinuationToken) (
+	ctx context.Context, filter backend.ListStacksFilter, _ backend.ContinuationToken) (
 	[]backend.StackSummary, backend.ContinuationToken, error,
 ) {
 	stacks, err := b.getLocalStacks(ctx)
@@ -695,15 +992,25 @@ func (b *localBackend) ListStacks(
 		return nil, nil, err
 	}
 
-	// Note that the provided stack filter is not honored, since fields like
-	// organizations and tags aren't persisted in the local backend.
+	// Note that only the tag filter is honored, since fields like
+	// organizations aren't persisted in the local backend.
 	results := make([]backend.StackSummary, 0, len(stacks))
 	for _, stackRef := range stacks {
-		chk, err := b.getCheckpoint(ctx, stackRef)
+		if filter.TagName != nil || filter.TagValue != nil {
+			tags, err := b.getStackTags(ctx, stackRef)
+			if err != nil {
+				return nil, nil, err
+			}
+			if !matchesTagFilter(tags, filter) {
+				continue
+			}
+		}
+
+		summary, err := b.getCheckpointSummary(ctx, stackRef)
 		if err != nil {
 			return nil, nil, err
 		}
-		results = append(results, newLocalStackSummary(stackRef, chk))
+		results = append(results, newLocalStackSummary(stackRef, summary))
 	}
 
 	return results, nil, nil
@@ -800,7 +1107,33 @@ func (b *localBackend) renameStack(ctx context.Context, oldRef *localBackendRefe
 	if err = b.renameHistory(ctx, oldRef, newRef); err != nil {
 		return err
 	}
-	return err
+
+	// And move the tags over to the new name.
+	tags, err := b.getStackTags(ctx, oldRef)
+	if err != nil {
+		return err
+	}
+	if tags != nil {
+		if err = b.saveStackTags(ctx, newRef, tags); err != nil {
+			return err
+		}
+	}
+	return b.removeStackTags(ctx, oldRef)
+}
+
+// matchesTagFilter returns true if the given tags satisfy the tag part of a stack filter: some tag must have the
+// filter's tag name, if given, and the filter's tag value, if given.
+func matchesTagFilter(tags map[apitype.StackTagName]string, filter backend.ListStacksFilter) bool {
+	for k, v := range tags {
+		if filter.TagName != nil && *filter.TagName != "" && k != *filter.TagName {
+			continue
+		}
+		if filter.TagValue != nil && v != *filter.TagValue {
+			continue
+		}
+		return true
+	}
+	return false
 }
 
 func (b *localBackend) GetLatestConfiguration(ctx context.Context,
@@ -926,6 +1259,21 @@ func (b *localBackend) apply(
 		return nil, nil, result.FromError(err)
 	}
 
+	// Enforce the policy packs that the backend's policy configuration requires for this stack.
+	requiredPolicies, err := b.getRequiredPolicies(ctx, localStackRef)
+	if err != nil {
+		return nil, nil, result.FromError(err)
+	}
+	op.Opts.Engine.RequiredPolicies = append(op.Opts.Engine.RequiredPolicies, requiredPolicies...)
+
+	// Like the service does when an update starts, pick up any metadata changes in the stack's tags.
+	if !opts.DryRun {
+		tags := backend.GetMergedStackTags(ctx, stack, op.Root, op.Proj)
+		if err := b.saveStackTags(ctx, localStackRef, tags); err != nil {
+			return nil, nil, result.FromError(fmt.Errorf("saving stack tags: %w", err))
+		}
+	}
+
 	// Spawn a display loop to show events on the CLI.
 	displayEvents := make(chan engine.Event)
 	displayDone := make(chan bool)
@@ -938,25 +1286,53 @@ func (b *localBackend) apply(
 
 	scope := op.Scopes.NewScope(engineEvents, opts.DryRun)
 	eventsDone := make(chan bool)
+
+	// Save the events of updates alongside the entry that will be added to the stack's history, which are encrypted
+	// like the rest of the stack's state.
+	var historyPrefix string
+	var persistEvents chan engine.Event
+	persistDone := make(chan bool)
+	if !opts.DryRun {
+		historyPrefix = historyPathPrefix(localStackRef)
+		if providers, err := b.stackSecretsProviders(ctx, localStackRef); err != nil {
+			b.d.Warningf(diag.Message("", "Could not record the events of the update: %v"), err)
+		} else {
+			persistEvents = make(chan engine.Event)
+			go b.persistEngineEvents(ctx, historyPrefix, providers, persistEvents, persistDone)
+		}
+	}
+	if persistEvents == nil {
+		close(persistDone)
+	}
+
 	go func() {
-		// Pull in all events from the engine and send them to the two listeners.
+		// Pull in all events from the engine and send them to the listeners.
 		for e := range engineEvents {
 			displayEvents <- e
 
+			if persistEvents != nil {
+				persistEvents <- e
+			}
+
 			// If the caller also wants to see the events, stream them there also.
 			if events != nil {
 				events <- e
 			}
 		}
 
+		if persistEvents != nil {
+			close(persistEvents)
+		}
 		close(eventsDone)
 	}()
 
 	// Create the management machinery.
 	persister := b.newSnapshotPersister(ctx, localStackRef, op.SecretsManager)
 	manager := backend.NewSnapshotManager(persister, update.GetTarget().Snapshot)
+	cancelCtx, stopCancel := b.cancelOnLostLease(scope.Context(), stackRef)
+	defer stopCancel()
 	engineCtx := &engine.Context{
-		Cancel:          scope.Context(),
+		Cancel:          cancelCtx,
 		Events:          engineEvents,
 		SnapshotManager: manager,
 		BackendClient:   backend.NewBackendClient(b, op.SecretsProvider),
@@ -992,6 +1368,7 @@ func (b *localBackend) apply(
 	// Make sure the goroutine writing to displayEvents and events has exited before proceeding.
 	<-eventsDone
 	close(displayEvents)
+	<-persistDone
 
 	// Save update results.
 	backendUpdateResult := backend.SucceededResult
@@ -1015,8 +1392,15 @@ func (b *localBackend) apply(
 	var saveErr error
 	var backupErr error
 	if !opts.DryRun {
-		saveErr = b.addToHistory(ctx, localStackRef, info)
+		saveErr = b.writeHistoryEntry(ctx, localStackRef, info, nil, historyPrefix)
 		backupErr = b.backupStack(ctx, localStackRef)
+
+		// Old updates are pruned on a best effort basis; failing to do so shouldn't fail the update.
+		if saveErr == nil && !b.historyRetention.IsZero() {
+			if _, err := b.pruneHistory(ctx, localStackRef, b.historyRetention, false /*dryRun*/); err != nil {
+				b.d.Warningf(diag.Message("", "Could not prune the history of stack %q: %v"), localStackRef, err)
+			}
+		}
 	}
 
 	if updateRes != nil {
@@ -1069,6 +1453,32 @@ func (b *localBackend) apply(
 	return plan, changes, nil
 }
 
+// cancelOnLostLease returns a cancellation context for an update to the given stack, which is canceled along with the
+// given one, and also if the lock on the stack is broken by another process while the update is running. The returned
+// function stops watching for either once the update has finished.
+func (b *localBackend) cancelOnLostLease(
+	scope *cancel.Context, stackRef backend.StackReference,
+) (*cancel.Context, func()) {
+	cancelCtx, cancelSource := cancel.NewContext(context.Background())
+	lost, stop := b.lostLease(stackRef), make(chan struct{})
+	go func() {
+		select {
+		case <-scope.Canceled():
+		case <-lost:
+		case <-stop:
+			return
+		}
+		cancelSource.Cancel()
+
+		select {
+		case <-scope.Terminated():
+			cancelSource.Terminate()
+		case <-stop:
+		}
+	}()
+	return cancelCtx, func() { close(stop) }
+}
+
 // query executes a query program against the resource outputs of a locally hosted stack.
 func (b *localBackend) query(ctx context.Context, op backend.QueryOperation,
 	callerEventsOpt chan<- engine.Event,
@@ -1157,6 +1567,145 @@ func (b *localBackend) ExportDeployment(ctx context.Context,
 	}, nil
 }
 
+// ExportDeploymentForVersion exports the checkpoint saved with the given update in the stack's history. As with the
+// service, versions are positive integers and the first update of a stack is version 1.
+func (b *localBackend) ExportDeploymentForVersion(
+	ctx context.Context, stk backend.Stack, version string,
+) (*apitype.UntypedDeployment, error) {
+	versionNumber, err := strconv.Atoi(version)
+	if err != nil || versionNumber <= 0 {
+		return nil, fmt.Errorf(
+			"%q is not a valid stack version. It should be a positive integer",
+			version)
+	}
+
+	localStackRef, err := b.getReference(stk.Ref())
+	if err != nil {
+		return nil, err
+	}
+
+	chk, err := b.getHistoricalCheckpoint(ctx, localStackRef, versionNumber)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load checkpoint: %w", err)
+	}
+
+	data, err := encoding.JSON.Marshal(chk.Latest)
+	if err != nil {
+		return nil, err
+	}
+
+	return &apitype.UntypedDeployment{
+		Version:    3,
+		Deployment: json.RawMessage(data),
+	}, nil
+}
+
+// RollbackDeployment restores the checkpoint saved with the given update in the stack's history, and records the
+// rollback as a new update in the stack's history.
+func (b *localBackend) RollbackDeployment(ctx context.Context, stk backend.Stack, version string) error {
+	start := time.Now().Unix()
+	deployment, err := b.ExportDeploymentForVersion(ctx, stk, version)
+	if err != nil {
+		return err
+	}
+
+	localStackRef, err := b.getReference(stk.Ref())
+	if err != nil {
+		return err
+	}
+
+	// Restore the configuration recorded for the version too, so that it's what GetLatestConfiguration returns.
+	// ExportDeploymentForVersion has already checked that the version is a valid number.
+	versionNumber, err := strconv.Atoi(version)
+	contract.AssertNoErrorf(err, "invalid version %q", version)
+	var cfg config.Map
+	history, err := b.getHistory(ctx, localStackRef, 0 /*pageSize*/, 0 /*page*/)
+	if err != nil {
+		return err
+	}
+	for _, update := range history {
+		if update.Version == versionNumber {
+			cfg = update.Config
+			break
+		}
+	}
+
+	// Hold the lock until the rollback is recorded, so that the checkpoint copied into the history is the one that was
+	// restored rather than that of an update that ran in the meantime.
+	if err := b.Lock(ctx, localStackRef); err != nil {
+		return err
+	}
+	defer b.Unlock(ctx, localStackRef)
+
+	if err := b.importDeployment(ctx, localStackRef, deployment); err != nil {
+		return err
+	}
+
+	return b.addToHistory(ctx, localStackRef, backend.UpdateInfo{
+		Kind:        apitype.StackImportUpdate,
+		StartTime:   start,
+		Message:     fmt.Sprintf("Rollback to version %s", version),
+		Environment: map[string]string{backend.RollbackVersion: version},
+		Config:      cfg,
+		Result:      backend.SucceededResult,
+		EndTime:     time.Now().Unix(),
+	})
+}
+
+// ExportUpdateEvents returns the engine events saved with the given update in the stack's history. Versions are
+// numbered as for ExportDeploymentForVersion.
+func (b *localBackend) ExportUpdateEvents(
+	ctx context.Context, stk backend.Stack, version string,
+) ([]apitype.EngineEvent, error) {
+	versionNumber, err := strconv.Atoi(version)
+	if err != nil || versionNumber <= 0 {
+		return nil, fmt.Errorf(
+			"%q is not a valid stack version. It should be a positive integer",
+			version)
+	}
+
+	localStackRef, err := b.getReference(stk.Ref())
+	if err != nil {
+		return nil, err
+	}
+
+	return b.getHistoricalEvents(ctx, localStackRef, versionNumber)
+}
+
+func (b *localBackend) HistoryRetention(ctx context.Context) (backend.HistoryRetention, error) {
+	return b.historyRetention, nil
+}
+
+func (b *localBackend) SetHistoryRetention(ctx context.Context, policy backend.HistoryRetention) error {
+	err := b.updatePulumiMeta(ctx, "history retention", func(meta *pulumiMeta) { meta.History = policy })
+	if err != nil {
+		return err
+	}
+	b.historyRetention = policy
+	return nil
+}
+
+func (b *localBackend) PruneHistory(
+	ctx context.Context,
+	stk backend.Stack,
+	policy backend.HistoryRetention,
+	dryRun bool,
+) ([]backend.UpdateInfo, error) {
+	localStackRef, err := b.getReference(stk.Ref())
+	if err != nil {
+		return nil, err
+	}
+
+	if !dryRun {
+		if err := b.Lock(ctx, localStackRef); err != nil {
+			return nil, err
+		}
+		defer b.Unlock(ctx, localStackRef)
+	}
+
+	return b.pruneHistory(ctx, localStackRef, policy, dryRun)
+}
+
 func (b *localBackend) ImportDeployment(ctx context.Context, stk backend.Stack,
 	deployment *apitype.UntypedDeployment,
 ) error {
@@ -1171,16 +1720,44 @@ func (b *localBackend) ImportDeployment(ctx context.Context, stk backend.Stack,
 	}
 	defer b.Unlock(ctx, localStackRef)
 
-	stackName := localStackRef.FullyQualifiedName()
-	chk, err := stack.MarshalUntypedDeploymentToVersionedCheckpoint(stackName, deployment)
+	return b.importDeployment(ctx, localStackRef, deployment)
+}
+
+// importDeployment saves the given deployment as the stack's checkpoint. The caller must hold the stack's lock.
+func (b *localBackend) importDeployment(
+	ctx context.Context, ref *localBackendReference, deployment *apitype.UntypedDeployment,
+) error {
+	chk, err := stack.MarshalUntypedDeploymentToVersionedCheckpoint(ref.FullyQualifiedName(), deployment)
 	if err != nil {
 		return err
 	}
 
-	_, _, err = b.saveCheckpoint(ctx, localStackRef, chk)
+	_, _, err = b.saveCheckpoint(ctx, ref, chk)
 	return err
 }
 
+func (b *localBackend) ImportUpdate(ctx context.Context, stk backend.Stack,
+	update backend.UpdateInfo, deployment *apitype.UntypedDeployment,
+) error {
+	localStackRef, err := b.getReference(stk.Ref())
+	if err != nil {
+		return err
+	}
+
+	err = b.Lock(ctx, localStackRef)
+	if err != nil {
+		return err
+	}
+	defer b.Unlock(ctx, localStackRef)
+
+	chk, err := stack.MarshalUntypedDeploymentToVersionedCheckpoint(localStackRef.FullyQualifiedName(), deployment)
+	if err != nil {
+		return err
+	}
+
+	return b.writeHistoryEntry(ctx, localStackRef, update, chk, "")
+}
+
 func (b *localBackend) Logout() error {
 	return workspace.DeleteAccount(b.originalURL)
 }
@@ -1205,11 +1782,30 @@ func (b *localBackend) getLocalStacks(ctx context.Context) ([]*localBackendRefer
 func (b *localBackend) UpdateStackTags(ctx context.Context,
 	stack backend.Stack, tags map[apitype.StackTagName]string,
 ) error {
-	// The local backend does not currently persist tags.
-	return errors.New("stack tags not supported in --local mode")
+	localStackRef, err := b.getReference(stack.Ref())
+	if err != nil {
+		return err
+	}
+
+	// There's no service to validate the tags, so do it here.
+	if err := validation.ValidateStackTags(tags); err != nil {
+		return err
+	}
+
+	err = b.Lock(ctx, localStackRef)
+	if err != nil {
+		return err
+	}
+	defer b.Unlock(ctx, localStackRef)
+
+	return b.saveStackTags(ctx, localStackRef, tags)
 }
 
 func (b *localBackend) CancelCurrentUpdate(ctx context.Context, stackRef backend.StackReference) error {
+	if locker := lockerFor(b.bucket); locker != nil {
+		return locker.Unlock(ctx, stackLockDir(stackRef.FullyQualifiedName()), nil)
+	}
+
 	// Try to delete ALL the lock files
 	allFiles, err := listBucket(ctx, b.bucket, stackLockDir(stackRef.FullyQualifiedName()))
 	if err != nil {
@@ -21,11 +21,13 @@ import (
 	"gocloud.dev/blob/fileblob"
 
 	"github.com/pulumi/pulumi/pkg/v3/backend"
+	"github.com/pulumi/pulumi/pkg/v3/engine"
 	"github.com/pulumi/pulumi/pkg/v3/operations"
 	"github.com/pulumi/pulumi/pkg/v3/resource/deploy"
 	"github.com/pulumi/pulumi/pkg/v3/resource/stack"
 	"github.com/pulumi/pulumi/pkg/v3/secrets/b64"
 	"github.com/pulumi/pulumi/pkg/v3/secrets/passphrase"
+	"github.com/pulumi/pulumi/pkg/v3/util/cancel"
 	"github.com/pulumi/pulumi/sdk/v3/go/common/apitype"
 	"github.com/pulumi/pulumi/sdk/v3/go/common/diag"
 	"github.com/pulumi/pulumi/sdk/v3/go/common/diag/colors"
@@ -299,6 +301,127 @@ func TestCancel(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestLockLeases(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	ctx := context.Background()
+	newBackend := func() *localBackend {
+		b, err := New(ctx, diagtest.LogSink(t), "file://"+filepath.ToSlash(tmpDir), nil)
+		require.NoError(t, err)
+		return b.(*localBackend)
+	}
+	lb, other := newBackend(), newBackend()
+
+	aStackRef, err := lb.ParseStackReference("organization/project/a")
+	require.NoError(t, err)
+	_, err = lb.CreateStack(ctx, aStackRef, "", nil)
+	require.NoError(t, err)
+
+	// Locks that haven't expired are reported along with when they expire.
+	require.NoError(t, other.Lock(ctx, aStackRef))
+	err = lb.Lock(ctx, aStackRef)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "expires at")
+
+	locks, err := lb.StackLocks(ctx, aStackRef)
+	require.NoError(t, err)
+	require.Len(t, locks, 1)
+	assert.Equal(t, lb.url+"/"+filepath.ToSlash(other.lockPath(aStackRef)), locks[0].URL)
+	require.NotNil(t, locks[0].Expires)
+	assert.True(t, locks[0].Expires.After(locks[0].Timestamp))
+
+	// Simulate the other process being killed without releasing its lock, and its lease running out.
+	other.leasesLock.Lock()
+	other.leases[other.lockPath(aStackRef)].cancel()
+	other.leasesLock.Unlock()
+	expired, err := newLockContent(-time.Minute)
+	require.NoError(t, err)
+	content, err := json.Marshal(expired)
+	require.NoError(t, err)
+	require.NoError(t, lb.bucket.WriteAll(ctx, other.lockPath(aStackRef), content, nil))
+
+	// The expired lock is broken, and the stack can be locked.
+	require.NoError(t, lb.Lock(ctx, aStackRef))
+	exists, err := lb.bucket.Exists(ctx, other.lockPath(aStackRef))
+	require.NoError(t, err)
+	assert.False(t, exists)
+	lb.Unlock(ctx, aStackRef)
+
+	// Locks taken by older versions of the CLI have no lease, and never expire.
+	legacy, err := json.Marshal(map[string]interface{}{"pid": 1, "username": "u", "hostname": "h"})
+	require.NoError(t, err)
+	require.NoError(t, lb.bucket.WriteAll(ctx, other.lockPath(aStackRef), legacy, nil))
+	assert.Error(t, lb.checkForLock(ctx, aStackRef))
+	require.NoError(t, lb.CancelCurrentUpdate(ctx, aStackRef))
+}
+
+func TestLockLeaseRenewal(t *testing.T) {
+	t.Parallel()
+
+	tmpDir := t.TempDir()
+	ctx := context.Background()
+	b, err := New(ctx, diagtest.LogSink(t), "file://"+filepath.ToSlash(tmpDir), nil)
+	require.NoError(t, err)
+	lb := b.(*localBackend)
+	lb.lockLease = 300 * time.Millisecond
+
+	aStackRef, err := lb.ParseStackReference("organization/project/a")
+	require.NoError(t, err)
+	_, err = lb.CreateStack(ctx, aStackRef, "", nil)
+	require.NoError(t, err)
+
+	readLock := func() *lockContent {
+		bytes, err := lb.bucket.ReadAll(ctx, lb.lockPath(aStackRef))
+		require.NoError(t, err)
+		var l lockContent
+		require.NoError(t, json.Unmarshal(bytes, &l))
+		return &l
+	}
+
+	require.NoError(t, lb.Lock(ctx, aStackRef))
+	initial := readLock()
+
+	// The lease is renewed while the lock is held, so it never expires.
+	assert.Eventually(t, func() bool {
+		return readLock().Expires.After(*initial.Expires)
+	}, 5*time.Second, 50*time.Millisecond)
+	assert.False(t, readLock().expired(time.Now()))
+	assert.Equal(t, initial.Timestamp.Unix(), readLock().Timestamp.Unix())
+
+	// Once the lock is released, it isn't written again.
+	lb.Unlock(ctx, aStackRef)
+	time.Sleep(2 * lb.lockLease / 3)
+	exists, err := lb.bucket.Exists(ctx, lb.lockPath(aStackRef))
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	// A lock that's broken while held isn't renewed, and the update holding it is canceled.
+	require.NoError(t, lb.Lock(ctx, aStackRef))
+	scopeCtx, _ := cancel.NewContext(context.Background())
+	cancelCtx, stopCancel := lb.cancelOnLostLease(scopeCtx, aStackRef)
+	defer stopCancel()
+	require.NoError(t, lb.CancelCurrentUpdate(ctx, aStackRef))
+	lb.leasesLock.Lock()
+	done := lb.leases[lb.lockPath(aStackRef)].done
+	lb.leasesLock.Unlock()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("lease was not abandoned after the lock was broken")
+	}
+	select {
+	case <-cancelCtx.Canceled():
+	case <-time.After(5 * time.Second):
+		t.Fatal("update was not canceled after the lock was broken")
+	}
+	sp := lb.newSnapshotPersister(ctx, aStackRef.(*localBackendReference), nil)
+	assert.ErrorIs(t, sp.Save(&deploy.Snapshot{}), errLeaseLost)
+	exists, err = lb.bucket.Exists(ctx, lb.lockPath(aStackRef))
+	require.NoError(t, err)
+	assert.False(t, exists)
+}
+
 func TestRemoveMakesBackups(t *testing.T) {
 	t.Parallel()
 
@@ -1087,6 +1210,211 @@ func TestCreateStack_gzip(t *testing.T) {
 	assert.FileExists(t, filepath.Join(stateDir, ".pulumi", "stacks", "testproj", "foo.json.gz"))
 }
 
+func TestSetCompression(t *testing.T) {
+	t.Parallel()
+
+	stateDir := t.TempDir()
+	ctx := context.Background()
+	project := &workspace.Project{Name: "testproj"}
+	b, err := newLocalBackend(ctx, diagtest.LogSink(t), "file://"+filepath.ToSlash(stateDir), project, nil)
+	require.NoError(t, err)
+
+	fooRef, err := b.ParseStackReference("foo")
+	require.NoError(t, err)
+	_, err = b.CreateStack(ctx, fooRef, "", nil)
+	require.NoError(t, err)
+	ref := fooRef.(*localBackendReference)
+	require.NoError(t, b.addToHistory(ctx, ref, backend.UpdateInfo{Kind: apitype.UpdateUpdate}))
+	require.NoError(t, b.backupStack(ctx, ref))
+
+	stackDir := filepath.Join(stateDir, ".pulumi", "stacks", "testproj")
+	historyDir := filepath.Join(stateDir, ".pulumi", "history", "testproj", "foo")
+	backupDir := filepath.Join(stateDir, ".pulumi", "backups", "testproj", "foo")
+	countFiles := func(pattern string) int {
+		matches, err := filepath.Glob(pattern)
+		require.NoError(t, err)
+		return len(matches)
+	}
+
+	require.NoError(t, b.SetCompression(ctx, true))
+	assert.FileExists(t, filepath.Join(stackDir, "foo.json.gz"))
+	assert.NoFileExists(t, filepath.Join(stackDir, "foo.json"))
+	assert.Equal(t, 1, countFiles(filepath.Join(historyDir, "*.history.json.gz")))
+	assert.Equal(t, 1, countFiles(filepath.Join(historyDir, "*.checkpoint.json.gz")))
+	assert.Equal(t, 0, countFiles(filepath.Join(historyDir, "*.json")))
+	assert.Equal(t, 1, countFiles(filepath.Join(backupDir, "*.json.gz")))
+	assert.Equal(t, 0, countFiles(filepath.Join(backupDir, "*.json")))
+
+	// The setting is stored in the backend, so it applies to new backends as well.
+	b2, err := New(ctx, diagtest.LogSink(t), "file://"+filepath.ToSlash(stateDir), project)
+	require.NoError(t, err)
+	assert.True(t, b2.(*localBackend).gzip)
+	s, err := b2.GetStack(ctx, fooRef)
+	require.NoError(t, err)
+	require.NotNil(t, s)
+	history, err := b2.GetHistory(ctx, fooRef, 10, 1)
+	require.NoError(t, err)
+	assert.Len(t, history, 1)
+
+	// Unless the environment variable says otherwise.
+	b3, err := newLocalBackend(ctx, diagtest.LogSink(t), "file://"+filepath.ToSlash(stateDir), project,
+		&localBackendOptions{
+			Getenv: mapGetenv(map[string]string{
+				"PULUMI_SELF_MANAGED_STATE_GZIP": "false",
+			}),
+		})
+	require.NoError(t, err)
+	assert.False(t, b3.gzip)
+
+	require.NoError(t, b.SetCompression(ctx, false))
+	assert.FileExists(t, filepath.Join(stackDir, "foo.json"))
+	assert.NoFileExists(t, filepath.Join(stackDir, "foo.json.gz"))
+	assert.Equal(t, 1, countFiles(filepath.Join(historyDir, "*.history.json")))
+	assert.Equal(t, 1, countFiles(filepath.Join(historyDir, "*.checkpoint.json")))
+	assert.Equal(t, 0, countFiles(filepath.Join(historyDir, "*.gz")))
+	assert.Equal(t, 1, countFiles(filepath.Join(backupDir, "*.json")))
+	s, err = b.GetStack(ctx, fooRef)
+	require.NoError(t, err)
+	require.NotNil(t, s)
+}
+
+func TestSetEncryption(t *testing.T) {
+	t.Parallel()
+
+	stateDir := t.TempDir()
+	ctx := context.Background()
+	project := &workspace.Project{Name: "testproj"}
+	b, err := newLocalBackend(ctx, diagtest.LogSink(t), "file://"+filepath.ToSlash(stateDir), project, nil)
+	require.NoError(t, err)
+
+	fooRef, err := b.ParseStackReference("foo")
+	require.NoError(t, err)
+	foo, err := b.CreateStack(ctx, fooRef, "", nil)
+	require.NoError(t, err)
+	deployment, err := makeUntypedDeployment("a", "abc123",
+		"v1:4iF78gb0nF0=:v1:Co6IbTWYs/UdrjgY:FSrAWOFZnj9ealCUDdJL7LrUKXX9BA==")
+	require.NoError(t, err)
+	require.NoError(t, b.ImportDeployment(ctx, foo, deployment))
+	ref := fooRef.(*localBackendReference)
+	require.NoError(t, b.addToHistory(ctx, ref, backend.UpdateInfo{Kind: apitype.UpdateUpdate}))
+	require.NoError(t, b.backupStack(ctx, ref))
+
+	stackFile := filepath.Join(stateDir, ".pulumi", "stacks", "testproj", "foo.json")
+	historyDir := filepath.Join(stateDir, ".pulumi", "history", "testproj", "foo")
+	backupDir := filepath.Join(stateDir, ".pulumi", "backups", "testproj", "foo")
+	stateFiles := func() []string {
+		files := []string{stackFile}
+		for _, pattern := range []string{
+			filepath.Join(historyDir, "*.json"),
+			filepath.Join(backupDir, "*.json"),
+		} {
+			matches, err := filepath.Glob(pattern)
+			require.NoError(t, err)
+			files = append(files, matches...)
+		}
+		require.Len(t, files, 4)
+		return files
+	}
+	assertEncrypted := func(encrypted bool) {
+		for _, file := range stateFiles() {
+			data, err := os.ReadFile(file)
+			require.NoError(t, err)
+			assert.Equal(t, encrypted, isEncrypted(data), file)
+			if encrypted {
+				assert.NotContains(t, string(data), "a:b:c", file)
+			}
+		}
+	}
+	assertResources := func(b *localBackend) {
+		udep, err := b.ExportDeployment(ctx, foo)
+		require.NoError(t, err)
+		var dep apitype.DeploymentV3
+		require.NoError(t, json.Unmarshal(udep.Deployment, &dep))
+		require.Len(t, dep.Resources, 1)
+		assert.Equal(t, "a", string(dep.Resources[0].URN.Name()))
+		history, err := b.GetHistory(ctx, fooRef, 10, 1)
+		require.NoError(t, err)
+		assert.Len(t, history, 1)
+		udep, err = b.ExportDeploymentForVersion(ctx, foo, "1")
+		require.NoError(t, err)
+		require.NoError(t, json.Unmarshal(udep.Deployment, &dep))
+		assert.Len(t, dep.Resources, 1)
+	}
+
+	require.NoError(t, b.SetEncryption(ctx, true))
+	assertEncrypted(true)
+	assertResources(b)
+
+	// The setting is stored in the backend, so it applies to new backends as well, which can read the state.
+	b2, err := newLocalBackend(ctx, diagtest.LogSink(t), "file://"+filepath.ToSlash(stateDir), project, nil)
+	require.NoError(t, err)
+	assert.True(t, b2.encrypt)
+	assertResources(b2)
+
+	// New checkpoints are encrypted as well.
+	require.NoError(t, b2.ImportDeployment(ctx, foo, deployment))
+	assertEncrypted(true)
+
+	// Empty stacks have no secrets provider, and are left as they are.
+	barRef, err := b.ParseStackReference("bar")
+	require.NoError(t, err)
+	_, err = b.CreateStack(ctx, barRef, "", nil)
+	require.NoError(t, err)
+	data, err := os.ReadFile(filepath.Join(stateDir, ".pulumi", "stacks", "testproj", "bar.json"))
+	require.NoError(t, err)
+	assert.False(t, isEncrypted(data))
+
+	require.NoError(t, b.SetEncryption(ctx, false))
+	assertEncrypted(false)
+	assertResources(b)
+}
+
+func TestSetEncryption_compressed(t *testing.T) {
+	t.Parallel()
+
+	stateDir := t.TempDir()
+	ctx := context.Background()
+	project := &workspace.Project{Name: "testproj"}
+	b, err := newLocalBackend(ctx, diagtest.LogSink(t), "file://"+filepath.ToSlash(stateDir), project, nil)
+	require.NoError(t, err)
+
+	fooRef, err := b.ParseStackReference("foo")
+	require.NoError(t, err)
+	foo, err := b.CreateStack(ctx, fooRef, "", nil)
+	require.NoError(t, err)
+	deployment, err := makeUntypedDeployment("a", "abc123",
+		"v1:4iF78gb0nF0=:v1:Co6IbTWYs/UdrjgY:FSrAWOFZnj9ealCUDdJL7LrUKXX9BA==")
+	require.NoError(t, err)
+	require.NoError(t, b.ImportDeployment(ctx, foo, deployment))
+	require.NoError(t, b.SetEncryption(ctx, true))
+	require.NoError(t, b.SetCompression(ctx, true))
+
+	// The checkpoint is compressed before it's encrypted, and its summary is kept in the clear.
+	data, err := os.ReadFile(filepath.Join(stateDir, ".pulumi", "stacks", "testproj", "foo.json.gz"))
+	require.NoError(t, err)
+	require.True(t, isEncrypted(data))
+	var file encryptedFile
+	require.NoError(t, json.Unmarshal(data, &file))
+	require.NotNil(t, file.Summary)
+	require.NotNil(t, file.Summary.ResourceCount)
+	assert.Equal(t, 1, *file.Summary.ResourceCount)
+	plaintext, err := b.decryptFile(ctx, &file.Encrypted)
+	require.NoError(t, err)
+	assert.True(t, encoding.IsCompressed(plaintext))
+
+	stacks, _, err := b.ListStacks(ctx, backend.ListStacksFilter{}, nil /* inContToken */)
+	require.NoError(t, err)
+	require.Len(t, stacks, 1)
+	require.NotNil(t, stacks[0].ResourceCount())
+	assert.Equal(t, 1, *stacks[0].ResourceCount())
+
+	udep, err := b.ExportDeployment(ctx, foo)
+	require.NoError(t, err)
+	var dep apitype.DeploymentV3
+	require.NoError(t, json.Unmarshal(udep.Deployment, &dep))
+	assert.Len(t, dep.Resources, 1)
+}
+
 func TestCreateStack_retainCheckpoints(t *testing.T) {
 	t.Parallel()
 
@@ -1138,3 +1466,705 @@ func mapGetenv(m map[string]string) func(string) string {
 		return m[key]
 	}
 }
+
+func TestExportDeploymentForVersion(t *testing.T) {
+	t.Parallel()
+
+	stateDir := t.TempDir()
+	ctx := context.Background()
+	b, err := newLocalBackend(
+		ctx,
+		diagtest.LogSink(t), "file://"+filepath.ToSlash(stateDir),
+		&workspace.Project{Name: "testproj"},
+		nil,
+	)
+	require.NoError(t, err)
+
+	fooRef, err := b.parseStackReference("foo")
+	require.NoError(t, err)
+	foo, err := b.CreateStack(ctx, fooRef, "", nil)
+	require.NoError(t, err)
+
+	// Fake up two updates, each with a different resource in their checkpoint.
+	for _, name := range []tokens.QName{"a", "b"} {
+		data, err := json.Marshal(apitype.DeploymentV3{
+			Resources: []apitype.ResourceV3{{
+				URN:  resource.NewURN("foo", "testproj", "", "a:b:c", name),
+				Type: "a:b:c",
+			}},
+		})
+		require.NoError(t, err)
+		err = b.ImportDeployment(ctx, foo, &apitype.UntypedDeployment{Version: 3, Deployment: data})
+		require.NoError(t, err)
+		err = b.addToHistory(ctx, fooRef, backend.UpdateInfo{Kind: apitype.UpdateUpdate})
+		require.NoError(t, err)
+	}
+
+	history, err := b.GetHistory(ctx, fooRef, 0, 0)
+	require.NoError(t, err)
+	require.Len(t, history, 2)
+	assert.Equal(t, 2, history[0].Version)
+	assert.Equal(t, 1, history[1].Version)
+
+	for version, name := range map[string]string{"1": "a", "2": "b"} {
+		udep, err := b.ExportDeploymentForVersion(ctx, foo, version)
+		require.NoError(t, err)
+		var dep apitype.DeploymentV3
+		require.NoError(t, json.Unmarshal(udep.Deployment, &dep))
+		require.Len(t, dep.Resources, 1)
+		assert.Equal(t, name, string(dep.Resources[0].URN.Name()))
+	}
+
+	_, err = b.ExportDeploymentForVersion(ctx, foo, "3")
+	assert.ErrorContains(t, err, "has no version 3")
+	_, err = b.ExportDeploymentForVersion(ctx, foo, "latest")
+	assert.ErrorContains(t, err, "not a valid stack version")
+}
+
+func TestRollbackDeployment(t *testing.T) {
+	t.Parallel()
+
+	stateDir := t.TempDir()
+	ctx := context.Background()
+	b, err := newLocalBackend(
+		ctx,
+		diagtest.LogSink(t), "file://"+filepath.ToSlash(stateDir),
+		&workspace.Project{Name: "testproj"},
+		nil,
+	)
+	require.NoError(t, err)
+
+	fooRef, err := b.parseStackReference("foo")
+	require.NoError(t, err)
+	foo, err := b.CreateStack(ctx, fooRef, "", nil)
+	require.NoError(t, err)
+
+	for _, name := range []tokens.QName{"a", "b"} {
+		data, err := json.Marshal(apitype.DeploymentV3{
+			Resources: []apitype.ResourceV3{{
+				URN:  resource.NewURN("foo", "testproj", "", "a:b:c", name),
+				Type: "a:b:c",
+			}},
+		})
+		require.NoError(t, err)
+		err = b.ImportDeployment(ctx, foo, &apitype.UntypedDeployment{Version: 3, Deployment: data})
+		require.NoError(t, err)
+		err = b.addToHistory(ctx, fooRef, backend.UpdateInfo{Kind: apitype.UpdateUpdate})
+		require.NoError(t, err)
+	}
+
+	// Rolling back takes the stack's lock, so it fails while another process holds it.
+	other, err := New(ctx, diagtest.LogSink(t), "file://"+filepath.ToSlash(stateDir), &workspace.Project{Name: "testproj"})
+	require.NoError(t, err)
+	require.NoError(t, other.(*localBackend).Lock(ctx, fooRef))
+	assert.ErrorContains(t, b.RollbackDeployment(ctx, foo, "1"), "the stack is currently locked")
+	other.(*localBackend).Unlock(ctx, fooRef)
+
+	require.NoError(t, b.RollbackDeployment(ctx, foo, "1"))
+	assert.False(t, b.holdsLock(fooRef))
+
+	// The first version's resources are now the stack's current state.
+	foo, err = b.GetStack(ctx, fooRef)
+	require.NoError(t, err)
+	snap, err := foo.Snapshot(ctx, stack.DefaultSecretsProvider)
+	require.NoError(t, err)
+	require.Len(t, snap.Resources, 1)
+	assert.Equal(t, "a", string(snap.Resources[0].URN.Name()))
+
+	// And the rollback is recorded as a new version.
+	history, err := b.GetHistory(ctx, fooRef, 0, 0)
+	require.NoError(t, err)
+	require.Len(t, history, 3)
+	assert.Equal(t, 3, history[0].Version)
+	assert.Equal(t, apitype.StackImportUpdate, history[0].Kind)
+	assert.Equal(t, "1", history[0].Environment[backend.RollbackVersion])
+
+	udep, err := b.ExportDeploymentForVersion(ctx, foo, "3")
+	require.NoError(t, err)
+	var dep apitype.DeploymentV3
+	require.NoError(t, json.Unmarshal(udep.Deployment, &dep))
+	require.Len(t, dep.Resources, 1)
+	assert.Equal(t, "a", string(dep.Resources[0].URN.Name()))
+}
+
+func TestPruneHistory(t *testing.T) {
+	t.Parallel()
+
+	stateDir := t.TempDir()
+	ctx := context.Background()
+	b, err := newLocalBackend(
+		ctx,
+		diagtest.LogSink(t), "file://"+filepath.ToSlash(stateDir),
+		&workspace.Project{Name: "testproj"},
+		nil,
+	)
+	require.NoError(t, err)
+
+	fooRef, err := b.parseStackReference("foo")
+	require.NoError(t, err)
+	foo, err := b.CreateStack(ctx, fooRef, "", nil)
+	require.NoError(t, err)
+
+	deployments := map[string]*apitype.UntypedDeployment{}
+	importDeployment := func(name string) {
+		require.NoError(t, b.ImportDeployment(ctx, foo, deployments[name]))
+	}
+
+	// Fake up four updates that finished an hour apart, each with a different resource in their checkpoint.
+	now := time.Now()
+	for i, name := range []tokens.QName{"a", "b", "c", "d"} {
+		data, err := json.Marshal(apitype.DeploymentV3{
+			Resources: []apitype.ResourceV3{{
+				URN:  resource.NewURN("foo", "testproj", "", "a:b:c", name),
+				Type: "a:b:c",
+			}},
+		})
+		require.NoError(t, err)
+		deployments[string(name)] = &apitype.UntypedDeployment{Version: 3, Deployment: data}
+		importDeployment(string(name))
+		err = b.addToHistory(ctx, fooRef, backend.UpdateInfo{
+			Kind:    apitype.UpdateUpdate,
+			EndTime: now.Add(time.Duration(i-4) * time.Hour).Unix(),
+		})
+		require.NoError(t, err)
+	}
+
+	versions := func() []int {
+		history, err := b.GetHistory(ctx, fooRef, 0, 0)
+		require.NoError(t, err)
+		var versions []int
+		for _, update := range history {
+			versions = append(versions, update.Version)
+		}
+		return versions
+	}
+	prunedVersions := func(pruned []backend.UpdateInfo) []int {
+		var versions []int
+		for _, update := range pruned {
+			versions = append(versions, update.Version)
+		}
+		return versions
+	}
+
+	// A dry run doesn't remove anything.
+	pruned, err := b.PruneHistory(ctx, foo, backend.HistoryRetention{KeepUpdates: 2}, true /*dryRun*/)
+	require.NoError(t, err)
+	assert.Equal(t, []int{2, 1}, prunedVersions(pruned))
+	assert.Equal(t, []int{4, 3, 2, 1}, versions())
+
+	// Pruning keeps the versions of the remaining updates.
+	pruned, err = b.PruneHistory(ctx, foo, backend.HistoryRetention{KeepUpdates: 3}, false /*dryRun*/)
+	require.NoError(t, err)
+	assert.Equal(t, []int{1}, prunedVersions(pruned))
+	assert.Equal(t, []int{4, 3, 2}, versions())
+	_, err = b.ExportDeploymentForVersion(ctx, foo, "1")
+	assert.ErrorContains(t, err, "has no version 1")
+	udep, err := b.ExportDeploymentForVersion(ctx, foo, "2")
+	require.NoError(t, err)
+	var dep apitype.DeploymentV3
+	require.NoError(t, json.Unmarshal(udep.Deployment, &dep))
+	require.Len(t, dep.Resources, 1)
+	assert.Equal(t, "b", string(dep.Resources[0].URN.Name()))
+
+	// The update matching the current state is never pruned, and neither are those after it.
+	importDeployment("b")
+	pruned, err = b.PruneHistory(ctx, foo, backend.HistoryRetention{KeepUpdates: 1}, false /*dryRun*/)
+	require.NoError(t, err)
+	assert.Empty(t, pruned)
+	assert.Equal(t, []int{4, 3, 2}, versions())
+
+	// Updates can be kept for a duration instead.
+	importDeployment("d")
+	pruned, err = b.PruneHistory(ctx, foo, backend.HistoryRetention{KeepFor: 150 * time.Minute}, false /*dryRun*/)
+	require.NoError(t, err)
+	assert.Equal(t, []int{2}, prunedVersions(pruned))
+	assert.Equal(t, []int{4, 3}, versions())
+
+	// New updates are numbered after the latest one.
+	require.NoError(t, b.addToHistory(ctx, fooRef, backend.UpdateInfo{Kind: apitype.UpdateUpdate}))
+	assert.Equal(t, []int{5, 4, 3}, versions())
+	historyDir := filepath.Join(stateDir, ".pulumi", "history", "testproj", "foo")
+	checkpoints, err := filepath.Glob(filepath.Join(historyDir, "*.checkpoint.json"))
+	require.NoError(t, err)
+	assert.Len(t, checkpoints, 3)
+}
+
+func TestImportUpdate(t *testing.T) {
+	t.Parallel()
+
+	stateDir := t.TempDir()
+	ctx := context.Background()
+	b, err := newLocalBackend(
+		ctx,
+		diagtest.LogSink(t), "file://"+filepath.ToSlash(stateDir),
+		&workspace.Project{Name: "testproj"},
+		nil,
+	)
+	require.NoError(t, err)
+
+	fooRef, err := b.parseStackReference("foo")
+	require.NoError(t, err)
+	foo, err := b.CreateStack(ctx, fooRef, "", nil)
+	require.NoError(t, err)
+
+	// The first imported update keeps its version, so that a pruned history keeps its numbering.
+	for _, name := range []tokens.QName{"a", "b"} {
+		data, err := json.Marshal(apitype.DeploymentV3{
+			Resources: []apitype.ResourceV3{{
+				URN:  resource.NewURN("foo", "testproj", "", "a:b:c", name),
+				Type: "a:b:c",
+			}},
+		})
+		require.NoError(t, err)
+		err = b.ImportUpdate(ctx, foo, backend.UpdateInfo{Kind: apitype.UpdateUpdate, Version: 7},
+			&apitype.UntypedDeployment{Version: 3, Deployment: data})
+		require.NoError(t, err)
+	}
+
+	history, err := b.GetHistory(ctx, fooRef, 0, 0)
+	require.NoError(t, err)
+	require.Len(t, history, 2)
+	assert.Equal(t, 8, history[0].Version)
+	assert.Equal(t, 7, history[1].Version)
+
+	udep, err := b.ExportDeploymentForVersion(ctx, foo, "7")
+	require.NoError(t, err)
+	var dep apitype.DeploymentV3
+	require.NoError(t, json.Unmarshal(udep.Deployment, &dep))
+	require.Len(t, dep.Resources, 1)
+	assert.Equal(t, "a", string(dep.Resources[0].URN.Name()))
+
+	// The stack's current state isn't changed.
+	snap, err := foo.Snapshot(ctx, stack.DefaultSecretsProvider)
+	require.NoError(t, err)
+	assert.True(t, snap == nil || len(snap.Resources) == 0)
+}
+
+func TestExportUpdateEvents(t *testing.T) {
+	t.Parallel()
+
+	stateDir := t.TempDir()
+	ctx := context.Background()
+	b, err := newLocalBackend(
+		ctx,
+		diagtest.LogSink(t), "file://"+filepath.ToSlash(stateDir),
+		&workspace.Project{Name: "testproj"},
+		nil,
+	)
+	require.NoError(t, err)
+
+	fooRef, err := b.parseStackReference("foo")
+	require.NoError(t, err)
+	foo, err := b.CreateStack(ctx, fooRef, "", nil)
+	require.NoError(t, err)
+
+	// Updates run by the backend record their events, but other entries in the history don't.
+	// Events are written in batches as they're received.
+	pathPrefix := historyPathPrefix(fooRef)
+	events, done := make(chan engine.Event), make(chan bool)
+	go b.persistEngineEvents(ctx, pathPrefix, nil, events, done)
+	for i := 0; i < 60; i++ {
+		message := fmt.Sprintf("hello %d", i)
+		events <- engine.NewEvent(engine.StdoutColorEvent, engine.StdoutEventPayload{Message: message, Color: colors.Never})
+	}
+	events <- engine.NewEvent(engine.CancelEvent, nil)
+	close(events)
+	<-done
+	info := backend.UpdateInfo{Kind: apitype.UpdateUpdate}
+	require.NoError(t, b.writeHistoryEntry(ctx, fooRef, info, nil, pathPrefix))
+	data, err := json.Marshal(apitype.DeploymentV3{
+		Resources: []apitype.ResourceV3{{
+			URN:  resource.NewURN("foo", "testproj", "", "a:b:c", "a"),
+			Type: "a:b:c",
+		}},
+	})
+	require.NoError(t, err)
+	require.NoError(t, b.ImportDeployment(ctx, foo, &apitype.UntypedDeployment{Version: 3, Deployment: data}))
+	require.NoError(t, b.addToHistory(ctx, fooRef, backend.UpdateInfo{Kind: apitype.RefreshUpdate}))
+
+	actual, err := b.ExportUpdateEvents(ctx, foo, "1")
+	require.NoError(t, err)
+	require.Len(t, actual, 61)
+	for i, e := range actual[:60] {
+		assert.Equal(t, i, e.Sequence)
+		assert.NotZero(t, e.Timestamp)
+		require.NotNil(t, e.StdoutEvent)
+		assert.Equal(t, fmt.Sprintf("hello %d", i), e.StdoutEvent.Message)
+	}
+	assert.NotNil(t, actual[60].CancelEvent)
+
+	_, err = b.ExportUpdateEvents(ctx, foo, "2")
+	assert.ErrorContains(t, err, "no engine events were recorded for version 2")
+	_, err = b.ExportUpdateEvents(ctx, foo, "3")
+	assert.ErrorContains(t, err, "has no version 3")
+	_, err = b.ExportUpdateEvents(ctx, foo, "latest")
+	assert.ErrorContains(t, err, "not a valid stack version")
+
+	// The events are pruned along with their update.
+	historyDir := filepath.Join(stateDir, ".pulumi", "history", "testproj", "foo")
+	eventFiles := func() []string {
+		files, err := filepath.Glob(filepath.Join(historyDir, "*.events.*.json"))
+		require.NoError(t, err)
+		return files
+	}
+	assert.Len(t, eventFiles(), 2)
+	_, err = b.PruneHistory(ctx, foo, backend.HistoryRetention{KeepUpdates: 1}, false /*dryRun*/)
+	require.NoError(t, err)
+	assert.Empty(t, eventFiles())
+}
+
+func TestSetHistoryRetention(t *testing.T) {
+	t.Parallel()
+
+	stateDir := t.TempDir()
+	ctx := context.Background()
+	project := &workspace.Project{Name: "testproj"}
+	b, err := newLocalBackend(ctx, diagtest.LogSink(t), "file://"+filepath.ToSlash(stateDir), project, nil)
+	require.NoError(t, err)
+
+	policy := backend.HistoryRetention{KeepUpdates: 10, KeepFor: 24 * time.Hour}
+	require.NoError(t, b.SetHistoryRetention(ctx, policy))
+	meta, err := os.ReadFile(filepath.Join(stateDir, ".pulumi", "meta.yaml"))
+	require.NoError(t, err)
+	assert.Contains(t, string(meta), "keepFor: 24h0m0s")
+
+	// The policy is stored in the backend, so it applies to new backends as well.
+	b2, err := New(ctx, diagtest.LogSink(t), "file://"+filepath.ToSlash(stateDir), project)
+	require.NoError(t, err)
+	actual, err := b2.(backend.HistoryPruner).HistoryRetention(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, policy, actual)
+
+	// The legacy layout has nowhere to store it.
+	legacyDir := t.TempDir()
+	b3, err := newLocalBackend(ctx, diagtest.LogSink(t), "file://"+filepath.ToSlash(legacyDir), project,
+		&localBackendOptions{
+			Getenv: mapGetenv(map[string]string{
+				"PULUMI_SELF_MANAGED_STATE_LEGACY_LAYOUT": "1",
+			}),
+		})
+	require.NoError(t, err)
+	err = b3.SetHistoryRetention(ctx, policy)
+	assert.ErrorContains(t, err, "run 'pulumi state upgrade' first")
+}
+
+func TestStackTags(t *testing.T) {
+	t.Parallel()
+
+	stateDir := t.TempDir()
+	ctx := context.Background()
+	b, err := newLocalBackend(
+		ctx,
+		diagtest.LogSink(t), "file://"+filepath.ToSlash(stateDir),
+		&workspace.Project{Name: "testproj", Runtime: workspace.NewProjectRuntimeInfo("go", nil)},
+		nil,
+	)
+	require.NoError(t, err)
+	assert.True(t, b.SupportsTags())
+
+	fooRef, err := b.parseStackReference("foo")
+	require.NoError(t, err)
+	foo, err := b.CreateStack(ctx, fooRef, "", nil)
+	require.NoError(t, err)
+	barRef, err := b.parseStackReference("bar")
+	require.NoError(t, err)
+	_, err = b.CreateStack(ctx, barRef, "", nil)
+	require.NoError(t, err)
+
+	// New stacks get tags from the project.
+	assert.Equal(t, "testproj", foo.Tags()[apitype.ProjectNameTag])
+	assert.Equal(t, "go", foo.Tags()[apitype.ProjectRuntimeTag])
+
+	tags := foo.Tags()
+	tags["owner"] = "platform"
+	require.NoError(t, b.UpdateStackTags(ctx, foo, tags))
+
+	foo, err = b.GetStack(ctx, fooRef)
+	require.NoError(t, err)
+	assert.Equal(t, "platform", foo.Tags()["owner"])
+
+	tagName, tagValue := "owner", "platform"
+	stacks, _, err := b.ListStacks(ctx, backend.ListStacksFilter{TagName: &tagName, TagValue: &tagValue}, nil)
+	require.NoError(t, err)
+	require.Len(t, stacks, 1)
+	assert.Equal(t, "foo", stacks[0].Name().Name().String())
+
+	projectTag := string(apitype.ProjectNameTag)
+	stacks, _, err = b.ListStacks(ctx, backend.ListStacksFilter{TagName: &projectTag}, nil)
+	require.NoError(t, err)
+	assert.Len(t, stacks, 2)
+
+	// Tags move with the stack when it's renamed, and are removed with it.
+	bazRefI, err := b.RenameStack(ctx, foo, "baz")
+	require.NoError(t, err)
+	baz, err := b.GetStack(ctx, bazRefI)
+	require.NoError(t, err)
+	assert.Equal(t, "platform", baz.Tags()["owner"])
+	assert.NoFileExists(t, filepath.Join(stateDir, ".pulumi", "tags", "testproj", "foo.json"))
+
+	_, err = b.RemoveStack(ctx, baz, false)
+	require.NoError(t, err)
+	assert.NoFileExists(t, filepath.Join(stateDir, ".pulumi", "tags", "testproj", "baz.json"))
+
+	// Tags are validated, since there's no service to do it.
+	bar, err := b.GetStack(ctx, barRef)
+	require.NoError(t, err)
+	assert.Error(t, b.UpdateStackTags(ctx, bar, map[apitype.StackTagName]string{"": "empty"}))
+}
+
+//nolint:paralleltest // mutates environment variables
+func TestPolicyConfiguration(t *testing.T) {
+	t.Setenv("PULUMI_HOME", t.TempDir())
+
+	stateDir := t.TempDir()
+	ctx := context.Background()
+	b, err := newLocalBackend(
+		ctx,
+		diagtest.LogSink(t), "file://"+filepath.ToSlash(stateDir),
+		&workspace.Project{Name: "testproj"},
+		nil,
+	)
+	require.NoError(t, err)
+
+	fooRef, err := b.parseStackReference("foo")
+	require.NoError(t, err)
+	_, err = b.CreateStack(ctx, fooRef, "", nil)
+	require.NoError(t, err)
+	barRef, err := b.parseStackReference("bar")
+	require.NoError(t, err)
+	_, err = b.CreateStack(ctx, barRef, "", nil)
+	require.NoError(t, err)
+
+	packDir := filepath.Join(t.TempDir(), "security")
+	require.NoError(t, os.MkdirAll(packDir, 0o700))
+	require.NoError(t, os.WriteFile(filepath.Join(packDir, "PulumiPolicy.yaml"), []byte("runtime: yaml\n"), 0o600))
+	otherDir := filepath.Join(t.TempDir(), "cost")
+	require.NoError(t, os.MkdirAll(otherDir, 0o700))
+	require.NoError(t, os.WriteFile(filepath.Join(otherDir, "PulumiPolicy.yaml"), []byte("runtime: yaml\n"), 0o600))
+
+	// Without any configuration, no policies are required.
+	required, err := b.getRequiredPolicies(ctx, fooRef)
+	require.NoError(t, err)
+	assert.Empty(t, required)
+
+	security, err := b.GetPolicyPack(ctx, packDir, diagtest.LogSink(t))
+	require.NoError(t, err)
+	assert.Equal(t, "security", security.Ref().Name().String())
+	version := "1.0.0"
+	assert.Error(t, security.Enable(ctx, "", backend.PolicyPackOperation{VersionTag: &version}))
+
+	raw := json.RawMessage(`{"enforcementLevel":"mandatory"}`)
+	packConfig := map[string]*json.RawMessage{"no-public-buckets": &raw}
+	require.NoError(t, security.Enable(ctx, "", backend.PolicyPackOperation{Config: packConfig}))
+
+	cost, err := b.GetPolicyPack(ctx, otherDir, diagtest.LogSink(t))
+	require.NoError(t, err)
+	require.NoError(t, cost.Enable(ctx, "foo", backend.PolicyPackOperation{}))
+
+	// The default group applies to every stack, and the stack's own group only to it.
+	required, err = b.getRequiredPolicies(ctx, fooRef)
+	require.NoError(t, err)
+	require.Len(t, required, 2)
+	assert.Equal(t, "security", required[0].Name())
+	require.Contains(t, required[0].Config(), "no-public-buckets")
+	assert.JSONEq(t, string(raw), string(*required[0].Config()["no-public-buckets"]))
+	assert.Equal(t, "cost", required[1].Name())
+
+	required, err = b.getRequiredPolicies(ctx, barRef)
+	require.NoError(t, err)
+	require.Len(t, required, 1)
+	assert.Equal(t, "security", required[0].Name())
+
+	groups, _, err := b.ListPolicyGroups(ctx, "", nil)
+	require.NoError(t, err)
+	assert.Equal(t, []apitype.PolicyGroupSummary{
+		{Name: apitype.DefaultPolicyGroup, IsOrgDefault: true, NumStacks: 2, NumEnabledPolicyPacks: 1},
+		{Name: "organization/testproj/foo", NumStacks: 1, NumEnabledPolicyPacks: 1},
+	}, groups.PolicyGroups)
+
+	packs, _, err := b.ListPolicyPacks(ctx, "", nil)
+	require.NoError(t, err)
+	require.Len(t, packs.PolicyPacks, 2)
+	assert.Equal(t, "security", packs.PolicyPacks[0].Name)
+	assert.Equal(t, "cost", packs.PolicyPacks[1].Name)
+	assert.Equal(t, []string{required[0].Version()}, packs.PolicyPacks[0].VersionTags)
+
+	// Disabling a pack stops it being required, and it can't be disabled twice.
+	require.NoError(t, cost.Disable(ctx, "foo", backend.PolicyPackOperation{}))
+	assert.Error(t, cost.Disable(ctx, "foo", backend.PolicyPackOperation{}))
+	required, err = b.getRequiredPolicies(ctx, fooRef)
+	require.NoError(t, err)
+	require.Len(t, required, 1)
+
+	// The pack is installed from the bucket, so it doesn't need to be where it was enabled from.
+	require.NoError(t, os.RemoveAll(packDir))
+	path, err := required[0].Install(ctx)
+	require.NoError(t, err)
+	assert.FileExists(t, filepath.Join(path, "PulumiPolicy.yaml"))
+	again, err := required[0].Install(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, path, again)
+
+	// Packs that have been removed from the bucket fail to install.
+	t.Setenv("PULUMI_HOME", t.TempDir())
+	require.NoError(t, os.RemoveAll(filepath.Join(stateDir, PolicyPacksDir)))
+	_, err = required[0].Install(ctx)
+	assert.ErrorContains(t, err, "is missing from the backend")
+}
+
+func TestMemBackend(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	project := &workspace.Project{Name: "testproj"}
+	assert.True(t, IsFileStateBackendURL("mem://"))
+
+	b, err := New(ctx, diagtest.LogSink(t), "mem://TestMemBackend", project)
+	require.NoError(t, err)
+	ref, err := b.ParseStackReference("dev")
+	require.NoError(t, err)
+	_, err = b.CreateStack(ctx, ref, "", nil)
+	require.NoError(t, err)
+
+	// Backends opened with the same URL share their state.
+	same, err := New(ctx, diagtest.LogSink(t), "mem://TestMemBackend", project)
+	require.NoError(t, err)
+	stacks, _, err := same.ListStacks(ctx, backend.ListStacksFilter{}, nil)
+	require.NoError(t, err)
+	require.Len(t, stacks, 1)
+	assert.Equal(t, "dev", stacks[0].Name().Name().String())
+
+	require.NoError(t, b.(*localBackend).Lock(ctx, ref))
+	assert.Error(t, same.(*localBackend).checkForLock(ctx, ref))
+	b.(*localBackend).Unlock(ctx, ref)
+
+	// Backends opened with other URLs don't.
+	other, err := New(ctx, diagtest.LogSink(t), "mem://TestMemBackend-other", project)
+	require.NoError(t, err)
+	stacks, _, err = other.ListStacks(ctx, backend.ListStacksFilter{}, nil)
+	require.NoError(t, err)
+	assert.Empty(t, stacks)
+
+	// They can't be logged in to, since their state wouldn't outlive the command.
+	_, err = Login(ctx, diagtest.LogSink(t), "mem://TestMemBackend", project)
+	assert.ErrorIs(t, err, ErrMemBackendLogin)
+}
+
+type testRegisterResourceEvent struct {
+	deploy.SourceEvent
+}
+
+func (testRegisterResourceEvent) Goal() *resource.Goal               { return nil }
+func (testRegisterResourceEvent) Done(result *deploy.RegisterResult) {}
+
+func TestJournalRecovery(t *testing.T) {
+	t.Parallel()
+
+	stateDir := t.TempDir()
+	ctx := context.Background()
+	project := &workspace.Project{Name: "testproj"}
+	b, err := newLocalBackend(ctx, diagtest.LogSink(t), "file://"+filepath.ToSlash(stateDir), project,
+		&localBackendOptions{
+			Getenv: mapGetenv(map[string]string{
+				"PULUMI_SELF_MANAGED_STATE_JOURNAL": "true",
+			}),
+		})
+	require.NoError(t, err)
+
+	fooRef, err := b.parseStackReference("foo")
+	require.NoError(t, err)
+	_, err = b.CreateStack(ctx, fooRef, "", nil)
+	require.NoError(t, err)
+	base, _, err := b.getStack(ctx, fooRef)
+	require.NoError(t, err)
+
+	// Start an update that creates a resource, but never finishes.
+	require.NoError(t, b.Lock(ctx, fooRef))
+	persister := b.newSnapshotPersister(ctx, fooRef, b64.NewBase64SecretsManager())
+	manager := backend.NewSnapshotManager(persister, base)
+	res := &resource.State{
+		Type:    "a:b:c",
+		URN:     resource.NewURN("foo", "testproj", "", "a:b:c", "res"),
+		Inputs:  resource.PropertyMap{},
+		Outputs: resource.PropertyMap{"foo": resource.NewStringProperty("bar")},
+	}
+	create := deploy.NewCreateStep(nil, testRegisterResourceEvent{}, res)
+	mutation, err := manager.BeginMutation(create)
+	require.NoError(t, err)
+	require.NoError(t, mutation.End(create, true))
+
+	// The checkpoint hasn't been rewritten, but the journal has the step.
+	snap, _, err := b.getStack(ctx, fooRef)
+	require.NoError(t, err)
+	assert.True(t, snap == nil || len(snap.Resources) == 0)
+	journal, err := filepath.Glob(filepath.Join(stateDir, ".pulumi", "journals", "testproj", "foo", "*.json"))
+	require.NoError(t, err)
+	assert.NotEmpty(t, journal)
+
+	// Another process can't recover the journal while the stack is locked.
+	other, err := New(ctx, diagtest.LogSink(t), "file://"+filepath.ToSlash(stateDir), project)
+	require.NoError(t, err)
+	snap, _, err = other.(*localBackend).getStack(ctx, fooRef)
+	require.NoError(t, err)
+	assert.True(t, snap == nil || len(snap.Resources) == 0)
+
+	// But once the lock is released without the update completing, it replays the journal, locking the stack while
+	// it does so.
+	b.Unlock(ctx, fooRef)
+	snap, _, err = other.(*localBackend).getStack(ctx, fooRef)
+	require.NoError(t, err)
+	require.Len(t, snap.Resources, 1)
+	assert.Equal(t, res.URN, snap.Resources[0].URN)
+	assert.Equal(t, resource.NewStringProperty("bar"), snap.Resources[0].Outputs["foo"])
+	assert.False(t, other.(*localBackend).holdsLock(fooRef))
+	assert.NoError(t, b.checkForLock(ctx, fooRef))
+
+	journal, err = filepath.Glob(filepath.Join(stateDir, ".pulumi", "journals", "testproj", "foo", "*.json"))
+	require.NoError(t, err)
+	assert.Empty(t, journal)
+}
+
+func TestJournalCompaction(t *testing.T) {
+	t.Parallel()
+
+	stateDir := t.TempDir()
+	ctx := context.Background()
+	b, err := newLocalBackend(ctx, diagtest.LogSink(t), "file://"+filepath.ToSlash(stateDir),
+		&workspace.Project{Name: "testproj"},
+		&localBackendOptions{
+			Getenv: mapGetenv(map[string]string{
+				"PULUMI_SELF_MANAGED_STATE_JOURNAL": "true",
+			}),
+		})
+	require.NoError(t, err)
+
+	fooRef, err := b.parseStackReference("foo")
+	require.NoError(t, err)
+	_, err = b.CreateStack(ctx, fooRef, "", nil)
+	require.NoError(t, err)
+	base, _, err := b.getStack(ctx, fooRef)
+	require.NoError(t, err)
+
+	persister := b.newSnapshotPersister(ctx, fooRef, b64.NewBase64SecretsManager())
+	manager := backend.NewSnapshotManager(persister, base)
+	res := &resource.State{
+		Type:   "a:b:c",
+		URN:    resource.NewURN("foo", "testproj", "", "a:b:c", "res"),
+		Inputs: resource.PropertyMap{},
+	}
+	create := deploy.NewCreateStep(nil, testRegisterResourceEvent{}, res)
+	mutation, err := manager.BeginMutation(create)
+	require.NoError(t, err)
+	require.NoError(t, mutation.End(create, true))
+
+	// Closing the manager compacts the journal into the checkpoint.
+	require.NoError(t, manager.Close())
+	journal, err := filepath.Glob(filepath.Join(stateDir, ".pulumi", "journals", "testproj", "foo", "*"))
+	require.NoError(t, err)
+	assert.Empty(t, journal)
+
+	snap, _, err := b.getStack(ctx, fooRef)
+	require.NoError(t, err)
+	require.Len(t, snap.Resources, 1)
+	assert.Equal(t, res.URN, snap.Resources[0].URN)
+}
@@ -0,0 +1,525 @@
+// Copyright 2016-2023, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filestate
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"time"
+
+	"gocloud.dev/gcerrors"
+
+	"github.com/pulumi/pulumi/pkg/v3/resource/stack"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/apitype"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/encoding"
+)
+
+// encryptedFile is the envelope in which checkpoints, history and backups are stored when the backend encrypts its
+// state. The file is encrypted with a random data key, and the data key is encrypted with the stack's secrets
+// manager. The state of the secrets manager is stored in the clear, so that it can be recreated to decrypt the key.
+// Files are compressed, if the backend compresses its state, before they're encrypted.
+type encryptedFile struct {
+	Encrypted encryptedContent `json:"encrypted"`
+	// Summary is the summary of an encrypted checkpoint, which is stored in the clear so that stacks can be listed
+	// without decrypting their checkpoints.
+	Summary *checkpointSummary `json:"summary,omitempty"`
+}
+
+type encryptedContent struct {
+	// SecretsProviders is the secrets manager that encrypted the data key.
+	SecretsProviders apitype.SecretsProvidersV1 `json:"secretsProviders"`
+	// Key is the data key, encrypted by the secrets manager.
+	Key string `json:"key"`
+	// Nonce and Ciphertext are the file, encrypted with the data key using AES-256-GCM.
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// checkpointSummary is the part of a checkpoint that's shown when stacks are listed.
+type checkpointSummary struct {
+	LastUpdate    *time.Time `json:"lastUpdate,omitempty"`
+	ResourceCount *int       `json:"resourceCount,omitempty"`
+}
+
+// summarizeCheckpoint returns the summary of the given checkpoint, which may be of any version.
+func summarizeCheckpoint(checkpoint *apitype.VersionedCheckpoint) (*checkpointSummary, error) {
+	var chk struct {
+		Latest *struct {
+			Manifest struct {
+				Time time.Time `json:"time"`
+			} `json:"manifest"`
+			Resources []json.RawMessage `json:"resources"`
+		} `json:"latest"`
+	}
+	if len(checkpoint.Checkpoint) == 0 {
+		return &checkpointSummary{}, nil
+	}
+	if err := json.Unmarshal(checkpoint.Checkpoint, &chk); err != nil {
+		return nil, fmt.Errorf("reading checkpoint: %w", err)
+	}
+
+	var summary checkpointSummary
+	if chk.Latest != nil {
+		if t := chk.Latest.Manifest.Time; !t.IsZero() {
+			summary.LastUpdate = &t
+		}
+		count := len(chk.Latest.Resources)
+		summary.ResourceCount = &count
+	}
+	return &summary, nil
+}
+
+// encryptedFilePrefix starts every encrypted file, which lets readers tell them apart from plaintext JSON without
+// parsing them.
+var encryptedFilePrefix = []byte(`{"encrypted":`)
+
+// isEncrypted returns true if the given file is encrypted.
+func isEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, encryptedFilePrefix)
+}
+
+// dataKey is a key that files are encrypted with, along with its encrypted form.
+type dataKey struct {
+	key       []byte
+	encrypted string
+}
+
+// stateMarshaler marshals the files of a backend's state. Files are compressed if gzip is set, and then encrypted with
+// the given secrets providers, if any. Compressed and encrypted files are decoded when they're unmarshaled.
+type stateMarshaler struct {
+	ctx       context.Context
+	b         *localBackend
+	inner     encoding.Marshaler
+	providers *apitype.SecretsProvidersV1
+	gzip      bool
+
+	// summary is stored in the clear with the file if it's encrypted. It's set for the stack's checkpoint.
+	summary *checkpointSummary
+}
+
+// stateMarshaler returns a marshaler for files of the backend's state. If gzip is set, files are compressed, and if
+// the backend encrypts its state and providers is not nil, they're then encrypted with it.
+func (b *localBackend) stateMarshaler(
+	ctx context.Context, inner encoding.Marshaler, providers *apitype.SecretsProvidersV1, gzip bool,
+) *stateMarshaler {
+	if !b.encrypt {
+		providers = nil
+	}
+	return &stateMarshaler{ctx: ctx, b: b, inner: inner, providers: providers, gzip: gzip}
+}
+
+func (m *stateMarshaler) Marshal(v interface{}) ([]byte, error) {
+	data, err := m.inner.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return m.b.encodeStateFile(m.ctx, &stateFile{
+		data:       data,
+		compressed: m.gzip,
+		providers:  m.providers,
+		summary:    m.summary,
+	})
+}
+
+func (m *stateMarshaler) Unmarshal(data []byte, v interface{}) error {
+	file, err := m.b.decodeStateFile(m.ctx, data)
+	if err != nil {
+		return err
+	}
+	return m.inner.Unmarshal(file.data, v)
+}
+
+// stateFile is the content of a file of the backend's state, along with how it's stored.
+type stateFile struct {
+	// data is the content of the file, uncompressed and decrypted.
+	data []byte
+	// compressed is true if the file is compressed.
+	compressed bool
+	// providers are the secrets providers the file is encrypted with, if it's encrypted.
+	providers *apitype.SecretsProvidersV1
+	// summary is the summary stored with an encrypted checkpoint, if any.
+	summary *checkpointSummary
+}
+
+// encodeStateFile returns the bytes to store for a file of the backend's state. The file is compressed before it's
+// encrypted, since ciphertext can't be compressed.
+func (b *localBackend) encodeStateFile(ctx context.Context, file *stateFile) ([]byte, error) {
+	data := file.data
+	if file.compressed {
+		var err error
+		if data, err = gzipData(data); err != nil {
+			return nil, err
+		}
+	}
+	if file.providers == nil {
+		return data, nil
+	}
+	return b.encryptFile(ctx, data, file.providers, file.summary)
+}
+
+// decodeStateFile decodes the stored bytes of a file of the backend's state. Files that were encrypted before they
+// were compressed, as older versions of the CLI stored them, are decoded too.
+func (b *localBackend) decodeStateFile(ctx context.Context, data []byte) (*stateFile, error) {
+	file := &stateFile{}
+	for {
+		switch {
+		case encoding.IsCompressed(data):
+			var err error
+			if data, err = gunzip(data); err != nil {
+				return nil, err
+			}
+			file.compressed = true
+		case isEncrypted(data):
+			var envelope encryptedFile
+			if err := json.Unmarshal(data, &envelope); err != nil {
+				return nil, fmt.Errorf("reading encrypted file: %w", err)
+			}
+			plaintext, err := b.decryptFile(ctx, &envelope.Encrypted)
+			if err != nil {
+				return nil, err
+			}
+			data = plaintext
+			file.providers, file.summary = &envelope.Encrypted.SecretsProviders, envelope.Summary
+		default:
+			file.data = data
+			return file, nil
+		}
+	}
+}
+
+// encryptFile encrypts the given file with the secrets providers.
+func (b *localBackend) encryptFile(
+	ctx context.Context, data []byte, providers *apitype.SecretsProvidersV1, summary *checkpointSummary,
+) ([]byte, error) {
+	key, err := b.sealingKey(ctx, providers)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(key.key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(encryptedFile{
+		Encrypted: encryptedContent{
+			SecretsProviders: *providers,
+			Key:              key.encrypted,
+			Nonce:            nonce,
+			Ciphertext:       gcm.Seal(nil, nonce, data, nil),
+		},
+		Summary: summary,
+	})
+}
+
+// decryptFile decrypts the content of an encrypted file.
+func (b *localBackend) decryptFile(ctx context.Context, content *encryptedContent) ([]byte, error) {
+	key, err := b.openingKey(ctx, &content.SecretsProviders, content.Key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := gcm.Open(nil, content.Nonce, content.Ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("decrypting file: %w", err)
+	}
+	return plaintext, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealingKey returns the data key that files encrypted with the given secrets providers are encrypted with. A new
+// key is made the first time the providers are used by this backend, and reused after that, so that a secrets
+// manager such as a KMS isn't called every time a checkpoint is saved.
+func (b *localBackend) sealingKey(ctx context.Context, providers *apitype.SecretsProvidersV1) (*dataKey, error) {
+	id, err := json.Marshal(providers)
+	if err != nil {
+		return nil, err
+	}
+
+	b.keysLock.Lock()
+	defer b.keysLock.Unlock()
+	if key, has := b.sealingKeys[string(id)]; has {
+		return key, nil
+	}
+
+	sm, err := stack.DefaultSecretsProvider.OfType(providers.Type, providers.State)
+	if err != nil {
+		return nil, fmt.Errorf("creating secrets manager: %w", err)
+	}
+	enc, err := sm.Encrypter()
+	if err != nil {
+		return nil, err
+	}
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, err
+	}
+	encrypted, err := enc.EncryptValue(ctx, base64.StdEncoding.EncodeToString(key))
+	if err != nil {
+		return nil, fmt.Errorf("encrypting data key: %w", err)
+	}
+
+	k := &dataKey{key: key, encrypted: encrypted}
+	b.sealingKeys[string(id)] = k
+	b.openingKeys[string(id)+"\x00"+encrypted] = key
+	return k, nil
+}
+
+// openingKey decrypts the data key of a file encrypted with the given secrets providers. Keys are remembered, since
+// every file written by a process is encrypted with the same key.
+func (b *localBackend) openingKey(
+	ctx context.Context, providers *apitype.SecretsProvidersV1, encrypted string,
+) ([]byte, error) {
+	id, err := json.Marshal(providers)
+	if err != nil {
+		return nil, err
+	}
+
+	b.keysLock.Lock()
+	defer b.keysLock.Unlock()
+	if key, has := b.openingKeys[string(id)+"\x00"+encrypted]; has {
+		return key, nil
+	}
+
+	sm, err := stack.DefaultSecretsProvider.OfType(providers.Type, providers.State)
+	if err != nil {
+		return nil, fmt.Errorf("creating secrets manager: %w", err)
+	}
+	dec, err := sm.Decrypter()
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := dec.DecryptValue(ctx, encrypted)
+	if err != nil {
+		return nil, fmt.Errorf("decrypting data key: %w", err)
+	}
+	key, err := base64.StdEncoding.DecodeString(plaintext)
+	if err != nil {
+		return nil, fmt.Errorf("decrypting data key: %w", err)
+	}
+
+	b.openingKeys[string(id)+"\x00"+encrypted] = key
+	return key, nil
+}
+
+// checkpointSecretsProviders returns the secrets providers of the given checkpoint, which files of its stack are
+// encrypted with. It returns nil if the checkpoint is empty, and has no secrets providers to encrypt with. Non-empty
+// checkpoints without secrets providers can't be written to a backend that encrypts its state.
+func (b *localBackend) checkpointSecretsProviders(
+	checkpoint *apitype.VersionedCheckpoint,
+) (*apitype.SecretsProvidersV1, error) {
+	if !b.encrypt || checkpoint == nil {
+		return nil, nil
+	}
+
+	var chk struct {
+		Latest *struct {
+			Resources         []json.RawMessage           `json:"resources"`
+			PendingOperations []json.RawMessage           `json:"pending_operations"`
+			SecretsProviders  *apitype.SecretsProvidersV1 `json:"secrets_providers"`
+		} `json:"latest"`
+	}
+	if err := json.Unmarshal(checkpoint.Checkpoint, &chk); err != nil {
+		return nil, fmt.Errorf("reading checkpoint: %w", err)
+	}
+	if chk.Latest == nil {
+		return nil, nil
+	}
+	if chk.Latest.SecretsProviders == nil || chk.Latest.SecretsProviders.Type == "" {
+		if len(chk.Latest.Resources) == 0 && len(chk.Latest.PendingOperations) == 0 {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("the state is encrypted, but the checkpoint has no secrets provider to encrypt it with")
+	}
+	return chk.Latest.SecretsProviders, nil
+}
+
+// stackSecretsProviders returns the secrets providers that the files of the given stack are encrypted with, which
+// are those of its current checkpoint. It returns nil if the stack has no secrets providers.
+func (b *localBackend) stackSecretsProviders(
+	ctx context.Context, ref *localBackendReference,
+) (*apitype.SecretsProvidersV1, error) {
+	if !b.encrypt {
+		return nil, nil
+	}
+
+	data, err := b.bucket.ReadAll(ctx, b.stackPath(ctx, ref))
+	if err != nil {
+		return nil, err
+	}
+	if encoding.IsCompressed(data) {
+		if data, err = gunzip(data); err != nil {
+			return nil, err
+		}
+	}
+	if isEncrypted(data) {
+		var file encryptedFile
+		if err := json.Unmarshal(data, &file); err != nil {
+			return nil, fmt.Errorf("reading encrypted file: %w", err)
+		}
+		return &file.Encrypted.SecretsProviders, nil
+	}
+
+	var checkpoint apitype.VersionedCheckpoint
+	if err := json.Unmarshal(data, &checkpoint); err != nil {
+		return nil, fmt.Errorf("reading checkpoint: %w", err)
+	}
+	return b.checkpointSecretsProviders(&checkpoint)
+}
+
+// gunzip decompresses gzip compressed data.
+func gunzip(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// gzipData compresses data with gzip.
+func gzipData(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// encryptStack encrypts the checkpoint, history and backups of a stack with the secrets providers of its current
+// checkpoint, or decrypts them if encrypt is false. Files that are already encrypted with other secrets providers are
+// encrypted again.
+func (b *localBackend) encryptStack(ctx context.Context, ref *localBackendReference, encrypt bool) error {
+	var providers *apitype.SecretsProvidersV1
+	if encrypt {
+		var err error
+		if providers, err = b.stackSecretsProviders(ctx, ref); err != nil {
+			return err
+		}
+	}
+
+	plainPath := filepath.ToSlash(ref.StackBasePath()) + ".json"
+	keys := []string{
+		plainPath, plainPath + ".bak",
+		plainPath + encoding.GZIPExt, plainPath + encoding.GZIPExt + ".bak",
+	}
+	for _, dir := range []string{ref.HistoryDir(), ref.BackupDir()} {
+		files, err := listBucket(ctx, b.bucket, dir)
+		if err != nil {
+			if gcerrors.Code(err) == gcerrors.NotFound {
+				continue
+			}
+			return err
+		}
+		for _, file := range files {
+			if !file.IsDir {
+				keys = append(keys, file.Key)
+			}
+		}
+	}
+
+	for _, key := range keys {
+		if err := b.encryptStateFile(ctx, key, providers); err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// encryptStateFile rewrites a file in the bucket to be encrypted with the given secrets providers, or decrypted if
+// they're nil, keeping its compression. Files that are already in the requested form, or don't exist, are left alone.
+func (b *localBackend) encryptStateFile(
+	ctx context.Context, key string, providers *apitype.SecretsProvidersV1,
+) error {
+	data, err := b.bucket.ReadAll(ctx, key)
+	if err != nil {
+		if gcerrors.Code(err) == gcerrors.NotFound {
+			return nil
+		}
+		return err
+	}
+	file, err := b.decodeStateFile(ctx, data)
+	if err != nil {
+		return err
+	}
+
+	if providers == nil {
+		if file.providers == nil {
+			return nil
+		}
+		file.summary = nil
+	} else {
+		// Files that were compressed after they were encrypted are rewritten to be compressed first.
+		if isEncrypted(data) && sameSecretsProviders(file.providers, providers) {
+			return nil
+		}
+		if file.summary == nil {
+			file.summary = summarizeStateFile(file.data)
+		}
+	}
+	file.providers = providers
+
+	if data, err = b.encodeStateFile(ctx, file); err != nil {
+		return err
+	}
+	return b.bucket.WriteAll(ctx, key, data, nil)
+}
+
+// summarizeStateFile returns the summary of the given file if it's a checkpoint, so that it can be stored with the
+// checkpoint when it's encrypted.
+func summarizeStateFile(data []byte) *checkpointSummary {
+	var checkpoint apitype.VersionedCheckpoint
+	if err := json.Unmarshal(data, &checkpoint); err != nil || len(checkpoint.Checkpoint) == 0 {
+		return nil
+	}
+	summary, err := summarizeCheckpoint(&checkpoint)
+	if err != nil {
+		return nil
+	}
+	return summary
+}
+
+// sameSecretsProviders returns true if the two secrets providers are the same.
+func sameSecretsProviders(a, b *apitype.SecretsProvidersV1) bool {
+	aJSON, aErr := json.Marshal(a)
+	bJSON, bErr := json.Marshal(b)
+	return aErr == nil && bErr == nil && bytes.Equal(aJSON, bJSON)
+}
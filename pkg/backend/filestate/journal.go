@@ -0,0 +1,184 @@
+// Copyright 2016-2023, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filestate
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"path/filepath"
+	"sort"
+
+	"gocloud.dev/gcerrors"
+
+	"github.com/pulumi/pulumi/pkg/v3/backend"
+	"github.com/pulumi/pulumi/pkg/v3/resource/stack"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/apitype"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/diag"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/encoding"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/contract"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/logging"
+)
+
+// The journal of an update is stored as a sequence of segments in the stack's JournalDir, each holding the entries
+// appended by one write. Segments are named after their zero-padded sequence number so that they sort in order.
+
+// journalSegmentPath returns the path of the segment of the journal with the given sequence number.
+func (b *localBackend) journalSegmentPath(ref *localBackendReference, seq int) string {
+	segment := path.Join(filepath.ToSlash(ref.JournalDir()), fmt.Sprintf("%010d.json", seq))
+	if b.gzip {
+		segment += encoding.GZIPExt
+	}
+	return segment
+}
+
+// appendJournal writes the segment of the journal with the given sequence number, encrypted with the given secrets
+// providers if the backend encrypts its state. Starting a journal marks it as in
+// progress, so that it isn't recovered by this process until it has been compacted.
+func (b *localBackend) appendJournal(
+	ctx context.Context,
+	ref *localBackendReference,
+	seq int,
+	entries []backend.JournalEntry,
+	providers *apitype.SecretsProvidersV1,
+) error {
+	contract.Requiref(ref != nil, "ref", "must not be nil")
+
+	if seq == 0 {
+		b.journalsLock.Lock()
+		b.journals[ref.JournalDir()] = true
+		b.journalsLock.Unlock()
+	}
+
+	bytes, err := b.stateMarshaler(ctx, encoding.JSON, providers, b.gzip).Marshal(entries)
+	if err != nil {
+		return err
+	}
+	return b.bucket.WriteAll(ctx, b.journalSegmentPath(ref, seq), bytes, nil)
+}
+
+// endJournal marks the journal of an update to the given stack as no longer in progress.
+func (b *localBackend) endJournal(ref *localBackendReference) {
+	b.journalsLock.Lock()
+	defer b.journalsLock.Unlock()
+	delete(b.journals, ref.JournalDir())
+}
+
+// journalInProgress returns true if this process is currently writing the journal of an update to the given stack.
+func (b *localBackend) journalInProgress(ref *localBackendReference) bool {
+	b.journalsLock.Lock()
+	defer b.journalsLock.Unlock()
+	return b.journals[ref.JournalDir()]
+}
+
+// listJournal returns the keys of the segments of the journal of the given stack, in order.
+func (b *localBackend) listJournal(ctx context.Context, ref *localBackendReference) ([]string, error) {
+	files, err := listBucket(ctx, b.bucket, filepath.ToSlash(ref.JournalDir()))
+	if err != nil {
+		if gcerrors.Code(err) == gcerrors.NotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var keys []string
+	for _, file := range files {
+		if !file.IsDir {
+			keys = append(keys, file.Key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// readJournal reads the entries in the given journal segments.
+func (b *localBackend) readJournal(ctx context.Context, keys []string) ([]backend.JournalEntry, error) {
+	var entries []backend.JournalEntry
+	for _, key := range keys {
+		bytes, err := b.bucket.ReadAll(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		var segment []backend.JournalEntry
+		if err := b.stateMarshaler(ctx, encoding.JSON, nil, false).Unmarshal(bytes, &segment); err != nil {
+			return nil, fmt.Errorf("reading journal segment %s: %w", key, err)
+		}
+		entries = append(entries, segment...)
+	}
+	return entries, nil
+}
+
+// removeJournal removes the journal of the given stack, if it has one.
+func (b *localBackend) removeJournal(ctx context.Context, ref *localBackendReference) error {
+	keys, err := b.listJournal(ctx, ref)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := b.bucket.Delete(ctx, key); err != nil && gcerrors.Code(err) != gcerrors.NotFound {
+			return fmt.Errorf("deleting journal segment: %w", err)
+		}
+	}
+	return nil
+}
+
+// recoverJournal replays the journal left behind by an update to the given stack that never completed, if there is
+// one, and saves the result as the stack's checkpoint so that no step the update completed is lost. Stacks are read
+// without being locked, so the stack is locked while the journal is replayed, unless this backend already holds its
+// lock. If another process holds it, the journal is left alone for now, since the update may still be running, or
+// another reader may be replaying it.
+func (b *localBackend) recoverJournal(ctx context.Context, ref *localBackendReference) error {
+	contract.Requiref(ref != nil, "ref", "must not be nil")
+
+	if b.journalInProgress(ref) {
+		return nil
+	}
+	keys, err := b.listJournal(ctx, ref)
+	if err != nil {
+		return fmt.Errorf("listing journal: %w", err)
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+
+	if !b.holdsLock(ref) {
+		if err := b.Lock(ctx, ref); err != nil {
+			logging.V(5).Infof("not recovering journal of locked stack %s: %v", ref, err)
+			return nil
+		}
+		defer b.Unlock(ctx, ref)
+
+		// The journal may have been replayed by another process before the lock was taken.
+		if keys, err = b.listJournal(ctx, ref); err != nil || len(keys) == 0 {
+			return err
+		}
+	}
+
+	entries, err := b.readJournal(ctx, keys)
+	if err != nil {
+		return err
+	}
+	snapshot, err := backend.ReplayJournal(ctx, entries, stack.DefaultSecretsProvider)
+	if err != nil {
+		return fmt.Errorf("replaying journal: %w", err)
+	}
+	if _, err := b.saveStack(ctx, ref, snapshot, snapshot.SecretsManager); err != nil {
+		return err
+	}
+	b.d.Warningf(diag.Message("", "Recovered the state of stack %q from the journal of an update that did not complete"),
+		ref.String())
+
+	return b.removeJournal(ctx, ref)
+}
@@ -19,12 +19,19 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/user"
 	"path"
 	"path/filepath"
+	"sort"
 	"time"
 
+	"cloud.google.com/go/storage"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"gocloud.dev/blob"
+	"gocloud.dev/gcerrors"
+
 	"github.com/pulumi/pulumi/pkg/v3/backend"
 	"github.com/pulumi/pulumi/sdk/v3/go/common/diag"
 	"github.com/pulumi/pulumi/sdk/v3/go/common/tokens"
@@ -33,14 +40,34 @@ import (
 	"github.com/pulumi/pulumi/sdk/v3/go/common/workspace"
 )
 
+// defaultLockLease is how long a stack lock remains valid without being renewed by the process holding it.
+const defaultLockLease = 5 * time.Minute
+
 type lockContent struct {
 	Pid       int       `json:"pid"`
 	Username  string    `json:"username"`
 	Hostname  string    `json:"hostname"`
 	Timestamp time.Time `json:"timestamp"`
+	// Expires is when the lock's lease runs out unless it's renewed. Locks taken by older versions of the CLI have no
+	// lease, and never expire.
+	Expires *time.Time `json:"expires,omitempty"`
+	// ID identifies the backend holding the lock. Locks taken by older versions of the CLI have no ID.
+	ID string `json:"id,omitempty"`
+
+	// version is the version of the lock file that the lock was read from.
+	version objectVersion
 }
 
-func newLockContent() (*lockContent, error) {
+// objectVersion identifies a version of a file in a bucket, for drivers that support conditional writes, so that a
+// file such as a lock file is only replaced if it hasn't changed since it was read.
+type objectVersion struct {
+	// generation is the generation of the file in a GCS bucket.
+	generation int64
+	// etag is the ETag of the file in an Azure container.
+	etag string
+}
+
+func newLockContent(lease time.Duration) (*lockContent, error) {
 	u, err := user.Current()
 	if err != nil {
 		return nil, err
@@ -49,51 +76,241 @@ func newLockContent() (*lockContent, error) {
 	if err != nil {
 		return nil, err
 	}
+	now := time.Now()
+	expires := now.Add(lease)
 	return &lockContent{
 		Pid:       os.Getpid(),
 		Username:  u.Username,
 		Hostname:  hostname,
-		Timestamp: time.Now(),
+		Timestamp: now,
+		Expires:   &expires,
 	}, nil
 }
 
-// checkForLock looks for any existing locks for this stack, and returns a helpful diagnostic if there is one.
-func (b *localBackend) checkForLock(ctx context.Context, stackRef backend.StackReference) error {
+// expired returns true if the lock's lease ran out before the given time.
+func (l *lockContent) expired(now time.Time) bool {
+	return l.Expires != nil && now.After(*l.Expires)
+}
+
+// StackLock describes a lock held on a stack by a process updating it.
+type StackLock struct {
+	// URL is the location of the lock file in the backend.
+	URL string
+	// Pid, Username and Hostname identify the process holding the lock.
+	Pid      int
+	Username string
+	Hostname string
+	// Timestamp is when the lock was taken.
+	Timestamp time.Time
+	// Expires is when the lock's lease runs out unless it's renewed, or nil if the lock never expires.
+	Expires *time.Time
+}
+
+// lease is a lock held by this backend, which is renewed in the background until it's released.
+type lease struct {
+	content *lockContent
+	// version is the version of the lock file last written, for drivers that support conditional writes.
+	version objectVersion
+
+	cancel context.CancelFunc
+	done   chan struct{}
+	// lost is closed if the lock is broken by another process while it's held.
+	lost chan struct{}
+}
+
+// bucketLocker is implemented by the drivers of buckets that lock stacks themselves, rather than with lock files.
+// Such locks have no lease; they're held until they're released, or broken by `pulumi cancel`.
+type bucketLocker interface {
+	// Lock takes the lock with the given key, failing with errStackLocked if another backend holds it.
+	Lock(ctx context.Context, key string, l *lockContent) error
+	// Unlock releases the lock with the given key. If l is nil, the lock is released whoever holds it.
+	Unlock(ctx context.Context, key string, l *lockContent) error
+	// ReadLock returns the lock with the given key, or nil if it isn't held.
+	ReadLock(ctx context.Context, key string) (*lockContent, error)
+}
+
+// lockerFor returns the locker of a bucket whose driver locks stacks itself, or nil if it uses lock files.
+func lockerFor(bucket Bucket) bucketLocker {
+	wrapped, ok := bucket.(*wrappedBucket)
+	if !ok {
+		return nil
+	}
+	var rest *restBucket
+	if wrapped.bucket.As(&rest) {
+		return rest
+	}
+	return nil
+}
+
+// readLocks reads the locks held on a stack by other processes.
+func (b *localBackend) readLocks(ctx context.Context, stackRef backend.StackReference) (map[string]*lockContent, error) {
 	stackName := stackRef.FullyQualifiedName()
+	if locker := lockerFor(b.bucket); locker != nil {
+		key := stackLockDir(stackName)
+		l, err := locker.ReadLock(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		locks := make(map[string]*lockContent)
+		if l != nil && l.ID != b.lockID {
+			locks[key] = l
+		}
+		return locks, nil
+	}
+
 	allFiles, err := listBucket(ctx, b.bucket, stackLockDir(stackName))
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// lockPath may return a path with backslashes (\) on Windows.
 	// We need to convert it to a slash path (/) to compare it to
 	// the keys in the bucket which are always slash paths.
 	wantLock := filepath.ToSlash(b.lockPath(stackRef))
-	var lockKeys []string
+	locks := make(map[string]*lockContent)
 	for _, file := range allFiles {
 		if file.IsDir {
 			continue
 		}
-		if file.Key != wantLock {
-			lockKeys = append(lockKeys, file.Key)
+
+		l, err := readLockFile(ctx, b.bucket, file.Key)
+		if err != nil {
+			// The lock was released between listing and reading it.
+			if gcerrors.Code(err) == gcerrors.NotFound {
+				continue
+			}
+			return nil, err
+		}
+		// Skip our own lock. The lock file may be shared with other backends, so it's only ours if it has our ID.
+		if l.ID == b.lockID || (l.ID == "" && file.Key == wantLock) {
+			continue
+		}
+		locks[file.Key] = l
+	}
+	return locks, nil
+}
+
+// readLockFile reads a lock file, along with its version where the bucket's driver supports conditional writes.
+func readLockFile(ctx context.Context, bucket Bucket, key string) (*lockContent, error) {
+	content, version, err := readVersioned(ctx, bucket, key)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &lockContent{}
+	if err := json.Unmarshal(content, l); err != nil {
+		return nil, err
+	}
+	l.version = version
+	return l, nil
+}
+
+// readVersioned reads a file from a bucket, along with its version where the bucket's driver supports conditional
+// writes.
+func readVersioned(ctx context.Context, bucket Bucket, key string) ([]byte, objectVersion, error) {
+	wrapped, ok := bucket.(*wrappedBucket)
+	if !ok {
+		content, err := bucket.ReadAll(ctx, key)
+		return content, objectVersion{}, err
+	}
+
+	r, err := wrapped.bucket.NewReader(ctx, filepath.ToSlash(key), nil)
+	if err != nil {
+		return nil, objectVersion{}, err
+	}
+	defer contract.IgnoreClose(r)
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return nil, objectVersion{}, err
+	}
+
+	var version objectVersion
+	var gcsReader *storage.Reader
+	var azureResponse azblob.BlobDownloadResponse
+	if r.As(&gcsReader) {
+		version.generation = gcsReader.Attrs.Generation
+	} else if r.As(&azureResponse) && azureResponse.ETag != nil {
+		version.etag = *azureResponse.ETag
+	}
+	return content, version, nil
+}
+
+// StackLocks returns the locks currently held on a stack, sorted by when they were taken.
+func (b *localBackend) StackLocks(ctx context.Context, stackRef backend.StackReference) ([]StackLock, error) {
+	locks, err := b.readLocks(ctx, stackRef)
+	if err != nil {
+		return nil, err
+	}
+	// Include our own lock, if we hold one.
+	b.leasesLock.Lock()
+	if l, has := b.leases[b.lockPath(stackRef)]; has {
+		locks[filepath.ToSlash(b.lockPath(stackRef))] = l.content
+	}
+	b.leasesLock.Unlock()
+
+	result := make([]StackLock, 0, len(locks))
+	for key, l := range locks {
+		result = append(result, StackLock{
+			URL:       b.url + "/" + key,
+			Pid:       l.Pid,
+			Username:  l.Username,
+			Hostname:  l.Hostname,
+			Timestamp: l.Timestamp,
+			Expires:   l.Expires,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Timestamp.Before(result[j].Timestamp)
+	})
+	return result, nil
+}
+
+// checkForLock looks for any existing locks for this stack, and returns a helpful diagnostic if there is one. Locks
+// whose lease has expired are broken, since the process that held them has stopped renewing them.
+func (b *localBackend) checkForLock(ctx context.Context, stackRef backend.StackReference) error {
+	_, err := b.checkLocks(ctx, stackRef)
+	return err
+}
+
+// checkLocks is checkForLock, except that an expired lock in this backend's own lock file, which is shared with other
+// backends where the bucket's driver supports conditional writes, isn't deleted. It's returned instead, so that the
+// caller can take it over by replacing that version of the lock file.
+func (b *localBackend) checkLocks(ctx context.Context, stackRef backend.StackReference) (*lockContent, error) {
+	locks, err := b.readLocks(ctx, stackRef)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	ownKey := filepath.ToSlash(b.lockPath(stackRef))
+	var expired *lockContent
+	var lockKeys []string
+	for key, l := range locks {
+		if !l.expired(now) {
+			lockKeys = append(lockKeys, key)
+			continue
+		}
+		if key == ownKey {
+			expired = l
+			continue
+		}
+
+		if err := b.breakLock(ctx, key, l); err != nil {
+			if !errors.Is(err, errLockChanged) {
+				return nil, err
+			}
+			// The lock was renewed since it was read, so it's still held.
+			lockKeys = append(lockKeys, key)
 		}
 	}
+	sort.Strings(lockKeys)
 
 	if len(lockKeys) > 0 {
 		errorString := fmt.Sprintf("the stack is currently locked by %v lock(s). Either wait for the other "+
 			"process(es) to end or delete the lock file with `pulumi cancel`.", len(lockKeys))
 
 		for _, lock := range lockKeys {
-			content, err := b.bucket.ReadAll(ctx, lock)
-			if err != nil {
-				return err
-			}
-			l := &lockContent{}
-			err = json.Unmarshal(content, &l)
-			if err != nil {
-				return err
-			}
-
+			l := locks[lock]
 			errorString += fmt.Sprintf("\n  %v: created by %v@%v (pid %v) at %v",
 				b.url+"/"+lock,
 				l.Username,
@@ -101,49 +318,382 @@ func (b *localBackend) checkForLock(ctx context.Context, stackRef backend.StackR
 				l.Pid,
 				l.Timestamp.Format(time.RFC3339),
 			)
+			if l.Expires != nil {
+				errorString += fmt.Sprintf(", expires at %v", l.Expires.Format(time.RFC3339))
+			}
 		}
 
-		return errors.New(errorString)
+		return nil, errors.New(errorString)
+	}
+	return expired, nil
+}
+
+// breakLock deletes the lock file of another process whose lease has expired. The lock file is read again first, and
+// isn't deleted if it has been renewed since the given lock was read from it. Buckets whose drivers support
+// conditional writes don't need this, since their lock files are taken over with a conditional write instead.
+func (b *localBackend) breakLock(ctx context.Context, key string, l *lockContent) error {
+	current, err := readLockFile(ctx, b.bucket, key)
+	if err != nil {
+		if gcerrors.Code(err) == gcerrors.NotFound {
+			return nil
+		}
+		return err
+	}
+	if current.ID != l.ID || current.version != l.version || !current.expired(time.Now()) {
+		return errLockChanged
+	}
+
+	b.warnBreakingLock(key, l)
+	if err := b.bucket.Delete(ctx, key); err != nil && gcerrors.Code(err) != gcerrors.NotFound {
+		return err
 	}
 	return nil
 }
 
-func (b *localBackend) Lock(ctx context.Context, stackRef backend.StackReference) error {
-	//
-	err := b.checkForLock(ctx, stackRef)
+// warnBreakingLock warns that the given expired lock is being broken.
+func (b *localBackend) warnBreakingLock(key string, l *lockContent) {
+	b.d.Warningf(
+		diag.Message("", "breaking the lock at %v created by %v@%v (pid %v), whose lease expired at %v"),
+		b.url+"/"+key, l.Username, l.Hostname, l.Pid, l.Expires.Format(time.RFC3339))
+}
+
+// writeLock writes a lock file held by this backend. If replace is nil, the lock file must not exist, and otherwise
+// it must be the given version, such as the version last written when renewing a lock, or the version of an expired
+// lock that's being taken over. Where the bucket's driver supports conditional writes, these conditions are checked by
+// the write itself, and the lock file is shared by every backend, so that only one of them can take it. Other drivers
+// give each backend its own lock file, and check that it still exists before renewing it, which leaves a small window
+// for races. Writes whose conditions don't hold fail with errLockChanged.
+func (b *localBackend) writeLock(ctx context.Context, key string, l *lease, replace *objectVersion) error {
+	conditional := driverSupportsConditionalWrites(b.bucket)
+	if replace != nil && !conditional {
+		exists, err := b.bucket.Exists(ctx, key)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return errLockChanged
+		}
+	}
+
+	content, err := json.Marshal(l.content)
 	if err != nil {
 		return err
 	}
-	lockContent, err := newLockContent()
+
+	var gcsWriter *storage.Writer
+	if err := b.bucket.WriteAll(ctx, key, content, conditionalWriteOptions(replace, &gcsWriter)); err != nil {
+		if conditional && gcerrors.Code(err) == gcerrors.FailedPrecondition {
+			return errLockChanged
+		}
+		return err
+	}
+	if !conditional {
+		return nil
+	}
+
+	if gcsWriter != nil && gcsWriter.Attrs() != nil {
+		l.version = objectVersion{generation: gcsWriter.Attrs().Generation}
+		return nil
+	}
+	// The ETag of the lock file isn't returned by the write, so it's read back. Only backends whose lock has expired
+	// can replace it in the meantime, which is caught by checking that it's still ours.
+	written, err := readLockFile(ctx, b.bucket, key)
+	if err != nil {
+		if gcerrors.Code(err) == gcerrors.NotFound {
+			return errLockChanged
+		}
+		return err
+	}
+	if written.ID != l.content.ID {
+		return errLockChanged
+	}
+	l.version = written.version
+	return nil
+}
+
+// conditionalWriteOptions returns the options of a write that fails with gcerrors.FailedPrecondition unless the file
+// is at the given version, or doesn't exist if replace is nil, where the bucket's driver supports conditional writes.
+// They have no effect with other drivers. If gcsWriter isn't nil, it's set to the writer of writes to GCS buckets, from
+// which the new generation of the file can be read once it's written.
+func conditionalWriteOptions(replace *objectVersion, gcsWriter **storage.Writer) *blob.WriterOptions {
+	return &blob.WriterOptions{
+		BeforeWrite: func(as func(interface{}) bool) error {
+			var gcsObject **storage.ObjectHandle
+			if as(&gcsObject) {
+				if replace == nil {
+					*gcsObject = (*gcsObject).If(storage.Conditions{DoesNotExist: true})
+				} else if replace.generation != 0 {
+					*gcsObject = (*gcsObject).If(storage.Conditions{GenerationMatch: replace.generation})
+				}
+			}
+			// Fetch the writer after the object handle, so that we can read the new generation once it's written.
+			if gcsWriter != nil {
+				as(gcsWriter)
+			}
+
+			var azureOptions *azblob.UploadStreamOptions
+			if as(&azureOptions) {
+				conds := &azblob.ModifiedAccessConditions{}
+				etag := azblob.ETagAny
+				switch {
+				case replace == nil:
+					conds.IfNoneMatch = &etag
+				case replace.etag != "":
+					conds.IfMatch = &replace.etag
+				default:
+					conds.IfMatch = &etag
+				}
+				azureOptions.BlobAccessConditions = &azblob.BlobAccessConditions{ModifiedAccessConditions: conds}
+			}
+			return nil
+		},
+	}
+}
+
+// errLockChanged is returned when a lock file isn't in the state that a conditional write on it required.
+var errLockChanged = errors.New("the lock file was changed by another process")
+
+// errLeaseLost is returned when renewing a lock that's no longer held by this backend.
+var errLeaseLost = errors.New("the lock is no longer held")
+
+// driverSupportsConditionalWrites returns true if writeLock can make writes to the bucket conditional.
+func driverSupportsConditionalWrites(bucket Bucket) bool {
+	wrapped, ok := bucket.(*wrappedBucket)
+	if !ok {
+		return false
+	}
+	var gcsClient *storage.Client
+	var azureClient *azblob.ContainerClient
+	return wrapped.bucket.As(&gcsClient) || wrapped.bucket.As(&azureClient)
+}
+
+func (b *localBackend) Lock(ctx context.Context, stackRef backend.StackReference) error {
+	if locker := lockerFor(b.bucket); locker != nil {
+		if err := b.lockWith(ctx, locker, stackRef); err != nil {
+			return err
+		}
+		b.setHeld(stackRef, true)
+		return nil
+	}
+
+	expired, err := b.checkLocks(ctx, stackRef)
 	if err != nil {
 		return err
 	}
-	content, err := json.Marshal(lockContent)
+	lockContent, err := newLockContent(b.lockLease)
 	if err != nil {
 		return err
 	}
-	err = b.bucket.WriteAll(ctx, b.lockPath(stackRef), content, nil)
+	lockContent.ID = b.lockID
+	l := &lease{content: lockContent}
+
+	// An expired lock in our lock file is taken over by replacing the version of it that was read.
+	key := b.lockPath(stackRef)
+	var replace *objectVersion
+	if expired != nil {
+		b.warnBreakingLock(filepath.ToSlash(key), expired)
+		replace = &expired.version
+	}
+	err = b.writeLock(ctx, key, l, replace)
+	if errors.Is(err, errLockChanged) {
+		// Another backend took the lock first. Describe it, if it still holds it.
+		if lockErr := b.checkForLock(ctx, stackRef); lockErr != nil {
+			return lockErr
+		}
+		return errors.New("the stack is currently locked by another process; try again")
+	}
 	if err != nil {
 		return err
 	}
+
+	// Lock files taken by older versions of the CLI, or with drivers that don't support conditional writes, aren't
+	// excluded by the write, so back off if another process has taken one in the meantime.
 	err = b.checkForLock(ctx, stackRef)
 	if err != nil {
 		b.Unlock(ctx, stackRef)
 		return err
 	}
+
+	b.startLease(stackRef, l)
+	b.setHeld(stackRef, true)
 	return nil
 }
 
-func (b *localBackend) Unlock(ctx context.Context, stackRef backend.StackReference) {
-	err := b.bucket.Delete(ctx, b.lockPath(stackRef))
+// setHeld records whether this backend holds the lock on the given stack.
+func (b *localBackend) setHeld(stackRef backend.StackReference, held bool) {
+	key := stackLockDir(stackRef.FullyQualifiedName())
+	b.leasesLock.Lock()
+	defer b.leasesLock.Unlock()
+	if held {
+		b.held[key] = true
+	} else {
+		delete(b.held, key)
+	}
+}
+
+// holdsLock returns true if this backend holds the lock on the given stack.
+func (b *localBackend) holdsLock(stackRef backend.StackReference) bool {
+	b.leasesLock.Lock()
+	defer b.leasesLock.Unlock()
+	return b.held[stackLockDir(stackRef.FullyQualifiedName())]
+}
+
+// lockWith locks a stack with a bucket that locks stacks itself.
+func (b *localBackend) lockWith(ctx context.Context, locker bucketLocker, stackRef backend.StackReference) error {
+	l, err := newLockContent(b.lockLease)
 	if err != nil {
+		return err
+	}
+	l.ID, l.Expires = b.lockID, nil
+
+	err = locker.Lock(ctx, stackLockDir(stackRef.FullyQualifiedName()), l)
+	if errors.Is(err, errStackLocked) {
+		// Describe the process that holds the lock, if it still does.
+		if lockErr := b.checkForLock(ctx, stackRef); lockErr != nil {
+			return lockErr
+		}
+	}
+	return err
+}
+
+// startLease renews a lock in the background until it's released, so that it doesn't expire while it's held. The
+// lease is renewed a few times in each period so that a single failed renewal doesn't cause it to expire. If the lock
+// is broken by another process, the lease's lost channel is closed so that the update holding it is canceled.
+func (b *localBackend) startLease(stackRef backend.StackReference, l *lease) {
+	key := b.lockPath(stackRef)
+	ctx, cancel := context.WithCancel(context.Background())
+	l.cancel, l.done, l.lost = cancel, make(chan struct{}), make(chan struct{})
+
+	b.leasesLock.Lock()
+	b.leases[key] = l
+	b.leasesLock.Unlock()
+
+	go func() {
+		defer close(l.done)
+
+		ticker := time.NewTicker(b.lockLease / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			if err := b.renewLease(ctx, key, l); err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				if errors.Is(err, errLeaseLost) {
+					b.d.Errorf(
+						diag.Message("", "the lock at %v was broken by another process while it was held; "+
+							"canceling the update"),
+						path.Join(b.url, key))
+					close(l.lost)
+					return
+				}
+				b.d.Warningf(diag.Message("", "failed to renew the lock at %v: %v"), path.Join(b.url, key), err)
+			}
+		}
+	}()
+}
+
+// lostLease returns a channel that's closed if the lock held by this backend on the given stack is broken by another
+// process. It returns nil, which is never ready, if the backend doesn't hold a lease on the stack.
+func (b *localBackend) lostLease(stackRef backend.StackReference) <-chan struct{} {
+	b.leasesLock.Lock()
+	defer b.leasesLock.Unlock()
+	if l, has := b.leases[b.lockPath(stackRef)]; has {
+		return l.lost
+	}
+	return nil
+}
+
+// renewLease extends a lock held by this backend for another lease period.
+func (b *localBackend) renewLease(ctx context.Context, key string, l *lease) error {
+	expires := time.Now().Add(b.lockLease)
+	b.leasesLock.Lock()
+	renewed := lease{version: l.version}
+	content := *l.content
+	b.leasesLock.Unlock()
+	content.Expires = &expires
+	renewed.content = &content
+
+	if err := b.writeLock(ctx, key, &renewed, &renewed.version); err != nil {
+		if errors.Is(err, errLockChanged) {
+			return errLeaseLost
+		}
+		return err
+	}
+
+	b.leasesLock.Lock()
+	l.content, l.version = renewed.content, renewed.version
+	b.leasesLock.Unlock()
+	return nil
+}
+
+func (b *localBackend) Unlock(ctx context.Context, stackRef backend.StackReference) {
+	b.setHeld(stackRef, false)
+	if locker := lockerFor(b.bucket); locker != nil {
+		key := stackLockDir(stackRef.FullyQualifiedName())
+		if err := locker.Unlock(ctx, key, &lockContent{ID: b.lockID}); err != nil {
+			b.d.Errorf(
+				diag.Message("", "there was a problem releasing the lock at %v, manual clean up may be required: %v"),
+				path.Join(b.url, key), err)
+		}
+		return
+	}
+
+	// Stop renewing the lock before deleting it, so that it isn't written again.
+	b.leasesLock.Lock()
+	l, has := b.leases[b.lockPath(stackRef)]
+	delete(b.leases, b.lockPath(stackRef))
+	b.leasesLock.Unlock()
+	if has {
+		l.cancel()
+		<-l.done
+
+		// If the lock was broken, the lock file may now belong to another process.
+		select {
+		case <-l.lost:
+			return
+		default:
+		}
+	}
+
+	key := b.lockPath(stackRef)
+	if err := b.deleteLock(ctx, key, l); err != nil {
 		b.d.Errorf(
 			diag.Message("", "there was a problem deleting the lock at %v, manual clean up may be required: %v"),
-			path.Join(b.url, b.lockPath(stackRef)),
+			path.Join(b.url, key),
 			err)
 	}
 }
 
+// deleteLock deletes the lock file of a lock held by this backend, given its lease, if any. Where the bucket's driver
+// supports conditional writes, the lock file is shared with other backends, which take it over once its lease has
+// expired, so it's read again first, and left alone if it's no longer the version this backend last wrote.
+func (b *localBackend) deleteLock(ctx context.Context, key string, l *lease) error {
+	if driverSupportsConditionalWrites(b.bucket) {
+		current, err := readLockFile(ctx, b.bucket, key)
+		if err != nil {
+			if gcerrors.Code(err) == gcerrors.NotFound {
+				return nil
+			}
+			return err
+		}
+		if current.ID != b.lockID || (l != nil && current.version != l.version) {
+			b.d.Warningf(
+				diag.Message("", "the lock at %v was taken over by %v@%v (pid %v) after its lease expired; "+
+					"leaving it in place"),
+				path.Join(b.url, key), current.Username, current.Hostname, current.Pid)
+			return nil
+		}
+	}
+
+	return b.bucket.Delete(ctx, key)
+}
+
 func lockDir() string {
 	return path.Join(workspace.BookkeepingDir, workspace.LockDir)
 }
@@ -153,7 +703,15 @@ func stackLockDir(stack tokens.QName) string {
 	return path.Join(lockDir(), fsutil.QnamePath(stack))
 }
 
+// sharedLockFile is the name of the lock file of a stack in buckets whose drivers support conditional writes. Every
+// backend takes the same lock file, so that only one of the conditional writes that create it can succeed.
+const sharedLockFile = "lock.json"
+
 func (b *localBackend) lockPath(stackRef backend.StackReference) string {
 	contract.Requiref(stackRef != nil, "stack", "must not be nil")
-	return path.Join(stackLockDir(stackRef.FullyQualifiedName()), b.lockID+".json")
+	name := b.lockID + ".json"
+	if driverSupportsConditionalWrites(b.bucket) {
+		name = sharedLockFile
+	}
+	return path.Join(stackLockDir(stackRef.FullyQualifiedName()), name)
 }
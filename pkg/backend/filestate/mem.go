@@ -0,0 +1,61 @@
+// Copyright 2016-2023, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filestate
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"sync"
+
+	"gocloud.dev/blob"
+	"gocloud.dev/blob/memblob"
+)
+
+// MemPathPrefix is the prefix of URLs for backends that keep their state in memory, such as "mem://" or
+// "mem://my-tests". State is kept for the life of the process, and is shared by all backends opened with the same
+// URL host. This is intended for tests that open backends with New in the same process. Login and the CLI reject
+// these URLs, because each command runs in a new process that would start with empty state.
+const MemPathPrefix = memblob.Scheme + "://"
+
+// ErrMemBackendLogin is returned when logging in to an in-memory backend, or using one as the CLI's current backend.
+var ErrMemBackendLogin = errors.New("mem:// backends keep their state in the memory of a single process, " +
+	"so they can only be opened in-process with filestate.New; use a file:// backend in a temporary directory instead")
+
+// IsMemBackendURL returns true if the URL is that of an in-memory backend.
+func IsMemBackendURL(url string) bool {
+	return strings.HasPrefix(url, MemPathPrefix)
+}
+
+// memBuckets holds the buckets of all in-memory backends opened by this process, indexed by URL host.
+var memBuckets = struct {
+	sync.Mutex
+	buckets map[string]*blob.Bucket
+}{buckets: make(map[string]*blob.Bucket)}
+
+// openMemBucket returns the in-memory bucket for the given mem:// URL, creating it if this is the first time it has
+// been opened. memblob's own URL opener returns a new empty bucket each time, which would lose the state of stacks
+// between commands.
+func openMemBucket(u *url.URL) *blob.Bucket {
+	memBuckets.Lock()
+	defer memBuckets.Unlock()
+
+	bucket, has := memBuckets.buckets[u.Host]
+	if !has {
+		bucket = memblob.OpenBucket(nil)
+		memBuckets.buckets[u.Host] = bucket
+	}
+	return bucket
+}
@@ -20,6 +20,7 @@ import (
 	"path/filepath"
 	"strconv"
 
+	"github.com/pulumi/pulumi/pkg/v3/backend"
 	"github.com/pulumi/pulumi/sdk/v3/go/common/util/contract"
 	"github.com/pulumi/pulumi/sdk/v3/go/common/workspace"
 	"gocloud.dev/gcerrors"
@@ -49,6 +50,24 @@ type pulumiMeta struct {
 	// Does not use "omitempty" to differentiate
 	// between a missing field and a zero value.
 	Version int `json:"version" yaml:"version"`
+
+	// Gzip specifies whether checkpoints, history and backups
+	// are written with gzip compression.
+	// Files in either format are always readable.
+	//
+	// This can be overridden by setting the environment variable
+	// "PULUMI_SELF_MANAGED_STATE_GZIP".
+	Gzip bool `json:"gzip,omitempty" yaml:"gzip,omitempty"`
+
+	// History is the retention policy for the update history of stacks,
+	// enforced after each update.
+	// The zero value keeps the whole history.
+	History backend.HistoryRetention `json:"history,omitempty" yaml:"history,omitempty"`
+
+	// Encrypt specifies whether checkpoints, history and backups
+	// are encrypted with the secrets manager of their stack.
+	// Files in either format are always readable.
+	Encrypt bool `json:"encrypt,omitempty" yaml:"encrypt,omitempty"`
 }
 
 // ensurePulumiMeta loads the Pulumi state metadata file from the bucket.
@@ -134,6 +153,10 @@ func readPulumiMeta(ctx context.Context, b Bucket) (*pulumiMeta, error) {
 	var state struct {
 		// Version 0 is valid, so we need to use a pointer.
 		Version *int `yaml:"version"`
+
+		Gzip    bool                     `yaml:"gzip"`
+		History backend.HistoryRetention `yaml:"history"`
+		Encrypt bool                     `yaml:"encrypt"`
 	}
 
 	if err := yaml.Unmarshal(metaBody, &state); err != nil {
@@ -146,6 +169,9 @@ func readPulumiMeta(ctx context.Context, b Bucket) (*pulumiMeta, error) {
 
 	return &pulumiMeta{
 		Version: *state.Version,
+		Gzip:    state.Gzip,
+		History: state.History,
+		Encrypt: state.Encrypt,
 	}, nil
 }
 
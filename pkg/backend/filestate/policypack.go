@@ -0,0 +1,413 @@
+// Copyright 2016-2023, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filestate
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gocloud.dev/gcerrors"
+
+	"github.com/pulumi/pulumi/pkg/v3/backend"
+	"github.com/pulumi/pulumi/pkg/v3/engine"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/apitype"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/diag"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/encoding"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/tokens"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/archive"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/contract"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/result"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/workspace"
+	"github.com/pulumi/pulumi/sdk/v3/nodejs/npm"
+)
+
+// localPolicyOrg is the organization whose directory the Policy Packs enforced by filestate backends are installed in,
+// alongside those downloaded from the Pulumi Cloud.
+const localPolicyOrg = "local"
+
+// policyConfig is the policy configuration stored in the backend's bucket at PolicyConfigPath. It maps Policy Groups
+// to the local Policy Packs enabled for them. The default Policy Group applies to every stack, and every other Policy
+// Group is named after the fully qualified name of the single stack it applies to.
+type policyConfig struct {
+	PolicyGroups map[string][]policyPackConfig `json:"policyGroups"`
+}
+
+// policyPackConfig is a Policy Pack enabled for a Policy Group. An archive of the Policy Pack, as it was when it was
+// enabled, is stored in the bucket under PolicyPacksDir, so that it can be installed wherever the stacks are updated.
+type policyPackConfig struct {
+	// Name of the Policy Pack, which is the name of the directory it was enabled from.
+	Name string `json:"name"`
+	// Version identifies the archive of the Policy Pack, and is derived from its digest.
+	Version string `json:"version"`
+	// Config is the configuration for the Policy Pack, in the same form used by the Pulumi Cloud.
+	Config map[string]*json.RawMessage `json:"config,omitempty"`
+}
+
+// maxPolicyConfigAttempts is how many times updatePolicyConfig tries to apply a change before giving up, when other
+// processes keep changing the policy configuration at the same time.
+const maxPolicyConfigAttempts = 5
+
+// getPolicyConfig loads the policy configuration from the bucket. Backends without one have no policies enabled.
+func (b *localBackend) getPolicyConfig(ctx context.Context) (*policyConfig, error) {
+	config, _, err := b.readPolicyConfig(ctx)
+	return config, err
+}
+
+// readPolicyConfig loads the policy configuration from the bucket, along with its version, which is nil if the bucket
+// has no policy configuration yet.
+func (b *localBackend) readPolicyConfig(ctx context.Context) (*policyConfig, *objectVersion, error) {
+	bytes, version, err := readVersioned(ctx, b.bucket, PolicyConfigPath)
+	if err != nil {
+		if gcerrors.Code(err) == gcerrors.NotFound {
+			return &policyConfig{PolicyGroups: map[string][]policyPackConfig{}}, nil, nil
+		}
+		return nil, nil, fmt.Errorf("reading policy configuration %s: %w", PolicyConfigPath, err)
+	}
+
+	var config policyConfig
+	if err := encoding.JSON.Unmarshal(bytes, &config); err != nil {
+		return nil, nil, fmt.Errorf("reading policy configuration %s: %w", PolicyConfigPath, err)
+	}
+	if config.PolicyGroups == nil {
+		config.PolicyGroups = map[string][]policyPackConfig{}
+	}
+	return &config, &version, nil
+}
+
+// updatePolicyConfig applies the given change to the policy configuration in the bucket. Where the bucket's driver
+// supports conditional writes, the configuration is only replaced if no other process has changed it since it was
+// read, and otherwise the change is applied again to the latest configuration, so that concurrent changes aren't lost.
+// With other drivers, the last change written wins.
+func (b *localBackend) updatePolicyConfig(ctx context.Context, update func(config *policyConfig) error) error {
+	for attempt := 1; ; attempt++ {
+		config, version, err := b.readPolicyConfig(ctx)
+		if err != nil {
+			return err
+		}
+		if err := update(config); err != nil {
+			return err
+		}
+
+		bytes, err := encoding.JSON.Marshal(config)
+		if err != nil {
+			return err
+		}
+		err = b.bucket.WriteAll(ctx, PolicyConfigPath, bytes, conditionalWriteOptions(version, nil))
+		if gcerrors.Code(err) != gcerrors.FailedPrecondition || !driverSupportsConditionalWrites(b.bucket) {
+			return err
+		}
+		if attempt == maxPolicyConfigAttempts {
+			return fmt.Errorf("the policy configuration %s is being changed by other processes; try again",
+				PolicyConfigPath)
+		}
+	}
+}
+
+// policyGroupName resolves the name of a Policy Group as given by the user. An empty name is the default Policy
+// Group, and anything else must be a reference to a stack in this backend.
+func (b *localBackend) policyGroupName(policyGroup string) (string, error) {
+	if policyGroup == "" || policyGroup == apitype.DefaultPolicyGroup {
+		return apitype.DefaultPolicyGroup, nil
+	}
+
+	ref, err := b.parseStackReference(policyGroup)
+	if err != nil {
+		return "", fmt.Errorf("policy group %q must be the name of a stack: %w", policyGroup, err)
+	}
+	return ref.FullyQualifiedName().String(), nil
+}
+
+// getRequiredPolicies returns the Policy Packs that must be enforced for the given stack: those in the default Policy
+// Group followed by those in the stack's own Policy Group.
+func (b *localBackend) getRequiredPolicies(
+	ctx context.Context,
+	ref *localBackendReference,
+) ([]engine.RequiredPolicy, error) {
+	contract.Requiref(ref != nil, "ref", "must not be nil")
+
+	config, err := b.getPolicyConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var policies []engine.RequiredPolicy
+	for _, group := range []string{apitype.DefaultPolicyGroup, ref.FullyQualifiedName().String()} {
+		for _, pack := range config.PolicyGroups[group] {
+			policies = append(policies, newLocalRequiredPolicy(b, pack))
+		}
+	}
+	return policies, nil
+}
+
+// policyPackArchivePath returns the path in the bucket of the archive of a version of a Policy Pack.
+func policyPackArchivePath(name, version string) string {
+	return filepath.Join(PolicyPacksDir, name, version+".tgz")
+}
+
+// archivePolicyPack returns a .tgz of the Policy Pack in dir, with its files in a "package" directory as npm pack
+// produces them.
+func archivePolicyPack(ctx context.Context, dir string, proj *workspace.PolicyPackProject) ([]byte, error) {
+	// TODO[pulumi/pulumi#1334]: move to the language plugins so we don't have to hard code here.
+	if strings.EqualFold(proj.Runtime.Name(), "nodejs") {
+		tarball, err := npm.Pack(ctx, dir, os.Stderr)
+		if err != nil {
+			return nil, fmt.Errorf("running npm pack: %w", err)
+		}
+		return tarball, nil
+	}
+	return archive.TGZ(dir, "package", true /*useDefaultExcludes*/)
+}
+
+// policyGroupNames returns the names of the Policy Groups in the configuration in a stable order.
+func (config *policyConfig) policyGroupNames() []string {
+	names := make([]string, 0, len(config.PolicyGroups))
+	for name := range config.PolicyGroups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (b *localBackend) GetPolicyPack(ctx context.Context, policyPack string,
+	d diag.Sink,
+) (backend.PolicyPack, error) {
+	path, err := filepath.Abs(policyPack)
+	if err != nil {
+		return nil, err
+	}
+	return &localPolicyPack{
+		ref: &localPolicyPackReference{name: tokens.QName(filepath.Base(path)), path: path},
+		b:   b,
+	}, nil
+}
+
+func (b *localBackend) ListPolicyGroups(ctx context.Context, orgName string, _ backend.ContinuationToken) (
+	apitype.ListPolicyGroupsResponse, backend.ContinuationToken, error,
+) {
+	config, err := b.getPolicyConfig(ctx)
+	if err != nil {
+		return apitype.ListPolicyGroupsResponse{}, nil, err
+	}
+	stacks, err := b.getLocalStacks(ctx)
+	if err != nil {
+		return apitype.ListPolicyGroupsResponse{}, nil, err
+	}
+
+	// The default Policy Group is always listed, like it is for organizations in the Pulumi Cloud.
+	groups := []apitype.PolicyGroupSummary{{
+		Name:                  apitype.DefaultPolicyGroup,
+		IsOrgDefault:          true,
+		NumStacks:             len(stacks),
+		NumEnabledPolicyPacks: len(config.PolicyGroups[apitype.DefaultPolicyGroup]),
+	}}
+	for _, name := range config.policyGroupNames() {
+		if name == apitype.DefaultPolicyGroup {
+			continue
+		}
+		groups = append(groups, apitype.PolicyGroupSummary{
+			Name:                  name,
+			NumStacks:             1,
+			NumEnabledPolicyPacks: len(config.PolicyGroups[name]),
+		})
+	}
+	return apitype.ListPolicyGroupsResponse{PolicyGroups: groups}, nil, nil
+}
+
+func (b *localBackend) ListPolicyPacks(ctx context.Context, orgName string, _ backend.ContinuationToken) (
+	apitype.ListPolicyPacksResponse, backend.ContinuationToken, error,
+) {
+	config, err := b.getPolicyConfig(ctx)
+	if err != nil {
+		return apitype.ListPolicyPacksResponse{}, nil, err
+	}
+
+	// The same Policy Pack may be enabled for several Policy Groups, but is only listed once, with each of the versions
+	// that are enabled.
+	index := make(map[string]int)
+	seen := make(map[string]bool)
+	packs := []apitype.PolicyPackWithVersions{}
+	for _, group := range config.policyGroupNames() {
+		for _, pack := range config.PolicyGroups[group] {
+			i, has := index[pack.Name]
+			if !has {
+				i = len(packs)
+				index[pack.Name] = i
+				packs = append(packs, apitype.PolicyPackWithVersions{Name: pack.Name, DisplayName: pack.Name})
+			}
+			if key := pack.Name + "@" + pack.Version; !seen[key] {
+				seen[key] = true
+				packs[i].VersionTags = append(packs[i].VersionTags, pack.Version)
+			}
+		}
+	}
+	return apitype.ListPolicyPacksResponse{PolicyPacks: packs}, nil, nil
+}
+
+// localRequiredPolicy is a Policy Pack stored in the bucket that is enforced for a stack by the policy configuration.
+type localRequiredPolicy struct {
+	b    *localBackend
+	pack policyPackConfig
+}
+
+var _ engine.RequiredPolicy = (*localRequiredPolicy)(nil)
+
+func newLocalRequiredPolicy(b *localBackend, pack policyPackConfig) *localRequiredPolicy {
+	return &localRequiredPolicy{b: b, pack: pack}
+}
+
+func (rp *localRequiredPolicy) Name() string                        { return rp.pack.Name }
+func (rp *localRequiredPolicy) Version() string                     { return rp.pack.Version }
+func (rp *localRequiredPolicy) Config() map[string]*json.RawMessage { return rp.pack.Config }
+
+// Install downloads the Policy Pack's archive from the bucket and installs it, unless that version of it is installed
+// already.
+func (rp *localRequiredPolicy) Install(ctx context.Context) (string, error) {
+	policyPackPath, installed, err := workspace.GetPolicyPath(localPolicyOrg, rp.pack.Name, rp.pack.Version)
+	if err != nil {
+		return "", err
+	} else if installed {
+		return policyPackPath, nil
+	}
+
+	fmt.Printf("Installing policy pack %s %s...\n", rp.pack.Name, rp.pack.Version)
+
+	tarball, err := rp.b.bucket.ReadAll(ctx, policyPackArchivePath(rp.pack.Name, rp.pack.Version))
+	if err != nil {
+		if gcerrors.Code(err) == gcerrors.NotFound {
+			return "", fmt.Errorf("required policy pack %q is missing from the backend; "+
+				"enable it again with `pulumi policy enable`", rp.pack.Name)
+		}
+		return "", fmt.Errorf("downloading required policy pack %q: %w", rp.pack.Name, err)
+	}
+	return policyPackPath, backend.InstallRequiredPolicy(ctx, policyPackPath, io.NopCloser(bytes.NewReader(tarball)))
+}
+
+// localPolicyPackReference is a reference to a Policy Pack in a directory on disk.
+type localPolicyPackReference struct {
+	// name of the PolicyPack, which is the name of its directory.
+	name tokens.QName
+	// path is the absolute path to the directory containing the PolicyPack.
+	path string
+}
+
+var _ backend.PolicyPackReference = (*localPolicyPackReference)(nil)
+
+func (pr *localPolicyPackReference) String() string     { return pr.path }
+func (pr *localPolicyPackReference) OrgName() string    { return "" }
+func (pr *localPolicyPackReference) Name() tokens.QName { return pr.name }
+
+func (pr *localPolicyPackReference) CloudConsoleURL() string { return "" }
+
+// localPolicyPack is the filestate implementation of the PolicyPack interface. Enabling it stores an archive of the
+// PolicyPack in the backend's bucket, and enabling and disabling it edits the bucket's policy configuration.
+type localPolicyPack struct {
+	// ref identifies the PolicyPack on disk.
+	ref *localPolicyPackReference
+	// b is a pointer to the backend whose policy configuration is managed.
+	b *localBackend
+}
+
+var _ backend.PolicyPack = (*localPolicyPack)(nil)
+
+func (pack *localPolicyPack) Ref() backend.PolicyPackReference { return pack.ref }
+func (pack *localPolicyPack) Backend() backend.Backend         { return pack.b }
+
+func (pack *localPolicyPack) Publish(ctx context.Context, op backend.PublishOperation) result.Result {
+	return result.Error("File state backend does not support publishing policy packs; " +
+		"use `pulumi policy enable <path> latest` to enforce a local policy pack instead")
+}
+
+func (pack *localPolicyPack) Enable(ctx context.Context, policyGroup string, op backend.PolicyPackOperation) error {
+	if op.VersionTag != nil {
+		return errors.New("local policy packs are not versioned; specify `latest` to enable the policy pack on disk")
+	}
+	proj, err := workspace.LoadPolicyPack(filepath.Join(pack.ref.path, "PulumiPolicy.yaml"))
+	if err != nil {
+		return fmt.Errorf("could not load a policy pack from %q: %w", pack.ref.path, err)
+	}
+
+	group, err := pack.b.policyGroupName(policyGroup)
+	if err != nil {
+		return err
+	}
+
+	// Store the Policy Pack in the bucket, so that every machine that updates the backend's stacks can install it.
+	tarball, err := archivePolicyPack(ctx, pack.ref.path, proj)
+	if err != nil {
+		return fmt.Errorf("could not archive the policy pack in %q: %w", pack.ref.path, err)
+	}
+	digest := sha256.Sum256(tarball)
+	version := hex.EncodeToString(digest[:])[:12]
+	archivePath := policyPackArchivePath(string(pack.ref.name), version)
+	if err := pack.b.bucket.WriteAll(ctx, archivePath, tarball, nil); err != nil {
+		return fmt.Errorf("storing the policy pack: %w", err)
+	}
+
+	// Enabling a Policy Pack that is already enabled replaces its version and configuration.
+	enabled := policyPackConfig{Name: string(pack.ref.name), Version: version, Config: op.Config}
+	return pack.b.updatePolicyConfig(ctx, func(config *policyConfig) error {
+		packs := config.PolicyGroups[group]
+		for i, p := range packs {
+			if p.Name == enabled.Name {
+				packs[i] = enabled
+				return nil
+			}
+		}
+		config.PolicyGroups[group] = append(packs, enabled)
+		return nil
+	})
+}
+
+func (pack *localPolicyPack) Disable(ctx context.Context, policyGroup string, op backend.PolicyPackOperation) error {
+	group, err := pack.b.policyGroupName(policyGroup)
+	if err != nil {
+		return err
+	}
+
+	return pack.b.updatePolicyConfig(ctx, func(config *policyConfig) error {
+		packs := config.PolicyGroups[group]
+		for i, p := range packs {
+			if p.Name == string(pack.ref.name) {
+				packs = append(packs[:i], packs[i+1:]...)
+				if len(packs) == 0 {
+					delete(config.PolicyGroups, group)
+				} else {
+					config.PolicyGroups[group] = packs
+				}
+				return nil
+			}
+		}
+		return fmt.Errorf("policy pack %q is not enabled for policy group %q", pack.ref.name, group)
+	})
+}
+
+func (pack *localPolicyPack) Validate(ctx context.Context, op backend.PolicyPackOperation) error {
+	return errors.New("File state backend does not support validating policy pack configuration")
+}
+
+func (pack *localPolicyPack) Remove(ctx context.Context, op backend.PolicyPackOperation) error {
+	return errors.New("File state backend does not support removing policy packs; " +
+		"use `pulumi policy disable` to stop enforcing a local policy pack")
+}
@@ -0,0 +1,484 @@
+// Copyright 2016-2023, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filestate
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"gocloud.dev/blob"
+	"gocloud.dev/blob/driver"
+	"gocloud.dev/gcerrors"
+)
+
+// RESTSchemePrefix prefixes the scheme of the base URL of an HTTP server that stores state, to form the URL of a
+// backend such as "rest+https://example.com/state". The protocol spoken with the server is described by the
+// restserver package, which implements it.
+const RESTSchemePrefix = "rest+"
+
+// restSchemes are the URL schemes of REST backends.
+var restSchemes = []string{RESTSchemePrefix + "http", RESTSchemePrefix + "https"}
+
+func init() {
+	for _, scheme := range restSchemes {
+		blob.DefaultURLMux().RegisterBucket(scheme, restURLOpener{})
+	}
+}
+
+// isRESTScheme returns true if the URL scheme is that of a REST backend.
+func isRESTScheme(scheme string) bool {
+	for _, s := range restSchemes {
+		if scheme == s {
+			return true
+		}
+	}
+	return false
+}
+
+// restURLOpener opens REST buckets with blob.DefaultURLMux, so that their schemes are recognized as filestate URLs.
+// Backends open them with openRESTBucket, which reads the environment through the backend's options.
+type restURLOpener struct{}
+
+func (restURLOpener) OpenBucketURL(ctx context.Context, u *url.URL) (*blob.Bucket, error) {
+	return openRESTBucket(u, os.Getenv)
+}
+
+// openRESTBucket returns a bucket that stores its objects on the HTTP server at the given rest+http(s) URL.
+func openRESTBucket(u *url.URL, getenv func(string) string) (*blob.Bucket, error) {
+	base := *u
+	base.Scheme = strings.TrimPrefix(u.Scheme, RESTSchemePrefix)
+	base.Path = strings.TrimSuffix(base.Path, "/")
+	base.RawPath = ""
+
+	b := &restBucket{
+		client:       http.DefaultClient,
+		base:         base.String(),
+		username:     getenv(PulumiFilestateRESTUsernameEnvVar),
+		password:     getenv(PulumiFilestateRESTPasswordEnvVar),
+		token:        getenv(PulumiFilestateRESTTokenEnvVar),
+		updateMethod: methodOrDefault(getenv(PulumiFilestateRESTUpdateMethodEnvVar), http.MethodPut),
+		lockMethod:   methodOrDefault(getenv(PulumiFilestateRESTLockMethodEnvVar), "LOCK"),
+		unlockMethod: methodOrDefault(getenv(PulumiFilestateRESTUnlockMethodEnvVar), "UNLOCK"),
+	}
+	if b.token != "" && (b.username != "" || b.password != "") {
+		return nil, fmt.Errorf("only one of %s and %s may be set",
+			PulumiFilestateRESTTokenEnvVar, PulumiFilestateRESTUsernameEnvVar)
+	}
+	return blob.NewBucket(b), nil
+}
+
+func methodOrDefault(method, def string) string {
+	if method == "" {
+		return def
+	}
+	return strings.ToUpper(method)
+}
+
+// restBucket is a gocloud blob driver for the REST state protocol.
+type restBucket struct {
+	client *http.Client
+	// base is the URL that object keys are relative to, without a trailing slash.
+	base string
+
+	username string
+	password string
+	token    string
+
+	updateMethod string
+	lockMethod   string
+	unlockMethod string
+}
+
+var _ driver.Bucket = (*restBucket)(nil)
+
+// restList is the body of the response to a request that lists objects.
+type restList struct {
+	Objects []restListObject `json:"objects"`
+}
+
+// restListObject describes an object in a listing.
+type restListObject struct {
+	Key      string    `json:"key"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+}
+
+// restError is returned for requests that the server doesn't respond to with a 2xx status.
+type restError struct {
+	Method     string
+	URL        string
+	StatusCode int
+	Body       []byte
+}
+
+func (e *restError) Error() string {
+	msg := strings.TrimSpace(string(e.Body))
+	if msg == "" {
+		msg = http.StatusText(e.StatusCode)
+	}
+	return fmt.Sprintf("%s %s: %d %s", e.Method, e.URL, e.StatusCode, msg)
+}
+
+// objectURL returns the URL of the object with the given key.
+func (b *restBucket) objectURL(key string) string {
+	segments := strings.Split(key, "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	return b.base + "/" + strings.Join(segments, "/")
+}
+
+// do sends a request to the server, returning a *restError if it doesn't respond with a 2xx status. The caller must
+// close the body of the response.
+func (b *restBucket) do(
+	ctx context.Context, method, url string, body []byte, header http.Header,
+) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, reader)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if b.token != "" {
+		req.Header.Set("Authorization", "Bearer "+b.token)
+	} else if b.username != "" || b.password != "" {
+		req.SetBasicAuth(b.username, b.password)
+	}
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+		return nil, &restError{Method: method, URL: url, StatusCode: resp.StatusCode, Body: respBody}
+	}
+	return resp, nil
+}
+
+// doAll sends a request to the server and reads the whole body of its response.
+func (b *restBucket) doAll(
+	ctx context.Context, method, url string, body []byte, header http.Header,
+) (*http.Response, []byte, error) {
+	resp, err := b.do(ctx, method, url, body, header)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	return resp, respBody, nil
+}
+
+func (b *restBucket) ErrorCode(err error) gcerrors.ErrorCode {
+	var restErr *restError
+	if !errors.As(err, &restErr) {
+		return gcerrors.Unknown
+	}
+	switch restErr.StatusCode {
+	case http.StatusNotFound:
+		return gcerrors.NotFound
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return gcerrors.PermissionDenied
+	case http.StatusConflict, http.StatusPreconditionFailed, http.StatusLocked:
+		return gcerrors.FailedPrecondition
+	case http.StatusMethodNotAllowed, http.StatusNotImplemented:
+		return gcerrors.Unimplemented
+	default:
+		return gcerrors.Unknown
+	}
+}
+
+// As exposes the driver itself, so that backends can lock stacks with it.
+func (b *restBucket) As(i interface{}) bool {
+	p, ok := i.(**restBucket)
+	if ok {
+		*p = b
+	}
+	return ok
+}
+
+func (b *restBucket) ErrorAs(err error, i interface{}) bool {
+	return false
+}
+
+// responseAttributes reads the attributes of an object from the headers of a GET or HEAD response.
+func responseAttributes(resp *http.Response) *driver.Attributes {
+	attrs := &driver.Attributes{
+		ContentType: resp.Header.Get("Content-Type"),
+		Size:        resp.ContentLength,
+		ETag:        resp.Header.Get("ETag"),
+	}
+	if attrs.ContentType == "" {
+		attrs.ContentType = "application/octet-stream"
+	}
+	if modified, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		attrs.ModTime = modified
+	}
+	return attrs
+}
+
+func (b *restBucket) Attributes(ctx context.Context, key string) (*driver.Attributes, error) {
+	resp, err := b.do(ctx, http.MethodHead, b.objectURL(key), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return responseAttributes(resp), nil
+}
+
+// ListPaged lists all the objects with the given prefix in a single request, and pages through them locally.
+func (b *restBucket) ListPaged(ctx context.Context, opts *driver.ListOptions) (*driver.ListPage, error) {
+	if opts.BeforeList != nil {
+		if err := opts.BeforeList(func(interface{}) bool { return false }); err != nil {
+			return nil, err
+		}
+	}
+
+	_, body, err := b.doAll(ctx, http.MethodGet, b.base+"/?prefix="+url.QueryEscape(opts.Prefix), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	var list restList
+	if err := json.Unmarshal(body, &list); err != nil {
+		return nil, fmt.Errorf("reading the list of objects with prefix %q: %w", opts.Prefix, err)
+	}
+
+	// Group the objects into directories when listing with a delimiter.
+	objects := make([]*driver.ListObject, 0, len(list.Objects))
+	dirs := make(map[string]bool)
+	for _, obj := range list.Objects {
+		if !strings.HasPrefix(obj.Key, opts.Prefix) {
+			continue
+		}
+		if opts.Delimiter != "" {
+			rest := strings.TrimPrefix(obj.Key, opts.Prefix)
+			if i := strings.Index(rest, opts.Delimiter); i >= 0 {
+				dir := opts.Prefix + rest[:i+len(opts.Delimiter)]
+				if !dirs[dir] {
+					dirs[dir] = true
+					objects = append(objects, &driver.ListObject{Key: dir, IsDir: true})
+				}
+				continue
+			}
+		}
+		objects = append(objects, &driver.ListObject{Key: obj.Key, Size: obj.Size, ModTime: obj.Modified})
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+
+	page := &driver.ListPage{}
+	for _, obj := range objects {
+		if len(opts.PageToken) > 0 && obj.Key <= string(opts.PageToken) {
+			continue
+		}
+		if opts.PageSize > 0 && len(page.Objects) == opts.PageSize {
+			page.NextPageToken = []byte(page.Objects[len(page.Objects)-1].Key)
+			break
+		}
+		page.Objects = append(page.Objects, obj)
+	}
+	return page, nil
+}
+
+// NewRangeReader reads the whole object, and returns the requested range of it.
+func (b *restBucket) NewRangeReader(
+	ctx context.Context, key string, offset, length int64, opts *driver.ReaderOptions,
+) (driver.Reader, error) {
+	resp, body, err := b.doAll(ctx, http.MethodGet, b.objectURL(key), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if opts.BeforeRead != nil {
+		if err := opts.BeforeRead(func(interface{}) bool { return false }); err != nil {
+			return nil, err
+		}
+	}
+
+	attrs := responseAttributes(resp)
+	size := int64(len(body))
+	if offset > size {
+		offset = size
+	}
+	end := size
+	if length >= 0 && offset+length < size {
+		end = offset + length
+	}
+	return &restReader{
+		Reader: bytes.NewReader(body[offset:end]),
+		attrs:  &driver.ReaderAttributes{ContentType: attrs.ContentType, ModTime: attrs.ModTime, Size: size},
+	}, nil
+}
+
+type restReader struct {
+	*bytes.Reader
+	attrs *driver.ReaderAttributes
+}
+
+func (r *restReader) Close() error                         { return nil }
+func (r *restReader) Attributes() *driver.ReaderAttributes { return r.attrs }
+func (r *restReader) As(interface{}) bool                  { return false }
+
+// NewTypedWriter buffers the object, and writes it to the server when the writer is closed.
+func (b *restBucket) NewTypedWriter(
+	ctx context.Context, key, contentType string, opts *driver.WriterOptions,
+) (driver.Writer, error) {
+	if opts.BeforeWrite != nil {
+		if err := opts.BeforeWrite(func(interface{}) bool { return false }); err != nil {
+			return nil, err
+		}
+	}
+	return &restWriter{ctx: ctx, bucket: b, key: key, contentType: contentType}, nil
+}
+
+type restWriter struct {
+	ctx         context.Context
+	bucket      *restBucket
+	key         string
+	contentType string
+	buf         bytes.Buffer
+}
+
+func (w *restWriter) Write(p []byte) (int, error) {
+	return w.buf.Write(p)
+}
+
+func (w *restWriter) Close() error {
+	// gocloud cancels the writer's context to abort a write, in which case nothing should be written.
+	if err := w.ctx.Err(); err != nil {
+		return err
+	}
+	header := http.Header{"Content-Type": []string{w.contentType}}
+	resp, err := w.bucket.do(w.ctx, w.bucket.updateMethod, w.bucket.objectURL(w.key), w.buf.Bytes(), header)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// Copy copies an object by reading it and writing it again, since the protocol has no request for copying.
+func (b *restBucket) Copy(ctx context.Context, dstKey, srcKey string, opts *driver.CopyOptions) error {
+	if opts.BeforeCopy != nil {
+		if err := opts.BeforeCopy(func(interface{}) bool { return false }); err != nil {
+			return err
+		}
+	}
+	resp, body, err := b.doAll(ctx, http.MethodGet, b.objectURL(srcKey), nil, nil)
+	if err != nil {
+		return err
+	}
+	header := http.Header{"Content-Type": []string{responseAttributes(resp).ContentType}}
+	resp, err = b.do(ctx, b.updateMethod, b.objectURL(dstKey), body, header)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func (b *restBucket) Delete(ctx context.Context, key string) error {
+	resp, err := b.do(ctx, http.MethodDelete, b.objectURL(key), nil, nil)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// SignedURL returns the unsigned URL of an object for GET requests, which is enough to link to the checkpoint of a
+// stack. The server decides whether it needs credentials.
+func (b *restBucket) SignedURL(ctx context.Context, key string, opts *driver.SignedURLOptions) (string, error) {
+	if opts.Method != http.MethodGet {
+		return "", &restError{Method: opts.Method, URL: b.objectURL(key), StatusCode: http.StatusNotImplemented}
+	}
+	return b.objectURL(key), nil
+}
+
+func (b *restBucket) Close() error {
+	return nil
+}
+
+// errStackLocked is returned when a stack can't be locked because another process holds its lock.
+var errStackLocked = errors.New("the stack is locked by another process")
+
+// Lock takes the lock with the given key on the server.
+func (b *restBucket) Lock(ctx context.Context, key string, l *lockContent) error {
+	body, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+	header := http.Header{"Content-Type": []string{"application/json"}}
+	resp, err := b.do(ctx, b.lockMethod, b.objectURL(key), body, header)
+	if err != nil {
+		if b.ErrorCode(err) == gcerrors.FailedPrecondition {
+			return fmt.Errorf("%w: %v", errStackLocked, err)
+		}
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// Unlock releases the lock with the given key on the server. If l is nil, the lock is released whoever holds it, and
+// it's not an error if it isn't held.
+func (b *restBucket) Unlock(ctx context.Context, key string, l *lockContent) error {
+	var body []byte
+	header := http.Header{}
+	if l != nil {
+		var err error
+		if body, err = json.Marshal(l); err != nil {
+			return err
+		}
+		header.Set("Content-Type", "application/json")
+	}
+	resp, err := b.do(ctx, b.unlockMethod, b.objectURL(key), body, header)
+	if err != nil {
+		if l == nil && b.ErrorCode(err) == gcerrors.NotFound {
+			return nil
+		}
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// ReadLock returns the lock with the given key held on the server, or nil if it isn't held.
+func (b *restBucket) ReadLock(ctx context.Context, key string) (*lockContent, error) {
+	_, body, err := b.doAll(ctx, http.MethodGet, b.objectURL(key)+"?lock", nil, nil)
+	if err != nil {
+		if b.ErrorCode(err) == gcerrors.NotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var l lockContent
+	if err := json.Unmarshal(body, &l); err != nil {
+		return nil, fmt.Errorf("reading the lock at %v: %w", b.objectURL(key), err)
+	}
+	return &l, nil
+}
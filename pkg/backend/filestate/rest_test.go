@@ -0,0 +1,145 @@
+// Copyright 2016-2023, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filestate
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pulumi/pulumi/pkg/v3/backend"
+	"github.com/pulumi/pulumi/pkg/v3/backend/filestate/restserver"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/apitype"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/testing/diagtest"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/workspace"
+)
+
+// newRESTTestServer starts a reference server mounted under /state, returning the URL of a backend that uses it.
+func newRESTTestServer(t *testing.T, server *restserver.Server) string {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	mux.Handle("/state/", http.StripPrefix("/state", server))
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return RESTSchemePrefix + srv.URL + "/state"
+}
+
+func TestRESTBackend(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	project := &workspace.Project{Name: "testproj"}
+	server := restserver.New()
+	server.Token = "secret"
+	stateURL := newRESTTestServer(t, server)
+	assert.True(t, IsFileStateBackendURL(stateURL))
+
+	getenv := mapGetenv(map[string]string{"PULUMI_SELF_MANAGED_STATE_REST_TOKEN": "secret"})
+	b, err := newLocalBackend(ctx, diagtest.LogSink(t), stateURL, project, &localBackendOptions{Getenv: getenv})
+	require.NoError(t, err)
+
+	ref, err := b.ParseStackReference("organization/testproj/dev")
+	require.NoError(t, err)
+	s, err := b.CreateStack(ctx, ref, "", nil)
+	require.NoError(t, err)
+	require.NoError(t, b.UpdateStackTags(ctx, s, map[apitype.StackTagName]string{"team": "infra"}))
+
+	// Another backend using the same server sees the stack.
+	same, err := newLocalBackend(ctx, diagtest.LogSink(t), stateURL, project, &localBackendOptions{Getenv: getenv})
+	require.NoError(t, err)
+	stacks, _, err := same.ListStacks(ctx, backend.ListStacksFilter{}, nil)
+	require.NoError(t, err)
+	require.Len(t, stacks, 1)
+	assert.Equal(t, "dev", stacks[0].Name().String())
+	s, err = same.GetStack(ctx, ref)
+	require.NoError(t, err)
+	assert.Equal(t, "infra", s.Tags()["team"])
+
+	// Stacks are locked by the server.
+	require.NoError(t, b.Lock(ctx, ref))
+	err = same.Lock(ctx, ref)
+	assert.ErrorContains(t, err, "the stack is currently locked by 1 lock(s)")
+	locks, err := same.StackLocks(ctx, ref)
+	require.NoError(t, err)
+	assert.Len(t, locks, 1)
+	b.Unlock(ctx, ref)
+	require.NoError(t, same.Lock(ctx, ref))
+
+	// And their locks can be broken.
+	require.NoError(t, b.CancelCurrentUpdate(ctx, ref))
+	require.NoError(t, b.Lock(ctx, ref))
+	b.Unlock(ctx, ref)
+	require.NoError(t, b.CancelCurrentUpdate(ctx, ref))
+
+	removed, err := b.RemoveStack(ctx, s, false)
+	require.NoError(t, err)
+	assert.False(t, removed)
+	stacks, _, err = same.ListStacks(ctx, backend.ListStacksFilter{}, nil)
+	require.NoError(t, err)
+	assert.Empty(t, stacks)
+}
+
+func TestRESTBackendAuth(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	project := &workspace.Project{Name: "testproj"}
+	server := restserver.New()
+	server.Username, server.Password = "user", "pass"
+	server.LockMethod, server.UnlockMethod = http.MethodPost, http.MethodPatch
+	stateURL := newRESTTestServer(t, server)
+
+	_, err := newLocalBackend(ctx, diagtest.LogSink(t), stateURL, project, &localBackendOptions{
+		Getenv: mapGetenv(map[string]string{
+			"PULUMI_SELF_MANAGED_STATE_REST_USERNAME": "user",
+			"PULUMI_SELF_MANAGED_STATE_REST_PASSWORD": "wrong",
+		}),
+	})
+	assert.ErrorContains(t, err, "401")
+
+	_, err = newLocalBackend(ctx, diagtest.LogSink(t), stateURL, project, &localBackendOptions{
+		Getenv: mapGetenv(map[string]string{
+			"PULUMI_SELF_MANAGED_STATE_REST_USERNAME": "user",
+			"PULUMI_SELF_MANAGED_STATE_REST_TOKEN":    "secret",
+		}),
+	})
+	assert.ErrorContains(t, err, "only one of")
+
+	// Servers that only allow standard methods can be locked with other methods. Writes must then use PUT, since the
+	// server uses POST for locking.
+	getenv := mapGetenv(map[string]string{
+		"PULUMI_SELF_MANAGED_STATE_REST_USERNAME":      "user",
+		"PULUMI_SELF_MANAGED_STATE_REST_PASSWORD":      "pass",
+		"PULUMI_SELF_MANAGED_STATE_REST_LOCK_METHOD":   "post",
+		"PULUMI_SELF_MANAGED_STATE_REST_UNLOCK_METHOD": "patch",
+	})
+	b, err := newLocalBackend(ctx, diagtest.LogSink(t), stateURL, project, &localBackendOptions{Getenv: getenv})
+	require.NoError(t, err)
+	other, err := newLocalBackend(ctx, diagtest.LogSink(t), stateURL, project, &localBackendOptions{Getenv: getenv})
+	require.NoError(t, err)
+	ref, err := b.ParseStackReference("dev")
+	require.NoError(t, err)
+	_, err = b.CreateStack(ctx, ref, "", nil)
+	require.NoError(t, err)
+	require.NoError(t, b.Lock(ctx, ref))
+	assert.Error(t, other.checkForLock(ctx, ref))
+	b.Unlock(ctx, ref)
+	assert.NoError(t, other.checkForLock(ctx, ref))
+}
@@ -0,0 +1,307 @@
+// Copyright 2016-2023, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package restserver is a reference implementation of the protocol spoken by the REST state backend, which stores
+// the state of self-managed stacks on an HTTP server. The backend is selected by logging in with a URL such as
+// "rest+https://example.com/state", which is the base URL "https://example.com/state" prefixed with "rest+".
+//
+// The state is a set of objects, each of which is named by a slash-separated key such as
+// ".pulumi/stacks/proj/dev.json". An object is stored at the base URL joined with its key, with each path segment
+// escaped, and is accessed with the following requests:
+//
+//	GET    {base}/{key}       200 with the object as the body, or 404 if there's no such object.
+//	HEAD   {base}/{key}       As GET, without the body.
+//	PUT    {base}/{key}       Creates or replaces the object with the body of the request. The method used for
+//	                          writes can be changed to POST with PULUMI_SELF_MANAGED_STATE_REST_UPDATE_METHOD.
+//	DELETE {base}/{key}       Deletes the object, responding 404 if there's no such object.
+//	GET    {base}/?prefix={p} Lists the objects whose keys start with the given prefix, which may be empty.
+//
+// Successful responses have a 2xx status. GET and HEAD responses should set Content-Length and Last-Modified, and
+// may set Content-Type and ETag. A listing is a JSON object of the form:
+//
+//	{"objects": [{"key": ".pulumi/meta.yaml", "size": 12, "modified": "2023-05-16T12:00:00Z"}]}
+//
+// Stacks are locked by the server, rather than with lock objects. The lock for a stack has a key such as
+// ".pulumi/locks/org/proj/dev" and is accessed with the following requests:
+//
+//	LOCK   {base}/{key}       Takes the lock. The body is a JSON object that describes the process taking the lock,
+//	                          whose "id" field identifies it. Responds 200 once the lock is held, including when
+//	                          it's already held with the same ID, or 423 with the body of the request that holds the
+//	                          lock if it's held with another ID.
+//	UNLOCK {base}/{key}       Releases the lock. The body is as for LOCK, and the server responds 409 with the body
+//	                          of the request that holds the lock if it's held with another ID. A request without a
+//	                          body releases the lock whoever holds it, which is how `pulumi cancel` breaks locks.
+//	                          Responds 404 if the lock isn't held.
+//	GET    {base}/{key}?lock  200 with the body of the request that holds the lock, or 404 if it isn't held.
+//
+// The methods used to take and release locks can be changed with PULUMI_SELF_MANAGED_STATE_REST_LOCK_METHOD and
+// PULUMI_SELF_MANAGED_STATE_REST_UNLOCK_METHOD, for servers or proxies that only allow standard methods.
+//
+// Requests are authenticated with basic authentication if PULUMI_SELF_MANAGED_STATE_REST_USERNAME and
+// PULUMI_SELF_MANAGED_STATE_REST_PASSWORD are set, or with a bearer token if PULUMI_SELF_MANAGED_STATE_REST_TOKEN
+// is set. Servers should respond 401 or 403 to requests that aren't authorized.
+package restserver
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Server is an in-memory implementation of the REST state protocol. It is intended for tests, and as a starting
+// point for implementing compatible servers.
+type Server struct {
+	// Username and Password, if set, are required with basic authentication.
+	Username string
+	Password string
+	// Token, if set, is required as a bearer token.
+	Token string
+	// LockMethod and UnlockMethod are the methods used to take and release locks. They default to LOCK and UNLOCK.
+	LockMethod   string
+	UnlockMethod string
+
+	m       sync.Mutex
+	objects map[string]object
+	locks   map[string][]byte
+}
+
+type object struct {
+	data        []byte
+	contentType string
+	modified    time.Time
+}
+
+// ListObject describes an object in a listing.
+type ListObject struct {
+	Key      string    `json:"key"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+}
+
+// List is the body of the response to a request that lists objects.
+type List struct {
+	Objects []ListObject `json:"objects"`
+}
+
+// LockInfo is the part of the body of a request to take or release a lock that the server needs to understand. The
+// server stores the whole body, and returns it to requests that find the lock held.
+type LockInfo struct {
+	ID string `json:"id"`
+}
+
+// New returns a server that holds no objects.
+func New() *Server {
+	return &Server{
+		objects: make(map[string]object),
+		locks:   make(map[string][]byte),
+	}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="pulumi"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	key := strings.TrimPrefix(r.URL.Path, "/")
+	lockMethod, unlockMethod := s.LockMethod, s.UnlockMethod
+	if lockMethod == "" {
+		lockMethod = "LOCK"
+	}
+	if unlockMethod == "" {
+		unlockMethod = "UNLOCK"
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	switch {
+	case key == "" && r.Method == http.MethodGet:
+		s.list(w, r.URL.Query().Get("prefix"))
+	case key == "":
+		http.Error(w, "objects must have a key", http.StatusBadRequest)
+	case r.Method == lockMethod:
+		s.lock(w, r, key)
+	case r.Method == unlockMethod:
+		s.unlock(w, r, key)
+	case r.Method == http.MethodGet && r.URL.Query().Has("lock"):
+		s.readLock(w, key)
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		s.read(w, r, key)
+	case r.Method == http.MethodPut || r.Method == http.MethodPost:
+		s.write(w, r, key)
+	case r.Method == http.MethodDelete:
+		s.delete(w, key)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// authorized returns true if the request has the credentials the server requires.
+func (s *Server) authorized(r *http.Request) bool {
+	if s.Username != "" || s.Password != "" {
+		username, password, ok := r.BasicAuth()
+		return ok && equal(username, s.Username) && equal(password, s.Password)
+	}
+	if s.Token != "" {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		return equal(token, s.Token)
+	}
+	return true
+}
+
+func equal(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+func (s *Server) list(w http.ResponseWriter, prefix string) {
+	list := List{Objects: []ListObject{}}
+	for key, obj := range s.objects {
+		if strings.HasPrefix(key, prefix) {
+			list.Objects = append(list.Objects, ListObject{
+				Key:      key,
+				Size:     int64(len(obj.data)),
+				Modified: obj.modified,
+			})
+		}
+	}
+	sort.Slice(list.Objects, func(i, j int) bool { return list.Objects[i].Key < list.Objects[j].Key })
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (s *Server) read(w http.ResponseWriter, r *http.Request, key string) {
+	obj, has := s.objects[key]
+	if !has {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	if obj.contentType != "" {
+		w.Header().Set("Content-Type", obj.contentType)
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(obj.data)))
+	w.Header().Set("Last-Modified", obj.modified.UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodGet {
+		_, _ = w.Write(obj.data)
+	}
+}
+
+func (s *Server) write(w http.ResponseWriter, r *http.Request, key string) {
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.objects[key] = object{
+		data:        data,
+		contentType: r.Header.Get("Content-Type"),
+		modified:    time.Now(),
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) delete(w http.ResponseWriter, key string) {
+	if _, has := s.objects[key]; !has {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	delete(s.objects, key)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) lock(w http.ResponseWriter, r *http.Request, key string) {
+	body, info, ok := readLockInfo(w, r)
+	if !ok {
+		return
+	}
+	if info.ID == "" {
+		http.Error(w, "locks must have an id", http.StatusBadRequest)
+		return
+	}
+	if held, has := s.locks[key]; has && lockID(held) != info.ID {
+		writeBody(w, http.StatusLocked, held)
+		return
+	}
+	s.locks[key] = body
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) unlock(w http.ResponseWriter, r *http.Request, key string) {
+	_, info, ok := readLockInfo(w, r)
+	if !ok {
+		return
+	}
+	held, has := s.locks[key]
+	if !has {
+		http.Error(w, "not locked", http.StatusNotFound)
+		return
+	}
+	if info.ID != "" && lockID(held) != info.ID {
+		writeBody(w, http.StatusConflict, held)
+		return
+	}
+	delete(s.locks, key)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) readLock(w http.ResponseWriter, key string) {
+	held, has := s.locks[key]
+	if !has {
+		http.Error(w, "not locked", http.StatusNotFound)
+		return
+	}
+	writeBody(w, http.StatusOK, held)
+}
+
+// readLockInfo reads the body of a request to take or release a lock. An empty body has an empty ID.
+func readLockInfo(w http.ResponseWriter, r *http.Request) ([]byte, LockInfo, bool) {
+	var info LockInfo
+	body, err := io.ReadAll(r.Body)
+	if err == nil && len(body) > 0 {
+		err = json.Unmarshal(body, &info)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, info, false
+	}
+	return body, info, true
+}
+
+func lockID(body []byte) string {
+	var info LockInfo
+	_ = json.Unmarshal(body, &info)
+	return info.ID
+}
+
+func writeBody(w http.ResponseWriter, status int, body []byte) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(body)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeBody(w, status, body)
+}
@@ -0,0 +1,102 @@
+// Copyright 2016-2023, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package restserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer(t *testing.T) {
+	t.Parallel()
+
+	s := New()
+	do := func(method, target, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(method, target, strings.NewReader(body)))
+		return w
+	}
+
+	// Objects.
+	assert.Equal(t, http.StatusNotFound, do("GET", "/a/b.json", "").Code)
+	assert.Equal(t, http.StatusNoContent, do("PUT", "/a/b.json", "{}").Code)
+	assert.Equal(t, http.StatusNoContent, do("POST", "/a/c.json", "[]").Code)
+	assert.Equal(t, http.StatusNoContent, do("PUT", "/b.json", "").Code)
+	w := do("GET", "/a/b.json", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "{}", w.Body.String())
+	assert.NotEmpty(t, w.Header().Get("Last-Modified"))
+	w = do("HEAD", "/a/c.json", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "2", w.Header().Get("Content-Length"))
+	assert.Empty(t, w.Body.String())
+
+	w = do("GET", "/?prefix=a/", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var list List
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	require.Len(t, list.Objects, 2)
+	assert.Equal(t, "a/b.json", list.Objects[0].Key)
+	assert.Equal(t, "a/c.json", list.Objects[1].Key)
+
+	assert.Equal(t, http.StatusNoContent, do("DELETE", "/a/b.json", "").Code)
+	assert.Equal(t, http.StatusNotFound, do("DELETE", "/a/b.json", "").Code)
+
+	// Locks.
+	assert.Equal(t, http.StatusNotFound, do("GET", "/locks/dev?lock", "").Code)
+	assert.Equal(t, http.StatusOK, do("LOCK", "/locks/dev", `{"id":"a","pid":1}`).Code)
+	assert.Equal(t, http.StatusOK, do("LOCK", "/locks/dev", `{"id":"a","pid":2}`).Code)
+	w = do("LOCK", "/locks/dev", `{"id":"b"}`)
+	assert.Equal(t, http.StatusLocked, w.Code)
+	assert.JSONEq(t, `{"id":"a","pid":2}`, w.Body.String())
+	w = do("GET", "/locks/dev?lock", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"id":"a","pid":2}`, w.Body.String())
+	assert.Equal(t, http.StatusNotFound, do("GET", "/locks/dev", "").Code, "locks aren't objects")
+
+	assert.Equal(t, http.StatusConflict, do("UNLOCK", "/locks/dev", `{"id":"b"}`).Code)
+	assert.Equal(t, http.StatusOK, do("UNLOCK", "/locks/dev", `{"id":"a"}`).Code)
+	assert.Equal(t, http.StatusNotFound, do("UNLOCK", "/locks/dev", `{"id":"a"}`).Code)
+
+	// A request without a body breaks the lock.
+	assert.Equal(t, http.StatusOK, do("LOCK", "/locks/dev", `{"id":"b"}`).Code)
+	assert.Equal(t, http.StatusOK, do("UNLOCK", "/locks/dev", "").Code)
+	assert.Equal(t, http.StatusNotFound, do("GET", "/locks/dev?lock", "").Code)
+}
+
+func TestServerAuth(t *testing.T) {
+	t.Parallel()
+
+	s := New()
+	s.Token = "secret"
+	do := func(authorization string) int {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("PUT", "/a.json", strings.NewReader("{}"))
+		if authorization != "" {
+			r.Header.Set("Authorization", authorization)
+		}
+		s.ServeHTTP(w, r)
+		return w.Code
+	}
+	assert.Equal(t, http.StatusUnauthorized, do(""))
+	assert.Equal(t, http.StatusUnauthorized, do("Bearer wrong"))
+	assert.Equal(t, http.StatusNoContent, do("Bearer secret"))
+}
@@ -16,9 +16,12 @@ package filestate
 
 import (
 	"context"
+	"encoding/json"
 
+	"github.com/pulumi/pulumi/pkg/v3/backend"
 	"github.com/pulumi/pulumi/pkg/v3/resource/deploy"
 	"github.com/pulumi/pulumi/pkg/v3/secrets"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/apitype"
 )
 
 // localSnapshotManager is a simple SnapshotManager implementation that persists snapshots
@@ -38,14 +41,70 @@ func (sp *localSnapshotPersister) SecretsManager() secrets.Manager {
 }
 
 func (sp *localSnapshotPersister) Save(snapshot *deploy.Snapshot) error {
+	// Once the lock on the stack has been broken, another process may be updating it.
+	select {
+	case <-sp.backend.lostLease(sp.ref):
+		return errLeaseLost
+	default:
+	}
 	_, err := sp.backend.saveStack(sp.ctx, sp.ref, snapshot, sp.sm)
 	return err
 }
 
+// localJournalPersister is a localSnapshotPersister that appends the changes made by each step of an update to a
+// journal, and only saves the stack's checkpoint when the update completes.
+type localJournalPersister struct {
+	*localSnapshotPersister
+
+	seq       int                         // the sequence number of the next segment of the journal.
+	providers *apitype.SecretsProvidersV1 // the secrets providers that the journal is encrypted with.
+}
+
+var _ backend.JournalPersister = (*localJournalPersister)(nil)
+
+func (jp *localJournalPersister) Append(entries []backend.JournalEntry) error {
+	if err := jp.backend.appendJournal(jp.ctx, jp.ref, jp.seq, entries, jp.providers); err != nil {
+		return err
+	}
+	jp.seq++
+	return nil
+}
+
+func (jp *localJournalPersister) Compact(snapshot *deploy.Snapshot) error {
+	// If the checkpoint can't be saved, the journal is left to be recovered by the next operation on the stack.
+	defer jp.backend.endJournal(jp.ref)
+
+	if err := jp.Save(snapshot); err != nil {
+		return err
+	}
+	return jp.backend.removeJournal(jp.ctx, jp.ref)
+}
+
 func (b *localBackend) newSnapshotPersister(
 	ctx context.Context,
 	ref *localBackendReference,
 	sm secrets.Manager,
-) *localSnapshotPersister {
-	return &localSnapshotPersister{ctx: ctx, ref: ref, backend: b, sm: sm}
+) backend.SnapshotPersister {
+	sp := &localSnapshotPersister{ctx: ctx, ref: ref, backend: b, sm: sm}
+	if !b.journal {
+		return sp
+	}
+
+	// The journal is encrypted with the secrets manager of the update. Encrypted state without a secrets manager is
+	// always saved as whole checkpoints.
+	var providers *apitype.SecretsProvidersV1
+	if b.encrypt {
+		if sm == nil {
+			return sp
+		}
+		providers = &apitype.SecretsProvidersV1{Type: sm.Type()}
+		if state := sm.State(); state != nil {
+			rm, err := json.Marshal(state)
+			if err != nil {
+				return sp
+			}
+			providers.State = rm
+		}
+	}
+	return &localJournalPersister{localSnapshotPersister: sp, providers: providers}
 }
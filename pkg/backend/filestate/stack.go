@@ -40,19 +40,24 @@ type Stack interface {
 
 // localStack is a local stack descriptor.
 type localStack struct {
-	ref      *localBackendReference // the stack's reference (qualified name).
-	path     string                 // a path to the stack's checkpoint file on disk.
-	snapshot *deploy.Snapshot       // a snapshot representing the latest deployment state.
-	b        *localBackend          // a pointer to the backend this stack belongs to.
+	ref      *localBackendReference          // the stack's reference (qualified name).
+	path     string                          // a path to the stack's checkpoint file on disk.
+	snapshot *deploy.Snapshot                // a snapshot representing the latest deployment state.
+	tags     map[apitype.StackTagName]string // the stack's tags.
+	b        *localBackend                   // a pointer to the backend this stack belongs to.
 }
 
-func newStack(ref *localBackendReference, path string, snapshot *deploy.Snapshot, b *localBackend) Stack {
+func newStack(
+	ref *localBackendReference, path string, snapshot *deploy.Snapshot,
+	tags map[apitype.StackTagName]string, b *localBackend,
+) Stack {
 	contract.Requiref(ref != nil, "ref", "ref was nil")
 
 	return &localStack{
 		ref:      ref,
 		path:     path,
 		snapshot: snapshot,
+		tags:     tags,
 		b:        b,
 	}
 }
@@ -65,7 +70,7 @@ func (s *localStack) Snapshot(ctx context.Context, secretsProvider secrets.Provi
 }
 func (s *localStack) Backend() backend.Backend              { return s.b }
 func (s *localStack) Path() string                          { return s.path }
-func (s *localStack) Tags() map[apitype.StackTagName]string { return nil }
+func (s *localStack) Tags() map[apitype.StackTagName]string { return s.tags }
 
 func (s *localStack) Remove(ctx context.Context, force bool) (bool, error) {
 	return backend.RemoveStack(ctx, s, force)
@@ -123,12 +128,12 @@ func (s *localStack) DefaultSecretManager(info *workspace.ProjectStack) (secrets
 }
 
 type localStackSummary struct {
-	name backend.StackReference
-	chk  *apitype.CheckpointV3
+	name    backend.StackReference
+	summary *checkpointSummary
 }
 
-func newLocalStackSummary(name backend.StackReference, chk *apitype.CheckpointV3) localStackSummary {
-	return localStackSummary{name: name, chk: chk}
+func newLocalStackSummary(name backend.StackReference, summary *checkpointSummary) localStackSummary {
+	return localStackSummary{name: name, summary: summary}
 }
 
 func (lss localStackSummary) Name() backend.StackReference {
@@ -136,18 +141,15 @@ func (lss localStackSummary) Name() backend.StackReference {
 }
 
 func (lss localStackSummary) LastUpdate() *time.Time {
-	if lss.chk != nil && lss.chk.Latest != nil {
-		if t := lss.chk.Latest.Manifest.Time; !t.IsZero() {
-			return &t
-		}
+	if lss.summary != nil {
+		return lss.summary.LastUpdate
 	}
 	return nil
 }
 
 func (lss localStackSummary) ResourceCount() *int {
-	if lss.chk != nil && lss.chk.Latest != nil {
-		count := len(lss.chk.Latest.Resources)
-		return &count
+	if lss.summary != nil {
+		return lss.summary.ResourceCount
 	}
 	return nil
 }
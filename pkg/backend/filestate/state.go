@@ -15,12 +15,15 @@
 package filestate
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 
@@ -36,6 +39,7 @@ import (
 	"github.com/pulumi/pulumi/pkg/v3/resource/stack"
 	"github.com/pulumi/pulumi/pkg/v3/secrets"
 	"github.com/pulumi/pulumi/sdk/v3/go/common/apitype"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/diag"
 	"github.com/pulumi/pulumi/sdk/v3/go/common/encoding"
 	"github.com/pulumi/pulumi/sdk/v3/go/common/resource/config"
 	"github.com/pulumi/pulumi/sdk/v3/go/common/util/cmdutil"
@@ -140,6 +144,10 @@ func (b *localBackend) getStack(
 ) (*deploy.Snapshot, string, error) {
 	contract.Requiref(ref != nil, "ref", "must not be nil")
 
+	if err := b.recoverJournal(ctx, ref); err != nil {
+		return nil, "", fmt.Errorf("failed to recover journal: %w", err)
+	}
+
 	file := b.stackPath(ctx, ref)
 
 	chk, err := b.getCheckpoint(ctx, ref)
@@ -170,14 +178,41 @@ func (b *localBackend) getCheckpoint(ctx context.Context, ref *localBackendRefer
 	if err != nil {
 		return nil, err
 	}
-	m := encoding.JSON
-	if encoding.IsCompressed(bytes) {
-		m = encoding.Gzip(m)
-	}
+	m := b.stateMarshaler(ctx, encoding.JSON, nil, false)
 
 	return stack.UnmarshalVersionedCheckpointToLatestCheckpoint(m, bytes)
 }
 
+// getCheckpointSummary returns the summary of the checkpoint of the given stack. Encrypted checkpoints store their
+// summaries in the clear, so that stacks can be listed without decrypting their checkpoints.
+func (b *localBackend) getCheckpointSummary(
+	ctx context.Context, ref *localBackendReference,
+) (*checkpointSummary, error) {
+	data, err := b.bucket.ReadAll(ctx, b.stackPath(ctx, ref))
+	if err != nil {
+		return nil, err
+	}
+	if encoding.IsCompressed(data) {
+		if data, err = gunzip(data); err != nil {
+			return nil, err
+		}
+	}
+	if isEncrypted(data) {
+		var file encryptedFile
+		if err := json.Unmarshal(data, &file); err != nil {
+			return nil, fmt.Errorf("reading encrypted file: %w", err)
+		}
+		// Checkpoints encrypted by older versions of the CLI have no summary.
+		return file.Summary, nil
+	}
+
+	var checkpoint apitype.VersionedCheckpoint
+	if err := json.Unmarshal(data, &checkpoint); err != nil {
+		return nil, fmt.Errorf("reading checkpoint: %w", err)
+	}
+	return summarizeCheckpoint(&checkpoint)
+}
+
 func (b *localBackend) saveCheckpoint(
 	ctx context.Context,
 	ref *localBackendReference,
@@ -192,11 +227,22 @@ func (b *localBackend) saveCheckpoint(
 	if filepath.Ext(file) == "" {
 		file = file + ext
 	}
+	providers, err := b.checkpointSecretsProviders(checkpoint)
+	if err != nil {
+		return "", "", fmt.Errorf("encrypting the checkpoint of stack %s: %w", ref, err)
+	}
+	sm := b.stateMarshaler(ctx, m, providers, b.gzip)
+	if sm.providers != nil {
+		// Encrypted checkpoints keep their summaries in the clear for listing stacks.
+		if sm.summary, err = summarizeCheckpoint(checkpoint); err != nil {
+			return "", "", err
+		}
+	}
+	m = sm
 	if b.gzip {
 		if filepath.Ext(file) != encoding.GZIPExt {
 			file = file + ".gz"
 		}
-		m = encoding.Gzip(m)
 	} else {
 		file = strings.TrimSuffix(file, ".gz")
 	}
@@ -298,6 +344,54 @@ func (b *localBackend) saveStack(
 	return file, nil
 }
 
+// getStackTags loads the tags for the given stack. Stacks created before tags were supported have no tags.
+func (b *localBackend) getStackTags(
+	ctx context.Context,
+	ref *localBackendReference,
+) (map[apitype.StackTagName]string, error) {
+	contract.Requiref(ref != nil, "ref", "must not be nil")
+
+	tagsPath := ref.TagsPath()
+	bytes, err := b.bucket.ReadAll(ctx, tagsPath)
+	if err != nil {
+		if gcerrors.Code(err) == gcerrors.NotFound {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("reading tags file %s: %w", tagsPath, err)
+	}
+
+	var tags map[apitype.StackTagName]string
+	if err := encoding.JSON.Unmarshal(bytes, &tags); err != nil {
+		return nil, fmt.Errorf("reading tags file %s: %w", tagsPath, err)
+	}
+	return tags, nil
+}
+
+// saveStackTags replaces the tags for the given stack.
+func (b *localBackend) saveStackTags(
+	ctx context.Context,
+	ref *localBackendReference,
+	tags map[apitype.StackTagName]string,
+) error {
+	contract.Requiref(ref != nil, "ref", "must not be nil")
+
+	bytes, err := encoding.JSON.Marshal(tags)
+	if err != nil {
+		return err
+	}
+	return b.bucket.WriteAll(ctx, ref.TagsPath(), bytes, nil)
+}
+
+// removeStackTags removes the tags for the given stack, if it has any.
+func (b *localBackend) removeStackTags(ctx context.Context, ref *localBackendReference) error {
+	contract.Requiref(ref != nil, "ref", "must not be nil")
+
+	if err := b.bucket.Delete(ctx, ref.TagsPath()); err != nil && gcerrors.Code(err) != gcerrors.NotFound {
+		return fmt.Errorf("deleting tags file: %w", err)
+	}
+	return nil
+}
+
 // removeStack removes information about a stack from the current workspace.
 func (b *localBackend) removeStack(ctx context.Context, ref *localBackendReference) error {
 	contract.Requiref(ref != nil, "ref", "must not be nil")
@@ -306,6 +400,13 @@ func (b *localBackend) removeStack(ctx context.Context, ref *localBackendReferen
 	file := b.stackPath(ctx, ref)
 	backupTarget(ctx, b.bucket, file, false)
 
+	if err := b.removeStackTags(ctx, ref); err != nil {
+		return err
+	}
+	if err := b.removeJournal(ctx, ref); err != nil {
+		return err
+	}
+
 	historyDir := ref.HistoryDir()
 	return removeAllByPrefix(ctx, b.bucket, historyDir)
 }
@@ -408,13 +509,9 @@ func (b *localBackend) stackPath(ctx context.Context, ref *localBackendReference
 	return plainPath
 }
 
-// getHistory returns locally stored update history. The first element of the result will be
+// listHistory returns the update records in the stack's history directory. The first element of the result will be
 // the most recent update record.
-func (b *localBackend) getHistory(
-	ctx context.Context,
-	stack *localBackendReference,
-	pageSize int, page int,
-) ([]backend.UpdateInfo, error) {
+func (b *localBackend) listHistory(ctx context.Context, stack *localBackendReference) ([]*blob.ListObject, error) {
 	contract.Requiref(stack != nil, "stack", "must not be nil")
 
 	dir := stack.HistoryDir()
@@ -446,6 +543,20 @@ func (b *localBackend) getHistory(
 
 		historyEntries = append(historyEntries, file)
 	}
+	return historyEntries, nil
+}
+
+// getHistory returns locally stored update history. The first element of the result will be
+// the most recent update record. Updates are numbered from 1, the oldest update, like the service does.
+func (b *localBackend) getHistory(
+	ctx context.Context,
+	stack *localBackendReference,
+	pageSize int, page int,
+) ([]backend.UpdateInfo, error) {
+	historyEntries, err := b.listHistory(ctx, stack)
+	if err != nil {
+		return nil, err
+	}
 
 	start := 0
 	end := len(historyEntries) - 1
@@ -460,30 +571,206 @@ func (b *localBackend) getHistory(
 		}
 	}
 
+	latest, err := b.latestHistoryVersion(ctx, historyEntries)
+	if err != nil {
+		return nil, err
+	}
+
 	var updates []backend.UpdateInfo
 
 	for i := start; i <= end; i++ {
-		file := historyEntries[i]
-		filepath := file.Key
+		update, err := b.readHistoryEntry(ctx, historyEntries[i].Key)
+		if err != nil {
+			return nil, err
+		}
+		update.Version = latest - i
+
+		updates = append(updates, update)
+	}
+
+	return updates, nil
+}
+
+// readHistoryEntry reads the update recorded in the given history file.
+func (b *localBackend) readHistoryEntry(ctx context.Context, filepath string) (backend.UpdateInfo, error) {
+	var update backend.UpdateInfo
+	bytes, err := b.bucket.ReadAll(ctx, filepath)
+	if err != nil {
+		return update, fmt.Errorf("reading history file %s: %w", filepath, err)
+	}
+	m := b.stateMarshaler(ctx, encoding.JSON, nil, false)
+	if err := m.Unmarshal(bytes, &update); err != nil {
+		return update, fmt.Errorf("reading history file %s: %w", filepath, err)
+	}
+	return update, nil
+}
+
+// latestHistoryVersion returns the version of the most recent of the given history entries, which must be in most
+// recent order. The versions of the rest follow on from it, one per entry. Entries record their version, since the
+// oldest entries may have been pruned, but those written by older versions of the CLI don't; histories made up of
+// such entries have never been pruned, and are numbered by their position.
+func (b *localBackend) latestHistoryVersion(ctx context.Context, historyEntries []*blob.ListObject) (int, error) {
+	if len(historyEntries) == 0 {
+		return 0, nil
+	}
+	update, err := b.readHistoryEntry(ctx, historyEntries[0].Key)
+	if err != nil {
+		return 0, err
+	}
+	if update.Version > 0 {
+		return update.Version, nil
+	}
+	return len(historyEntries), nil
+}
 
-		var update backend.UpdateInfo
-		b, err := b.bucket.ReadAll(ctx, filepath)
+// getHistoricalCheckpoint loads the copy of the checkpoint saved alongside the given version of the stack's update
+// history, as numbered by getHistory.
+func (b *localBackend) getHistoricalCheckpoint(
+	ctx context.Context,
+	ref *localBackendReference,
+	version int,
+) (*apitype.CheckpointV3, error) {
+	historyFile, err := b.historyEntryForVersion(ctx, ref, version)
+	if err != nil {
+		return nil, err
+	}
+	return b.readHistoricalCheckpoint(ctx, historyFile)
+}
+
+// historyEntryForVersion returns the path of the history file for the given version of the stack's update history,
+// as numbered by getHistory.
+func (b *localBackend) historyEntryForVersion(
+	ctx context.Context,
+	ref *localBackendReference,
+	version int,
+) (string, error) {
+	historyEntries, err := b.listHistory(ctx, ref)
+	if err != nil {
+		return "", err
+	}
+	latest, err := b.latestHistoryVersion(ctx, historyEntries)
+	if err != nil {
+		return "", err
+	}
+	index := latest - version
+	if version < 1 || index < 0 || index >= len(historyEntries) {
+		return "", fmt.Errorf("stack %s has no version %d", ref, version)
+	}
+	return historyEntries[index].Key, nil
+}
+
+// historicalCheckpointPath returns the path of the copy of the checkpoint saved alongside the given history file.
+// The checkpoint shares its name with the history file, apart from the kind of record.
+func historicalCheckpointPath(historyFile string) string {
+	return strings.Replace(historyFile, ".history.json", ".checkpoint.json", 1)
+}
+
+// historicalEventsPrefix returns the prefix of the files holding the engine events saved alongside the given history
+// file, which exist only for updates that were run by this backend. The events are saved in batches, each in a file
+// named after its zero-padded sequence number so that they sort in order.
+func historicalEventsPrefix(historyFile string) string {
+	return historyFile[:strings.LastIndex(historyFile, ".history.json")] + ".events."
+}
+
+// listHistoricalEvents returns the keys of the files holding the engine events saved alongside the given history file,
+// in the order they were written.
+func (b *localBackend) listHistoricalEvents(ctx context.Context, historyFile string) ([]string, error) {
+	iter := b.bucket.List(&blob.ListOptions{Prefix: historicalEventsPrefix(historyFile)})
+	var keys []string
+	for {
+		file, err := iter.Next(ctx)
+		if err == io.EOF {
+			break
+		}
 		if err != nil {
-			return nil, fmt.Errorf("reading history file %s: %w", filepath, err)
+			return nil, fmt.Errorf("could not list bucket: %w", err)
 		}
-		m := encoding.JSON
-		if encoding.IsCompressed(b) {
-			m = encoding.Gzip(m)
+		keys = append(keys, file.Key)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// getHistoricalEvents loads the engine events saved alongside the given version of the stack's update history, as
+// numbered by getHistory.
+func (b *localBackend) getHistoricalEvents(
+	ctx context.Context,
+	ref *localBackendReference,
+	version int,
+) ([]apitype.EngineEvent, error) {
+	historyFile, err := b.historyEntryForVersion(ctx, ref, version)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := b.listHistoricalEvents(ctx, historyFile)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no engine events were recorded for version %d of stack %s", version, ref)
+	}
+
+	m := b.stateMarshaler(ctx, encoding.JSON, nil, false)
+	var events []apitype.EngineEvent
+	for _, key := range keys {
+		bytes, err := b.bucket.ReadAll(ctx, key)
+		if err != nil {
+			return nil, fmt.Errorf("reading events file %s: %w", key, err)
 		}
-		err = m.Unmarshal(b, &update)
+		var batch apitype.EngineEventBatch
+		if err := m.Unmarshal(bytes, &batch); err != nil {
+			return nil, fmt.Errorf("reading events file %s: %w", key, err)
+		}
+		events = append(events, batch.Events...)
+	}
+	return events, nil
+}
+
+// persistEngineEvents saves the engine events of an update alongside the entry with the given path prefix in the
+// stack's history, so that the update can be displayed again. Like the service does, events are written in batches
+// as they're received rather than held until the update completes. Failing to save the events shouldn't fail the
+// update, so the rest of the events are dropped with a warning instead.
+func (b *localBackend) persistEngineEvents(
+	ctx context.Context,
+	pathPrefix string,
+	providers *apitype.SecretsProvidersV1,
+	events <-chan engine.Event,
+	done chan<- bool,
+) {
+	defer close(done)
+
+	m, ext := b.stateMarshaler(ctx, encoding.JSON, providers, b.gzip), "json"
+	if b.gzip {
+		ext += ".gz"
+	}
+
+	err := backend.RecordEngineEvents(events, func(batch int, events []apitype.EngineEvent) error {
+		byts, err := m.Marshal(&apitype.EngineEventBatch{Events: events})
 		if err != nil {
-			return nil, fmt.Errorf("reading history file %s: %w", filepath, err)
+			return err
 		}
+		eventsFile := fmt.Sprintf("%s.events.%010d.%s", pathPrefix, batch, ext)
+		return b.bucket.WriteAll(ctx, eventsFile, byts, nil)
+	})
+	if err != nil {
+		b.d.Warningf(diag.Message("", "Could not record the events of the update: %v"), err)
+	}
+}
 
-		updates = append(updates, update)
+// readHistoricalCheckpoint loads the copy of the checkpoint saved alongside the given history file.
+func (b *localBackend) readHistoricalCheckpoint(
+	ctx context.Context,
+	historyFile string,
+) (*apitype.CheckpointV3, error) {
+	chkpath := historicalCheckpointPath(historyFile)
+	bytes, err := b.bucket.ReadAll(ctx, chkpath)
+	if err != nil {
+		return nil, fmt.Errorf("reading checkpoint file %s: %w", chkpath, err)
 	}
+	m := b.stateMarshaler(ctx, encoding.JSON, nil, false)
 
-	return updates, nil
+	return stack.UnmarshalVersionedCheckpointToLatestCheckpoint(m, bytes)
 }
 
 func (b *localBackend) renameHistory(ctx context.Context, oldName, newName *localBackendReference) error {
@@ -531,16 +818,59 @@ func (b *localBackend) renameHistory(ctx context.Context, oldName, newName *loca
 
 // addToHistory saves the UpdateInfo and makes a copy of the current Checkpoint file.
 func (b *localBackend) addToHistory(ctx context.Context, ref *localBackendReference, update backend.UpdateInfo) error {
+	update.Version = 0
+	return b.writeHistoryEntry(ctx, ref, update, nil, "")
+}
+
+// historyPathPrefix returns the prefix of the files of a new entry in the stack's history.
+func historyPathPrefix(ref *localBackendReference) string {
+	return path.Join(ref.HistoryDir(), fmt.Sprintf("%s-%d", ref.name, time.Now().UnixNano()))
+}
+
+// writeHistoryEntry saves the UpdateInfo along with the given checkpoint, or a copy of the current Checkpoint file if
+// it's nil. The entry's files are named with the given prefix, as returned by historyPathPrefix, or a new one if it's
+// empty. The update is numbered after the latest one in the stack's history, or keeps its version if it's the first
+// one, as happens when a stack's history is imported from elsewhere.
+func (b *localBackend) writeHistoryEntry(
+	ctx context.Context,
+	ref *localBackendReference,
+	update backend.UpdateInfo,
+	checkpoint *apitype.VersionedCheckpoint,
+	pathPrefix string,
+) error {
 	contract.Requiref(ref != nil, "ref", "must not be nil")
 
-	dir := ref.HistoryDir()
+	// Number the update after the latest one, so that versions stay the same when old updates are pruned.
+	historyEntries, err := b.listHistory(ctx, ref)
+	if err != nil {
+		return err
+	}
+	if len(historyEntries) > 0 || update.Version <= 0 {
+		latest, err := b.latestHistoryVersion(ctx, historyEntries)
+		if err != nil {
+			return err
+		}
+		update.Version = latest + 1
+	}
 
 	// Prefix for the update and checkpoint files.
-	pathPrefix := path.Join(dir, fmt.Sprintf("%s-%d", ref.name, time.Now().UnixNano()))
+	if pathPrefix == "" {
+		pathPrefix = historyPathPrefix(ref)
+	}
+
+	// The update is encrypted along with the checkpoint saved with it.
+	var providers *apitype.SecretsProvidersV1
+	if checkpoint != nil {
+		providers, err = b.checkpointSecretsProviders(checkpoint)
+	} else {
+		providers, err = b.stackSecretsProviders(ctx, ref)
+	}
+	if err != nil {
+		return err
+	}
 
-	m, ext := encoding.JSON, "json"
+	m, ext := b.stateMarshaler(ctx, encoding.JSON, providers, b.gzip), "json"
 	if b.gzip {
-		m = encoding.Gzip(m)
 		ext += ".gz"
 	}
 
@@ -555,11 +885,137 @@ func (b *localBackend) addToHistory(ctx context.Context, ref *localBackendRefere
 		return err
 	}
 
-	// Make a copy of the checkpoint file. (Assuming it already exists.)
 	checkpointFile := fmt.Sprintf("%s.checkpoint.%s", pathPrefix, ext)
+	if checkpoint != nil {
+		byts, err := m.Marshal(checkpoint)
+		if err != nil {
+			return err
+		}
+		return b.bucket.WriteAll(ctx, checkpointFile, byts, nil)
+	}
+
+	// Make a copy of the checkpoint file. (Assuming it already exists.)
 	return b.bucket.Copy(ctx, checkpointFile, b.stackPath(ctx, ref), nil)
 }
 
+// pruneHistory removes the updates in the stack's history that the given policy doesn't keep, returning them most
+// recent first. Only a run of the oldest updates is ever removed, so that the versions of the rest don't have gaps,
+// and the run stops at the update whose checkpoint matches the stack's current state. If dryRun is true, the updates
+// are returned but not removed.
+func (b *localBackend) pruneHistory(
+	ctx context.Context,
+	ref *localBackendReference,
+	policy backend.HistoryRetention,
+	dryRun bool,
+) ([]backend.UpdateInfo, error) {
+	contract.Requiref(ref != nil, "ref", "must not be nil")
+
+	historyEntries, err := b.listHistory(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	latest, err := b.latestHistoryVersion(ctx, historyEntries)
+	if err != nil {
+		return nil, err
+	}
+
+	current, err := b.getCheckpoint(ctx, ref)
+	if err != nil && gcerrors.Code(err) != gcerrors.NotFound {
+		return nil, fmt.Errorf("reading current checkpoint: %w", err)
+	}
+
+	now := time.Now()
+	var pruned []backend.UpdateInfo
+	count := 0
+	for i := len(historyEntries) - 1; i >= 0; i-- {
+		key := historyEntries[i].Key
+		update, err := b.readHistoryEntry(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		end := update.EndTime
+		if end == 0 {
+			end = update.StartTime
+		}
+		if policy.Keeps(now, time.Unix(end, 0), i) {
+			break
+		}
+		if current != nil {
+			chk, err := b.readHistoricalCheckpoint(ctx, key)
+			if err != nil {
+				return nil, err
+			}
+			if sameState(chk, current) {
+				break
+			}
+		}
+
+		update.Version = latest - i
+		pruned = append([]backend.UpdateInfo{update}, pruned...)
+		count++
+	}
+	if dryRun || count == 0 {
+		return pruned, nil
+	}
+
+	// The versions of the remaining updates are numbered from the most recent one, so make sure that it records
+	// its version before any updates are removed.
+	if err := b.stampHistoryEntry(ctx, ref, historyEntries[0].Key, latest); err != nil {
+		return nil, err
+	}
+
+	for _, file := range historyEntries[len(historyEntries)-count:] {
+		eventKeys, err := b.listHistoricalEvents(ctx, file.Key)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range append(eventKeys, historicalCheckpointPath(file.Key), file.Key) {
+			if err := b.bucket.Delete(ctx, key); err != nil && gcerrors.Code(err) != gcerrors.NotFound {
+				return nil, fmt.Errorf("deleting history file %s: %w", key, err)
+			}
+		}
+	}
+	return pruned, nil
+}
+
+// stampHistoryEntry records the given version in the history file, if it doesn't already record one.
+func (b *localBackend) stampHistoryEntry(
+	ctx context.Context, ref *localBackendReference, historyFile string, version int,
+) error {
+	update, err := b.readHistoryEntry(ctx, historyFile)
+	if err != nil {
+		return err
+	}
+	if update.Version != 0 {
+		return nil
+	}
+	update.Version = version
+
+	providers, err := b.stackSecretsProviders(ctx, ref)
+	if err != nil {
+		return err
+	}
+	m := b.stateMarshaler(ctx, encoding.JSON, providers, strings.HasSuffix(historyFile, encoding.GZIPExt))
+	bytes, err := m.Marshal(&update)
+	if err != nil {
+		return err
+	}
+	return b.bucket.WriteAll(ctx, historyFile, bytes, nil)
+}
+
+// sameState returns true if the two checkpoints record the same resources and pending operations.
+func sameState(a, b *apitype.CheckpointV3) bool {
+	if a.Latest == nil || b.Latest == nil {
+		return a.Latest == b.Latest
+	}
+	marshal := func(d *apitype.DeploymentV3) []byte {
+		byts, err := json.Marshal([]interface{}{d.Resources, d.PendingOperations})
+		contract.AssertNoErrorf(err, "marshaling deployment")
+		return byts
+	}
+	return bytes.Equal(marshal(a.Latest), marshal(b.Latest))
+}
+
 // isPulumiDirEmpty reports whether the .pulumi directory inside the bucket
 // (used by us for bookkeeping) is empty.
 // This will ignore files in the bucket outside of the .pulumi directory.
@@ -45,6 +45,22 @@ var (
 	// BackupsDir is a path under the state's root directory
 	// where the filestate backend stores backups of stacks.
 	BackupsDir = filepath.Join(workspace.BookkeepingDir, workspace.BackupDir)
+
+	// TagsDir is a path under the state's root directory
+	// where the filestate backend stores tags for all stacks.
+	TagsDir = filepath.Join(workspace.BookkeepingDir, "tags")
+
+	// JournalsDir is a path under the state's root directory
+	// where the filestate backend stores the journals of updates in progress.
+	JournalsDir = filepath.Join(workspace.BookkeepingDir, "journals")
+
+	// PolicyConfigPath is a path under the state's root directory
+	// where the filestate backend stores which policy packs to enforce for its stacks.
+	PolicyConfigPath = filepath.Join(workspace.BookkeepingDir, "policies.json")
+
+	// PolicyPacksDir is a path under the state's root directory
+	// where the filestate backend stores archives of the policy packs enabled for its stacks.
+	PolicyPacksDir = filepath.Join(workspace.BookkeepingDir, "policies")
 )
 
 // referenceStore stores and provides access to stack information.
@@ -74,6 +90,18 @@ type referenceStore interface {
 	// This must be under BackupsDir.
 	BackupDir(*localBackendReference) string
 
+	// TagsPath returns the path to the file
+	// where tags for this stack are stored.
+	//
+	// This must be under TagsDir.
+	TagsPath(*localBackendReference) string
+
+	// JournalDir returns the path to the directory
+	// where the journal of an update to this stack is stored.
+	//
+	// This must be under JournalsDir.
+	JournalDir(*localBackendReference) string
+
 	// ListReferences lists all stack references in the store.
 	ListReferences(context.Context) ([]*localBackendReference, error)
 
@@ -131,6 +159,16 @@ func (p *projectReferenceStore) BackupDir(stack *localBackendReference) string {
 	return filepath.Join(BackupsDir, fsutil.NamePath(stack.project), fsutil.NamePath(stack.name))
 }
 
+func (p *projectReferenceStore) TagsPath(stack *localBackendReference) string {
+	contract.Requiref(stack.project != "", "ref.project", "must not be empty")
+	return filepath.Join(TagsDir, fsutil.NamePath(stack.project), fsutil.NamePath(stack.name)) + ".json"
+}
+
+func (p *projectReferenceStore) JournalDir(stack *localBackendReference) string {
+	contract.Requiref(stack.project != "", "ref.project", "must not be empty")
+	return filepath.Join(JournalsDir, fsutil.NamePath(stack.project), fsutil.NamePath(stack.name))
+}
+
 func (p *projectReferenceStore) ParseReference(stackRef string) (*localBackendReference, error) {
 	// We accept the following forms:
 	//
@@ -351,6 +389,16 @@ func (p *legacyReferenceStore) BackupDir(stack *localBackendReference) string {
 	return filepath.Join(BackupsDir, fsutil.NamePath(stack.name))
 }
 
+func (p *legacyReferenceStore) TagsPath(stack *localBackendReference) string {
+	contract.Requiref(stack.project == "", "ref.project", "must be empty")
+	return filepath.Join(TagsDir, fsutil.NamePath(stack.name)) + ".json"
+}
+
+func (p *legacyReferenceStore) JournalDir(stack *localBackendReference) string {
+	contract.Requiref(stack.project == "", "ref.project", "must be empty")
+	return filepath.Join(JournalsDir, fsutil.NamePath(stack.name))
+}
+
 func (p *legacyReferenceStore) ParseReference(stackRef string) (*localBackendReference, error) {
 	if !tokens.IsName(stackRef) || len(stackRef) > 100 {
 		return nil, fmt.Errorf(
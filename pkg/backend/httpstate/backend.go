@@ -126,8 +126,12 @@ type cloudBackend struct {
 	currentProject *workspace.Project
 }
 
-// Assert we implement the backend.Backend and backend.SpecificDeploymentExporter interfaces.
-var _ backend.SpecificDeploymentExporter = &cloudBackend{}
+// Assert we implement the backend.Backend, backend.SpecificDeploymentExporter and backend.DeploymentRollbacker
+// interfaces.
+var (
+	_ backend.SpecificDeploymentExporter = &cloudBackend{}
+	_ backend.DeploymentRollbacker       = &cloudBackend{}
+)
 
 // New creates a new Pulumi backend for the given cloud API URL and token.
 func New(d diag.Sink, cloudURL string, project *workspace.Project, insecure bool) (Backend, error) {
@@ -1389,6 +1393,16 @@ func (b *cloudBackend) ExportDeploymentForVersion(
 	return b.exportDeployment(ctx, stack.Ref(), &versionNumber)
 }
 
+// RollbackDeployment restores a previous version of the stack's deployment. The service records the import of the
+// restored deployment in the stack's history.
+func (b *cloudBackend) RollbackDeployment(ctx context.Context, stack backend.Stack, version string) error {
+	deployment, err := b.ExportDeploymentForVersion(ctx, stack, version)
+	if err != nil {
+		return err
+	}
+	return b.ImportDeployment(ctx, stack, deployment)
+}
+
 // exportDeployment exports the checkpoint file for a stack, optionally getting a previous version.
 func (b *cloudBackend) exportDeployment(
 	ctx context.Context, stackRef backend.StackReference, version *int,
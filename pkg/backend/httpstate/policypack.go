@@ -5,7 +5,6 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"os"
 	"path/filepath"
 	"strconv"
@@ -18,12 +17,9 @@ import (
 	"github.com/pulumi/pulumi/sdk/v3/go/common/apitype"
 	"github.com/pulumi/pulumi/sdk/v3/go/common/tokens"
 	"github.com/pulumi/pulumi/sdk/v3/go/common/util/archive"
-	"github.com/pulumi/pulumi/sdk/v3/go/common/util/contract"
-	"github.com/pulumi/pulumi/sdk/v3/go/common/util/logging"
 	"github.com/pulumi/pulumi/sdk/v3/go/common/util/result"
 	"github.com/pulumi/pulumi/sdk/v3/go/common/workspace"
 	"github.com/pulumi/pulumi/sdk/v3/nodejs/npm"
-	"github.com/pulumi/pulumi/sdk/v3/python"
 )
 
 type cloudRequiredPolicy struct {
@@ -75,7 +71,7 @@ func (rp *cloudRequiredPolicy) Install(ctx context.Context) (string, error) {
 		return "", err
 	}
 
-	return policyPackPath, installRequiredPolicy(ctx, policyPackPath, policyPackTarball)
+	return policyPackPath, backend.InstallRequiredPolicy(ctx, policyPackPath, policyPackTarball)
 }
 
 func (rp *cloudRequiredPolicy) Config() map[string]*json.RawMessage { return rp.RequiredPolicy.Config }
@@ -242,90 +238,3 @@ func (pack *cloudPolicyPack) Remove(ctx context.Context, op backend.PolicyPackOp
 	}
 	return pack.cl.RemovePolicyPackByVersion(ctx, pack.ref.orgName, string(pack.ref.name), *op.VersionTag)
 }
-
-const packageDir = "package"
-
-func installRequiredPolicy(ctx context.Context, finalDir string, tgz io.ReadCloser) error {
-	// If part of the directory tree is missing, os.MkdirTemp will return an error, so make sure
-	// the path we're going to create the temporary folder in actually exists.
-	if err := os.MkdirAll(filepath.Dir(finalDir), 0o700); err != nil {
-		return fmt.Errorf("creating plugin root: %w", err)
-	}
-
-	tempDir, err := os.MkdirTemp(filepath.Dir(finalDir), fmt.Sprintf("%s.tmp", filepath.Base(finalDir)))
-	if err != nil {
-		return fmt.Errorf("creating plugin directory %s: %w", tempDir, err)
-	}
-
-	// The policy pack files are actually in a directory called `package`.
-	tempPackageDir := filepath.Join(tempDir, packageDir)
-	if err := os.MkdirAll(tempPackageDir, 0o700); err != nil {
-		return fmt.Errorf("creating plugin root: %w", err)
-	}
-
-	// If we early out of this function, try to remove the temp folder we created.
-	defer func() {
-		contract.IgnoreError(os.RemoveAll(tempDir))
-	}()
-
-	// Uncompress the policy pack.
-	err = archive.ExtractTGZ(tgz, tempDir)
-	if err != nil {
-		return fmt.Errorf("failed to extract tarball: %w", err)
-	}
-
-	logging.V(7).Infof("Unpacking policy pack %q %q\n", tempDir, finalDir)
-
-	// If two calls to `plugin install` for the same plugin are racing, the second one will be
-	// unable to rename the directory. That's OK, just ignore the error. The temp directory created
-	// as part of the install will be cleaned up when we exit by the defer above.
-	if err := os.Rename(tempPackageDir, finalDir); err != nil && !os.IsExist(err) {
-		return fmt.Errorf("moving plugin: %w", err)
-	}
-
-	projPath := filepath.Join(finalDir, "PulumiPolicy.yaml")
-	proj, err := workspace.LoadPolicyPack(projPath)
-	if err != nil {
-		return fmt.Errorf("failed to load policy project at %s: %w", finalDir, err)
-	}
-
-	// TODO[pulumi/pulumi#1334]: move to the language plugins so we don't have to hard code here.
-	if strings.EqualFold(proj.Runtime.Name(), "nodejs") {
-		if err := completeNodeJSInstall(ctx, finalDir); err != nil {
-			return err
-		}
-	} else if strings.EqualFold(proj.Runtime.Name(), "python") {
-		if err := completePythonInstall(ctx, finalDir, projPath, proj); err != nil {
-			return err
-		}
-	}
-
-	fmt.Println("Finished installing policy pack")
-	fmt.Println()
-
-	return nil
-}
-
-func completeNodeJSInstall(ctx context.Context, finalDir string) error {
-	if bin, err := npm.Install(ctx, finalDir, false /*production*/, nil, os.Stderr); err != nil {
-		return fmt.Errorf("failed to install dependencies of policy pack; you may need to re-run `%s install` "+
-			"in %q before this policy pack works"+": %w", bin, finalDir, err)
-	}
-
-	return nil
-}
-
-func completePythonInstall(ctx context.Context, finalDir, projPath string, proj *workspace.PolicyPackProject) error {
-	const venvDir = "venv"
-	if err := python.InstallDependencies(ctx, finalDir, venvDir, false /*showOutput*/); err != nil {
-		return err
-	}
-
-	// Save project with venv info.
-	proj.Runtime.SetOption("virtualenv", venvDir)
-	if err := proj.Save(projPath); err != nil {
-		return fmt.Errorf("saving project at %s: %w", projPath, err)
-	}
-
-	return nil
-}
@@ -0,0 +1,341 @@
+// Copyright 2016-2023, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/pulumi/pulumi/pkg/v3/engine"
+	"github.com/pulumi/pulumi/pkg/v3/resource/deploy"
+	"github.com/pulumi/pulumi/pkg/v3/resource/stack"
+	"github.com/pulumi/pulumi/pkg/v3/secrets"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/apitype"
+	sdkDisplay "github.com/pulumi/pulumi/sdk/v3/go/common/display"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/resource"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/resource/config"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/logging"
+)
+
+// JournalPersister is a SnapshotPersister that can persist the changes made to a snapshot incrementally. A
+// SnapshotManager using a JournalPersister appends an entry to the persister's journal for each step of an update,
+// as the engine's own Journal does, rather than saving the whole snapshot after every step, and compacts the journal
+// into a full snapshot when it is closed. The snapshot can be recovered from the journal with ReplayJournal if that
+// never happens.
+type JournalPersister interface {
+	SnapshotPersister
+
+	// Append persists the given entries after any that have been appended since the journal was last compacted.
+	Append(entries []JournalEntry) error
+	// Compact persists the given snapshot in full, and then discards the journal.
+	Compact(snapshot *deploy.Snapshot) error
+}
+
+// JournalEntry is an engine.JournalEntry as it's persisted by a JournalPersister. The resource states of the entry's
+// step are identified by ID, since engine.JournalEntries.Snap relies on entries that refer to the same resource state
+// sharing it.
+type JournalEntry struct {
+	// Kind is the kind of the entry.
+	Kind engine.JournalEntryKind `json:"kind"`
+	// Op is the operation performed by the step, and URN is the URN of the resource it operates on.
+	Op  sdkDisplay.StepOp `json:"op,omitempty"`
+	URN resource.URN      `json:"urn,omitempty"`
+	// Old and New are the old and new resource states of the step, if it has them.
+	Old *JournalState `json:"old,omitempty"`
+	New *JournalState `json:"new,omitempty"`
+	// Original is the resource state replaced by an import replacement step, which the engine changes in place.
+	Original *JournalState `json:"original,omitempty"`
+
+	// Base is the serialized base snapshot of the update. An entry with a base starts the journal: entries before it
+	// are ignored, and the resources of the base snapshot are identified by their index in it.
+	Base *apitype.DeploymentV3 `json:"base,omitempty"`
+}
+
+// JournalState is a resource state referred to by a JournalEntry.
+type JournalState struct {
+	// ID identifies the resource state. Resource states that aren't part of the base snapshot are numbered after those
+	// that are, in the order in which they're first recorded.
+	ID int `json:"id"`
+	// State is the serialized resource state as of the entry. The engine changes resource states in place, so later
+	// entries replace the state of earlier ones with the same ID.
+	State apitype.ResourceV3 `json:"state"`
+}
+
+// journalStep is a step of an update that's waiting to be recorded in the journal.
+type journalStep struct {
+	kind engine.JournalEntryKind
+	step deploy.Step
+}
+
+// snapshotJournal turns the steps of an update into entries for a JournalPersister.
+type snapshotJournal struct {
+	persister JournalPersister
+	enc       config.Encrypter // encrypts secrets in recorded resource states.
+
+	lock   sync.Mutex
+	queued []journalStep // steps that haven't been recorded yet.
+
+	started bool                    // true once the base snapshot has been recorded.
+	base    []*resource.State       // the resources of the base snapshot when it was recorded.
+	ids     map[*resource.State]int // identifies the resource states referenced by the journal.
+	entries []JournalEntry          // entries that haven't been appended to the journal yet.
+	err     error                   // the first error encountered while recording entries.
+	nextID  int                     // the ID of the next resource state seen by the journal.
+}
+
+func newSnapshotJournal(persister JournalPersister) *snapshotJournal {
+	return &snapshotJournal{persister: persister}
+}
+
+// enqueue queues an entry for the given step. Steps are begun and ended concurrently, so their entries are recorded
+// by the SnapshotManager's service loop when it next retires a mutation.
+func (j *snapshotJournal) enqueue(kind engine.JournalEntryKind, step deploy.Step) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	j.queued = append(j.queued, journalStep{kind: kind, step: step})
+}
+
+// journalMutation is a SnapshotMutation whose end is recorded in the journal.
+type journalMutation struct {
+	engine.SnapshotMutation
+
+	journal *snapshotJournal
+}
+
+func (m *journalMutation) End(step deploy.Step, successful bool) error {
+	kind := engine.JournalEntryFailure
+	if successful {
+		kind = engine.JournalEntrySuccess
+	}
+	m.journal.enqueue(kind, step)
+	return m.SnapshotMutation.End(step, successful)
+}
+
+// rebase records the base snapshot if the engine has replaced its resources since it was last recorded. The engine
+// does this after refreshing resources and when adding default providers, before it makes any other changes. Any
+// changes that the SnapshotManager has made are included in the recorded base, so that the journal stays complete.
+func (j *snapshotJournal) rebase(sm *SnapshotManager) {
+	var resources []*resource.State
+	if sm.baseSnapshot != nil {
+		resources = sm.baseSnapshot.Resources
+	}
+	if j.started && sameResources(j.base, resources) {
+		return
+	}
+	logging.V(9).Infof("SnapshotManager: recording base snapshot in journal")
+
+	if j.enc == nil {
+		if sm := j.persister.SecretsManager(); sm != nil {
+			enc, err := sm.Encrypter()
+			if err != nil {
+				j.fail(fmt.Errorf("getting encrypter for journal: %w", err))
+				return
+			}
+			j.enc = enc
+		} else {
+			j.enc = config.NewPanicCrypter()
+		}
+	}
+
+	// Any entries that haven't been appended yet are superseded by the new base.
+	current := sm.snap()
+	var operations []resource.Operation
+	if sm.baseSnapshot != nil {
+		operations = sm.baseSnapshot.PendingOperations
+	}
+	base := deploy.NewSnapshot(current.Manifest, current.SecretsManager, current.Resources, operations)
+	deployment, err := stack.SerializeDeployment(base, j.persister.SecretsManager(), false /* showSecrets */)
+	if err != nil {
+		j.fail(fmt.Errorf("serializing base snapshot for journal: %w", err))
+		return
+	}
+
+	j.started, j.base, j.entries = true, resources, []JournalEntry{{Base: deployment}}
+	j.ids, j.nextID = make(map[*resource.State]int, len(base.Resources)), len(base.Resources)
+	for i, res := range base.Resources {
+		j.ids[res] = i
+	}
+}
+
+// sameResources returns true if both slices share the same backing array and length.
+func sameResources(a, b []*resource.State) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	return len(a) == 0 || &a[0] == &b[0]
+}
+
+// recordQueued records the entries of the steps that have been queued since it was last called.
+func (j *snapshotJournal) recordQueued() {
+	j.lock.Lock()
+	queued := j.queued
+	j.queued = nil
+	j.lock.Unlock()
+
+	if !j.started || j.err != nil {
+		return
+	}
+	for _, q := range queued {
+		entry := JournalEntry{Kind: q.kind, Op: q.step.Op(), URN: q.step.URN()}
+		entry.Old = j.state(q.step.Old())
+		entry.New = j.state(q.step.New())
+		if importStep, ok := q.step.(*deploy.ImportStep); ok {
+			entry.Original = j.state(importStep.Original())
+		}
+		if j.err != nil {
+			return
+		}
+		j.entries = append(j.entries, entry)
+	}
+}
+
+// state serializes the given resource state for an entry, or returns nil if there isn't one.
+func (j *snapshotJournal) state(state *resource.State) *JournalState {
+	if state == nil {
+		return nil
+	}
+
+	id, ok := j.ids[state]
+	if !ok {
+		id = j.nextID
+		j.ids[state] = id
+		j.nextID++
+	}
+
+	res, err := stack.SerializeResource(state, j.enc, false /* showSecrets */)
+	if err != nil {
+		j.fail(fmt.Errorf("serializing resource %s for journal: %w", state.URN, err))
+		return nil
+	}
+	return &JournalState{ID: id, State: res}
+}
+
+func (j *snapshotJournal) fail(err error) {
+	if j.err == nil {
+		j.err = err
+	}
+}
+
+// flush appends any outstanding entries to the journal.
+func (j *snapshotJournal) flush() error {
+	if j.err != nil {
+		return j.err
+	}
+	if len(j.entries) == 0 {
+		return nil
+	}
+	if err := j.persister.Append(j.entries); err != nil {
+		return fmt.Errorf("failed to append to journal: %w", err)
+	}
+	j.entries = nil
+	return nil
+}
+
+// replayedStep is a step read back from a journal. It only has what engine.JournalEntries.Snap needs to replay it,
+// and can't be executed.
+type replayedStep struct {
+	deploy.Step
+
+	op       sdkDisplay.StepOp
+	urn      resource.URN
+	old, new *resource.State
+}
+
+func (s *replayedStep) Op() sdkDisplay.StepOp { return s.op }
+func (s *replayedStep) URN() resource.URN     { return s.urn }
+func (s *replayedStep) Old() *resource.State  { return s.old }
+func (s *replayedStep) New() *resource.State  { return s.new }
+
+// ReplayJournal reconstructs the snapshot that the update recorded in the given journal entries had reached, by
+// replaying them with engine.JournalEntries.Snap. The entries must start with one that has a base snapshot.
+func ReplayJournal(
+	ctx context.Context,
+	entries []JournalEntry,
+	secretsProvider secrets.Provider,
+) (*deploy.Snapshot, error) {
+	var base *deploy.Snapshot
+	var states map[int]*resource.State
+	var steps engine.JournalEntries
+	var dec config.Decrypter
+	var enc config.Encrypter
+
+	// resolve returns the resource state that the given entry refers to, updated to its state as of the entry.
+	resolve := func(s *JournalState) (*resource.State, error) {
+		if s == nil {
+			return nil, nil
+		}
+		res, err := stack.DeserializeResource(s.State, dec, enc)
+		if err != nil {
+			return nil, fmt.Errorf("deserializing journal resource %d: %w", s.ID, err)
+		}
+		if state, ok := states[s.ID]; ok {
+			// Update the state in place, since earlier entries may refer to it.
+			*state = *res
+			return state, nil
+		}
+		states[s.ID] = res
+		return res, nil
+	}
+
+	for _, e := range entries {
+		if e.Base != nil {
+			var err error
+			if base, err = stack.DeserializeDeploymentV3(ctx, *e.Base, secretsProvider); err != nil {
+				return nil, fmt.Errorf("deserializing journal base snapshot: %w", err)
+			}
+			states, steps = make(map[int]*resource.State, len(base.Resources)), nil
+			for i, res := range base.Resources {
+				states[i] = res
+			}
+
+			dec, enc = config.NewPanicCrypter(), config.NewPanicCrypter()
+			if base.SecretsManager != nil {
+				if dec, err = base.SecretsManager.Decrypter(); err != nil {
+					return nil, err
+				}
+				if enc, err = base.SecretsManager.Encrypter(); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+		if base == nil {
+			return nil, errors.New("journal does not start with a base snapshot")
+		}
+
+		old, err := resolve(e.Old)
+		if err != nil {
+			return nil, err
+		}
+		new, err := resolve(e.New)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := resolve(e.Original); err != nil {
+			return nil, err
+		}
+		steps = append(steps, engine.JournalEntry{
+			Kind: e.Kind,
+			Step: &replayedStep{op: e.Op, urn: e.URN, old: old, new: new},
+		})
+	}
+	if base == nil {
+		return nil, errors.New("journal is empty")
+	}
+
+	return steps.Snap(base)
+}
@@ -0,0 +1,202 @@
+// Copyright 2016-2023, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pulumi/pulumi/pkg/v3/engine"
+	"github.com/pulumi/pulumi/pkg/v3/resource/deploy"
+	"github.com/pulumi/pulumi/pkg/v3/resource/stack"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/resource"
+)
+
+type MockJournalPersister struct {
+	MockStackPersister
+
+	Entries   []JournalEntry
+	Compacted []*deploy.Snapshot
+}
+
+func (m *MockJournalPersister) Append(entries []JournalEntry) error {
+	// Round trip the entries through JSON, like a real journal would.
+	bytes, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	var appended []JournalEntry
+	if err := json.Unmarshal(bytes, &appended); err != nil {
+		return err
+	}
+	m.Entries = append(m.Entries, appended...)
+	return nil
+}
+
+func (m *MockJournalPersister) Compact(snap *deploy.Snapshot) error {
+	m.Compacted = append(m.Compacted, snap)
+	m.Entries = nil
+	return nil
+}
+
+// assertSameResources asserts that two snapshots have the same resources, in the same order.
+func assertSameResources(t *testing.T, expected, actual *deploy.Snapshot) {
+	t.Helper()
+
+	require.Len(t, actual.Resources, len(expected.Resources))
+	for i, res := range expected.Resources {
+		assert.Equal(t, res.URN, actual.Resources[i].URN, "resource %d", i)
+		assert.Equal(t, res.Delete, actual.Resources[i].Delete, "resource %d", i)
+		assert.Equal(t, res.Dependencies, actual.Resources[i].Dependencies, "resource %d", i)
+		assert.True(t, res.Outputs.DeepEquals(actual.Resources[i].Outputs), "resource %d", i)
+	}
+	assert.Len(t, actual.PendingOperations, len(expected.PendingOperations))
+}
+
+func TestJournalReplay(t *testing.T) {
+	t.Parallel()
+
+	a := NewResource("a")
+	b := NewResource("b", a.URN)
+	c := NewResource("c", a.URN, b.URN)
+	d := NewResource("d", c.URN)
+	snap := NewSnapshot([]*resource.State{a, b, c, d})
+
+	jp := &MockJournalPersister{}
+	manager := NewSnapshotManager(jp, snap)
+
+	// After each step, replaying the journal must produce the snapshot that the manager would have saved.
+	checkReplay := func() {
+		t.Helper()
+		replayed, err := ReplayJournal(context.Background(), jp.Entries, stack.DefaultSecretsProvider)
+		require.NoError(t, err)
+		expected, err := manager.snap().NormalizeURNReferences()
+		require.NoError(t, err)
+		assertSameResources(t, expected, replayed)
+	}
+	applyStep := func(step deploy.Step) {
+		t.Helper()
+		mutation, err := manager.BeginMutation(step)
+		require.NoError(t, err)
+		require.NoError(t, mutation.End(step, true))
+	}
+
+	// A same step with no changes is elided, so it's appended along with the next step.
+	applyStep(deploy.NewSameStep(nil, MockRegisterResourceEvent{}, a, NewResource(string(a.URN))))
+	assert.Empty(t, jp.Entries)
+	bPrime := NewResource(string(b.URN))
+	applyStep(deploy.NewSameStep(nil, MockRegisterResourceEvent{}, b, bPrime))
+	checkReplay()
+
+	// The engine marks the replaced resource for deletion in place.
+	cPrime := NewResource(string(c.URN), bPrime.URN)
+	createReplacement := deploy.NewCreateReplacementStep(nil, MockRegisterResourceEvent{}, c, cPrime, nil, nil, nil, true)
+	c.Delete = true
+	applyStep(createReplacement)
+	checkReplay()
+
+	dPrime := NewResource(string(d.URN), cPrime.URN)
+	applyStep(deploy.NewUpdateStep(nil, MockRegisterResourceEvent{}, d, dPrime, nil, nil, nil, nil))
+	checkReplay()
+
+	// Outputs are registered by changing the new resource state in place.
+	dPrime.Outputs["foo"] = resource.NewStringProperty("bar")
+	require.NoError(t, manager.RegisterResourceOutputs(deploy.NewSameStep(nil, nil, d, dPrime)))
+	checkReplay()
+
+	// A pending operation is replayed too.
+	e := NewResource("e")
+	create := deploy.NewCreateStep(nil, MockRegisterResourceEvent{}, e)
+	_, err := manager.BeginMutation(create)
+	require.NoError(t, err)
+	checkReplay()
+
+	// Nothing has been saved in full yet.
+	assert.Empty(t, jp.SavedSnapshots)
+	assert.Empty(t, jp.Compacted)
+
+	// Closing the manager compacts the journal into a full snapshot.
+	expected := manager.snap()
+	require.NoError(t, manager.Close())
+	require.Len(t, jp.Compacted, 1)
+	assertSameResources(t, expected, jp.Compacted[0])
+	assert.Empty(t, jp.Entries)
+}
+
+func TestJournalRebase(t *testing.T) {
+	t.Parallel()
+
+	a := NewResource("a")
+	b := NewResource("b")
+	snap := NewSnapshot([]*resource.State{a, b})
+
+	jp := &MockJournalPersister{}
+	manager := NewSnapshotManager(jp, snap)
+
+	aPrime := NewResource(string(a.URN))
+	aPrime.Outputs["foo"] = resource.NewStringProperty("bar")
+	step := deploy.NewUpdateStep(nil, MockRegisterResourceEvent{}, a, aPrime, nil, nil, nil, nil)
+	mutation, err := manager.BeginMutation(step)
+	require.NoError(t, err)
+	require.NoError(t, mutation.End(step, true))
+
+	// The engine replaces the base snapshot's resources after a refresh.
+	refreshedB := NewResource(string(b.URN))
+	refreshedB.Outputs["refreshed"] = resource.NewBoolProperty(true)
+	snap.Resources = []*resource.State{a, refreshedB}
+
+	step = deploy.NewDeleteStep(nil, map[resource.URN]bool{}, refreshedB)
+	mutation, err = manager.BeginMutation(step)
+	require.NoError(t, err)
+
+	// The journal is rebased on the refreshed snapshot.
+	var bases int
+	for _, e := range jp.Entries {
+		if e.Base != nil {
+			bases++
+		}
+	}
+	assert.Equal(t, 2, bases)
+
+	replayed, err := ReplayJournal(context.Background(), jp.Entries, stack.DefaultSecretsProvider)
+	require.NoError(t, err)
+	require.Len(t, replayed.Resources, 2)
+	assert.Equal(t, a.URN, replayed.Resources[0].URN)
+	assert.Equal(t, resource.NewStringProperty("bar"), replayed.Resources[0].Outputs["foo"])
+	assert.Equal(t, resource.NewBoolProperty(true), replayed.Resources[1].Outputs["refreshed"])
+	require.Len(t, replayed.PendingOperations, 1)
+	assert.Equal(t, resource.OperationTypeDeleting, replayed.PendingOperations[0].Type)
+
+	require.NoError(t, mutation.End(step, true))
+	replayed, err = ReplayJournal(context.Background(), jp.Entries, stack.DefaultSecretsProvider)
+	require.NoError(t, err)
+	require.Len(t, replayed.Resources, 1)
+	assert.Empty(t, replayed.PendingOperations)
+}
+
+func TestReplayJournal_noBase(t *testing.T) {
+	t.Parallel()
+
+	_, err := ReplayJournal(context.Background(), nil, stack.DefaultSecretsProvider)
+	assert.ErrorContains(t, err, "journal is empty")
+
+	entries := []JournalEntry{{Kind: engine.JournalEntrySuccess, Op: deploy.OpSame}}
+	_, err = ReplayJournal(context.Background(), entries, stack.DefaultSecretsProvider)
+	assert.ErrorContains(t, err, "does not start with a base snapshot")
+}
@@ -64,6 +64,7 @@ type SnapshotManager struct {
 	dones            map[*resource.State]bool // The set of resources that have been operated upon already by this plan
 	completeOps      map[*resource.State]bool // The set of resources that have completed their operation
 	doVerify         bool                     // If true, verify the snapshot before persisting it
+	journal          *snapshotJournal         // The journal of changes, if the persister is a JournalPersister
 	mutationRequests chan<- mutationRequest   // The queue of mutation requests, to be retired serially by the manager
 	cancel           chan bool                // A channel used to request cancellation of any new mutation requests.
 	done             <-chan error             // A channel that sends a single result when the manager has shut down.
@@ -120,6 +121,9 @@ func (sm *SnapshotManager) mutate(mutator func() bool) error {
 // Note that this is completely not thread-safe and defeats the purpose of having a `mutate` callback
 // entirely, but the hope is that this state of things will not be permament.
 func (sm *SnapshotManager) RegisterResourceOutputs(step deploy.Step) error {
+	if sm.journal != nil {
+		sm.journal.enqueue(engine.JournalEntryOutputs, step)
+	}
 	return sm.mutate(func() bool { return true })
 }
 
@@ -130,6 +134,20 @@ func (sm *SnapshotManager) BeginMutation(step deploy.Step) (engine.SnapshotMutat
 	contract.Requiref(step != nil, "step", "cannot be nil")
 	logging.V(9).Infof("SnapshotManager: Beginning mutation for step `%s` on resource `%s`", step.Op(), step.URN())
 
+	if sm.journal == nil {
+		return sm.beginMutation(step)
+	}
+
+	sm.journal.enqueue(engine.JournalEntryBegin, step)
+	mutation, err := sm.beginMutation(step)
+	if err != nil {
+		return nil, err
+	}
+	return &journalMutation{SnapshotMutation: mutation, journal: sm.journal}, nil
+}
+
+// beginMutation returns the SnapshotMutation for the given step, recording its intent to mutate the snapshot.
+func (sm *SnapshotManager) beginMutation(step deploy.Step) (engine.SnapshotMutation, error) {
 	switch step.Op() {
 	case deploy.OpSame:
 		return &sameSnapshotMutation{sm}, nil
@@ -684,6 +702,53 @@ serviceLoop:
 	done <- err
 }
 
+// journalServiceLoop appends the changes made by each mutation to the persister's journal instead of saving the
+// whole Snapshot, and compacts the journal into a full Snapshot when SnapshotManager.Close() is invoked. Changes
+// made by mutations whose writes are elided are appended along with those of the next mutation that isn't.
+func (sm *SnapshotManager) journalServiceLoop(mutationRequests chan mutationRequest, done chan error) {
+serviceLoop:
+	for {
+		select {
+		case request := <-mutationRequests:
+			// The engine may have replaced the base snapshot's resources since the last mutation.
+			sm.journal.rebase(sm)
+			sm.journal.recordQueued()
+
+			var err error
+			if request.mutator() {
+				err = sm.journal.flush()
+			}
+			request.result <- err
+		case <-sm.cancel:
+			break serviceLoop
+		}
+	}
+
+	// Nothing has changed if there were no mutations.
+	var err error
+	if sm.journal.started {
+		err = sm.compactJournal()
+	}
+	done <- err
+}
+
+// compactJournal persists the current snapshot in full, replacing the journal.
+func (sm *SnapshotManager) compactJournal() error {
+	snap, err := sm.snap().NormalizeURNReferences()
+	if err != nil {
+		return fmt.Errorf("failed to normalize URN references: %w", err)
+	}
+	if err := sm.journal.persister.Compact(snap); err != nil {
+		return fmt.Errorf("failed to save snapshot: %w", err)
+	}
+	if sm.doVerify {
+		if err := snap.VerifyIntegrity(); err != nil {
+			return fmt.Errorf("failed to verify snapshot: %w", err)
+		}
+	}
+	return nil
+}
+
 // unsafeServiceLoop doesn't save Snapshots when mutations occur and instead saves Snapshots when
 // SnapshotManager.Close() is invoked. It trades reliability for speed as every mutation does not
 // cause a Snapshot to be serialized to the user's state backend.
@@ -722,7 +787,13 @@ func NewSnapshotManager(persister SnapshotPersister, baseSnap *deploy.Snapshot)
 
 	serviceLoop := manager.defaultServiceLoop
 
+	if journal, ok := persister.(JournalPersister); ok {
+		manager.journal = newSnapshotJournal(journal)
+		serviceLoop = manager.journalServiceLoop
+	}
+
 	if env.SkipCheckpoints.Value() {
+		manager.journal = nil
 		serviceLoop = manager.unsafeServiceLoop
 	}
 
@@ -0,0 +1,999 @@
+// Copyright 2016-2023, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sqlstate
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofrs/uuid"
+	user "github.com/tweekmonster/luser"
+
+	"github.com/pulumi/pulumi/pkg/v3/backend"
+	"github.com/pulumi/pulumi/pkg/v3/backend/display"
+	"github.com/pulumi/pulumi/pkg/v3/backend/filestate"
+	"github.com/pulumi/pulumi/pkg/v3/engine"
+	"github.com/pulumi/pulumi/pkg/v3/operations"
+	"github.com/pulumi/pulumi/pkg/v3/resource/deploy"
+	"github.com/pulumi/pulumi/pkg/v3/resource/edit"
+	"github.com/pulumi/pulumi/pkg/v3/resource/stack"
+	"github.com/pulumi/pulumi/pkg/v3/secrets"
+	"github.com/pulumi/pulumi/pkg/v3/util/validation"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/apitype"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/diag"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/diag/colors"
+	sdkDisplay "github.com/pulumi/pulumi/sdk/v3/go/common/display"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/encoding"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/resource/config"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/tokens"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/contract"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/result"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/workspace"
+)
+
+// Backend extends the base backend interface with specific information about SQL backends.
+type Backend interface {
+	backend.Backend
+	sql() // at the moment, no SQL specific info, so just use a marker function.
+}
+
+// Assert we implement the optional backend.SpecificDeploymentExporter, backend.HistoryImporter and
+// backend.UpdateEventsExporter interfaces.
+var (
+	_ backend.SpecificDeploymentExporter = &sqlBackend{}
+	_ backend.HistoryImporter            = &sqlBackend{}
+	_ backend.UpdateEventsExporter       = &sqlBackend{}
+)
+
+type sqlBackend struct {
+	d diag.Sink
+
+	// originalURL is the URL provided when the sqlBackend was initialized, for example "sqlite://~/pulumi.db".
+	originalURL string
+
+	store *store
+
+	// lockID identifies the locks taken by this backend.
+	lockID string
+
+	// The current project, if any.
+	currentProject atomic.Pointer[workspace.Project]
+}
+
+type sqlBackendReference struct {
+	name    tokens.Name
+	project tokens.Name
+
+	// A thread-safe way to get the current project.
+	// The function reference or the pointer returned by the function may be nil.
+	currentProject func() *workspace.Project
+}
+
+func (r *sqlBackendReference) String() string {
+	if r.currentProject != nil {
+		proj := r.currentProject()
+		// When stringifying backend references, we take the current project (if present) into account.
+		// If the project names match, we can elide them.
+		if proj != nil && string(r.project) == string(proj.Name) {
+			return string(r.name)
+		}
+	}
+
+	return fmt.Sprintf("organization/%s/%s", r.project, r.name)
+}
+
+func (r *sqlBackendReference) Name() tokens.Name {
+	return r.name
+}
+
+func (r *sqlBackendReference) Project() (tokens.Name, bool) {
+	return r.project, true
+}
+
+func (r *sqlBackendReference) FullyQualifiedName() tokens.QName {
+	return tokens.QName(fmt.Sprintf("organization/%s/%s", r.project, r.name))
+}
+
+// SQLitePrefix is the prefix of the URLs of backends that store state in a SQLite database, such as
+// "sqlite://~/pulumi.db".
+const SQLitePrefix = "sqlite://"
+
+// IsSQLStateBackendURL returns true if the URL uses the scheme of one of the supported SQL databases.
+func IsSQLStateBackendURL(urlstr string) bool {
+	scheme, _, ok := strings.Cut(urlstr, "://")
+	if !ok {
+		return false
+	}
+	_, has := dialects[scheme]
+	return has
+}
+
+// New constructs a new SQL backend, which stores state in the database at the given URL, such as
+// "sqlite://~/pulumi.db" for a SQLite database file. The database's tables are created if they don't exist.
+func New(ctx context.Context, d diag.Sink, originalURL string, project *workspace.Project) (Backend, error) {
+	scheme, location, ok := strings.Cut(originalURL, "://")
+	dialect, has := dialects[scheme]
+	if !ok || !has {
+		return nil, fmt.Errorf("unsupported SQL backend URL %q; the supported schemes are %s",
+			originalURL, strings.Join(dialectSchemes(), ", "))
+	}
+
+	dataSource, err := dialect.dataSource(location)
+	if err != nil {
+		return nil, fmt.Errorf("parsing backend URL %q: %w", originalURL, err)
+	}
+	store, err := openStore(ctx, dialect, dataSource)
+	if err != nil {
+		return nil, fmt.Errorf("opening the database at %q: %w", originalURL, err)
+	}
+
+	lockID, err := uuid.NewV4()
+	if err != nil {
+		contract.IgnoreClose(store)
+		return nil, err
+	}
+
+	b := &sqlBackend{
+		d:           d,
+		originalURL: originalURL,
+		store:       store,
+		lockID:      lockID.String(),
+	}
+	b.currentProject.Store(project)
+	return b, nil
+}
+
+func Login(ctx context.Context, d diag.Sink, url string, project *workspace.Project) (Backend, error) {
+	be, err := New(ctx, d, url, project)
+	if err != nil {
+		return nil, err
+	}
+	return be, workspace.StoreAccount(be.URL(), workspace.Account{}, true)
+}
+
+func (b *sqlBackend) getReference(ref backend.StackReference) (*sqlBackendReference, error) {
+	stackRef, ok := ref.(*sqlBackendReference)
+	if !ok {
+		return nil, fmt.Errorf("bad stack reference type")
+	}
+	return stackRef, nil
+}
+
+func (b *sqlBackend) sql() {}
+
+func (b *sqlBackend) Name() string {
+	name, err := os.Hostname()
+	contract.IgnoreError(err)
+	if name == "" {
+		name = "sql"
+	}
+	return name
+}
+
+func (b *sqlBackend) URL() string {
+	return b.originalURL
+}
+
+func (b *sqlBackend) SetCurrentProject(project *workspace.Project) {
+	b.currentProject.Store(project)
+}
+
+func (b *sqlBackend) SupportsTags() bool {
+	return true
+}
+
+func (b *sqlBackend) SupportsOrganizations() bool {
+	return false
+}
+
+func (b *sqlBackend) ParseStackReference(stackRef string) (backend.StackReference, error) {
+	return b.parseStackReference(stackRef)
+}
+
+func (b *sqlBackend) parseStackReference(stackRef string) (*sqlBackendReference, error) {
+	// We accept the following forms:
+	//
+	// 1. <stack-name>
+	// 2. <org-name>/<stack-name>
+	// 3. <org-name>/<project-name>/<stack-name>
+	//
+	// org-name must always be "organization", as for the filestate backend.
+	if stackRef == "" {
+		return nil, errors.New("stack name must not be empty")
+	}
+
+	var name, project, org string
+	split := strings.Split(stackRef, "/") // guaranteed to have at least one element
+	switch len(split) {
+	case 1:
+		name = split[0]
+	case 2:
+		org = split[0]
+		name = split[1]
+	case 3:
+		org = split[0]
+		project = split[1]
+		name = split[2]
+	}
+
+	if org != "" && org != "organization" {
+		return nil, errors.New("organization name must be 'organization'")
+	}
+
+	if project == "" {
+		currentProject := b.currentProject.Load()
+		if currentProject == nil {
+			return nil, fmt.Errorf("if you're using the --stack flag, " +
+				"pass the fully qualified name (organization/project/stack)")
+		}
+
+		project = currentProject.Name.String()
+	}
+
+	if len(project) > 100 {
+		return nil, errors.New("project names are limited to 100 characters")
+	}
+
+	if !tokens.IsName(project) {
+		return nil, fmt.Errorf(
+			"project names may only contain alphanumerics, hyphens, underscores, and periods: %s",
+			project)
+	}
+
+	if !tokens.IsName(name) || len(name) > 100 {
+		return nil, fmt.Errorf(
+			"stack names are limited to 100 characters and may only contain alphanumeric, hyphens, underscores, or periods: %s",
+			name)
+	}
+
+	return b.newReference(tokens.Name(project), tokens.Name(name)), nil
+}
+
+func (b *sqlBackend) newReference(project, name tokens.Name) *sqlBackendReference {
+	return &sqlBackendReference{
+		name:           name,
+		project:        project,
+		currentProject: b.currentProject.Load,
+	}
+}
+
+// ValidateStackName verifies the stack name is valid for the SQL backend.
+func (b *sqlBackend) ValidateStackName(stackRef string) error {
+	_, err := b.ParseStackReference(stackRef)
+	return err
+}
+
+func (b *sqlBackend) DoesProjectExist(ctx context.Context, projectName string) (bool, error) {
+	return b.store.projectExists(ctx, projectName)
+}
+
+// Confirm the specified stack's project doesn't contradict the meta.yaml of the current project.
+// If the CWD is not in a Pulumi project, does not contradict.
+// If the project name in Pulumi.yaml is "foo", a stack with a name of bar/foo should not work.
+func currentProjectContradictsWorkspace(stack *sqlBackendReference) bool {
+	contract.Requiref(stack != nil, "stack", "is nil")
+
+	projPath, err := workspace.DetectProjectPath()
+	if err != nil {
+		return false
+	}
+
+	if projPath == "" {
+		return false
+	}
+
+	proj, err := workspace.LoadProject(projPath)
+	if err != nil {
+		return false
+	}
+
+	return proj.Name.String() != stack.project.String()
+}
+
+func (b *sqlBackend) CreateStack(ctx context.Context, stackRef backend.StackReference,
+	root string, opts *backend.CreateStackOptions,
+) (backend.Stack, error) {
+	if opts != nil && len(opts.Teams) > 0 {
+		return nil, backend.ErrTeamsNotSupported
+	}
+
+	sqlStackRef, err := b.getReference(stackRef)
+	if err != nil {
+		return nil, err
+	}
+
+	err = b.Lock(ctx, stackRef)
+	if err != nil {
+		return nil, err
+	}
+	defer b.Unlock(ctx, stackRef)
+
+	if currentProjectContradictsWorkspace(sqlStackRef) {
+		return nil, fmt.Errorf("provided project name %q doesn't match Pulumi.yaml", sqlStackRef.project)
+	}
+
+	stackName := sqlStackRef.FullyQualifiedName()
+	tags := backend.GetEnvironmentTagsForCurrentStack(root, b.currentProject.Load())
+
+	if err = validation.ValidateStackProperties(stackName.Name().String(), tags); err != nil {
+		return nil, fmt.Errorf("validating stack properties: %w", err)
+	}
+
+	chk, err := stack.SerializeCheckpoint(stackName, nil, nil, false /* showSecrets */)
+	if err != nil {
+		return nil, fmt.Errorf("serializaing checkpoint: %w", err)
+	}
+	byts, err := encoding.JSON.Marshal(chk)
+	if err != nil {
+		return nil, err
+	}
+
+	err = b.store.createStack(ctx, string(sqlStackRef.project), string(sqlStackRef.name), byts, tags)
+	if errors.Is(err, errStackExists) {
+		return nil, &backend.StackAlreadyExistsError{StackName: string(stackName)}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	stack := newStack(sqlStackRef, nil, tags, b)
+	b.d.Infof(diag.Message("", "Created stack '%s'"), stack.Ref())
+
+	return stack, nil
+}
+
+func (b *sqlBackend) GetStack(ctx context.Context, stackRef backend.StackReference) (backend.Stack, error) {
+	sqlStackRef, err := b.getReference(stackRef)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot, err := b.getStack(ctx, sqlStackRef)
+	switch {
+	case errors.Is(err, errStackNotFound):
+		return nil, nil
+	case err != nil:
+		return nil, err
+	}
+
+	tags, err := b.store.getTags(ctx, string(sqlStackRef.project), string(sqlStackRef.name))
+	if err != nil {
+		return nil, err
+	}
+	return newStack(sqlStackRef, snapshot, tags, b), nil
+}
+
+func (b *sqlBackend) ListStacks(
+	ctx context.Context, filter backend.ListStacksFilter, _ backend.ContinuationToken) (
+	[]backend.StackSummary, backend.ContinuationToken, error,
+) {
+	// Note that only the project and tag filters are honored, since fields like
+	// organizations aren't persisted in the SQL backend.
+	stacks, err := b.store.listStacks(ctx, stackFilter{
+		project:  filter.Project,
+		tagName:  filter.TagName,
+		tagValue: filter.TagValue,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	results := make([]backend.StackSummary, 0, len(stacks))
+	for _, rec := range stacks {
+		ref := b.newReference(tokens.Name(rec.project), tokens.Name(rec.name))
+		results = append(results, newSQLStackSummary(ref, rec))
+	}
+
+	return results, nil, nil
+}
+
+func (b *sqlBackend) RemoveStack(ctx context.Context, stack backend.Stack, force bool) (bool, error) {
+	sqlStackRef, err := b.getReference(stack.Ref())
+	if err != nil {
+		return false, err
+	}
+
+	err = b.Lock(ctx, sqlStackRef)
+	if err != nil {
+		return false, err
+	}
+	defer b.Unlock(ctx, sqlStackRef)
+
+	snapshot, err := b.getStack(ctx, sqlStackRef)
+	if err != nil {
+		return false, err
+	}
+
+	// Don't remove stacks that still have resources.
+	if !force && snapshot != nil && len(snapshot.Resources) > 0 {
+		return true, errors.New("refusing to remove stack because it still contains resources")
+	}
+
+	return false, b.store.removeStack(ctx, string(sqlStackRef.project), string(sqlStackRef.name))
+}
+
+func (b *sqlBackend) RenameStack(ctx context.Context, stk backend.Stack,
+	newName tokens.QName,
+) (backend.StackReference, error) {
+	sqlStackRef, err := b.getReference(stk.Ref())
+	if err != nil {
+		return nil, err
+	}
+
+	// Ensure the new stack name is valid.
+	newRef, err := b.parseStackReference(string(newName))
+	if err != nil {
+		return nil, err
+	}
+
+	err = b.Lock(ctx, sqlStackRef)
+	if err != nil {
+		return nil, err
+	}
+	defer b.Unlock(ctx, sqlStackRef)
+
+	// Get the current state from the stack to be renamed.
+	snap, err := b.getStack(ctx, sqlStackRef)
+	if err != nil {
+		return nil, err
+	}
+
+	// If we have a snapshot, we need to rename the URNs inside it to use the new stack name.
+	if snap != nil {
+		if err = edit.RenameStack(snap, newRef.name, tokens.PackageName(newRef.project)); err != nil {
+			return nil, err
+		}
+	}
+
+	// Pass nil to re-use the existing secrets manager from the snapshot.
+	chk, err := stack.SerializeCheckpoint(newRef.FullyQualifiedName(), snap, nil, false /* showSecrets */)
+	if err != nil {
+		return nil, fmt.Errorf("serializaing checkpoint: %w", err)
+	}
+	byts, err := encoding.JSON.Marshal(chk)
+	if err != nil {
+		return nil, err
+	}
+
+	// The stack keeps its history and tags, which are stored alongside it.
+	err = b.store.renameStack(ctx,
+		string(sqlStackRef.project), string(sqlStackRef.name),
+		string(newRef.project), string(newRef.name), byts)
+	if errors.Is(err, errStackExists) {
+		return nil, fmt.Errorf("a stack named %s already exists", newRef.String())
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return newRef, nil
+}
+
+func (b *sqlBackend) GetLatestConfiguration(ctx context.Context,
+	stack backend.Stack,
+) (config.Map, error) {
+	hist, err := b.GetHistory(ctx, stack.Ref(), 1 /*pageSize*/, 1 /*page*/)
+	if err != nil {
+		return nil, err
+	}
+	if len(hist) == 0 {
+		return nil, backend.ErrNoPreviousDeployment
+	}
+
+	return hist[0].Config, nil
+}
+
+func (b *sqlBackend) GetPolicyPack(ctx context.Context, policyPack string,
+	d diag.Sink,
+) (backend.PolicyPack, error) {
+	return nil, errors.New("SQL state backend does not support resource policy")
+}
+
+func (b *sqlBackend) ListPolicyGroups(ctx context.Context, orgName string, _ backend.ContinuationToken) (
+	apitype.ListPolicyGroupsResponse, backend.ContinuationToken, error,
+) {
+	return apitype.ListPolicyGroupsResponse{}, nil, errors.New("SQL state backend does not support resource policy")
+}
+
+func (b *sqlBackend) ListPolicyPacks(ctx context.Context, orgName string, _ backend.ContinuationToken) (
+	apitype.ListPolicyPacksResponse, backend.ContinuationToken, error,
+) {
+	return apitype.ListPolicyPacksResponse{}, nil, errors.New("SQL state backend does not support resource policy")
+}
+
+func (b *sqlBackend) Preview(ctx context.Context, stack backend.Stack,
+	op backend.UpdateOperation,
+) (*deploy.Plan, sdkDisplay.ResourceChanges, result.Result) {
+	// We can skip PreviewThenPromptThenExecute and just go straight to Execute.
+	opts := backend.ApplierOptions{
+		DryRun:   true,
+		ShowLink: true,
+	}
+	return b.apply(ctx, apitype.PreviewUpdate, stack, op, opts, nil /*events*/)
+}
+
+func (b *sqlBackend) Update(ctx context.Context, stack backend.Stack,
+	op backend.UpdateOperation,
+) (sdkDisplay.ResourceChanges, result.Result) {
+	err := b.Lock(ctx, stack.Ref())
+	if err != nil {
+		return nil, result.FromError(err)
+	}
+	defer b.Unlock(ctx, stack.Ref())
+
+	return backend.PreviewThenPromptThenExecute(ctx, apitype.UpdateUpdate, stack, op, b.apply)
+}
+
+func (b *sqlBackend) Import(ctx context.Context, stack backend.Stack,
+	op backend.UpdateOperation, imports []deploy.Import,
+) (sdkDisplay.ResourceChanges, result.Result) {
+	err := b.Lock(ctx, stack.Ref())
+	if err != nil {
+		return nil, result.FromError(err)
+	}
+	defer b.Unlock(ctx, stack.Ref())
+
+	op.Imports = imports
+	return backend.PreviewThenPromptThenExecute(ctx, apitype.ResourceImportUpdate, stack, op, b.apply)
+}
+
+func (b *sqlBackend) Refresh(ctx context.Context, stack backend.Stack,
+	op backend.UpdateOperation,
+) (sdkDisplay.ResourceChanges, result.Result) {
+	err := b.Lock(ctx, stack.Ref())
+	if err != nil {
+		return nil, result.FromError(err)
+	}
+	defer b.Unlock(ctx, stack.Ref())
+
+	return backend.PreviewThenPromptThenExecute(ctx, apitype.RefreshUpdate, stack, op, b.apply)
+}
+
+func (b *sqlBackend) Destroy(ctx context.Context, stack backend.Stack,
+	op backend.UpdateOperation,
+) (sdkDisplay.ResourceChanges, result.Result) {
+	err := b.Lock(ctx, stack.Ref())
+	if err != nil {
+		return nil, result.FromError(err)
+	}
+	defer b.Unlock(ctx, stack.Ref())
+
+	return backend.PreviewThenPromptThenExecute(ctx, apitype.DestroyUpdate, stack, op, b.apply)
+}
+
+func (b *sqlBackend) Query(ctx context.Context, op backend.QueryOperation) result.Result {
+	return backend.RunQuery(ctx, b, op, nil /*events*/, b.newQuery)
+}
+
+func (b *sqlBackend) Watch(ctx context.Context, stk backend.Stack,
+	op backend.UpdateOperation, paths []string,
+) result.Result {
+	return backend.Watch(ctx, b, stk, op, b.apply, paths)
+}
+
+// apply actually performs the provided type of update on a stack stored in the database.
+func (b *sqlBackend) apply(
+	ctx context.Context, kind apitype.UpdateKind, stack backend.Stack,
+	op backend.UpdateOperation, opts backend.ApplierOptions,
+	events chan<- engine.Event,
+) (*deploy.Plan, sdkDisplay.ResourceChanges, result.Result) {
+	stackRef := stack.Ref()
+	sqlStackRef, err := b.getReference(stackRef)
+	if err != nil {
+		return nil, nil, result.FromError(err)
+	}
+
+	if currentProjectContradictsWorkspace(sqlStackRef) {
+		return nil, nil, result.Errorf("provided project name %q doesn't match Pulumi.yaml", sqlStackRef.project)
+	}
+
+	stackName := stackRef.FullyQualifiedName()
+	actionLabel := backend.ActionLabel(kind, opts.DryRun)
+
+	if !(op.Opts.Display.JSONDisplay || op.Opts.Display.Type == display.DisplayWatch) {
+		// Print a banner so it's clear this is a self-managed deployment.
+		fmt.Printf(op.Opts.Display.Color.Colorize(
+			colors.SpecHeadline+"%s (%s):"+colors.Reset+"\n"), actionLabel, stackRef)
+	}
+
+	// Start the update.
+	update, err := b.newUpdate(ctx, sqlStackRef, op)
+	if err != nil {
+		return nil, nil, result.FromError(err)
+	}
+
+	// Like the service does when an update starts, pick up any metadata changes in the stack's tags.
+	if !opts.DryRun {
+		tags := backend.GetMergedStackTags(ctx, stack, op.Root, op.Proj)
+		if err := b.store.setTags(ctx, string(sqlStackRef.project), string(sqlStackRef.name), tags); err != nil {
+			return nil, nil, result.FromError(fmt.Errorf("saving stack tags: %w", err))
+		}
+	}
+
+	// Spawn a display loop to show events on the CLI.
+	displayEvents := make(chan engine.Event)
+	displayDone := make(chan bool)
+	go display.ShowEvents(
+		strings.ToLower(actionLabel), kind, stackName.Name(), op.Proj.Name, "",
+		displayEvents, displayDone, op.Opts.Display, opts.DryRun)
+
+	// Create a separate event channel for engine events that we'll pipe to both listening streams.
+	engineEvents := make(chan engine.Event)
+
+	scope := op.Scopes.NewScope(engineEvents, opts.DryRun)
+	eventsDone := make(chan bool)
+
+	// Save the events of updates as they're received, so that they can be recorded in the stack's history.
+	var eventsID string
+	var persistEvents chan engine.Event
+	var persistDone chan bool
+	if !opts.DryRun {
+		eventsID = uuid.Must(uuid.NewV4()).String()
+		persistEvents = make(chan engine.Event)
+		persistDone = make(chan bool)
+		go b.persistEngineEvents(ctx, sqlStackRef, eventsID, persistEvents, persistDone)
+	}
+
+	go func() {
+		// Pull in all events from the engine and send them to the listeners.
+		for e := range engineEvents {
+			displayEvents <- e
+
+			if persistEvents != nil {
+				persistEvents <- e
+			}
+
+			// If the caller also wants to see the events, stream them there also.
+			if events != nil {
+				events <- e
+			}
+		}
+
+		close(eventsDone)
+	}()
+
+	// Create the management machinery.
+	persister := b.newSnapshotPersister(ctx, sqlStackRef, op.SecretsManager)
+	manager := backend.NewSnapshotManager(persister, update.GetTarget().Snapshot)
+	engineCtx := &engine.Context{
+		Cancel:          scope.Context(),
+		Events:          engineEvents,
+		SnapshotManager: manager,
+		BackendClient:   backend.NewBackendClient(b, op.SecretsProvider),
+	}
+
+	// Perform the update
+	start := time.Now().Unix()
+	var plan *deploy.Plan
+	var changes sdkDisplay.ResourceChanges
+	var updateRes result.Result
+	switch kind {
+	case apitype.PreviewUpdate:
+		plan, changes, updateRes = engine.Update(update, engineCtx, op.Opts.Engine, true)
+	case apitype.UpdateUpdate:
+		_, changes, updateRes = engine.Update(update, engineCtx, op.Opts.Engine, opts.DryRun)
+	case apitype.ResourceImportUpdate:
+		_, changes, updateRes = engine.Import(update, engineCtx, op.Opts.Engine, op.Imports, opts.DryRun)
+	case apitype.RefreshUpdate:
+		_, changes, updateRes = engine.Refresh(update, engineCtx, op.Opts.Engine, opts.DryRun)
+	case apitype.DestroyUpdate:
+		_, changes, updateRes = engine.Destroy(update, engineCtx, op.Opts.Engine, opts.DryRun)
+	default:
+		contract.Failf("Unrecognized update kind: %s", kind)
+	}
+	end := time.Now().Unix()
+
+	// Wait for the display to finish showing all the events.
+	<-displayDone
+	scope.Close() // Don't take any cancellations anymore, we're shutting down.
+	close(engineEvents)
+	contract.IgnoreClose(manager)
+
+	// Make sure the goroutine writing to displayEvents and events has exited before proceeding.
+	<-eventsDone
+	close(displayEvents)
+
+	// Wait for the events to be saved before recording them in the history.
+	if persistEvents != nil {
+		close(persistEvents)
+		<-persistDone
+	}
+
+	// Save update results.
+	backendUpdateResult := backend.SucceededResult
+	if updateRes != nil {
+		backendUpdateResult = backend.FailedResult
+	}
+	info := backend.UpdateInfo{
+		Kind:            kind,
+		StartTime:       start,
+		Message:         op.M.Message,
+		Environment:     op.M.Environment,
+		Config:          update.GetTarget().Config,
+		Result:          backendUpdateResult,
+		EndTime:         end,
+		ResourceChanges: changes,
+	}
+
+	var saveErr error
+	if !opts.DryRun {
+		saveErr = b.addToHistory(ctx, sqlStackRef, info, nil, eventsID)
+	}
+
+	if updateRes != nil {
+		// We swallow saveErr as it is less important than the updateErr.
+		return plan, changes, updateRes
+	}
+
+	if saveErr != nil {
+		return plan, changes, result.FromError(fmt.Errorf("saving update info: %w", saveErr))
+	}
+
+	return plan, changes, nil
+}
+
+func (b *sqlBackend) GetHistory(
+	ctx context.Context,
+	stackRef backend.StackReference,
+	pageSize int,
+	page int,
+) ([]backend.UpdateInfo, error) {
+	sqlStackRef, err := b.getReference(stackRef)
+	if err != nil {
+		return nil, err
+	}
+
+	offset := 0
+	if pageSize > 0 {
+		if page < 1 {
+			page = 1
+		}
+		offset = (page - 1) * pageSize
+	}
+
+	records, err := b.store.listUpdates(ctx, string(sqlStackRef.project), string(sqlStackRef.name), pageSize, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	updates := make([]backend.UpdateInfo, 0, len(records))
+	for _, rec := range records {
+		var update backend.UpdateInfo
+		if err := json.Unmarshal(rec.info, &update); err != nil {
+			return nil, fmt.Errorf("reading version %d of stack %s: %w", rec.version, sqlStackRef, err)
+		}
+		update.Version = rec.version
+		updates = append(updates, update)
+	}
+	return updates, nil
+}
+
+func (b *sqlBackend) GetLogs(ctx context.Context,
+	secretsProvider secrets.Provider, stack backend.Stack, cfg backend.StackConfiguration,
+	query operations.LogQuery,
+) ([]operations.LogEntry, error) {
+	sqlStackRef, err := b.getReference(stack.Ref())
+	if err != nil {
+		return nil, err
+	}
+
+	target, err := b.getTarget(ctx, sqlStackRef, cfg.Config, cfg.Decrypter)
+	if err != nil {
+		return nil, err
+	}
+
+	return filestate.GetLogsForTarget(target, query)
+}
+
+func (b *sqlBackend) ExportDeployment(ctx context.Context,
+	stk backend.Stack,
+) (*apitype.UntypedDeployment, error) {
+	sqlStackRef, err := b.getReference(stk.Ref())
+	if err != nil {
+		return nil, err
+	}
+
+	chk, err := b.getCheckpoint(ctx, sqlStackRef)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load checkpoint: %w", err)
+	}
+
+	return untypedDeployment(chk)
+}
+
+// ExportDeploymentForVersion exports the checkpoint saved with the given update in the stack's history. As with the
+// service, versions are positive integers and the first update of a stack is version 1.
+func (b *sqlBackend) ExportDeploymentForVersion(
+	ctx context.Context, stk backend.Stack, version string,
+) (*apitype.UntypedDeployment, error) {
+	sqlStackRef, versionNumber, err := b.parseVersion(stk, version)
+	if err != nil {
+		return nil, err
+	}
+
+	byts, err := b.store.getUpdateCheckpoint(ctx, string(sqlStackRef.project), string(sqlStackRef.name), versionNumber)
+	if errors.Is(err, errUpdateNotFound) {
+		return nil, fmt.Errorf("version %d of stack %s not found", versionNumber, sqlStackRef)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	chk, err := stack.UnmarshalVersionedCheckpointToLatestCheckpoint(encoding.JSON, byts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load checkpoint: %w", err)
+	}
+
+	return untypedDeployment(chk)
+}
+
+// ExportUpdateEvents returns the engine events saved with the given update in the stack's history. Versions are
+// numbered as for ExportDeploymentForVersion.
+func (b *sqlBackend) ExportUpdateEvents(
+	ctx context.Context, stk backend.Stack, version string,
+) ([]apitype.EngineEvent, error) {
+	sqlStackRef, versionNumber, err := b.parseVersion(stk, version)
+	if err != nil {
+		return nil, err
+	}
+
+	batches, err := b.store.getUpdateEvents(ctx, string(sqlStackRef.project), string(sqlStackRef.name), versionNumber)
+	if errors.Is(err, errUpdateNotFound) {
+		return nil, fmt.Errorf("version %d of stack %s not found", versionNumber, sqlStackRef)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if batches == nil {
+		return nil, fmt.Errorf("no engine events were recorded for version %d of stack %s", versionNumber, sqlStackRef)
+	}
+
+	var events []apitype.EngineEvent
+	for _, byts := range batches {
+		var batch apitype.EngineEventBatch
+		if err := json.Unmarshal(byts, &batch); err != nil {
+			return nil, err
+		}
+		events = append(events, batch.Events...)
+	}
+	return events, nil
+}
+
+// parseVersion parses the version of an update in the history of the given stack.
+func (b *sqlBackend) parseVersion(stk backend.Stack, version string) (*sqlBackendReference, int, error) {
+	versionNumber, err := strconv.Atoi(version)
+	if err != nil || versionNumber <= 0 {
+		return nil, 0, fmt.Errorf(
+			"%q is not a valid stack version. It should be a positive integer",
+			version)
+	}
+
+	sqlStackRef, err := b.getReference(stk.Ref())
+	if err != nil {
+		return nil, 0, err
+	}
+	return sqlStackRef, versionNumber, nil
+}
+
+func untypedDeployment(chk *apitype.CheckpointV3) (*apitype.UntypedDeployment, error) {
+	data, err := encoding.JSON.Marshal(chk.Latest)
+	if err != nil {
+		return nil, err
+	}
+
+	return &apitype.UntypedDeployment{
+		Version:    3,
+		Deployment: json.RawMessage(data),
+	}, nil
+}
+
+func (b *sqlBackend) ImportDeployment(ctx context.Context, stk backend.Stack,
+	deployment *apitype.UntypedDeployment,
+) error {
+	sqlStackRef, err := b.getReference(stk.Ref())
+	if err != nil {
+		return err
+	}
+
+	err = b.Lock(ctx, sqlStackRef)
+	if err != nil {
+		return err
+	}
+	defer b.Unlock(ctx, sqlStackRef)
+
+	stackName := sqlStackRef.FullyQualifiedName()
+	chk, err := stack.MarshalUntypedDeploymentToVersionedCheckpoint(stackName, deployment)
+	if err != nil {
+		return err
+	}
+
+	return b.saveCheckpoint(ctx, sqlStackRef, chk)
+}
+
+func (b *sqlBackend) ImportUpdate(ctx context.Context, stk backend.Stack,
+	update backend.UpdateInfo, deployment *apitype.UntypedDeployment,
+) error {
+	sqlStackRef, err := b.getReference(stk.Ref())
+	if err != nil {
+		return err
+	}
+
+	err = b.Lock(ctx, sqlStackRef)
+	if err != nil {
+		return err
+	}
+	defer b.Unlock(ctx, sqlStackRef)
+
+	chk, err := stack.MarshalUntypedDeploymentToVersionedCheckpoint(sqlStackRef.FullyQualifiedName(), deployment)
+	if err != nil {
+		return err
+	}
+
+	return b.addToHistory(ctx, sqlStackRef, update, chk, "")
+}
+
+func (b *sqlBackend) Logout() error {
+	return workspace.DeleteAccount(b.originalURL)
+}
+
+func (b *sqlBackend) LogoutAll() error {
+	return workspace.DeleteAllAccounts()
+}
+
+func (b *sqlBackend) CurrentUser() (string, []string, error) {
+	user, err := user.Current()
+	if err != nil {
+		return "", nil, err
+	}
+	return user.Username, nil, nil
+}
+
+// UpdateStackTags updates the stacks's tags, replacing all existing tags.
+func (b *sqlBackend) UpdateStackTags(ctx context.Context,
+	stack backend.Stack, tags map[apitype.StackTagName]string,
+) error {
+	sqlStackRef, err := b.getReference(stack.Ref())
+	if err != nil {
+		return err
+	}
+
+	// There's no service to validate the tags, so do it here.
+	if err := validation.ValidateStackTags(tags); err != nil {
+		return err
+	}
+
+	err = b.Lock(ctx, sqlStackRef)
+	if err != nil {
+		return err
+	}
+	defer b.Unlock(ctx, sqlStackRef)
+
+	return b.store.setTags(ctx, string(sqlStackRef.project), string(sqlStackRef.name), tags)
+}
+
+func (b *sqlBackend) CancelCurrentUpdate(ctx context.Context, stackRef backend.StackReference) error {
+	sqlStackRef, err := b.getReference(stackRef)
+	if err != nil {
+		return err
+	}
+	return b.store.unlock(ctx, string(sqlStackRef.project), string(sqlStackRef.name), "" /*lockID*/)
+}
@@ -0,0 +1,371 @@
+// Copyright 2016-2023, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sqlstate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pulumi/pulumi/pkg/v3/backend"
+	"github.com/pulumi/pulumi/pkg/v3/engine"
+	"github.com/pulumi/pulumi/pkg/v3/resource/deploy"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/apitype"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/diag/colors"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/resource"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/testing/diagtest"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/tokens"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/workspace"
+)
+
+// newTestBackend returns a backend that stores state in a new SQLite database.
+func newTestBackend(t *testing.T) (*sqlBackend, string) {
+	t.Helper()
+
+	url := SQLitePrefix + filepath.ToSlash(filepath.Join(t.TempDir(), "pulumi.db"))
+	b, err := New(context.Background(), diagtest.LogSink(t), url, &workspace.Project{Name: "testproj"})
+	require.NoError(t, err)
+	t.Cleanup(func() { assert.NoError(t, b.(*sqlBackend).store.Close()) })
+	return b.(*sqlBackend), url
+}
+
+// testDeployment returns a deployment of the given stack in testproj with resources of the given names.
+func testDeployment(t *testing.T, stack string, names ...string) *apitype.UntypedDeployment {
+	t.Helper()
+
+	var resources []apitype.ResourceV3
+	for _, name := range names {
+		resources = append(resources, apitype.ResourceV3{
+			URN:    resource.NewURN(tokens.QName(stack), "testproj", "", "a:b:c", tokens.QName(name)),
+			Type:   "a:b:c",
+			Custom: true,
+			ID:     resource.ID(name),
+		})
+	}
+	data, err := json.Marshal(apitype.DeploymentV3{Resources: resources})
+	require.NoError(t, err)
+	return &apitype.UntypedDeployment{Version: 3, Deployment: data}
+}
+
+func TestIsSQLStateBackendURL(t *testing.T) {
+	t.Parallel()
+
+	assert.True(t, IsSQLStateBackendURL("sqlite://~/pulumi.db"))
+	assert.True(t, IsSQLStateBackendURL("sqlite:///tmp/pulumi.db?cache=shared"))
+	assert.False(t, IsSQLStateBackendURL("file://~"))
+	assert.False(t, IsSQLStateBackendURL("https://api.pulumi.com"))
+	assert.False(t, IsSQLStateBackendURL("sqlite"))
+}
+
+func TestCreateAndListStacks(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	b, url := newTestBackend(t)
+
+	fooRef, err := b.ParseStackReference("foo")
+	require.NoError(t, err)
+	assert.Equal(t, "foo", fooRef.String())
+	assert.Equal(t, tokens.QName("organization/testproj/foo"), fooRef.FullyQualifiedName())
+	foo, err := b.CreateStack(ctx, fooRef, "", nil)
+	require.NoError(t, err)
+	require.NoError(t, b.UpdateStackTags(ctx, foo, map[apitype.StackTagName]string{"env": "dev"}))
+
+	_, err = b.CreateStack(ctx, fooRef, "", nil)
+	var existsErr *backend.StackAlreadyExistsError
+	assert.ErrorAs(t, err, &existsErr)
+
+	barRef, err := b.ParseStackReference("organization/otherproj/bar")
+	require.NoError(t, err)
+	bar, err := b.CreateStack(ctx, barRef, "", nil)
+	require.NoError(t, err)
+	require.NoError(t, b.ImportDeployment(ctx, bar, testDeployment(t, "bar", "a", "b")))
+
+	exists, err := b.DoesProjectExist(ctx, "otherproj")
+	require.NoError(t, err)
+	assert.True(t, exists)
+	exists, err = b.DoesProjectExist(ctx, "missing")
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	// Stacks are visible to other backends using the same database.
+	b2, err := New(ctx, diagtest.LogSink(t), url, &workspace.Project{Name: "testproj"})
+	require.NoError(t, err)
+	stacks, _, err := b2.ListStacks(ctx, backend.ListStacksFilter{}, nil)
+	require.NoError(t, err)
+	require.Len(t, stacks, 2)
+	assert.Equal(t, "organization/otherproj/bar", stacks[0].Name().String())
+	assert.Equal(t, 2, *stacks[0].ResourceCount())
+	assert.Equal(t, "foo", stacks[1].Name().String())
+	assert.Equal(t, 0, *stacks[1].ResourceCount())
+
+	project, tagName, tagValue := "testproj", "env", "dev"
+	stacks, _, err = b2.ListStacks(ctx, backend.ListStacksFilter{Project: &project}, nil)
+	require.NoError(t, err)
+	require.Len(t, stacks, 1)
+	assert.Equal(t, "foo", stacks[0].Name().String())
+	stacks, _, err = b2.ListStacks(ctx, backend.ListStacksFilter{TagName: &tagName, TagValue: &tagValue}, nil)
+	require.NoError(t, err)
+	require.Len(t, stacks, 1)
+	assert.Equal(t, "foo", stacks[0].Name().String())
+
+	stack, err := b2.GetStack(ctx, fooRef)
+	require.NoError(t, err)
+	require.NotNil(t, stack)
+	assert.Equal(t, map[apitype.StackTagName]string{"env": "dev"}, stack.Tags())
+
+	missingRef, err := b2.ParseStackReference("missing")
+	require.NoError(t, err)
+	stack, err = b2.GetStack(ctx, missingRef)
+	require.NoError(t, err)
+	assert.Nil(t, stack)
+}
+
+func TestHistory(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	b, _ := newTestBackend(t)
+
+	fooRef, err := b.parseStackReference("foo")
+	require.NoError(t, err)
+	foo, err := b.CreateStack(ctx, fooRef, "", nil)
+	require.NoError(t, err)
+
+	// Updates run by the backend record their events, but other entries in the history don't.
+	// Events are written in batches as they're received.
+	eventsID := "events"
+	events, done := make(chan engine.Event), make(chan bool)
+	go b.persistEngineEvents(ctx, fooRef, eventsID, events, done)
+	for i := 0; i < 60; i++ {
+		message := fmt.Sprintf("hello %d", i)
+		events <- engine.NewEvent(engine.StdoutColorEvent, engine.StdoutEventPayload{Message: message, Color: colors.Never})
+	}
+	events <- engine.NewEvent(engine.CancelEvent, nil)
+	close(events)
+	<-done
+	require.NoError(t, b.addToHistory(ctx, fooRef, backend.UpdateInfo{
+		Kind:    apitype.UpdateUpdate,
+		Message: "first",
+		Result:  backend.SucceededResult,
+	}, nil, eventsID))
+	require.NoError(t, b.ImportDeployment(ctx, foo, testDeployment(t, "foo", "a")))
+	require.NoError(t, b.addToHistory(ctx, fooRef, backend.UpdateInfo{
+		Kind:    apitype.RefreshUpdate,
+		Message: "second",
+		Result:  backend.FailedResult,
+	}, nil, ""))
+
+	history, err := b.GetHistory(ctx, fooRef, 0 /*pageSize*/, 0 /*page*/)
+	require.NoError(t, err)
+	require.Len(t, history, 2)
+	assert.Equal(t, 2, history[0].Version)
+	assert.Equal(t, "second", history[0].Message)
+	assert.Equal(t, backend.FailedResult, history[0].Result)
+	assert.Equal(t, 1, history[1].Version)
+	assert.Equal(t, "first", history[1].Message)
+
+	history, err = b.GetHistory(ctx, fooRef, 1 /*pageSize*/, 2 /*page*/)
+	require.NoError(t, err)
+	require.Len(t, history, 1)
+	assert.Equal(t, 1, history[0].Version)
+
+	// Each update keeps the checkpoint of the stack at the time.
+	deployment, err := b.ExportDeploymentForVersion(ctx, foo, "1")
+	require.NoError(t, err)
+	var v3 apitype.DeploymentV3
+	require.NoError(t, json.Unmarshal(deployment.Deployment, &v3))
+	assert.Empty(t, v3.Resources)
+	deployment, err = b.ExportDeploymentForVersion(ctx, foo, "2")
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(deployment.Deployment, &v3))
+	assert.Len(t, v3.Resources, 1)
+	_, err = b.ExportDeploymentForVersion(ctx, foo, "3")
+	assert.ErrorContains(t, err, "version 3 of stack foo not found")
+
+	actual, err := b.ExportUpdateEvents(ctx, foo, "1")
+	require.NoError(t, err)
+	require.Len(t, actual, 61)
+	for i, e := range actual[:60] {
+		assert.Equal(t, i, e.Sequence)
+		assert.NotZero(t, e.Timestamp)
+		require.NotNil(t, e.StdoutEvent)
+		assert.Equal(t, fmt.Sprintf("hello %d", i), e.StdoutEvent.Message)
+	}
+	assert.NotNil(t, actual[60].CancelEvent)
+	_, err = b.ExportUpdateEvents(ctx, foo, "2")
+	assert.ErrorContains(t, err, "no engine events were recorded for version 2")
+	_, err = b.ExportUpdateEvents(ctx, foo, "latest")
+	assert.ErrorContains(t, err, "not a valid stack version")
+}
+
+func TestImportUpdate(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	b, _ := newTestBackend(t)
+
+	fooRef, err := b.parseStackReference("foo")
+	require.NoError(t, err)
+	foo, err := b.CreateStack(ctx, fooRef, "", nil)
+	require.NoError(t, err)
+
+	// The first imported update keeps its version, so that versions match those of the stack it's copied from.
+	update := backend.UpdateInfo{Kind: apitype.UpdateUpdate, Version: 5, Result: backend.SucceededResult}
+	require.NoError(t, b.ImportUpdate(ctx, foo, update, testDeployment(t, "foo", "a")))
+	require.NoError(t, b.ImportUpdate(ctx, foo, update, testDeployment(t, "foo", "a", "b")))
+
+	history, err := b.GetHistory(ctx, fooRef, 0 /*pageSize*/, 0 /*page*/)
+	require.NoError(t, err)
+	require.Len(t, history, 2)
+	assert.Equal(t, 6, history[0].Version)
+	assert.Equal(t, 5, history[1].Version)
+
+	deployment, err := b.ExportDeploymentForVersion(ctx, foo, "5")
+	require.NoError(t, err)
+	var v3 apitype.DeploymentV3
+	require.NoError(t, json.Unmarshal(deployment.Deployment, &v3))
+	assert.Len(t, v3.Resources, 1)
+
+	// Importing updates doesn't change the stack's current state.
+	deployment, err = b.ExportDeployment(ctx, foo)
+	require.NoError(t, err)
+	var current apitype.DeploymentV3
+	require.NoError(t, json.Unmarshal(deployment.Deployment, &current))
+	assert.Empty(t, current.Resources)
+}
+
+func TestLocking(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	b, url := newTestBackend(t)
+	b2, err := New(ctx, diagtest.LogSink(t), url, &workspace.Project{Name: "testproj"})
+	require.NoError(t, err)
+
+	fooRef, err := b.parseStackReference("foo")
+	require.NoError(t, err)
+	foo, err := b.CreateStack(ctx, fooRef, "", nil)
+	require.NoError(t, err)
+
+	require.NoError(t, b.Lock(ctx, fooRef))
+	// Taking the lock again with the same backend succeeds.
+	require.NoError(t, b.Lock(ctx, fooRef))
+
+	// Another backend can't take the lock, or write to the stack while it's held.
+	foo2, err := b2.GetStack(ctx, fooRef)
+	require.NoError(t, err)
+	err = b2.(*sqlBackend).Lock(ctx, fooRef)
+	assert.ErrorContains(t, err, "the stack is currently locked by 1 lock(s)")
+	assert.ErrorContains(t, err, "organization/testproj/foo: created by")
+	err = b2.ImportDeployment(ctx, foo2, testDeployment(t, "foo", "a"))
+	assert.ErrorContains(t, err, "the stack is currently locked")
+
+	// The holder of the lock can still write to the stack.
+	persister := b.newSnapshotPersister(ctx, fooRef, nil)
+	require.NoError(t, persister.Save(&deploy.Snapshot{}))
+
+	// Once the lock is broken, the other backend can take it, and the holder can no longer write to the stack.
+	require.NoError(t, b2.CancelCurrentUpdate(ctx, fooRef))
+	require.NoError(t, b2.(*sqlBackend).Lock(ctx, fooRef))
+	err = persister.Save(&deploy.Snapshot{})
+	assert.ErrorContains(t, err, "the stack is currently locked")
+
+	// Releasing a lock that isn't held leaves the lock of the other backend alone.
+	b.Unlock(ctx, fooRef)
+	err = b.ImportDeployment(ctx, foo, testDeployment(t, "foo", "a"))
+	assert.ErrorContains(t, err, "the stack is currently locked")
+	b2.(*sqlBackend).Unlock(ctx, fooRef)
+	require.NoError(t, b.ImportDeployment(ctx, foo, testDeployment(t, "foo", "a")))
+}
+
+func TestRenameAndRemoveStack(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	b, _ := newTestBackend(t)
+
+	fooRef, err := b.parseStackReference("foo")
+	require.NoError(t, err)
+	foo, err := b.CreateStack(ctx, fooRef, "", nil)
+	require.NoError(t, err)
+	require.NoError(t, b.UpdateStackTags(ctx, foo, map[apitype.StackTagName]string{"env": "dev"}))
+	require.NoError(t, b.ImportDeployment(ctx, foo, testDeployment(t, "foo", "a")))
+	require.NoError(t, b.addToHistory(ctx, fooRef, backend.UpdateInfo{Kind: apitype.UpdateUpdate}, nil, ""))
+
+	barRef, err := b.parseStackReference("bar")
+	require.NoError(t, err)
+	_, err = b.CreateStack(ctx, barRef, "", nil)
+	require.NoError(t, err)
+	_, err = b.RenameStack(ctx, foo, "bar")
+	assert.ErrorContains(t, err, "a stack named bar already exists")
+
+	// The renamed stack keeps its tags and history, and its resources are renamed.
+	bazRef, err := b.RenameStack(ctx, foo, "baz")
+	require.NoError(t, err)
+	stack, err := b.GetStack(ctx, fooRef)
+	require.NoError(t, err)
+	assert.Nil(t, stack)
+	baz, err := b.GetStack(ctx, bazRef)
+	require.NoError(t, err)
+	require.NotNil(t, baz)
+	assert.Equal(t, map[apitype.StackTagName]string{"env": "dev"}, baz.Tags())
+	snap, err := baz.Snapshot(ctx, nil)
+	require.NoError(t, err)
+	require.Len(t, snap.Resources, 1)
+	assert.Equal(t, tokens.QName("baz"), snap.Resources[0].URN.Stack())
+	history, err := b.GetHistory(ctx, bazRef, 0 /*pageSize*/, 0 /*page*/)
+	require.NoError(t, err)
+	assert.Len(t, history, 1)
+
+	// Stacks with resources are only removed when forced, along with their history.
+	hasResources, err := b.RemoveStack(ctx, baz, false /*force*/)
+	assert.True(t, hasResources)
+	assert.ErrorContains(t, err, "still contains resources")
+	_, err = b.RemoveStack(ctx, baz, true /*force*/)
+	require.NoError(t, err)
+	stack, err = b.GetStack(ctx, bazRef)
+	require.NoError(t, err)
+	assert.Nil(t, stack)
+	history, err = b.GetHistory(ctx, bazRef, 0 /*pageSize*/, 0 /*page*/)
+	require.NoError(t, err)
+	assert.Empty(t, history)
+}
+
+func TestParseStackReference(t *testing.T) {
+	t.Parallel()
+
+	b, _ := newTestBackend(t)
+
+	_, err := b.ParseStackReference("")
+	assert.ErrorContains(t, err, "stack name must not be empty")
+	_, err = b.ParseStackReference("myorg/foo")
+	assert.ErrorContains(t, err, "organization name must be 'organization'")
+	_, err = b.ParseStackReference("organization/proj/foo bar")
+	assert.ErrorContains(t, err, "stack names are limited to 100 characters")
+
+	ref, err := b.ParseStackReference("organization/proj/foo")
+	require.NoError(t, err)
+	assert.Equal(t, "organization/proj/foo", ref.String())
+
+	b.SetCurrentProject(nil)
+	_, err = b.ParseStackReference("foo")
+	assert.ErrorContains(t, err, "pass the fully qualified name")
+}
@@ -0,0 +1,140 @@
+// Copyright 2016-2023, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sqlstate
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// dialect describes how the backend uses a particular kind of SQL database. The queries run by the store are written
+// in the SQL that's common to the databases we support, with "?" placeholders for their arguments, and the dialect
+// fills in the rest.
+//
+// Supporting another database, such as Postgres, means registering a dialect for it with registerDialect from the
+// init function of a file that imports its database/sql driver.
+type dialect struct {
+	// scheme is the scheme of the backend URLs that use this dialect, e.g. "sqlite" for "sqlite://path".
+	scheme string
+	// driver is the name of the database/sql driver used to open the database.
+	driver string
+	// dataSource returns the data source name used to open the database, given the rest of the backend URL after the
+	// scheme and "://".
+	dataSource func(location string) (string, error)
+
+	// idType is the type of an auto-incrementing integer primary key column.
+	idType string
+	// blobType is the type of a column that holds arbitrary bytes.
+	blobType string
+	// numberedPlaceholders is true if the database's placeholders are numbered ($1, $2, ...) rather than "?".
+	numberedPlaceholders bool
+
+	// isUniqueViolation returns true if the error was returned because a statement violated a uniqueness constraint.
+	isUniqueViolation func(err error) bool
+}
+
+// dialects are the registered dialects, indexed by their schemes.
+var dialects = map[string]*dialect{}
+
+// registerDialect makes a dialect available to backends with URLs that use its scheme.
+func registerDialect(d *dialect) {
+	dialects[d.scheme] = d
+}
+
+// dialectSchemes returns the schemes of the registered dialects, in order.
+func dialectSchemes() []string {
+	schemes := make([]string, 0, len(dialects))
+	for scheme := range dialects {
+		schemes = append(schemes, scheme)
+	}
+	sort.Strings(schemes)
+	return schemes
+}
+
+// rebind rewrites the "?" placeholders in a query to the database's placeholders.
+func (d *dialect) rebind(query string) string {
+	if !d.numberedPlaceholders {
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+	for _, c := range query {
+		if c != '?' {
+			b.WriteRune(c)
+			continue
+		}
+		n++
+		b.WriteString("$" + strconv.Itoa(n))
+	}
+	return b.String()
+}
+
+// schema returns the statements that create the tables of the store, if they don't already exist.
+func (d *dialect) schema() []string {
+	return []string{
+		`CREATE TABLE IF NOT EXISTS schema_version (
+			version INTEGER NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS stacks (
+			id ` + d.idType + `,
+			project TEXT NOT NULL,
+			name TEXT NOT NULL,
+			checkpoint ` + d.blobType + ` NOT NULL,
+			resource_count INTEGER NOT NULL DEFAULT 0,
+			last_update BIGINT,
+			UNIQUE (project, name)
+		)`,
+		`CREATE TABLE IF NOT EXISTS stack_tags (
+			stack_id INTEGER NOT NULL REFERENCES stacks (id) ON DELETE CASCADE,
+			name TEXT NOT NULL,
+			value TEXT NOT NULL,
+			PRIMARY KEY (stack_id, name)
+		)`,
+		`CREATE TABLE IF NOT EXISTS updates (
+			stack_id INTEGER NOT NULL REFERENCES stacks (id) ON DELETE CASCADE,
+			version INTEGER NOT NULL,
+			kind TEXT NOT NULL,
+			result TEXT NOT NULL,
+			start_time BIGINT NOT NULL,
+			end_time BIGINT NOT NULL,
+			info ` + d.blobType + ` NOT NULL,
+			checkpoint ` + d.blobType + ` NOT NULL,
+			events_id TEXT,
+			PRIMARY KEY (stack_id, version)
+		)`,
+		// The engine events of an update are written in batches while it runs, before it's added to the history, so
+		// they're keyed by an ID that's recorded with the update once it's added.
+		`CREATE TABLE IF NOT EXISTS update_events (
+			stack_id INTEGER NOT NULL REFERENCES stacks (id) ON DELETE CASCADE,
+			events_id TEXT NOT NULL,
+			batch INTEGER NOT NULL,
+			events ` + d.blobType + ` NOT NULL,
+			PRIMARY KEY (stack_id, events_id, batch)
+		)`,
+		// Locks are taken on stacks by name, so that a stack can be locked while it's created.
+		`CREATE TABLE IF NOT EXISTS stack_locks (
+			project TEXT NOT NULL,
+			name TEXT NOT NULL,
+			lock_id TEXT NOT NULL,
+			pid INTEGER NOT NULL,
+			username TEXT NOT NULL,
+			hostname TEXT NOT NULL,
+			created BIGINT NOT NULL,
+			PRIMARY KEY (project, name)
+		)`,
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright 2016-2023, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sqlstate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	user "github.com/tweekmonster/luser"
+
+	"github.com/pulumi/pulumi/pkg/v3/backend"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/diag"
+)
+
+// Lock takes the lock on a stack, which is held until it's released with Unlock or broken by `pulumi cancel`. The
+// lock is a row in the database, which is inserted in a transaction, so that only one process can hold it.
+func (b *sqlBackend) Lock(ctx context.Context, stackRef backend.StackReference) error {
+	sqlStackRef, err := b.getReference(stackRef)
+	if err != nil {
+		return err
+	}
+
+	u, err := user.Current()
+	if err != nil {
+		return err
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return err
+	}
+
+	err = b.store.lock(ctx, string(sqlStackRef.project), string(sqlStackRef.name), lockRecord{
+		lockID:   b.lockID,
+		pid:      os.Getpid(),
+		username: u.Username,
+		hostname: hostname,
+		created:  time.Now(),
+	})
+	return b.describeLockError(sqlStackRef, err)
+}
+
+// Unlock releases the lock on a stack taken by this backend.
+func (b *sqlBackend) Unlock(ctx context.Context, stackRef backend.StackReference) {
+	sqlStackRef, err := b.getReference(stackRef)
+	if err == nil {
+		err = b.store.unlock(ctx, string(sqlStackRef.project), string(sqlStackRef.name), b.lockID)
+	}
+	if err != nil {
+		b.d.Errorf(
+			diag.Message("", "there was a problem releasing the lock on stack %v, manual clean up may be required: %v"),
+			stackRef, err)
+	}
+}
+
+// describeLockError explains the errors returned when a stack is locked by another process, and how to break the
+// lock. Other errors are returned as they are.
+func (b *sqlBackend) describeLockError(ref *sqlBackendReference, err error) error {
+	var lockErr *errStackLocked
+	if !errors.As(err, &lockErr) {
+		return err
+	}
+
+	l := lockErr.holder
+	return fmt.Errorf("the stack is currently locked by 1 lock(s). Either wait for the other "+
+		"process(es) to end or delete the lock with `pulumi cancel`.\n  %v: created by %v@%v (pid %v) at %v",
+		ref.FullyQualifiedName(), l.username, l.hostname, l.pid, l.created.Format(time.RFC3339))
+}
@@ -0,0 +1,80 @@
+// Copyright 2016-2023, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sqlstate
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	user "github.com/tweekmonster/luser"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// The SQLite driver is a translation of SQLite's C code to Go, so that the backend works in CLIs built without cgo, as
+// released CLIs are.
+func init() {
+	registerDialect(&dialect{
+		scheme:     "sqlite",
+		driver:     "sqlite",
+		dataSource: sqliteDataSource,
+		// An INTEGER PRIMARY KEY column is an alias for the rowid, which is assigned automatically.
+		idType:   "INTEGER PRIMARY KEY",
+		blobType: "BLOB",
+		isUniqueViolation: func(err error) bool {
+			var sqliteErr *sqlite.Error
+			return errors.As(err, &sqliteErr) &&
+				(sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE ||
+					sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY)
+		},
+	})
+}
+
+// sqliteDataSource returns the data source name of the database file at the given path, which may start with ~ for
+// the user's home directory, and may be followed by a query of options for the driver.
+func sqliteDataSource(location string) (string, error) {
+	path, query, _ := strings.Cut(location, "?")
+	if path == "" {
+		return "", errors.New("the path of the database file must not be empty")
+	}
+
+	// We need to specially handle ~, as the file:// backend does.
+	if strings.HasPrefix(path, "~") {
+		usr, err := user.Current()
+		if err != nil {
+			return "", fmt.Errorf("could not determine current user to resolve `sqlite://~` path: %w", err)
+		}
+		if path == "~" {
+			path = usr.HomeDir
+		} else {
+			path = filepath.Join(usr.HomeDir, path[2:])
+		}
+	}
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return "", fmt.Errorf("building the absolute path of the database file: %w", err)
+	}
+
+	// Wait for other processes rather than failing when the database is busy, and take the write lock when a
+	// transaction begins, so that transactions that read and then write can't deadlock with each other. The
+	// database's foreign keys must be enforced for stacks to be deleted with the rows that refer to them.
+	options := "_pragma=busy_timeout(10000)&_txlock=immediate&_pragma=foreign_keys(1)&_pragma=journal_mode(WAL)"
+	if query != "" {
+		options += "&" + query
+	}
+	return "file:" + filepath.ToSlash(path) + "?" + options, nil
+}
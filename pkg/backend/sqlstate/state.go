@@ -0,0 +1,281 @@
+// Copyright 2016-2023, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sqlstate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/pulumi/pulumi/pkg/v3/backend"
+	"github.com/pulumi/pulumi/pkg/v3/backend/filestate"
+	"github.com/pulumi/pulumi/pkg/v3/engine"
+	"github.com/pulumi/pulumi/pkg/v3/resource/deploy"
+	"github.com/pulumi/pulumi/pkg/v3/resource/stack"
+	"github.com/pulumi/pulumi/pkg/v3/secrets"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/apitype"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/diag"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/encoding"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/resource/config"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/contract"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/logging"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/workspace"
+)
+
+type sqlQuery struct {
+	root string
+	proj *workspace.Project
+}
+
+func (q *sqlQuery) GetRoot() string {
+	return q.root
+}
+
+func (q *sqlQuery) GetProject() *workspace.Project {
+	return q.proj
+}
+
+// update is an implementation of engine.Update backed by state in a SQL database.
+type update struct {
+	root    string
+	proj    *workspace.Project
+	target  *deploy.Target
+	backend *sqlBackend
+}
+
+func (u *update) GetRoot() string {
+	return u.root
+}
+
+func (u *update) GetProject() *workspace.Project {
+	return u.proj
+}
+
+func (u *update) GetTarget() *deploy.Target {
+	return u.target
+}
+
+func (b *sqlBackend) newQuery(
+	ctx context.Context,
+	op backend.QueryOperation,
+) (engine.QueryInfo, error) {
+	return &sqlQuery{root: op.Root, proj: op.Proj}, nil
+}
+
+func (b *sqlBackend) newUpdate(
+	ctx context.Context,
+	ref *sqlBackendReference,
+	op backend.UpdateOperation,
+) (*update, error) {
+	contract.Requiref(ref != nil, "ref", "must not be nil")
+
+	// Construct the deployment target.
+	target, err := b.getTarget(ctx, ref,
+		op.StackConfiguration.Config, op.StackConfiguration.Decrypter)
+	if err != nil {
+		return nil, err
+	}
+
+	// Construct and return a new update.
+	return &update{
+		root:    op.Root,
+		proj:    op.Proj,
+		target:  target,
+		backend: b,
+	}, nil
+}
+
+func (b *sqlBackend) getTarget(
+	ctx context.Context,
+	stack *sqlBackendReference,
+	cfg config.Map,
+	dec config.Decrypter,
+) (*deploy.Target, error) {
+	contract.Requiref(stack != nil, "stack", "must not be nil")
+	snapshot, err := b.getStack(ctx, stack)
+	if err != nil {
+		return nil, err
+	}
+	return &deploy.Target{
+		Name:         stack.Name(),
+		Organization: "organization", // like filestate, we have no organizations, but always say it's "organization"
+		Config:       cfg,
+		Decrypter:    dec,
+		Snapshot:     snapshot,
+	}, nil
+}
+
+// getStack loads the snapshot of the given stack, failing with errStackNotFound if it doesn't exist.
+func (b *sqlBackend) getStack(
+	ctx context.Context,
+	ref *sqlBackendReference,
+) (*deploy.Snapshot, error) {
+	contract.Requiref(ref != nil, "ref", "must not be nil")
+
+	chk, err := b.getCheckpoint(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	// Materialize an actual snapshot object.
+	snapshot, err := stack.DeserializeCheckpoint(ctx, stack.DefaultSecretsProvider, chk)
+	if err != nil {
+		return nil, err
+	}
+
+	// Ensure the snapshot passes verification before returning it, to catch bugs early.
+	if !filestate.DisableIntegrityChecking {
+		if verifyerr := snapshot.VerifyIntegrity(); verifyerr != nil {
+			return nil, fmt.Errorf("%s: snapshot integrity failure; refusing to use it: %w", ref, verifyerr)
+		}
+	}
+
+	return snapshot, nil
+}
+
+// getCheckpoint loads the checkpoint of the given stack, failing with errStackNotFound if it doesn't exist.
+func (b *sqlBackend) getCheckpoint(ctx context.Context, ref *sqlBackendReference) (*apitype.CheckpointV3, error) {
+	rec, err := b.store.getStack(ctx, string(ref.project), string(ref.name))
+	if err != nil {
+		return nil, err
+	}
+	if rec == nil {
+		return nil, errStackNotFound
+	}
+
+	return stack.UnmarshalVersionedCheckpointToLatestCheckpoint(encoding.JSON, rec.checkpoint)
+}
+
+// saveCheckpoint replaces the checkpoint of the given stack. This fails if another process holds the stack's lock.
+func (b *sqlBackend) saveCheckpoint(
+	ctx context.Context,
+	ref *sqlBackendReference,
+	checkpoint *apitype.VersionedCheckpoint,
+) error {
+	byts, err := encoding.JSON.Marshal(checkpoint)
+	if err != nil {
+		return fmt.Errorf("An IO error occurred while marshalling the checkpoint: %w", err)
+	}
+
+	// Summarize the checkpoint, so that stacks can be listed without reading their checkpoints.
+	var resourceCount int
+	var lastUpdate *time.Time
+	chk, err := stack.UnmarshalVersionedCheckpointToLatestCheckpoint(encoding.JSON, byts)
+	if err != nil {
+		return err
+	}
+	if chk.Latest != nil {
+		resourceCount = len(chk.Latest.Resources)
+		if t := chk.Latest.Manifest.Time; !t.IsZero() {
+			lastUpdate = &t
+		}
+	}
+
+	err = b.store.saveCheckpoint(ctx, string(ref.project), string(ref.name), b.lockID, byts, resourceCount, lastUpdate)
+	if err != nil {
+		return b.describeLockError(ref, err)
+	}
+
+	logging.V(7).Infof("Saved stack %s checkpoint to: %s", ref.FullyQualifiedName(), b.originalURL)
+	return nil
+}
+
+func (b *sqlBackend) saveStack(
+	ctx context.Context,
+	ref *sqlBackendReference, snap *deploy.Snapshot,
+	sm secrets.Manager,
+) error {
+	contract.Requiref(ref != nil, "ref", "ref was nil")
+	chk, err := stack.SerializeCheckpoint(ref.FullyQualifiedName(), snap, sm, false /* showSecrets */)
+	if err != nil {
+		return fmt.Errorf("serializaing checkpoint: %w", err)
+	}
+
+	if err := b.saveCheckpoint(ctx, ref, chk); err != nil {
+		return err
+	}
+
+	if !filestate.DisableIntegrityChecking {
+		// Finally, *after* writing the checkpoint, check the integrity.  This is done afterwards so that we write
+		// out the checkpoint since it may contain resource state updates.  But we will warn the user that the
+		// checkpoint is already written and might be bad.
+		if verifyerr := snap.VerifyIntegrity(); verifyerr != nil {
+			return fmt.Errorf(
+				"%s: snapshot integrity failure; it was already written, but is invalid: %w", ref, verifyerr)
+		}
+	}
+
+	return nil
+}
+
+// addToHistory records an update in the stack's history, along with the given checkpoint, or the stack's current
+// checkpoint if it's nil, and the ID under which persistEngineEvents saved the update's events, if any.
+func (b *sqlBackend) addToHistory(
+	ctx context.Context,
+	ref *sqlBackendReference,
+	update backend.UpdateInfo,
+	checkpoint *apitype.VersionedCheckpoint,
+	eventsID string,
+) error {
+	contract.Requiref(ref != nil, "ref", "must not be nil")
+
+	info, err := json.Marshal(&update)
+	if err != nil {
+		return err
+	}
+
+	var checkpointBytes []byte
+	if checkpoint != nil {
+		if checkpointBytes, err = encoding.JSON.Marshal(checkpoint); err != nil {
+			return err
+		}
+	}
+
+	_, err = b.store.addUpdate(ctx, string(ref.project), string(ref.name), updateRecord{
+		version:   update.Version,
+		kind:      string(update.Kind),
+		result:    string(update.Result),
+		startTime: update.StartTime,
+		endTime:   update.EndTime,
+		info:      info,
+	}, checkpointBytes, eventsID)
+	return err
+}
+
+// persistEngineEvents saves the engine events of an update under the given ID, so that they can be recorded with the
+// update in the stack's history. Like the service does, events are written in batches as they're received rather than
+// held until the update completes. Failing to save the events shouldn't fail the update, so the rest of the events are
+// dropped with a warning instead.
+func (b *sqlBackend) persistEngineEvents(
+	ctx context.Context,
+	ref *sqlBackendReference,
+	eventsID string,
+	events <-chan engine.Event,
+	done chan<- bool,
+) {
+	defer close(done)
+
+	err := backend.RecordEngineEvents(events, func(batch int, events []apitype.EngineEvent) error {
+		byts, err := json.Marshal(&apitype.EngineEventBatch{Events: events})
+		if err != nil {
+			return err
+		}
+		return b.store.addEvents(ctx, string(ref.project), string(ref.name), eventsID, batch, byts)
+	})
+	if err != nil {
+		b.d.Warningf(diag.Message("", "Could not record the events of the update: %v"), err)
+	}
+}
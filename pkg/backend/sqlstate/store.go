@@ -0,0 +1,571 @@
+// Copyright 2016-2023, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sqlstate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/contract"
+)
+
+// schemaVersion is the version of the tables created by this version of the CLI. Databases with a later version were
+// created by a later version of the CLI, and can't be used.
+const schemaVersion = 1
+
+var (
+	// errStackNotFound is returned by operations on stacks that don't exist.
+	errStackNotFound = errors.New("stack not found")
+	// errStackExists is returned when creating or renaming a stack to the name of a stack that already exists.
+	errStackExists = errors.New("stack already exists")
+)
+
+// errStackLocked is returned when a stack can't be locked, or written to, because another process holds its lock.
+type errStackLocked struct {
+	holder lockRecord
+}
+
+func (e *errStackLocked) Error() string {
+	return fmt.Sprintf("the stack is locked by %v@%v (pid %v)", e.holder.username, e.holder.hostname, e.holder.pid)
+}
+
+// stackRecord is a row of the stacks table.
+type stackRecord struct {
+	project string
+	name    string
+	// checkpoint is the stack's apitype.VersionedCheckpoint, as JSON.
+	checkpoint []byte
+	// resourceCount and lastUpdate describe the checkpoint, so that stacks can be listed without reading it.
+	resourceCount int
+	lastUpdate    *time.Time
+}
+
+// updateRecord is a row of the updates table.
+type updateRecord struct {
+	version   int
+	kind      string
+	result    string
+	startTime int64
+	endTime   int64
+	// info is the update's backend.UpdateInfo, as JSON.
+	info []byte
+}
+
+// lockRecord is a row of the stack_locks table.
+type lockRecord struct {
+	lockID   string
+	pid      int
+	username string
+	hostname string
+	created  time.Time
+}
+
+// stackFilter restricts the stacks returned by listStacks. Nil fields don't restrict them.
+type stackFilter struct {
+	project  *string
+	tagName  *string
+	tagValue *string
+}
+
+// store is the storage layer of the backend, which keeps stacks, their checkpoints, history, tags and locks in the
+// tables of a SQL database. It knows nothing of the contents of checkpoints and updates, which the backend passes to
+// it already serialized. Operations that touch more than one row are run in a transaction, so they either happen
+// completely or not at all.
+type store struct {
+	db      *sql.DB
+	dialect *dialect
+}
+
+// openStore opens the database with the given data source name, creating the store's tables if they don't exist.
+func openStore(ctx context.Context, d *dialect, dataSource string) (*store, error) {
+	db, err := sql.Open(d.driver, dataSource)
+	if err != nil {
+		return nil, err
+	}
+	s := &store{db: db, dialect: d}
+	if err := s.migrate(ctx); err != nil {
+		contract.IgnoreClose(db)
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *store) Close() error {
+	return s.db.Close()
+}
+
+// migrate creates the store's tables, and checks that the database wasn't created by a later version of the CLI.
+func (s *store) migrate(ctx context.Context) error {
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		for _, stmt := range s.dialect.schema() {
+			if _, err := tx.ExecContext(ctx, stmt); err != nil {
+				return fmt.Errorf("creating tables: %w", err)
+			}
+		}
+
+		var version int
+		err := tx.QueryRowContext(ctx, "SELECT version FROM schema_version").Scan(&version)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			_, err = tx.ExecContext(ctx, s.q("INSERT INTO schema_version (version) VALUES (?)"), schemaVersion)
+			return err
+		case err != nil:
+			return err
+		case version > schemaVersion:
+			return fmt.Errorf("state store unsupported: schema version (%d) is not supported "+
+				"by this version of the Pulumi CLI", version)
+		}
+		return nil
+	})
+}
+
+// q rewrites a query for the store's database.
+func (s *store) q(query string) string {
+	return s.dialect.rebind(query)
+}
+
+// inTx runs the given function in a transaction, which is committed if it succeeds and rolled back otherwise.
+func (s *store) inTx(ctx context.Context, f func(tx *sql.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := f(tx); err != nil {
+		contract.IgnoreError(tx.Rollback())
+		return err
+	}
+	return tx.Commit()
+}
+
+// stackID returns the ID of the row of the given stack.
+func (s *store) stackID(ctx context.Context, tx *sql.Tx, project, name string) (int64, error) {
+	var id int64
+	err := tx.QueryRowContext(ctx, s.q("SELECT id FROM stacks WHERE project = ? AND name = ?"), project, name).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, errStackNotFound
+	}
+	return id, err
+}
+
+// createStack adds a stack with the given checkpoint and tags, failing with errStackExists if there's already a stack
+// with the same name.
+func (s *store) createStack(
+	ctx context.Context, project, name string, checkpoint []byte, tags map[string]string,
+) error {
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		var id int64
+		err := tx.QueryRowContext(ctx,
+			s.q("INSERT INTO stacks (project, name, checkpoint) VALUES (?, ?, ?) RETURNING id"),
+			project, name, checkpoint).Scan(&id)
+		if err != nil {
+			if s.dialect.isUniqueViolation(err) {
+				return errStackExists
+			}
+			return err
+		}
+		return s.insertTags(ctx, tx, id, tags)
+	})
+}
+
+// getStack returns the given stack, or nil if it doesn't exist.
+func (s *store) getStack(ctx context.Context, project, name string) (*stackRecord, error) {
+	row := s.db.QueryRowContext(ctx, s.q(
+		"SELECT project, name, checkpoint, resource_count, last_update FROM stacks WHERE project = ? AND name = ?"),
+		project, name)
+	rec, err := scanStack(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return rec, err
+}
+
+// listStacks returns the stacks that match the given filter, ordered by project and name. The checkpoints of the
+// stacks aren't read.
+func (s *store) listStacks(ctx context.Context, filter stackFilter) ([]stackRecord, error) {
+	query := "SELECT project, name, NULL, resource_count, last_update FROM stacks WHERE 1 = 1"
+	var args []interface{}
+	if filter.project != nil {
+		query += " AND project = ?"
+		args = append(args, *filter.project)
+	}
+	if filter.tagName != nil || filter.tagValue != nil {
+		query += " AND EXISTS (SELECT 1 FROM stack_tags WHERE stack_tags.stack_id = stacks.id"
+		if filter.tagName != nil && *filter.tagName != "" {
+			query += " AND stack_tags.name = ?"
+			args = append(args, *filter.tagName)
+		}
+		if filter.tagValue != nil {
+			query += " AND stack_tags.value = ?"
+			args = append(args, *filter.tagValue)
+		}
+		query += ")"
+	}
+	query += " ORDER BY project, name"
+
+	rows, err := s.db.QueryContext(ctx, s.q(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer contract.IgnoreClose(rows)
+
+	var stacks []stackRecord
+	for rows.Next() {
+		rec, err := scanStack(rows)
+		if err != nil {
+			return nil, err
+		}
+		stacks = append(stacks, *rec)
+	}
+	return stacks, rows.Err()
+}
+
+// projectExists returns true if there are any stacks in the given project.
+func (s *store) projectExists(ctx context.Context, project string) (bool, error) {
+	var count int
+	err := s.db.QueryRowContext(ctx, s.q("SELECT COUNT(*) FROM stacks WHERE project = ?"), project).Scan(&count)
+	return count > 0, err
+}
+
+// rowScanner is implemented by *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanStack(row rowScanner) (*stackRecord, error) {
+	var rec stackRecord
+	var lastUpdate sql.NullInt64
+	if err := row.Scan(&rec.project, &rec.name, &rec.checkpoint, &rec.resourceCount, &lastUpdate); err != nil {
+		return nil, err
+	}
+	if lastUpdate.Valid {
+		t := time.Unix(lastUpdate.Int64, 0)
+		rec.lastUpdate = &t
+	}
+	return &rec, nil
+}
+
+// saveCheckpoint replaces the checkpoint of the given stack, along with the summary of it. The checkpoint isn't saved
+// if another process holds the stack's lock, so that a process whose lock was broken can't overwrite the changes made
+// by the process that took it next.
+func (s *store) saveCheckpoint(
+	ctx context.Context, project, name, lockID string,
+	checkpoint []byte, resourceCount int, lastUpdate *time.Time,
+) error {
+	var lastUpdateUnix sql.NullInt64
+	if lastUpdate != nil {
+		lastUpdateUnix = sql.NullInt64{Int64: lastUpdate.Unix(), Valid: true}
+	}
+
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		if _, err := s.stackID(ctx, tx, project, name); err != nil {
+			return err
+		}
+		if holder, err := s.readLockTx(ctx, tx, project, name); err != nil {
+			return err
+		} else if holder != nil && holder.lockID != lockID {
+			return &errStackLocked{holder: *holder}
+		}
+
+		_, err := tx.ExecContext(ctx, s.q(
+			"UPDATE stacks SET checkpoint = ?, resource_count = ?, last_update = ? WHERE project = ? AND name = ?"),
+			checkpoint, resourceCount, lastUpdateUnix, project, name)
+		return err
+	})
+}
+
+// removeStack deletes the given stack, along with its history and tags.
+func (s *store) removeStack(ctx context.Context, project, name string) error {
+	res, err := s.db.ExecContext(ctx, s.q("DELETE FROM stacks WHERE project = ? AND name = ?"), project, name)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return errStackNotFound
+	}
+	return nil
+}
+
+// renameStack gives a stack a new name, and replaces its checkpoint with the given one, which refers to the new name.
+// The stack keeps its history and tags.
+func (s *store) renameStack(
+	ctx context.Context, project, name, newProject, newName string, checkpoint []byte,
+) error {
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		id, err := s.stackID(ctx, tx, project, name)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, s.q("UPDATE stacks SET project = ?, name = ?, checkpoint = ? WHERE id = ?"),
+			newProject, newName, checkpoint, id)
+		if err != nil && s.dialect.isUniqueViolation(err) {
+			return errStackExists
+		}
+		return err
+	})
+}
+
+// getTags returns the tags of the given stack.
+func (s *store) getTags(ctx context.Context, project, name string) (map[string]string, error) {
+	rows, err := s.db.QueryContext(ctx, s.q(
+		"SELECT stack_tags.name, stack_tags.value FROM stack_tags JOIN stacks ON stacks.id = stack_tags.stack_id "+
+			"WHERE stacks.project = ? AND stacks.name = ?"),
+		project, name)
+	if err != nil {
+		return nil, err
+	}
+	defer contract.IgnoreClose(rows)
+
+	tags := make(map[string]string)
+	for rows.Next() {
+		var k, v string
+		if err := rows.Scan(&k, &v); err != nil {
+			return nil, err
+		}
+		tags[k] = v
+	}
+	return tags, rows.Err()
+}
+
+// setTags replaces all the tags of the given stack.
+func (s *store) setTags(ctx context.Context, project, name string, tags map[string]string) error {
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		id, err := s.stackID(ctx, tx, project, name)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, s.q("DELETE FROM stack_tags WHERE stack_id = ?"), id); err != nil {
+			return err
+		}
+		return s.insertTags(ctx, tx, id, tags)
+	})
+}
+
+func (s *store) insertTags(ctx context.Context, tx *sql.Tx, id int64, tags map[string]string) error {
+	for k, v := range tags {
+		_, err := tx.ExecContext(ctx, s.q("INSERT INTO stack_tags (stack_id, name, value) VALUES (?, ?, ?)"), id, k, v)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addUpdate adds an update to the history of the given stack, along with the checkpoint it recorded and the ID of the
+// engine events it emitted, if any. If checkpoint is nil, the stack's current checkpoint is recorded. The update is
+// numbered after the latest one in the stack's history, or keeps its version if it's the first one, as happens when a
+// stack's history is imported from elsewhere. The update's version is returned.
+func (s *store) addUpdate(
+	ctx context.Context, project, name string, update updateRecord, checkpoint []byte, eventsID string,
+) (int, error) {
+	var version int
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		id, err := s.stackID(ctx, tx, project, name)
+		if err != nil {
+			return err
+		}
+
+		var latest sql.NullInt64
+		err = tx.QueryRowContext(ctx, s.q("SELECT MAX(version) FROM updates WHERE stack_id = ?"), id).Scan(&latest)
+		if err != nil {
+			return err
+		}
+		version = update.version
+		if latest.Valid || version <= 0 {
+			version = int(latest.Int64) + 1
+		}
+
+		if checkpoint == nil {
+			err = tx.QueryRowContext(ctx, s.q("SELECT checkpoint FROM stacks WHERE id = ?"), id).Scan(&checkpoint)
+			if err != nil {
+				return err
+			}
+		}
+
+		events := sql.NullString{String: eventsID, Valid: eventsID != ""}
+		_, err = tx.ExecContext(ctx, s.q(
+			"INSERT INTO updates (stack_id, version, kind, result, start_time, end_time, info, checkpoint, events_id) "+
+				"VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)"),
+			id, version, update.kind, update.result, update.startTime, update.endTime, update.info, checkpoint, events)
+		return err
+	})
+	return version, err
+}
+
+// listUpdates returns the updates in the history of the given stack, most recent first. If limit is positive, at
+// most that many updates are returned, after skipping the given number of the most recent ones.
+func (s *store) listUpdates(ctx context.Context, project, name string, limit, offset int) ([]updateRecord, error) {
+	query := "SELECT updates.version, updates.kind, updates.result, updates.start_time, updates.end_time, " +
+		"updates.info FROM updates JOIN stacks ON stacks.id = updates.stack_id " +
+		"WHERE stacks.project = ? AND stacks.name = ? ORDER BY updates.version DESC"
+	args := []interface{}{project, name}
+	if limit > 0 {
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, limit, offset)
+	}
+
+	rows, err := s.db.QueryContext(ctx, s.q(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer contract.IgnoreClose(rows)
+
+	var updates []updateRecord
+	for rows.Next() {
+		var rec updateRecord
+		if err := rows.Scan(&rec.version, &rec.kind, &rec.result, &rec.startTime, &rec.endTime, &rec.info); err != nil {
+			return nil, err
+		}
+		updates = append(updates, rec)
+	}
+	return updates, rows.Err()
+}
+
+// getUpdateCheckpoint returns the checkpoint recorded with the given version of the stack's history. It fails with
+// errUpdateNotFound if there's no such version.
+func (s *store) getUpdateCheckpoint(ctx context.Context, project, name string, version int) ([]byte, error) {
+	var checkpoint []byte
+	err := s.db.QueryRowContext(ctx, s.q(
+		"SELECT updates.checkpoint FROM updates JOIN stacks ON stacks.id = updates.stack_id "+
+			"WHERE stacks.project = ? AND stacks.name = ? AND updates.version = ?"),
+		project, name, version).Scan(&checkpoint)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errUpdateNotFound
+	}
+	return checkpoint, err
+}
+
+// addEvents saves a batch of the engine events emitted by an update of the given stack, under the ID that's recorded
+// with the update once it's added to the history.
+func (s *store) addEvents(ctx context.Context, project, name, eventsID string, batch int, events []byte) error {
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		id, err := s.stackID(ctx, tx, project, name)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, s.q(
+			"INSERT INTO update_events (stack_id, events_id, batch, events) VALUES (?, ?, ?, ?)"),
+			id, eventsID, batch, events)
+		return err
+	})
+}
+
+// getUpdateEvents returns the batches of engine events recorded with the given version of the stack's history, in
+// order, or nil if the update has no events. It fails with errUpdateNotFound if there's no such version.
+func (s *store) getUpdateEvents(ctx context.Context, project, name string, version int) ([][]byte, error) {
+	var batches [][]byte
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		var id int64
+		var eventsID sql.NullString
+		err := tx.QueryRowContext(ctx, s.q(
+			"SELECT stacks.id, updates.events_id FROM updates JOIN stacks ON stacks.id = updates.stack_id "+
+				"WHERE stacks.project = ? AND stacks.name = ? AND updates.version = ?"),
+			project, name, version).Scan(&id, &eventsID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return errUpdateNotFound
+		}
+		if err != nil || !eventsID.Valid {
+			return err
+		}
+
+		rows, err := tx.QueryContext(ctx, s.q(
+			"SELECT events FROM update_events WHERE stack_id = ? AND events_id = ? ORDER BY batch"),
+			id, eventsID.String)
+		if err != nil {
+			return err
+		}
+		defer contract.IgnoreClose(rows)
+
+		for rows.Next() {
+			var events []byte
+			if err := rows.Scan(&events); err != nil {
+				return err
+			}
+			batches = append(batches, events)
+		}
+		return rows.Err()
+	})
+	return batches, err
+}
+
+// errUpdateNotFound is returned for versions that aren't in a stack's history.
+var errUpdateNotFound = errors.New("update not found")
+
+// lock takes the lock on the given stack, failing with errStackLocked if it's held by another process. Taking a lock
+// that's already held with the same ID succeeds.
+func (s *store) lock(ctx context.Context, project, name string, l lockRecord) error {
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, s.q(
+			"INSERT INTO stack_locks (project, name, lock_id, pid, username, hostname, created) "+
+				"VALUES (?, ?, ?, ?, ?, ?, ?) ON CONFLICT (project, name) DO NOTHING"),
+			project, name, l.lockID, l.pid, l.username, l.hostname, l.created.Unix())
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil || n > 0 {
+			return err
+		}
+
+		holder, err := s.readLockTx(ctx, tx, project, name)
+		if err != nil {
+			return err
+		}
+		if holder != nil && holder.lockID != l.lockID {
+			return &errStackLocked{holder: *holder}
+		}
+		return nil
+	})
+}
+
+// unlock releases the lock on the given stack if it's held with the given ID, or whoever holds it if the ID is empty.
+func (s *store) unlock(ctx context.Context, project, name, lockID string) error {
+	query := "DELETE FROM stack_locks WHERE project = ? AND name = ?"
+	args := []interface{}{project, name}
+	if lockID != "" {
+		query += " AND lock_id = ?"
+		args = append(args, lockID)
+	}
+	_, err := s.db.ExecContext(ctx, s.q(query), args...)
+	return err
+}
+
+// readLock returns the lock held on the given stack, or nil if it isn't locked.
+func (s *store) readLock(ctx context.Context, project, name string) (*lockRecord, error) {
+	var l *lockRecord
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		var err error
+		l, err = s.readLockTx(ctx, tx, project, name)
+		return err
+	})
+	return l, err
+}
+
+func (s *store) readLockTx(ctx context.Context, tx *sql.Tx, project, name string) (*lockRecord, error) {
+	var l lockRecord
+	var created int64
+	err := tx.QueryRowContext(ctx, s.q(
+		"SELECT lock_id, pid, username, hostname, created FROM stack_locks WHERE project = ? AND name = ?"),
+		project, name).Scan(&l.lockID, &l.pid, &l.username, &l.hostname, &created)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	l.created = time.Unix(created, 0)
+	return &l, nil
+}
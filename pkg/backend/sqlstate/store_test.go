@@ -0,0 +1,83 @@
+// Copyright 2016-2023, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sqlstate
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRebind(t *testing.T) {
+	t.Parallel()
+
+	query := "SELECT a FROM t WHERE b = ? AND c = ?"
+	assert.Equal(t, query, (&dialect{}).rebind(query))
+	assert.Equal(t, "SELECT a FROM t WHERE b = $1 AND c = $2", (&dialect{numberedPlaceholders: true}).rebind(query))
+}
+
+func TestOpenStore_unsupportedSchemaVersion(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	d := dialects["sqlite"]
+	dataSource, err := d.dataSource(filepath.Join(t.TempDir(), "pulumi.db"))
+	require.NoError(t, err)
+
+	s, err := openStore(ctx, d, dataSource)
+	require.NoError(t, err)
+	_, err = s.db.ExecContext(ctx, "UPDATE schema_version SET version = ?", schemaVersion+1)
+	require.NoError(t, err)
+	require.NoError(t, s.Close())
+
+	// Opening the store again keeps the tables as they are, but rejects the later version.
+	_, err = openStore(ctx, d, dataSource)
+	assert.ErrorContains(t, err, "state store unsupported")
+}
+
+func TestStoreLock(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	d := dialects["sqlite"]
+	dataSource, err := d.dataSource(filepath.Join(t.TempDir(), "pulumi.db"))
+	require.NoError(t, err)
+	s, err := openStore(ctx, d, dataSource)
+	require.NoError(t, err)
+	defer func() { assert.NoError(t, s.Close()) }()
+
+	// Stacks can be locked before they exist.
+	require.NoError(t, s.lock(ctx, "proj", "foo", lockRecord{lockID: "a", pid: 1}))
+	require.NoError(t, s.lock(ctx, "proj", "foo", lockRecord{lockID: "a", pid: 2}))
+	err = s.lock(ctx, "proj", "foo", lockRecord{lockID: "b"})
+	var lockErr *errStackLocked
+	require.ErrorAs(t, err, &lockErr)
+	assert.Equal(t, 1, lockErr.holder.pid)
+	require.NoError(t, s.lock(ctx, "proj", "bar", lockRecord{lockID: "b"}))
+
+	require.NoError(t, s.unlock(ctx, "proj", "foo", "b"))
+	l, err := s.readLock(ctx, "proj", "foo")
+	require.NoError(t, err)
+	require.NotNil(t, l)
+	assert.Equal(t, "a", l.lockID)
+
+	require.NoError(t, s.unlock(ctx, "proj", "foo", "a"))
+	l, err = s.readLock(ctx, "proj", "foo")
+	require.NoError(t, err)
+	assert.Nil(t, l)
+}
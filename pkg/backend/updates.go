@@ -94,6 +94,9 @@ const (
 	// UpdatePlan ("true", "false") indicates if an explicit update plan was used for the update (either
 	// saving one, or constraining to one).
 	UpdatePlan = "updatePlan"
+
+	// RollbackVersion is the version of the stack's history that a rollback restored the stack's state to.
+	RollbackVersion = "rollback.version"
 )
 
 // UpdateInfo describes a previous update.
@@ -16,12 +16,14 @@ package main
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/pulumi/pulumi/sdk/v3/go/common/util/result"
 
 	"github.com/spf13/cobra"
 
 	"github.com/pulumi/pulumi/pkg/v3/backend/display"
+	"github.com/pulumi/pulumi/pkg/v3/backend/filestate"
 	"github.com/pulumi/pulumi/sdk/v3/go/common/diag/colors"
 	"github.com/pulumi/pulumi/sdk/v3/go/common/util/cmdutil"
 )
@@ -40,7 +42,10 @@ func newCancelCmd() *cobra.Command {
 			"inconsistent state if a resource operation was pending when the update was canceled.\n" +
 			"\n" +
 			"After this command completes successfully, the stack will be ready for further\n" +
-			"updates.",
+			"updates.\n" +
+			"\n" +
+			"For self-managed backends, this command breaks the locks held on the stack, after\n" +
+			"showing which processes hold them and for how long.",
 		Run: cmdutil.RunResultFunc(func(cmd *cobra.Command, args []string) result.Result {
 			ctx := commandContext()
 			// Use the stack provided or, if missing, default to the current one.
@@ -61,8 +66,22 @@ func newCancelCmd() *cobra.Command {
 				return result.FromError(err)
 			}
 
-			// Ensure the user really wants to do this.
+			// Self-managed backends have no record of running updates, only the locks held on the stack. Show who
+			// holds them, so that the user can tell whether the update is still running before breaking them.
 			stackName := string(s.Ref().Name())
+			if fb, ok := s.Backend().(filestate.Backend); ok {
+				locks, err := fb.StackLocks(ctx, s.Ref())
+				if err != nil {
+					return result.FromError(err)
+				}
+				if len(locks) == 0 {
+					fmt.Printf("Stack '%s' is not locked by any update\n", stackName)
+					return nil
+				}
+				printStackLocks(locks)
+			}
+
+			// Ensure the user really wants to do this.
 			prompt := fmt.Sprintf("This will irreversibly cancel the currently running update for '%s'!", stackName)
 			if cmdutil.Interactive() && (!yes && !confirmPrompt(prompt, stackName, opts)) {
 				fmt.Println("confirmation declined")
@@ -92,3 +111,23 @@ func newCancelCmd() *cobra.Command {
 
 	return cmd
 }
+
+// printStackLocks describes the locks held on a stack in a self-managed backend.
+func printStackLocks(locks []filestate.StackLock) {
+	now := time.Now()
+	fmt.Printf("The stack is locked by %d lock(s):\n", len(locks))
+	for _, l := range locks {
+		held := now.Sub(l.Timestamp).Round(time.Second)
+		fmt.Printf("  %s: held by %s@%s (pid %d) for %s", l.URL, l.Username, l.Hostname, l.Pid, held)
+		switch {
+		case l.Expires == nil:
+			fmt.Printf(", does not expire")
+		case now.After(*l.Expires):
+			fmt.Printf(", expired %s ago", now.Sub(*l.Expires).Round(time.Second))
+		default:
+			fmt.Printf(", expires in %s", l.Expires.Sub(now).Round(time.Second))
+		}
+		fmt.Println()
+	}
+	fmt.Println()
+}
@@ -21,6 +21,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 	"regexp"
 	"sort"
 	"strings"
@@ -32,6 +33,7 @@ import (
 	"github.com/pulumi/pulumi/pkg/v3/backend"
 	"github.com/pulumi/pulumi/pkg/v3/backend/display"
 	"github.com/pulumi/pulumi/pkg/v3/secrets"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/diag"
 	"github.com/pulumi/pulumi/sdk/v3/go/common/resource/config"
 	"github.com/pulumi/pulumi/sdk/v3/go/common/tokens"
 	"github.com/pulumi/pulumi/sdk/v3/go/common/util/cmdutil"
@@ -91,6 +93,9 @@ func newConfigCmd() *cobra.Command {
 	cmd.AddCommand(newConfigSetAllCmd(&stack))
 	cmd.AddCommand(newConfigRefreshCmd(&stack))
 	cmd.AddCommand(newConfigCopyCmd(&stack))
+	cmd.AddCommand(newConfigValidateCmd(&stack))
+	cmd.AddCommand(newConfigImportCmd(&stack))
+	cmd.AddCommand(newConfigDiffCmd(&stack))
 
 	return cmd
 }
@@ -484,10 +489,199 @@ func newConfigRefreshCmd(stack *string) *cobra.Command {
 	return refreshCmd
 }
 
+func newConfigValidateCmd(stack *string) *cobra.Command {
+	validateCmd := &cobra.Command{
+		Use:   "validate",
+		Short: "Validate a stack's configuration against the configuration declared by the project",
+		Long: "Validate a stack's configuration against the configuration declared by the project.\n" +
+			"\n" +
+			"The `config` section of Pulumi.yaml can declare the type of each configuration value, whether\n" +
+			"it is secret, and constraints on it:\n" +
+			"\n" +
+			"    config:\n" +
+			"      size:\n" +
+			"        type: string\n" +
+			"        enum: [small, large]\n" +
+			"      name:\n" +
+			"        type: string\n" +
+			"        pattern: ^[a-z]+$\n" +
+			"      replicas:\n" +
+			"        type: integer\n" +
+			"        minimum: 1\n" +
+			"        maximum: 10\n" +
+			"\n" +
+			"This command reports every required value the stack is missing, every value that has the wrong\n" +
+			"type or breaks a constraint, and every secret value that is stored in plaintext, along with the\n" +
+			"file and line of each problem. Values in the project's namespace that Pulumi.yaml doesn't declare\n" +
+			"are reported as warnings. `pulumi preview` runs the same checks before previewing.",
+		Args: cmdutil.NoArgs,
+		Run: cmdutil.RunFunc(func(cmd *cobra.Command, args []string) error {
+			ctx := commandContext()
+			opts := display.Options{
+				Color: cmdutil.GetGlobalColorization(),
+			}
+
+			project, _, err := readProject()
+			if err != nil {
+				return err
+			}
+
+			s, err := requireStack(ctx, *stack, stackOfferNew|stackSetCurrent, opts)
+			if err != nil {
+				return err
+			}
+
+			ps, err := loadProjectStack(project, s)
+			if err != nil {
+				return err
+			}
+
+			// Check the values of the shared config files that the stack imports too.
+			layered, err := loadLayeredConfig(project, s, ps)
+			if err != nil {
+				return err
+			}
+			cfg := layered.config
+
+			// Only secret values need decrypting, so avoid prompting for a passphrase if there aren't any. Secrets from
+			// shared config files are re-encrypted so that the stack's decrypter can decrypt all of them.
+			var dec config.Decrypter
+			if cfg.HasSecureValue() {
+				sm, _, err := getStackSecretsManager(s, ps)
+				if err != nil {
+					return fmt.Errorf("getting stack secrets manager: %w", err)
+				}
+				if cfg, err = layered.reencrypt(sm.Encrypter); err != nil {
+					return err
+				}
+				if dec, err = sm.Decrypter(); err != nil {
+					return fmt.Errorf("getting stack decrypter: %w", err)
+				}
+			}
+
+			problems, err := workspace.ValidateStackConfig(project, cfg, ps.RawValue(), dec)
+			if err != nil {
+				return err
+			}
+
+			format := configProblemFormatter(s, layered, true /*withSeverity*/)
+			errs := 0
+			for _, p := range problems {
+				fmt.Println(format(p))
+				if !p.Warning {
+					errs++
+				}
+			}
+			if errs > 0 {
+				return fmt.Errorf("the configuration of stack '%s' has %d error(s)", s.Ref().Name(), errs)
+			}
+
+			fmt.Printf("The configuration of stack '%s' is valid\n", s.Ref().Name())
+			return nil
+		}),
+	}
+
+	return validateCmd
+}
+
+// checkStackConfig reports the problems workspace.ValidateStackConfig finds with a stack's configuration as
+// diagnostics, failing if any of them are errors.
+func checkStackConfig(
+	project *workspace.Project,
+	stack backend.Stack,
+	cfg config.Map,
+	dec config.Decrypter,
+) error {
+	// The stack's config file, and the shared config files it imports, are only used to find the file and line of
+	// each problem, and may not exist if the configuration came from the backend.
+	var raw []byte
+	var layered *layeredConfig
+	if ps, err := loadProjectStack(project, stack); err == nil {
+		raw = ps.RawValue()
+		layered, _ = loadLayeredConfig(project, stack, ps)
+	}
+
+	problems, err := workspace.ValidateStackConfig(project, cfg, raw, dec)
+	if err != nil {
+		return err
+	}
+
+	format := configProblemFormatter(stack, layered, false /*withSeverity*/)
+	errs := 0
+	for _, p := range problems {
+		if p.Warning {
+			cmdutil.Diag().Warningf(diag.RawMessage("" /*urn*/, format(p)))
+		} else {
+			cmdutil.Diag().Errorf(diag.RawMessage("" /*urn*/, format(p)))
+			errs++
+		}
+	}
+	if errs > 0 {
+		return fmt.Errorf("the configuration of stack '%s' has %d error(s)", stack.Ref().Name(), errs)
+	}
+	return nil
+}
+
+// configProblemFormatter returns a function that formats the problems with a stack's configuration, with the paths
+// of the project's and stack's config files. Problems with values that come from the shared config files the stack
+// imports are located in those files instead, if layered is non-nil. The severity of each problem is only included if
+// withSeverity is true, since diagnostics show it themselves. The paths are left out if they can't be found.
+func configProblemFormatter(
+	stack backend.Stack,
+	layered *layeredConfig,
+	withSeverity bool,
+) func(workspace.ConfigProblem) string {
+	projectPath, _ := workspace.DetectProjectPath()
+	stackPath, _ := getProjectStackPath(stack)
+	return func(p workspace.ConfigProblem) string {
+		path := stackPath
+		if key, err := config.ParseKey(p.Key); err == nil && layered != nil && !p.InProject {
+			if layer := layered.origin(key, false /*path*/); layer != nil {
+				path = layer.Path
+				p.Line = workspace.ConfigKeyLine(layer.Stack.RawValue(), layered.projectName, key)
+			}
+		}
+		return formatConfigProblem(p, projectPath, path, withSeverity)
+	}
+}
+
+// formatConfigProblem formats a problem with a stack's configuration like a compiler would, as
+// "<file>:<line>: <severity>: <key> <message>", with the path of the file relative to the working directory.
+func formatConfigProblem(p workspace.ConfigProblem, projectPath, stackPath string, withSeverity bool) string {
+	path := stackPath
+	if p.InProject {
+		path = projectPath
+	}
+	if cwd, err := os.Getwd(); err == nil && path != "" {
+		if rel, err := filepath.Rel(cwd, path); err == nil && !strings.HasPrefix(rel, "..") {
+			path = rel
+		}
+	}
+
+	var prefix []string
+	if path != "" && p.Line > 0 {
+		prefix = append(prefix, fmt.Sprintf("%s:%d", path, p.Line))
+	} else if path != "" {
+		prefix = append(prefix, path)
+	}
+	if withSeverity && p.Warning {
+		prefix = append(prefix, "warning")
+	} else if withSeverity {
+		prefix = append(prefix, "error")
+	}
+
+	message := p.Key + " " + p.Message
+	if len(prefix) == 0 {
+		return message
+	}
+	return strings.Join(prefix, ": ") + ": " + message
+}
+
 func newConfigSetCmd(stack *string) *cobra.Command {
 	var plaintext bool
 	var secret bool
 	var path bool
+	var layer string
 
 	setCmd := &cobra.Command{
 		Use:   "set <key> [value]",
@@ -501,7 +695,12 @@ func newConfigSetCmd(stack *string) *cobra.Command {
 			"  - `pulumi config set --path parent.nested value` " +
 			"will set the value of `parent` to a map `nested: value`.\n" +
 			"  - `pulumi config set --path '[\"parent.name\"].[\"nested.name\"]' value` will set the value of \n" +
-			"    `parent.name` to a map `nested.name: value`.",
+			"    `parent.name` to a map `nested.name: value`.\n\n" +
+			"The `--layer` flag can be used to set a value in one of the shared config files that the stack imports,\n" +
+			"rather than in the stack's own config file:\n\n" +
+			"  - `pulumi config set --layer config/common.yaml aws:region us-east-1` will set the value of\n" +
+			"    `aws:region` for every stack that imports `config/common.yaml`.\n\n" +
+			"Secret values set in a shared config file are encrypted with the secrets provider that the file names.",
 		Args: cmdutil.RangeArgs(1, 2),
 		Run: cmdutil.RunFunc(func(cmd *cobra.Command, args []string) error {
 			ctx := commandContext()
@@ -554,12 +753,31 @@ func newConfigSetCmd(stack *string) *cobra.Command {
 				return err
 			}
 
+			// Values are set in the stack's own config file, unless --layer names a shared config file it imports.
+			target := ps
+			var targetLayer *workspace.ConfigLayer
+			if layer != "" {
+				if targetLayer, err = loadConfigLayer(project, s, ps, layer); err != nil {
+					return err
+				}
+				target = targetLayer.Stack
+			}
+
 			// Encrypt the config value if needed.
 			var v config.Value
 			if secret {
 				// We're always going to save, so can ignore the bool for if getStackEncrypter changed the
 				// config data.
-				c, _, cerr := getStackEncrypter(s, ps)
+				var c config.Encrypter
+				var cerr error
+				if targetLayer != nil {
+					var sm secrets.Manager
+					if sm, cerr = getConfigLayerSecretsManager(targetLayer); cerr == nil {
+						c, cerr = sm.Encrypter()
+					}
+				} else {
+					c, _, cerr = getStackEncrypter(s, ps)
+				}
 				if cerr != nil {
 					return cerr
 				}
@@ -579,11 +797,19 @@ func newConfigSetCmd(stack *string) *cobra.Command {
 				}
 			}
 
-			err = ps.Config.Set(key, v, path)
+			err = target.Config.Set(key, v, path)
 			if err != nil {
 				return err
 			}
 
+			if targetLayer != nil {
+				if _, has := ps.Config[topLevelConfigKey(key, path)]; has {
+					cmdutil.Diag().Warningf(diag.RawMessage("" /*urn*/, fmt.Sprintf(
+						"stack '%s' sets '%s' itself, which overrides the value in '%s'",
+						s.Ref().Name(), prettyKey(key), targetLayer.Import)))
+				}
+				return targetLayer.Stack.Save(targetLayer.Path)
+			}
 			return saveProjectStack(s, ps)
 		}),
 	}
@@ -591,6 +817,9 @@ func newConfigSetCmd(stack *string) *cobra.Command {
 	setCmd.PersistentFlags().BoolVar(
 		&path, "path", false,
 		"The key contains a path to a property in a map or list to set")
+	setCmd.PersistentFlags().StringVar(
+		&layer, "layer", "",
+		"Set the value in this shared config file that the stack imports, rather than in the stack's own config file")
 	setCmd.PersistentFlags().BoolVar(
 		&plaintext, "plaintext", false,
 		"Save the value as plaintext (unencrypted)")
@@ -783,6 +1012,8 @@ type configValueJSON struct {
 	Value       *string     `json:"value,omitempty"`
 	ObjectValue interface{} `json:"objectValue,omitempty"`
 	Secret      bool        `json:"secret"`
+	// Origin is the file that the value comes from. It's only set for stacks that import shared config files.
+	Origin string `json:"origin,omitempty"`
 }
 
 func listConfig(ctx context.Context,
@@ -796,30 +1027,68 @@ func listConfig(ctx context.Context,
 		return err
 	}
 
+	// Include the values of the shared config files that the stack imports.
+	layered, err := loadLayeredConfig(project, stack, ps)
+	if err != nil {
+		return err
+	}
+	cfg := layered.config
+
+	// When a stack imports shared config files, we show where each value comes from.
+	origins := map[config.Key]string{}
+	if len(ps.Imports) > 0 {
+		stackPath, err := getProjectStackPath(stack)
+		if err != nil {
+			return err
+		}
+		for key := range cfg {
+			origins[key] = layered.originName(key, stackPath)
+		}
+	}
+
 	stackName := stack.Ref().Name().String()
 	// when listing configuration values
 	// also show values coming from the project
-	err = workspace.ApplyProjectConfig(stackName, project, ps.Config)
+	err = workspace.ApplyProjectConfig(stackName, project, cfg)
 	if err != nil {
 		return err
 	}
-
-	cfg := ps.Config
-
-	// By default, we will use a blinding decrypter to show "[secret]". If requested, display secrets in plaintext.
-	decrypter := config.NewBlindingDecrypter()
-	if cfg.HasSecureValue() && showSecrets {
-		stackDecrypter, needsSave, err := getStackDecrypter(stack, ps)
+	if len(ps.Imports) > 0 {
+		projectPath, err := workspace.DetectProjectPath()
 		if err != nil {
 			return err
 		}
-		// This may have setup the stack's secrets provider, so save the stack if needed.
-		if needsSave {
-			if err = saveProjectStack(stack, ps); err != nil {
-				return fmt.Errorf("save stack config: %w", err)
+		for key := range cfg {
+			if _, has := origins[key]; !has {
+				origins[key] = filepath.Base(projectPath)
+			}
+		}
+	}
+
+	// By default, we will use a blinding decrypter to show "[secret]". If requested, display secrets in plaintext,
+	// decrypting each of them with the secrets provider of the file that it comes from.
+	var stackDecrypter config.Decrypter
+	decrypterFor := func(key config.Key) (config.Decrypter, error) {
+		if !showSecrets || !cfg[key].Secure() {
+			return config.NewBlindingDecrypter(), nil
+		}
+		if layer := layered.origin(key, false /*path*/); layer != nil {
+			return layered.layerDecrypter(layer)
+		}
+		if stackDecrypter == nil {
+			dec, needsSave, err := getStackDecrypter(stack, ps)
+			if err != nil {
+				return nil, err
 			}
+			// This may have setup the stack's secrets provider, so save the stack if needed.
+			if needsSave {
+				if err = saveProjectStack(stack, ps); err != nil {
+					return nil, fmt.Errorf("save stack config: %w", err)
+				}
+			}
+			stackDecrypter = dec
 		}
-		decrypter = stackDecrypter
+		return stackDecrypter, nil
 	}
 
 	var keys config.KeyArray
@@ -835,8 +1104,13 @@ func listConfig(ctx context.Context,
 		for _, key := range keys {
 			entry := configValueJSON{
 				Secret: cfg[key].Secure(),
+				Origin: origins[key],
 			}
 
+			decrypter, err := decrypterFor(key)
+			if err != nil {
+				return err
+			}
 			decrypted, err := cfg[key].Value(decrypter)
 			if err != nil {
 				return fmt.Errorf("could not decrypt configuration value: %w", err)
@@ -866,18 +1140,31 @@ func listConfig(ctx context.Context,
 			return err
 		}
 	} else {
+		headers := []string{"KEY", "VALUE"}
+		if len(ps.Imports) > 0 {
+			headers = append(headers, "ORIGIN")
+		}
+
 		rows := []cmdutil.TableRow{}
 		for _, key := range keys {
+			decrypter, err := decrypterFor(key)
+			if err != nil {
+				return err
+			}
 			decrypted, err := cfg[key].Value(decrypter)
 			if err != nil {
 				return fmt.Errorf("could not decrypt configuration value: %w", err)
 			}
 
-			rows = append(rows, cmdutil.TableRow{Columns: []string{prettyKey(key), decrypted}})
+			columns := []string{prettyKey(key), decrypted}
+			if len(ps.Imports) > 0 {
+				columns = append(columns, origins[key])
+			}
+			rows = append(rows, cmdutil.TableRow{Columns: columns})
 		}
 
 		cmdutil.PrintTable(cmdutil.Table{
-			Headers: []string{"KEY", "VALUE"},
+			Headers: headers,
 			Rows:    rows,
 		})
 	}
@@ -899,13 +1186,19 @@ func getConfig(ctx context.Context, stack backend.Stack, key config.Key, path, j
 		return err
 	}
 
+	// Include the values of the shared config files that the stack imports.
+	layered, err := loadLayeredConfig(project, stack, ps)
+	if err != nil {
+		return err
+	}
+	cfg := layered.config
+
 	stackName := stack.Ref().Name().String()
 	// when asking for a configuration value, include values from the project config
-	err = workspace.ApplyProjectConfig(stackName, project, ps.Config)
+	err = workspace.ApplyProjectConfig(stackName, project, cfg)
 	if err != nil {
 		return err
 	}
-	cfg := ps.Config
 
 	v, ok, err := cfg.Get(key, path)
 	if err != nil {
@@ -913,7 +1206,11 @@ func getConfig(ctx context.Context, stack backend.Stack, key config.Key, path, j
 	}
 	if ok {
 		var d config.Decrypter
-		if v.Secure() {
+		if layer := layered.origin(key, path); layer != nil && v.Secure() {
+			if d, err = layered.layerDecrypter(layer); err != nil {
+				return fmt.Errorf("could not create a decrypter for shared config file '%s': %w", layer.Import, err)
+			}
+		} else if v.Secure() {
 			var err error
 			var needsSave bool
 			if d, needsSave, err = getStackDecrypter(stack, ps); err != nil {
@@ -1032,12 +1329,25 @@ func getStackConfiguration(
 		}
 	}
 
+	// Merge in the values of the shared config files that the stack imports. Their secrets are re-encrypted with the
+	// stack's secrets manager, so that the engine can decrypt the whole configuration with the stack's decrypter.
+	cfg := workspaceStack.Config
+	if len(workspaceStack.Imports) > 0 {
+		layered, err := loadLayeredConfig(project, stack, workspaceStack)
+		if err != nil {
+			return defaultStackConfig, nil, err
+		}
+		if cfg, err = layered.reencrypt(sm.Encrypter); err != nil {
+			return defaultStackConfig, nil, err
+		}
+	}
+
 	// If there are no secrets in the configuration, we should never use the decrypter, so it is safe to return
 	// one which panics if it is used. This provides for some nice UX in the common case (since, for example, building
 	// the correct decrypter for the local backend would involve prompting for a passphrase)
-	if !workspaceStack.Config.HasSecureValue() {
+	if !cfg.HasSecureValue() {
 		return backend.StackConfiguration{
-			Config:    workspaceStack.Config,
+			Config:    cfg,
 			Decrypter: config.NewPanicCrypter(),
 		}, sm, nil
 	}
@@ -1048,7 +1358,7 @@ func getStackConfiguration(
 	}
 
 	return backend.StackConfiguration{
-		Config:    workspaceStack.Config,
+		Config:    cfg,
 		Decrypter: crypter,
 	}, sm, nil
 }
@@ -0,0 +1,455 @@
+// Copyright 2016-2023, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/spf13/cobra"
+
+	"github.com/pulumi/pulumi/pkg/v3/backend"
+	"github.com/pulumi/pulumi/pkg/v3/backend/display"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/diag/colors"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/resource"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/resource/config"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/cmdutil"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/workspace"
+)
+
+func newConfigDiffCmd(stack *string) *cobra.Command {
+	var version string
+	var showSecrets bool
+	var jsonOut bool
+
+	diffCmd := &cobra.Command{
+		Use:   "diff [stack] [other-stack]",
+		Short: "Compare the configuration of two stacks, or of a stack and an earlier update",
+		Long: "Compare the configuration of two stacks, or of a stack and an earlier update.\n" +
+			"\n" +
+			"With two stacks, `pulumi config diff staging prod` shows how the configuration of prod differs\n" +
+			"from that of staging. With --version, `pulumi config diff --version 3` shows how the current\n" +
+			"configuration of a stack differs from the configuration that update 3 of it was run with, as\n" +
+			"numbered by `pulumi stack history`. Use `--version latest` to compare against the most recent\n" +
+			"update. The stack is the current stack, or the one given by --stack or as an argument.\n" +
+			"\n" +
+			"The configuration of each stack includes the values of any shared config files it imports and\n" +
+			"the values in Pulumi.yaml. Values within objects and arrays are compared one by one, and are\n" +
+			"shown by their paths, as they would be given to `pulumi config set --path`. Secret values are\n" +
+			"decrypted to compare them, but are only shown with --show-secrets.",
+		Args: cmdutil.MaximumNArgs(2),
+		Run: cmdutil.RunFunc(func(cmd *cobra.Command, args []string) error {
+			ctx := commandContext()
+			opts := display.Options{
+				Color: cmdutil.GetGlobalColorization(),
+			}
+
+			project, _, err := readProject()
+			if err != nil {
+				return err
+			}
+
+			var fromLabel, toLabel string
+			var from, to map[string]flatConfigValue
+			var stacks []backend.Stack
+			switch {
+			case version != "" && len(args) == 2:
+				return errors.New("--version compares a single stack with an earlier update of it; " +
+					"give only one stack")
+			case version != "":
+				name := *stack
+				if len(args) == 1 {
+					name = args[0]
+				}
+				s, err := requireStack(ctx, name, stackLoadOnly, opts)
+				if err != nil {
+					return err
+				}
+				stacks = append(stacks, s)
+				cfg, dec, err := loadConfigForDiff(ctx, project, s)
+				if err != nil {
+					return err
+				}
+				if to, err = flattenConfig(ctx, cfg, dec); err != nil {
+					return err
+				}
+
+				updateCfg, updateVersion, err := getUpdateConfiguration(ctx, s, version)
+				if err != nil {
+					return err
+				}
+				if err = workspace.ApplyProjectConfig(s.Ref().Name().String(), project, updateCfg); err != nil {
+					return err
+				}
+				var updateDec config.Decrypter = config.NewPanicCrypter()
+				if updateCfg.HasSecureValue() {
+					if updateDec, err = getConfigDiffDecrypter(ctx, s, updateVersion); err != nil {
+						return err
+					}
+				}
+				if from, err = flattenConfig(ctx, updateCfg, updateDec); err != nil {
+					return fmt.Errorf("reading the configuration of update %d: %w", updateVersion, err)
+				}
+				fromLabel = fmt.Sprintf("%s (update %d)", s.Ref().Name(), updateVersion)
+				toLabel = s.Ref().Name().String()
+			case len(args) == 2:
+				labels := []*string{&fromLabel, &toLabel}
+				flats := []*map[string]flatConfigValue{&from, &to}
+				for i, name := range args {
+					s, err := requireStack(ctx, name, stackLoadOnly, opts)
+					if err != nil {
+						return err
+					}
+					stacks = append(stacks, s)
+					cfg, dec, err := loadConfigForDiff(ctx, project, s)
+					if err != nil {
+						return err
+					}
+					if *flats[i], err = flattenConfig(ctx, cfg, dec); err != nil {
+						return err
+					}
+					*labels[i] = s.Ref().Name().String()
+				}
+			default:
+				return errors.New("give two stacks to compare, or use --version to compare a stack with " +
+					"an earlier update of it")
+			}
+
+			if showSecrets {
+				for _, s := range stacks {
+					log3rdPartySecretsProviderDecryptionEvent(ctx, s, "", "pulumi config diff")
+				}
+			}
+
+			diffs := diffConfig(from, to)
+			if jsonOut {
+				return printJSON(newConfigDiffJSON(fromLabel, toLabel, diffs, showSecrets))
+			}
+			return printConfigDiff(os.Stdout, fromLabel, toLabel, diffs, project, showSecrets, opts)
+		}),
+	}
+
+	diffCmd.PersistentFlags().StringVar(
+		&version, "version", "",
+		"Compare the stack's configuration with the configuration of this update of it, or 'latest'")
+	diffCmd.PersistentFlags().BoolVar(
+		&showSecrets, "show-secrets", false,
+		"Show secret values instead of displaying blinded values")
+	diffCmd.PersistentFlags().BoolVarP(
+		&jsonOut, "json", "j", false,
+		"Emit output as JSON")
+
+	return diffCmd
+}
+
+// loadConfigForDiff loads the current configuration of a stack, including the values of the shared config files it
+// imports and of the project, along with a decrypter for it.
+func loadConfigForDiff(
+	ctx context.Context,
+	project *workspace.Project,
+	s backend.Stack,
+) (config.Map, config.Decrypter, error) {
+	sc, _, err := getStackConfiguration(ctx, s, project, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err = workspace.ApplyProjectConfig(s.Ref().Name().String(), project, sc.Config); err != nil {
+		return nil, nil, err
+	}
+	return sc.Config, sc.Decrypter, nil
+}
+
+// getConfigDiffDecrypter returns the decrypter for the configuration that the given update of a stack was run with.
+// Its secrets were encrypted by the secrets manager that the stack had at the time, which is saved with the update's
+// checkpoint, rather than by the one the stack has now.
+func getConfigDiffDecrypter(ctx context.Context, s backend.Stack, version int) (config.Decrypter, error) {
+	snap, err := loadStackVersion(ctx, s, strconv.Itoa(version))
+	if err != nil {
+		return nil, fmt.Errorf("cannot decrypt the secrets in the configuration of update %d: %w", version, err)
+	}
+	if snap == nil || snap.SecretsManager == nil {
+		return nil, fmt.Errorf("cannot decrypt the secrets in the configuration of update %d: "+
+			"its checkpoint doesn't record the secrets provider they were encrypted with", version)
+	}
+	return snap.SecretsManager.Decrypter()
+}
+
+// getUpdateConfiguration returns the configuration that an update of a stack was run with, along with the update's
+// version. version is either the version of the update, as numbered by `pulumi stack history`, or "latest".
+func getUpdateConfiguration(ctx context.Context, s backend.Stack, version string) (config.Map, int, error) {
+	want := -1
+	if version != "latest" {
+		v, err := strconv.Atoi(version)
+		if err != nil || v < 1 {
+			return nil, 0, fmt.Errorf("invalid version %q; use the number of an update or 'latest'", version)
+		}
+		want = v
+	}
+
+	// Updates are listed from the most recent, so we page back through them until we reach the version we want.
+	const pageSize = 50
+	for page := 1; ; page++ {
+		updates, err := s.Backend().GetHistory(ctx, s.Ref(), pageSize, page)
+		if err != nil {
+			return nil, 0, fmt.Errorf("getting history: %w", err)
+		}
+		for _, u := range updates {
+			if want == -1 || u.Version == want {
+				cfg := u.Config
+				if cfg == nil {
+					cfg = make(config.Map)
+				}
+				return cfg, u.Version, nil
+			}
+		}
+		if len(updates) < pageSize || updates[len(updates)-1].Version < want {
+			break
+		}
+	}
+
+	if want == -1 {
+		return nil, 0, fmt.Errorf("stack '%s' has no updates", s.Ref().Name())
+	}
+	return nil, 0, fmt.Errorf("stack '%s' has no update with version %d", s.Ref().Name(), want)
+}
+
+// flatConfigValue is a single value in a configuration, which may be within an object or array.
+type flatConfigValue struct {
+	// key is the path-style key of the value, such as `proj:db.hosts[0]`.
+	key config.Key
+	// value is the value's text. Strings are themselves, and other values are JSON.
+	value  string
+	secret bool
+}
+
+// flattenConfig decrypts a configuration and flattens the values within objects and arrays into values of their own,
+// keyed by their paths.
+func flattenConfig(ctx context.Context, cfg config.Map, dec config.Decrypter) (map[string]flatConfigValue, error) {
+	result := make(map[string]flatConfigValue)
+	for k, v := range cfg {
+		root := resource.PropertyPath{k.Name()}
+		add := func(p resource.PropertyPath, value string, secret bool) {
+			key := config.MustMakeKey(k.Namespace(), p.String())
+			result[key.String()] = flatConfigValue{key: key, value: value, secret: secret}
+		}
+
+		if !v.Object() {
+			value, err := v.Value(dec)
+			if err != nil {
+				return nil, fmt.Errorf("could not decrypt configuration value '%s': %w", k, err)
+			}
+			add(root, value, v.Secure())
+			continue
+		}
+
+		obj, err := v.ToObject()
+		if err != nil {
+			return nil, fmt.Errorf("could not read configuration value '%s': %w", k, err)
+		}
+		if err := flattenConfigObject(ctx, obj, root, dec, add); err != nil {
+			return nil, fmt.Errorf("could not decrypt configuration value '%s': %w", k, err)
+		}
+	}
+	return result, nil
+}
+
+// flattenConfigObject calls add with the path and text of every value within an object or array. Secret values are
+// objects with a single "secure" property, which are decrypted.
+func flattenConfigObject(
+	ctx context.Context,
+	v interface{},
+	p resource.PropertyPath,
+	dec config.Decrypter,
+	add func(resource.PropertyPath, string, bool),
+) error {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if ciphertext, ok := v["secure"].(string); ok && len(v) == 1 {
+			plaintext, err := dec.DecryptValue(ctx, ciphertext)
+			if err != nil {
+				return err
+			}
+			add(p, plaintext, true)
+			return nil
+		}
+		if len(v) == 0 {
+			add(p, "{}", false)
+		}
+		for k, e := range v {
+			if err := flattenConfigObject(ctx, e, appendPropertyPath(p, k), dec, add); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		if len(v) == 0 {
+			add(p, "[]", false)
+		}
+		for i, e := range v {
+			if err := flattenConfigObject(ctx, e, appendPropertyPath(p, i), dec, add); err != nil {
+				return err
+			}
+		}
+	case string:
+		add(p, v, false)
+	default:
+		b, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		add(p, string(b), false)
+	}
+	return nil
+}
+
+// configDiffKind is how a value differs between two configurations.
+type configDiffKind string
+
+const (
+	configDiffAdded   configDiffKind = "added"
+	configDiffRemoved configDiffKind = "removed"
+	configDiffChanged configDiffKind = "changed"
+)
+
+// configValueDiff is a value that differs between two configurations. Old is nil for added values, and New for removed
+// ones.
+type configValueDiff struct {
+	Kind configDiffKind
+	Old  *flatConfigValue
+	New  *flatConfigValue
+}
+
+// key returns the key of the value that differs.
+func (d configValueDiff) key() config.Key {
+	if d.New != nil {
+		return d.New.key
+	}
+	return d.Old.key
+}
+
+// diffConfig compares two flattened configurations, returning the values that differ sorted by key. A value also
+// differs if it's secret in one configuration but not the other.
+func diffConfig(from, to map[string]flatConfigValue) []configValueDiff {
+	var diffs []configValueDiff
+	for k, o := range from {
+		o := o
+		n, has := to[k]
+		switch {
+		case !has:
+			diffs = append(diffs, configValueDiff{Kind: configDiffRemoved, Old: &o})
+		case n != o:
+			diffs = append(diffs, configValueDiff{Kind: configDiffChanged, Old: &o, New: &n})
+		}
+	}
+	for k, n := range to {
+		n := n
+		if _, has := from[k]; !has {
+			diffs = append(diffs, configValueDiff{Kind: configDiffAdded, New: &n})
+		}
+	}
+
+	sort.Slice(diffs, func(i, j int) bool {
+		return diffs[i].key().String() < diffs[j].key().String()
+	})
+	return diffs
+}
+
+// configValueDiffJSON is the shape of a value in the --json output of `pulumi config diff`. Old is omitted for added
+// values, and New for removed ones. Secret values are also omitted unless --show-secrets is passed.
+type configValueDiffJSON struct {
+	Key    string         `json:"key"`
+	Kind   configDiffKind `json:"kind"`
+	Old    *string        `json:"old,omitempty"`
+	New    *string        `json:"new,omitempty"`
+	Secret bool           `json:"secret"`
+}
+
+// configDiffJSON is the shape of the --json output of `pulumi config diff`. While we can add fields to this structure
+// in the future, we should not change existing fields.
+type configDiffJSON struct {
+	From    string                `json:"from"`
+	To      string                `json:"to"`
+	Changes []configValueDiffJSON `json:"changes"`
+}
+
+func newConfigDiffJSON(from, to string, diffs []configValueDiff, showSecrets bool) configDiffJSON {
+	value := func(v *flatConfigValue) *string {
+		if v == nil || (v.secret && !showSecrets) {
+			return nil
+		}
+		return &v.value
+	}
+
+	changes := make([]configValueDiffJSON, len(diffs))
+	for i, d := range diffs {
+		changes[i] = configValueDiffJSON{
+			Key:    d.key().String(),
+			Kind:   d.Kind,
+			Old:    value(d.Old),
+			New:    value(d.New),
+			Secret: (d.Old != nil && d.Old.secret) || (d.New != nil && d.New.secret),
+		}
+	}
+	return configDiffJSON{From: from, To: to, Changes: changes}
+}
+
+// printConfigDiff prints the values that differ between two configurations, prefixed by `+` if they were added, `-`
+// if they were removed and `~` if they changed.
+func printConfigDiff(
+	w io.Writer,
+	from, to string,
+	diffs []configValueDiff,
+	project *workspace.Project,
+	showSecrets bool,
+	opts display.Options,
+) error {
+	if len(diffs) == 0 {
+		_, err := fmt.Fprintf(w, "The configuration of %s and %s is the same\n", from, to)
+		return err
+	}
+
+	value := func(v *flatConfigValue) string {
+		if v.secret && !showSecrets {
+			return "[secret]"
+		}
+		if v.secret {
+			return v.value + " (secret)"
+		}
+		return v.value
+	}
+
+	fmt.Fprintf(w, "Comparing the configuration of %s with %s:\n", from, to)
+	for _, d := range diffs {
+		key := prettyKeyForProject(d.key(), project)
+		var line string
+		switch d.Kind {
+		case configDiffAdded:
+			line = fmt.Sprintf("%s+ %s: %s%s", colors.SpecCreate, key, value(d.New), colors.Reset)
+		case configDiffRemoved:
+			line = fmt.Sprintf("%s- %s: %s%s", colors.SpecDelete, key, value(d.Old), colors.Reset)
+		case configDiffChanged:
+			line = fmt.Sprintf("%s~ %s: %s => %s%s", colors.SpecUpdate, key, value(d.Old), value(d.New), colors.Reset)
+		}
+		if _, err := fmt.Fprintln(w, opts.Color.Colorize(line)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
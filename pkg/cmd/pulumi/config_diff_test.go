@@ -0,0 +1,279 @@
+// Copyright 2016-2023, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pulumi/pulumi/pkg/v3/backend"
+	"github.com/pulumi/pulumi/pkg/v3/backend/display"
+	"github.com/pulumi/pulumi/pkg/v3/secrets/b64"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/apitype"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/diag/colors"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/resource/config"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/workspace"
+)
+
+func mustParseConfigKey(s string) config.Key {
+	k, err := config.ParseKey(s)
+	if err != nil {
+		panic(err)
+	}
+	return k
+}
+
+func stringRef(s string) *string {
+	return &s
+}
+
+// newConfigDiffTestMap sets pairs of path-style keys and values in a new config.Map, in order, encrypting the secret
+// ones with config.Base64Crypter.
+func newConfigDiffTestMap(t *testing.T, values [][2]string, secrets [][2]string) config.Map {
+	cfg := config.Map{}
+	for _, kv := range values {
+		require.NoError(t, cfg.Set(mustParseConfigKey(kv[0]), config.NewValue(kv[1]), true /*path*/))
+	}
+	for _, kv := range secrets {
+		ciphertext, err := config.Base64Crypter.EncryptValue(context.Background(), kv[1])
+		require.NoError(t, err)
+		require.NoError(t, cfg.Set(mustParseConfigKey(kv[0]), config.NewSecureValue(ciphertext), true /*path*/))
+	}
+	return cfg
+}
+
+func TestFlattenConfig(t *testing.T) {
+	t.Parallel()
+
+	cfg := newConfigDiffTestMap(t, [][2]string{
+		{"proj:region", "us-east-1"},
+		{"proj:db.host", "localhost"},
+		{"proj:db.port", "5432"},
+		{"proj:db.tls", "true"},
+		{"proj:hosts[0]", "a"},
+		{"proj:hosts[1]", "b"},
+		{`proj:tags["a.b"]`, "c"},
+		{"aws:defaultRegion", "us-west-2"},
+	}, [][2]string{
+		{"proj:token", "abc"},
+		{"proj:db.password", "hunter2"},
+	})
+	cfg[mustParseConfigKey("proj:empty")] = config.NewObjectValue("{}")
+
+	flat, err := flattenConfig(context.Background(), cfg, config.Base64Crypter)
+	require.NoError(t, err)
+
+	got := map[string]string{}
+	for k, v := range flat {
+		assert.Equal(t, k, v.key.String())
+		if v.secret {
+			got[k] = v.value + " (secret)"
+		} else {
+			got[k] = v.value
+		}
+	}
+	assert.Equal(t, map[string]string{
+		"proj:region":       "us-east-1",
+		"proj:db.host":      "localhost",
+		"proj:db.port":      "5432",
+		"proj:db.tls":       "true",
+		"proj:db.password":  "hunter2 (secret)",
+		"proj:hosts[0]":     "a",
+		"proj:hosts[1]":     "b",
+		`proj:tags["a.b"]`:  "c",
+		"proj:token":        "abc (secret)",
+		"proj:empty":        "{}",
+		"aws:defaultRegion": "us-west-2",
+	}, got)
+}
+
+func TestDiffConfig(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	staging, err := flattenConfig(ctx, newConfigDiffTestMap(t, [][2]string{
+		{"proj:region", "us-east-1"},
+		{"proj:db.host", "staging.db"},
+		{"proj:db.port", "5432"},
+		{"proj:replicas", "1"},
+		{"proj:apiKey", "plain"},
+	}, [][2]string{
+		{"proj:password", "staging-pass"},
+		{"proj:same", "shared-secret"},
+	}), config.Base64Crypter)
+	require.NoError(t, err)
+
+	// Prod encrypts its secrets differently, so equal secrets only compare equal once decrypted.
+	prodCrypter := config.NewSymmetricCrypter(make([]byte, 32))
+	prodCfg := newConfigDiffTestMap(t, [][2]string{
+		{"proj:region", "us-east-1"},
+		{"proj:db.host", "prod.db"},
+		{"proj:db.port", "5432"},
+		{"proj:zone", "b"},
+	}, nil)
+	prodSecrets := map[string]string{"proj:password": "prod-pass", "proj:same": "shared-secret", "proj:apiKey": "plain"}
+	for k, v := range prodSecrets {
+		ciphertext, err := prodCrypter.EncryptValue(ctx, v)
+		require.NoError(t, err)
+		prodCfg[mustParseConfigKey(k)] = config.NewSecureValue(ciphertext)
+	}
+	prod, err := flattenConfig(ctx, prodCfg, prodCrypter)
+	require.NoError(t, err)
+
+	diffs := diffConfig(staging, prod)
+	var summary []string
+	for _, d := range diffs {
+		summary = append(summary, fmt.Sprintf("%s %s", d.Kind, d.key()))
+	}
+	assert.Equal(t, []string{
+		"changed proj:apiKey",
+		"changed proj:db.host",
+		"changed proj:password",
+		"removed proj:replicas",
+		"added proj:zone",
+	}, summary)
+
+	// Secret values are only shown if asked for.
+	out := newConfigDiffJSON("staging", "prod", diffs, false /*showSecrets*/)
+	assert.Equal(t, "staging", out.From)
+	assert.Equal(t,
+		configValueDiffJSON{Key: "proj:apiKey", Kind: configDiffChanged, Old: stringRef("plain"), Secret: true},
+		out.Changes[0])
+	assert.Equal(t, configValueDiffJSON{Key: "proj:password", Kind: configDiffChanged, Secret: true}, out.Changes[2])
+	assert.Equal(t, configValueDiffJSON{Key: "proj:replicas", Kind: configDiffRemoved, Old: stringRef("1")},
+		out.Changes[3])
+
+	out = newConfigDiffJSON("staging", "prod", diffs, true /*showSecrets*/)
+	assert.Equal(t, configValueDiffJSON{
+		Key:    "proj:password",
+		Kind:   configDiffChanged,
+		Old:    stringRef("staging-pass"),
+		New:    stringRef("prod-pass"),
+		Secret: true,
+	}, out.Changes[2])
+
+	var b bytes.Buffer
+	project := &workspace.Project{Name: "proj"}
+	opts := display.Options{Color: colors.Never}
+	require.NoError(t, printConfigDiff(&b, "staging", "prod", diffs, project, false /*showSecrets*/, opts))
+	assert.Equal(t, `Comparing the configuration of staging with prod:
+~ apiKey: plain => [secret]
+~ db.host: staging.db => prod.db
+~ password: [secret] => [secret]
+- replicas: 1
++ zone: b
+`, b.String())
+
+	b.Reset()
+	require.NoError(t, printConfigDiff(&b, "staging", "staging", nil, project, false /*showSecrets*/, opts))
+	assert.Equal(t, "The configuration of staging and staging is the same\n", b.String())
+}
+
+func TestGetUpdateConfiguration(t *testing.T) {
+	t.Parallel()
+
+	// The stack has 120 updates, with the newest first, of which every one sets "version" to its own version.
+	be := &backend.MockBackend{
+		GetHistoryF: func(_ context.Context, _ backend.StackReference, pageSize, page int) ([]backend.UpdateInfo, error) {
+			var updates []backend.UpdateInfo
+			for v := 120 - (page-1)*pageSize; v > 120-page*pageSize && v > 0; v-- {
+				updates = append(updates, backend.UpdateInfo{
+					Version: v,
+					Config:  config.Map{mustParseConfigKey("proj:version"): config.NewValue(fmt.Sprint(v))},
+				})
+			}
+			return updates, nil
+		},
+	}
+	s := &backend.MockStack{
+		BackendF: func() backend.Backend { return be },
+		RefF:     func() backend.StackReference { return &backend.MockStackReference{NameV: "dev"} },
+	}
+
+	for version, want := range map[string]int{"latest": 120, "120": 120, "75": 75, "1": 1} {
+		cfg, v, err := getUpdateConfiguration(context.Background(), s, version)
+		require.NoError(t, err, version)
+		assert.Equal(t, want, v)
+		assert.Equal(t, config.NewValue(fmt.Sprint(want)), cfg[mustParseConfigKey("proj:version")])
+	}
+
+	_, _, err := getUpdateConfiguration(context.Background(), s, "121")
+	assert.EqualError(t, err, "stack 'dev' has no update with version 121")
+	_, _, err = getUpdateConfiguration(context.Background(), s, "0")
+	assert.EqualError(t, err, `invalid version "0"; use the number of an update or 'latest'`)
+}
+
+// versionExportingBackend is a MockBackend that can export the deployments of earlier updates.
+type versionExportingBackend struct {
+	backend.MockBackend
+
+	deployments map[string]apitype.DeploymentV3
+}
+
+func (be *versionExportingBackend) ExportDeploymentForVersion(
+	_ context.Context, _ backend.Stack, version string,
+) (*apitype.UntypedDeployment, error) {
+	deployment, ok := be.deployments[version]
+	if !ok {
+		return nil, fmt.Errorf("stack has no version %s", version)
+	}
+	bytes, err := json.Marshal(deployment)
+	if err != nil {
+		return nil, err
+	}
+	return &apitype.UntypedDeployment{Version: 3, Deployment: bytes}, nil
+}
+
+func TestGetConfigDiffDecrypter(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	be := &versionExportingBackend{deployments: map[string]apitype.DeploymentV3{
+		"1": {},
+		"2": {SecretsProviders: &apitype.SecretsProvidersV1{Type: b64.Type, State: json.RawMessage(`{}`)}},
+	}}
+	s := &backend.MockStack{
+		BackendF: func() backend.Backend { return be },
+		RefF:     func() backend.StackReference { return &backend.MockStackReference{NameV: "dev"} },
+	}
+
+	// The configuration of an update is decrypted by the secrets manager saved with it.
+	dec, err := getConfigDiffDecrypter(ctx, s, 2)
+	require.NoError(t, err)
+	ciphertext, err := config.Base64Crypter.EncryptValue(ctx, "hunter2")
+	require.NoError(t, err)
+	plaintext, err := dec.DecryptValue(ctx, ciphertext)
+	require.NoError(t, err)
+	assert.Equal(t, "hunter2", plaintext)
+
+	_, err = getConfigDiffDecrypter(ctx, s, 1)
+	assert.EqualError(t, err, "cannot decrypt the secrets in the configuration of update 1: "+
+		"its checkpoint doesn't record the secrets provider they were encrypted with")
+	_, err = getConfigDiffDecrypter(ctx, s, 3)
+	assert.ErrorContains(t, err, "cannot decrypt the secrets in the configuration of update 3")
+
+	// Backends that can't export earlier deployments can't decrypt their configuration either.
+	s.BackendF = func() backend.Backend {
+		return &backend.MockBackend{NameF: func() string { return "mock" }}
+	}
+	_, err = getConfigDiffDecrypter(ctx, s, 2)
+	assert.ErrorContains(t, err, "does not provide the ability to export previous deployments")
+}
@@ -0,0 +1,496 @@
+// Copyright 2016-2023, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/hexops/gotextdiff"
+	"github.com/hexops/gotextdiff/myers"
+	"github.com/hexops/gotextdiff/span"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+
+	"github.com/pulumi/pulumi/pkg/v3/backend/display"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/diag"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/encoding"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/resource"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/resource/config"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/cmdutil"
+)
+
+func newConfigImportCmd(stack *string) *cobra.Command {
+	var format string
+	var secretKeys []string
+	var detectSecrets bool
+	var dryRun bool
+
+	importCmd := &cobra.Command{
+		Use:   "import <file>",
+		Short: "Import configuration values from a .env, JSON or YAML file",
+		Long: "Import configuration values from a .env, JSON or YAML file.\n" +
+			"\n" +
+			"Every value in the file is set in the stack's configuration, replacing any value the key\n" +
+			"already has. Keys are in the project's namespace unless they contain a ':', like `aws:region`.\n" +
+			"The format of the file is detected from its name, or can be given with --format. Use '-' to\n" +
+			"read the file from standard input.\n" +
+			"\n" +
+			"Nested objects and arrays in JSON and YAML files are imported as they would be by\n" +
+			"`pulumi config set --path`, so a file containing `{\"db\": {\"port\": 5432}}` sets the value\n" +
+			"`db.port`. Null values and empty objects and arrays are skipped.\n" +
+			"\n" +
+			"Values are stored in plaintext unless they are marked as secrets, in which case they are\n" +
+			"encrypted with the stack's secrets provider. --secret-keys marks the values whose keys match any\n" +
+			"of the given patterns, which can use the wildcards of `*`, `?` and `[...]` and are matched\n" +
+			"against both the path of a value, such as `db.password`, and its own name, such as `password`,\n" +
+			"ignoring case. --detect-secrets marks values that look like secrets, because their names\n" +
+			"mention passwords, secrets or tokens and their values look random.\n" +
+			"\n" +
+			"Use --dry-run to see the changes that would be made to the stack's configuration file\n" +
+			"without making them. A dry run doesn't create or select the stack, and shows secret values\n" +
+			"as `[secret]` rather than encrypting them.",
+		Args: cmdutil.ExactArgs(1),
+		Run: cmdutil.RunFunc(func(cmd *cobra.Command, args []string) error {
+			ctx := commandContext()
+			opts := display.Options{
+				Color: cmdutil.GetGlobalColorization(),
+			}
+
+			if format == "" {
+				var err error
+				if format, err = detectConfigImportFormat(args[0]); err != nil {
+					return err
+				}
+			}
+
+			var data []byte
+			var err error
+			if args[0] == "-" {
+				data, err = io.ReadAll(os.Stdin)
+			} else {
+				data, err = os.ReadFile(args[0])
+			}
+			if err != nil {
+				return err
+			}
+
+			project, _, err := readProject()
+			if err != nil {
+				return err
+			}
+
+			values, err := parseConfigImport(data, format, project.Name.String())
+			if err != nil {
+				return fmt.Errorf("reading %s: %w", args[0], err)
+			}
+
+			// A dry run doesn't change anything, so it neither creates nor selects the stack.
+			loadOpts := stackOfferNew | stackSetCurrent
+			if dryRun {
+				loadOpts = stackLoadOnly
+			}
+			s, err := requireStack(ctx, *stack, loadOpts, opts)
+			if err != nil {
+				return err
+			}
+
+			ps, err := loadProjectStack(project, s)
+			if err != nil {
+				return err
+			}
+			configPath, err := getProjectStackPath(s)
+			if err != nil {
+				return err
+			}
+
+			importer := &configImporter{
+				secretKeys:    secretKeys,
+				detectSecrets: detectSecrets,
+				encrypter: func() (config.Encrypter, error) {
+					// A dry run shows secrets without encrypting them, so that it doesn't set up the stack's
+					// secrets provider.
+					if dryRun {
+						return config.BlindingCrypter, nil
+					}
+					// We're always going to save, so can ignore the bool for if getStackEncrypter changed the
+					// config data.
+					enc, _, err := getStackEncrypter(s, ps)
+					return enc, err
+				},
+			}
+			before := string(ps.RawValue())
+			if err := importer.importConfig(ctx, ps.Config, values); err != nil {
+				return err
+			}
+			for _, warning := range importer.warnings {
+				cmdutil.Diag().Warningf(diag.RawMessage("" /*urn*/, warning))
+			}
+
+			if dryRun {
+				m, has := encoding.Marshalers[filepath.Ext(configPath)]
+				if !has {
+					return fmt.Errorf("no marshaler found for file format '%v'", filepath.Ext(configPath))
+				}
+				after, err := m.Marshal(ps)
+				if err != nil {
+					return err
+				}
+				return printConfigFileDiff(os.Stdout, filepath.Base(configPath), before, string(after))
+			}
+
+			if err := saveProjectStack(s, ps); err != nil {
+				return err
+			}
+			fmt.Printf("Imported %d configuration value(s) into stack '%s', %d of them secret\n",
+				len(values), s.Ref().Name(), importer.secrets)
+			return nil
+		}),
+	}
+
+	importCmd.PersistentFlags().StringVar(
+		&format, "format", "",
+		"The format of the file: dotenv, json or yaml. Detected from the file's name by default")
+	importCmd.PersistentFlags().StringSliceVar(
+		&secretKeys, "secret-keys", nil,
+		"Encrypt the values whose keys match any of these patterns, such as '*password*'")
+	importCmd.PersistentFlags().BoolVar(
+		&detectSecrets, "detect-secrets", false,
+		"Encrypt the values that look like secrets")
+	importCmd.PersistentFlags().BoolVar(
+		&dryRun, "dry-run", false,
+		"Show the changes that would be made to the stack's configuration file without making them")
+
+	return importCmd
+}
+
+// importedConfigValue is a value read by parseConfigImport.
+type importedConfigValue struct {
+	// namespace is the namespace of the value's key.
+	namespace string
+	// path is the path of the value, the first element of which is the name of the value's key.
+	path  resource.PropertyPath
+	value string
+}
+
+// key returns the path-style config key that the value should be set with.
+func (v importedConfigValue) key() config.Key {
+	return config.MustMakeKey(v.namespace, v.path.String())
+}
+
+// detectConfigImportFormat guesses the format of the file to import from its name.
+func detectConfigImportFormat(file string) (string, error) {
+	base := strings.ToLower(filepath.Base(file))
+	switch {
+	case base == ".env" || strings.HasPrefix(base, ".env.") || strings.HasSuffix(base, ".env"):
+		return "dotenv", nil
+	case strings.HasSuffix(base, ".json"):
+		return "json", nil
+	case strings.HasSuffix(base, ".yaml") || strings.HasSuffix(base, ".yml"):
+		return "yaml", nil
+	}
+	return "", fmt.Errorf("could not detect the format of %q; use --format to set it to dotenv, json or yaml", file)
+}
+
+// parseConfigImport reads the values in a file to import, in the order they appear in it. Keys without a namespace
+// are put in the project's namespace.
+func parseConfigImport(data []byte, format, projectName string) ([]importedConfigValue, error) {
+	switch format {
+	case "dotenv":
+		vars, err := parseDotenv(string(data))
+		if err != nil {
+			return nil, err
+		}
+		values := make([]importedConfigValue, len(vars))
+		for i, v := range vars {
+			values[i] = importedConfigValue{namespace: projectName, path: resource.PropertyPath{v.name}, value: v.value}
+		}
+		return values, nil
+	case "json", "yaml":
+		// JSON is YAML too, so we can read both formats the same way. Reading them as YAML nodes, rather than as
+		// values, lets us import scalars exactly as they're written.
+		var doc yaml.Node
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return nil, err
+		}
+		if len(doc.Content) == 0 {
+			return nil, nil
+		}
+		root := doc.Content[0]
+		if root.Kind != yaml.MappingNode {
+			return nil, errors.New("the file must contain an object")
+		}
+
+		var values []importedConfigValue
+		for i := 0; i+1 < len(root.Content); i += 2 {
+			namespace, name := projectName, root.Content[i].Value
+			if ns, n, found := strings.Cut(name, ":"); found {
+				namespace, name = ns, n
+			}
+			if name == "" {
+				return nil, fmt.Errorf("line %d: the key %q is not a valid configuration key", root.Content[i].Line,
+					root.Content[i].Value)
+			}
+
+			err := flattenYAMLConfig(root.Content[i+1], resource.PropertyPath{name}, func(p resource.PropertyPath, v string) {
+				values = append(values, importedConfigValue{namespace: namespace, path: p, value: v})
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+		return values, nil
+	default:
+		return nil, fmt.Errorf("unknown format %q; the supported formats are dotenv, json and yaml", format)
+	}
+}
+
+// flattenYAMLConfig calls set with the path and text of every scalar value within a YAML node.
+func flattenYAMLConfig(node *yaml.Node, p resource.PropertyPath, set func(resource.PropertyPath, string)) error {
+	for node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i]
+			if key.Kind != yaml.ScalarNode {
+				return fmt.Errorf("line %d: keys must be strings", key.Line)
+			}
+			if err := flattenYAMLConfig(node.Content[i+1], appendPropertyPath(p, key.Value), set); err != nil {
+				return err
+			}
+		}
+	case yaml.SequenceNode:
+		for i, item := range node.Content {
+			if err := flattenYAMLConfig(item, appendPropertyPath(p, i), set); err != nil {
+				return err
+			}
+		}
+	case yaml.ScalarNode:
+		if node.ShortTag() != "!!null" {
+			set(p, node.Value)
+		}
+	default:
+		return fmt.Errorf("line %d: unsupported value", node.Line)
+	}
+	return nil
+}
+
+// appendPropertyPath returns a new path with an element added to the end of an existing one.
+func appendPropertyPath(p resource.PropertyPath, element interface{}) resource.PropertyPath {
+	result := make(resource.PropertyPath, len(p), len(p)+1)
+	copy(result, p)
+	return append(result, element)
+}
+
+type dotenvVariable struct {
+	name  string
+	value string
+}
+
+var dotenvNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.\-]*$`)
+
+// parseDotenv reads the variables in a .env file. Each line sets a variable with NAME=VALUE, optionally preceded by
+// `export`. Values may be unquoted, in which case a ` #` starts a comment, single-quoted, in which case they're taken
+// literally, or double-quoted, in which case `\n`, `\r`, `\t`, `\"` and `\\` are escapes. Quoted values may span
+// lines. Blank lines and lines starting with `#` are ignored.
+func parseDotenv(text string) ([]dotenvVariable, error) {
+	lineOf := func(pos int) int {
+		return strings.Count(text[:pos], "\n") + 1
+	}
+	lineEnd := func(pos int) int {
+		if i := strings.IndexByte(text[pos:], '\n'); i >= 0 {
+			return pos + i
+		}
+		return len(text)
+	}
+
+	var vars []dotenvVariable
+	for pos := 0; pos < len(text); {
+		start, end := pos, lineEnd(pos)
+		pos = end + 1
+
+		current := strings.TrimSpace(text[start:end])
+		if current == "" || strings.HasPrefix(current, "#") {
+			continue
+		}
+		current = strings.TrimPrefix(current, "export ")
+
+		name, value, found := strings.Cut(current, "=")
+		name = strings.TrimSpace(name)
+		if !found || !dotenvNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("line %d: expected NAME=VALUE", lineOf(start))
+		}
+		value = strings.TrimLeft(value, " \t")
+
+		if value == "" || (value[0] != '"' && value[0] != '\'') {
+			if i := strings.Index(value, " #"); i >= 0 {
+				value = value[:i]
+			}
+			vars = append(vars, dotenvVariable{name: name, value: strings.TrimSpace(value)})
+			continue
+		}
+
+		// Quoted values run until the closing quote, which may be on a later line, so we scan the text from the
+		// opening quote rather than the line.
+		open := start + strings.IndexByte(text[start:end], '=') + 1
+		for text[open] == ' ' || text[open] == '\t' {
+			open++
+		}
+		quote := text[open]
+
+		var b strings.Builder
+		closing := -1
+		for i := open + 1; i < len(text) && closing == -1; i++ {
+			switch c := text[i]; {
+			case c == quote:
+				closing = i
+			case c == '\\' && quote == '"' && i+1 < len(text):
+				i++
+				switch text[i] {
+				case 'n':
+					b.WriteByte('\n')
+				case 'r':
+					b.WriteByte('\r')
+				case 't':
+					b.WriteByte('\t')
+				case '"', '\\':
+					b.WriteByte(text[i])
+				default:
+					b.WriteByte('\\')
+					b.WriteByte(text[i])
+				}
+			default:
+				b.WriteByte(c)
+			}
+		}
+		if closing == -1 {
+			return nil, fmt.Errorf("line %d: missing closing quote", lineOf(start))
+		}
+
+		// Anything after the closing quote on the same line must be a comment.
+		end = lineEnd(closing)
+		if trailing := strings.TrimSpace(text[closing+1 : end]); trailing != "" && !strings.HasPrefix(trailing, "#") {
+			return nil, fmt.Errorf("line %d: unexpected text after the closing quote", lineOf(closing))
+		}
+		pos = end + 1
+
+		vars = append(vars, dotenvVariable{name: name, value: b.String()})
+	}
+	return vars, nil
+}
+
+// configImporter sets imported values in a stack's configuration, encrypting the ones that should be secret.
+type configImporter struct {
+	// secretKeys are patterns for the paths or names of the values that should be secret.
+	secretKeys []string
+	// detectSecrets is true if values that look like secrets should be secret.
+	detectSecrets bool
+	// encrypter returns the encrypter for secret values. It's only called if there are any.
+	encrypter func() (config.Encrypter, error)
+
+	// secrets is the number of values that were encrypted.
+	secrets int
+	// warnings are about values that look like secrets, but were stored in plaintext.
+	warnings []string
+
+	enc config.Encrypter
+}
+
+func (imp *configImporter) importConfig(ctx context.Context, cfg config.Map, values []importedConfigValue) error {
+	for _, pattern := range imp.secretKeys {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid --secret-keys pattern %q: %w", pattern, err)
+		}
+	}
+
+	for _, v := range values {
+		key := v.key()
+
+		var value config.Value
+		switch {
+		case imp.isSecretKey(v.path) || (imp.detectSecrets && looksLikeSecret(key, v.value)):
+			if imp.enc == nil {
+				enc, err := imp.encrypter()
+				if err != nil {
+					return err
+				}
+				imp.enc = enc
+			}
+			ciphertext, err := imp.enc.EncryptValue(ctx, v.value)
+			if err != nil {
+				return err
+			}
+			value = config.NewSecureValue(ciphertext)
+			imp.secrets++
+		default:
+			value = config.NewValue(v.value)
+			if looksLikeSecret(key, v.value) {
+				imp.warnings = append(imp.warnings, fmt.Sprintf(
+					"the value of '%s' looks like a secret, but will be stored in plaintext; "+
+						"use --secret-keys or --detect-secrets to encrypt it", key))
+			}
+		}
+
+		if err := cfg.Set(key, value, true /*path*/); err != nil {
+			return fmt.Errorf("setting '%s': %w", key, err)
+		}
+	}
+	return nil
+}
+
+// isSecretKey returns true if the path of a value, or the name of the value, matches any of the secret key patterns.
+func (imp *configImporter) isSecretKey(p resource.PropertyPath) bool {
+	full := strings.ToLower(p.String())
+	var name string
+	for i := len(p) - 1; i >= 0; i-- {
+		if s, ok := p[i].(string); ok {
+			name = strings.ToLower(s)
+			break
+		}
+	}
+
+	for _, pattern := range imp.secretKeys {
+		pattern = strings.ToLower(pattern)
+		if ok, _ := path.Match(pattern, full); ok {
+			return true
+		}
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// printConfigFileDiff writes a unified diff of the changes to a config file.
+func printConfigFileDiff(w io.Writer, name, before, after string) error {
+	if before == after {
+		_, err := fmt.Fprintf(w, "No changes would be made to %s\n", name)
+		return err
+	}
+
+	edits := myers.ComputeEdits(span.URIFromPath(name), before, after)
+	_, err := fmt.Fprint(w, gotextdiff.ToUnified(name, name+" (after import)", before, edits))
+	return err
+}
@@ -0,0 +1,250 @@
+// Copyright 2016-2023, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/resource"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/resource/config"
+)
+
+func TestParseDotenv(t *testing.T) {
+	t.Parallel()
+
+	vars, err := parseDotenv(`# A comment
+PLAIN=value
+export EXPORTED=1
+
+SPACED = some value # a comment
+EMPTY=
+HASH=a#b
+SINGLE='literal \n "quotes"'
+DOUBLE="line one\nline \"two\"" # a comment
+MULTI="first
+second"
+LAST=done`)
+	require.NoError(t, err)
+	assert.Equal(t, []dotenvVariable{
+		{name: "PLAIN", value: "value"},
+		{name: "EXPORTED", value: "1"},
+		{name: "SPACED", value: "some value"},
+		{name: "EMPTY", value: ""},
+		{name: "HASH", value: "a#b"},
+		{name: "SINGLE", value: `literal \n "quotes"`},
+		{name: "DOUBLE", value: "line one\nline \"two\""},
+		{name: "MULTI", value: "first\nsecond"},
+		{name: "LAST", value: "done"},
+	}, vars)
+}
+
+func TestParseDotenv_errors(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		text    string
+		wantErr string
+	}{
+		{text: "A=1\nnot a variable", wantErr: "line 2: expected NAME=VALUE"},
+		{text: "A=1\n1A=2", wantErr: "line 2: expected NAME=VALUE"},
+		{text: "A=\"1\nB=2", wantErr: "line 1: missing closing quote"},
+		{text: "A='1\n2' trailing", wantErr: "line 2: unexpected text after the closing quote"},
+	}
+	for _, tt := range tests {
+		_, err := parseDotenv(tt.text)
+		assert.EqualError(t, err, tt.wantErr, tt.text)
+	}
+}
+
+func TestParseConfigImport(t *testing.T) {
+	t.Parallel()
+
+	yamlText := `
+name: web
+version: 1.10
+enabled: true
+aws:region: us-west-2
+db:
+  host: localhost
+  ports: [5432, 5433]
+  "user.name": admin
+  empty: {}
+  none: null
+`
+	jsonText := `{
+  "name": "web",
+  "version": 1.10,
+  "enabled": true,
+  "aws:region": "us-west-2",
+  "db": {
+    "host": "localhost",
+    "ports": [5432, 5433],
+    "user.name": "admin",
+    "empty": {},
+    "none": null
+  }
+}`
+
+	want := map[string]string{
+		"proj:name":            "web",
+		"proj:version":         "1.10",
+		"proj:enabled":         "true",
+		"aws:region":           "us-west-2",
+		"proj:db.host":         "localhost",
+		"proj:db.ports[0]":     "5432",
+		"proj:db.ports[1]":     "5433",
+		`proj:db["user.name"]`: "admin",
+	}
+
+	for format, text := range map[string]string{"yaml": yamlText, "json": jsonText} {
+		values, err := parseConfigImport([]byte(text), format, "proj")
+		require.NoError(t, err, format)
+
+		got := map[string]string{}
+		for _, v := range values {
+			got[v.key().String()] = v.value
+		}
+		assert.Equal(t, want, got, format)
+	}
+
+	_, err := parseConfigImport([]byte("[1, 2]"), "yaml", "proj")
+	assert.EqualError(t, err, "the file must contain an object")
+	_, err = parseConfigImport([]byte("A=1"), "toml", "proj")
+	assert.EqualError(t, err, `unknown format "toml"; the supported formats are dotenv, json and yaml`)
+}
+
+func TestDetectConfigImportFormat(t *testing.T) {
+	t.Parallel()
+
+	for file, want := range map[string]string{
+		".env":                "dotenv",
+		"dir/.env.production": "dotenv",
+		"prod.env":            "dotenv",
+		"settings.JSON":       "json",
+		"settings.yaml":       "yaml",
+		"settings.yml":        "yaml",
+	} {
+		format, err := detectConfigImportFormat(file)
+		require.NoError(t, err, file)
+		assert.Equal(t, want, format, file)
+	}
+
+	_, err := detectConfigImportFormat("settings.toml")
+	assert.EqualError(t, err,
+		`could not detect the format of "settings.toml"; use --format to set it to dotenv, json or yaml`)
+}
+
+func TestConfigImporter(t *testing.T) {
+	t.Parallel()
+
+	values, err := parseConfigImport([]byte(`
+apiToken: 7f3d9a2b4c8e1f6a0d5b9c3e7a1f4d8b
+DB_PASSWORD: hunter2
+db:
+  host: localhost
+  password: swordfish
+`), "yaml", "proj")
+	require.NoError(t, err)
+
+	t.Run("secret keys", func(t *testing.T) {
+		t.Parallel()
+
+		cfg := config.Map{}
+		imp := &configImporter{
+			secretKeys: []string{"*_password", "db.pass*"},
+			encrypter:  func() (config.Encrypter, error) { return config.Base64Crypter, nil },
+		}
+		require.NoError(t, imp.importConfig(context.Background(), cfg, values))
+		assert.Equal(t, 2, imp.secrets)
+
+		decrypted, err := cfg.Decrypt(config.Base64Crypter)
+		require.NoError(t, err)
+		assert.Equal(t, map[config.Key]string{
+			config.MustMakeKey("proj", "apiToken"):    "7f3d9a2b4c8e1f6a0d5b9c3e7a1f4d8b",
+			config.MustMakeKey("proj", "DB_PASSWORD"): "hunter2",
+			config.MustMakeKey("proj", "db"):          `{"host":"localhost","password":"swordfish"}`,
+		}, decrypted)
+		assert.True(t, cfg[config.MustMakeKey("proj", "DB_PASSWORD")].Secure())
+		assert.True(t, cfg[config.MustMakeKey("proj", "db")].Secure())
+		assert.False(t, cfg[config.MustMakeKey("proj", "apiToken")].Secure())
+
+		// The token looks like a secret, so we warn that it's stored in plaintext.
+		require.Len(t, imp.warnings, 1)
+		assert.Contains(t, imp.warnings[0], "the value of 'proj:apiToken' looks like a secret")
+	})
+
+	t.Run("detect secrets", func(t *testing.T) {
+		t.Parallel()
+
+		cfg := config.Map{}
+		imp := &configImporter{
+			detectSecrets: true,
+			encrypter:     func() (config.Encrypter, error) { return config.Base64Crypter, nil },
+		}
+		require.NoError(t, imp.importConfig(context.Background(), cfg, values))
+		assert.Equal(t, 1, imp.secrets)
+		assert.True(t, cfg[config.MustMakeKey("proj", "apiToken")].Secure())
+		assert.Empty(t, imp.warnings)
+	})
+
+	t.Run("no secrets", func(t *testing.T) {
+		t.Parallel()
+
+		cfg := config.Map{}
+		imp := &configImporter{
+			encrypter: func() (config.Encrypter, error) {
+				assert.Fail(t, "the encrypter should not be needed")
+				return nil, nil
+			},
+		}
+		require.NoError(t, imp.importConfig(context.Background(), cfg, []importedConfigValue{
+			{namespace: "proj", path: resource.PropertyPath{"region"}, value: "us-east-1"},
+		}))
+		assert.Equal(t, config.NewValue("us-east-1"), cfg[config.MustMakeKey("proj", "region")])
+	})
+
+	t.Run("invalid pattern", func(t *testing.T) {
+		t.Parallel()
+
+		imp := &configImporter{secretKeys: []string{"[a-"}}
+		err := imp.importConfig(context.Background(), config.Map{}, values)
+		assert.ErrorContains(t, err, `invalid --secret-keys pattern "[a-"`)
+	})
+}
+
+func TestPrintConfigFileDiff(t *testing.T) {
+	t.Parallel()
+
+	var out bytes.Buffer
+	require.NoError(t, printConfigFileDiff(&out, "Pulumi.dev.yaml",
+		"config:\n  proj:a: \"1\"\n",
+		"config:\n  proj:a: \"1\"\n  proj:b: \"2\"\n"))
+	assert.Equal(t, `--- Pulumi.dev.yaml
++++ Pulumi.dev.yaml (after import)
+@@ -1,2 +1,3 @@
+ config:
+   proj:a: "1"
++  proj:b: "2"
+`, out.String())
+
+	out.Reset()
+	require.NoError(t, printConfigFileDiff(&out, "Pulumi.dev.yaml", "config: {}\n", "config: {}\n"))
+	assert.Equal(t, "No changes would be made to Pulumi.dev.yaml\n", out.String())
+}
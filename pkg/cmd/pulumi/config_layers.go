@@ -0,0 +1,199 @@
+// Copyright 2016-2023, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/pulumi/pulumi/pkg/v3/backend"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/resource"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/resource/config"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/workspace"
+)
+
+// layeredConfig is a stack's configuration merged with the shared config files that it imports.
+type layeredConfig struct {
+	// config holds the merged values. Secret values are still encrypted by the secrets provider of the file they came
+	// from.
+	config config.Map
+	// projectName is the name of the stack's project, whose namespace keys in config files may be written without.
+	projectName string
+	// layers are the shared config files, in the order that the stack imports them.
+	layers []workspace.ConfigLayer
+	// origins maps the keys whose values come from shared config files to the files they came from.
+	origins map[config.Key]*workspace.ConfigLayer
+
+	// decrypters caches the decrypters of the shared config files, by their paths.
+	decrypters map[string]config.Decrypter
+}
+
+// newLayeredConfig merges the values of the shared config files that a stack imports, in order, under the stack's own
+// values. Values are merged by key, so a stack's value for a key replaces an imported one entirely, even if both are
+// objects.
+func newLayeredConfig(
+	project *workspace.Project,
+	stackPath string,
+	ps *workspace.ProjectStack,
+) (*layeredConfig, error) {
+	layers, err := workspace.LoadConfigLayers(project, stackPath, ps)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &layeredConfig{
+		config:      make(config.Map),
+		projectName: project.Name.String(),
+		layers:      layers,
+		origins:     make(map[config.Key]*workspace.ConfigLayer),
+		decrypters:  make(map[string]config.Decrypter),
+	}
+	for i := range c.layers {
+		for k, v := range c.layers[i].Stack.Config {
+			c.config[k] = v
+			c.origins[k] = &c.layers[i]
+		}
+	}
+	for k, v := range ps.Config {
+		c.config[k] = v
+		delete(c.origins, k)
+	}
+	return c, nil
+}
+
+// loadLayeredConfig merges a stack's configuration with the shared config files that its config file imports.
+func loadLayeredConfig(
+	project *workspace.Project,
+	stack backend.Stack,
+	ps *workspace.ProjectStack,
+) (*layeredConfig, error) {
+	// Only stacks that import files need the path of their config file, to find the files relative to it.
+	var stackPath string
+	if len(ps.Imports) > 0 {
+		var err error
+		if stackPath, err = getProjectStackPath(stack); err != nil {
+			return nil, err
+		}
+	}
+	return newLayeredConfig(project, stackPath, ps)
+}
+
+// loadConfigLayer loads one of the shared config files that a stack imports, which may be given either as the stack's
+// imports give it or as a path relative to the working directory. The file doesn't need to exist yet.
+func loadConfigLayer(
+	project *workspace.Project,
+	stack backend.Stack,
+	ps *workspace.ProjectStack,
+	name string,
+) (*workspace.ConfigLayer, error) {
+	stackPath, err := getProjectStackPath(stack)
+	if err != nil {
+		return nil, err
+	}
+
+	want, err := filepath.Abs(name)
+	if err != nil {
+		return nil, err
+	}
+	for _, imp := range ps.Imports {
+		path := workspace.ConfigLayerPath(stackPath, imp)
+		if abs, err := filepath.Abs(path); imp != name && (err != nil || abs != want) {
+			continue
+		}
+
+		layer, err := workspace.LoadProjectStack(project, path)
+		if err != nil {
+			return nil, fmt.Errorf("could not read the shared config file '%s': %w", imp, err)
+		}
+		return &workspace.ConfigLayer{Import: imp, Path: path, Stack: layer}, nil
+	}
+	return nil, fmt.Errorf("stack '%s' does not import '%s'; add it to the imports in %s first",
+		stack.Ref().Name(), name, filepath.Base(stackPath))
+}
+
+// topLevelConfigKey returns the key of the top-level value that a key refers to. If path is true, the key's name is
+// treated as a path, as it is by config.Map.Get, and may refer to a value within an object.
+func topLevelConfigKey(key config.Key, path bool) config.Key {
+	if path {
+		if p, err := resource.ParsePropertyPath(key.Name()); err == nil && len(p) > 0 {
+			if name, ok := p[0].(string); ok {
+				return config.MustMakeKey(key.Namespace(), name)
+			}
+		}
+	}
+	return key
+}
+
+// origin returns the shared config file that a key's value comes from, or nil if the value is the stack's own. If path
+// is true, the key's name is treated as a path.
+func (c *layeredConfig) origin(key config.Key, path bool) *workspace.ConfigLayer {
+	return c.origins[topLevelConfigKey(key, path)]
+}
+
+// originName returns how the origin of a key's value is shown to users: the path of the shared config file that it
+// comes from, as the stack imports it, or otherwise the name of the stack's own config file.
+func (c *layeredConfig) originName(key config.Key, stackPath string) string {
+	if layer := c.origin(key, false /*path*/); layer != nil {
+		return layer.Import
+	}
+	return filepath.Base(stackPath)
+}
+
+// layerDecrypter returns the decrypter for the secret values in a shared config file.
+func (c *layeredConfig) layerDecrypter(layer *workspace.ConfigLayer) (config.Decrypter, error) {
+	if dec, has := c.decrypters[layer.Path]; has {
+		return dec, nil
+	}
+
+	sm, err := getConfigLayerSecretsManager(layer)
+	if err != nil {
+		return nil, err
+	}
+	dec, err := sm.Decrypter()
+	if err != nil {
+		return nil, err
+	}
+	c.decrypters[layer.Path] = dec
+	return dec, nil
+}
+
+// reencrypt returns the merged values with the secret values from shared config files encrypted by the stack's
+// encrypter instead, so that the whole configuration can be decrypted by the stack's decrypter. The encrypter is only
+// created if there are any such values.
+func (c *layeredConfig) reencrypt(encrypter func() (config.Encrypter, error)) (config.Map, error) {
+	result := make(config.Map, len(c.config))
+	var enc config.Encrypter
+	for k, v := range c.config {
+		layer := c.origins[k]
+		if layer == nil || !v.Secure() {
+			result[k] = v
+			continue
+		}
+
+		dec, err := c.layerDecrypter(layer)
+		if err != nil {
+			return nil, fmt.Errorf("getting the decrypter of shared config file '%s': %w", layer.Import, err)
+		}
+		if enc == nil {
+			if enc, err = encrypter(); err != nil {
+				return nil, err
+			}
+		}
+		if result[k], err = v.Copy(dec, enc); err != nil {
+			return nil, fmt.Errorf("re-encrypting '%s' from shared config file '%s': %w", k, layer.Import, err)
+		}
+	}
+	return result, nil
+}
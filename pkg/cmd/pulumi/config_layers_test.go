@@ -0,0 +1,124 @@
+// Copyright 2016-2023, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/resource/config"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/workspace"
+)
+
+func TestLayeredConfig(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	secret, err := config.Base64Crypter.EncryptValue(ctx, "hunter2")
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	writeFile := func(name, content string) {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600))
+	}
+	writeFile("common.yaml", `secretsprovider: base64
+config:
+  region: us-east-1
+  zone: a
+  tags:
+    team: infra
+  password:
+    secure: `+secret+`
+`)
+	writeFile("us-west.yaml", `config:
+  region: us-west-2
+`)
+	writeFile("Pulumi.dev.yaml", `imports:
+  - common.yaml
+  - us-west.yaml
+config:
+  zone: b
+  tags:
+    owner: dev
+`)
+
+	project := &workspace.Project{Name: "proj"}
+	stackPath := filepath.Join(dir, "Pulumi.dev.yaml")
+	ps, err := workspace.LoadProjectStack(project, stackPath)
+	require.NoError(t, err)
+
+	layered, err := newLayeredConfig(project, stackPath, ps)
+	require.NoError(t, err)
+
+	key := func(name string) config.Key { return config.MustMakeKey("proj", name) }
+
+	// Later files override earlier ones, and the stack overrides them all, replacing objects entirely.
+	decrypted, err := layered.config.Decrypt(config.NewBlindingDecrypter())
+	require.NoError(t, err)
+	assert.Equal(t, map[config.Key]string{
+		key("region"):   "us-west-2",
+		key("zone"):     "b",
+		key("tags"):     `{"owner":"dev"}`,
+		key("password"): "[secret]",
+	}, decrypted)
+
+	assert.Equal(t, "us-west.yaml", layered.originName(key("region"), stackPath))
+	assert.Equal(t, "common.yaml", layered.originName(key("password"), stackPath))
+	assert.Equal(t, "Pulumi.dev.yaml", layered.originName(key("zone"), stackPath))
+	assert.Nil(t, layered.origin(key("tags.owner"), true /*path*/))
+	assert.Equal(t, "common.yaml", layered.origin(key("password"), true /*path*/).Import)
+
+	// Secrets from shared files are re-encrypted for the stack. We stand in for the shared file's secrets provider, so
+	// that its ciphertext can be told apart from the stack's.
+	layered.decrypters[layered.layers[0].Path] = config.Base64Crypter
+	stackCrypter := config.NewSymmetricCrypter(make([]byte, 32))
+	cfg, err := layered.reencrypt(func() (config.Encrypter, error) { return stackCrypter, nil })
+	require.NoError(t, err)
+
+	assert.True(t, cfg[key("password")].Secure())
+	plaintext, err := cfg[key("password")].Value(stackCrypter)
+	require.NoError(t, err)
+	assert.Equal(t, "hunter2", plaintext)
+	assert.Equal(t, layered.config[key("region")], cfg[key("region")])
+
+	// The stack's encrypter isn't needed if none of the imported values are secret.
+	delete(layered.config, key("password"))
+	_, err = layered.reencrypt(func() (config.Encrypter, error) {
+		assert.Fail(t, "the encrypter should not be needed")
+		return nil, nil
+	})
+	require.NoError(t, err)
+}
+
+func TestLayeredConfig_noImports(t *testing.T) {
+	t.Parallel()
+
+	ps := &workspace.ProjectStack{Config: config.Map{
+		config.MustMakeKey("proj", "a"): config.NewValue("1"),
+	}}
+	layered, err := newLayeredConfig(&workspace.Project{Name: "proj"}, "", ps)
+	require.NoError(t, err)
+	assert.Equal(t, ps.Config, layered.config)
+	assert.Empty(t, layered.origins)
+
+	// The merged values are a copy, so applying the project's config to them doesn't change the stack's.
+	layered.config[config.MustMakeKey("proj", "b")] = config.NewValue("2")
+	assert.Len(t, ps.Config, 1)
+}
@@ -15,6 +15,7 @@
 package main
 
 import (
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -46,3 +47,48 @@ func TestSecretDetection(t *testing.T) {
 	// The key name does not match the pattern, so even though this "looks like" a secret, we say it is not.
 	assert.False(t, looksLikeSecret(config.MustMakeKey("test", "okay"), "1415fc1f4eaeb5e096ee58c1480016638fff29bf"))
 }
+
+func TestFormatConfigProblem(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	projectPath := filepath.Join(dir, "Pulumi.yaml")
+	stackPath := filepath.Join(dir, "Pulumi.dev.yaml")
+
+	assert.Equal(t,
+		stackPath+":4: error: test:size must be one of 'small', 'large'",
+		formatConfigProblem(workspace.ConfigProblem{
+			Key:     "test:size",
+			Line:    4,
+			Message: "must be one of 'small', 'large'",
+		}, projectPath, stackPath, true))
+	assert.Equal(t,
+		projectPath+":7: error: test:region is required, but the stack has no value for it",
+		formatConfigProblem(workspace.ConfigProblem{
+			Key:       "test:region",
+			InProject: true,
+			Line:      7,
+			Message:   "is required, but the stack has no value for it",
+		}, projectPath, stackPath, true))
+	assert.Equal(t,
+		stackPath+": warning: test:typo is not declared in the project's configuration",
+		formatConfigProblem(workspace.ConfigProblem{
+			Key:     "test:typo",
+			Warning: true,
+			Message: "is not declared in the project's configuration",
+		}, projectPath, stackPath, true))
+	assert.Equal(t,
+		"error: test:size must be of type 'string'",
+		formatConfigProblem(workspace.ConfigProblem{
+			Key:     "test:size",
+			Message: "must be of type 'string'",
+		}, "", "", true))
+
+	assert.Equal(t,
+		stackPath+":4: test:size must be of type 'string'",
+		formatConfigProblem(workspace.ConfigProblem{
+			Key:     "test:size",
+			Line:    4,
+			Message: "must be of type 'string'",
+		}, projectPath, stackPath, false))
+}
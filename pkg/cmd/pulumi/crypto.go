@@ -21,7 +21,9 @@ import (
 	"github.com/pulumi/pulumi/pkg/v3/backend"
 	"github.com/pulumi/pulumi/pkg/v3/resource/stack"
 	"github.com/pulumi/pulumi/pkg/v3/secrets"
+	"github.com/pulumi/pulumi/pkg/v3/secrets/age"
 	"github.com/pulumi/pulumi/pkg/v3/secrets/cloud"
+	"github.com/pulumi/pulumi/pkg/v3/secrets/multi"
 	"github.com/pulumi/pulumi/pkg/v3/secrets/passphrase"
 	"github.com/pulumi/pulumi/sdk/v3/go/common/resource/config"
 	"github.com/pulumi/pulumi/sdk/v3/go/common/util/deepcopy"
@@ -59,7 +61,12 @@ func getStackSecretsManager(s backend.Stack, ps *workspace.ProjectStack) (secret
 
 	var sm secrets.Manager
 	var err error
-	if ps.SecretsProvider != passphrase.Type && ps.SecretsProvider != "default" && ps.SecretsProvider != "" {
+	if ps.SecretsProvider == multi.Type {
+		sm, err = multi.NewMultiSecretsManager(ps)
+	} else if age.IsAgeSecretsProvider(ps.SecretsProvider) {
+		sm, err = age.NewAgeSecretsManager(
+			ps, ps.SecretsProvider, false /* rotateSecretsProvider */)
+	} else if ps.SecretsProvider != passphrase.Type && ps.SecretsProvider != "default" && ps.SecretsProvider != "" {
 		sm, err = cloud.NewCloudSecretsManager(
 			ps, ps.SecretsProvider, false /* rotateSecretsProvider */)
 	} else if ps.EncryptionSalt != "" {
@@ -77,6 +84,32 @@ func getStackSecretsManager(s backend.Stack, ps *workspace.ProjectStack) (secret
 	return stack.NewCachingSecretsManager(sm), needsSave, nil
 }
 
+// getConfigLayerSecretsManager returns the secrets manager for the secret values in a shared config file. Shared config
+// files aren't part of any one stack, so they can't use a backend's default secrets provider and must name their own.
+func getConfigLayerSecretsManager(layer *workspace.ConfigLayer) (secrets.Manager, error) {
+	ps := layer.Stack
+
+	var sm secrets.Manager
+	var err error
+	switch {
+	case ps.SecretsProvider == multi.Type:
+		sm, err = multi.NewMultiSecretsManager(ps)
+	case age.IsAgeSecretsProvider(ps.SecretsProvider):
+		sm, err = age.NewAgeSecretsManager(ps, ps.SecretsProvider, false /* rotateSecretsProvider */)
+	case ps.SecretsProvider != passphrase.Type && ps.SecretsProvider != "default" && ps.SecretsProvider != "":
+		sm, err = cloud.NewCloudSecretsManager(ps, ps.SecretsProvider, false /* rotateSecretsProvider */)
+	case ps.SecretsProvider == passphrase.Type || ps.EncryptionSalt != "":
+		sm, err = passphrase.NewPromptingPassphraseSecretsManager(ps, false /* rotateSecretsProvider */)
+	default:
+		return nil, fmt.Errorf("the shared config file '%s' has no secrets provider; set its `secretsprovider` "+
+			"to passphrase, to age recipients or to the URL of a key, such as awskms://alias/shared-config", layer.Import)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return stack.NewCachingSecretsManager(sm), nil
+}
+
 func needsSaveProjectStackAfterSecretManger(stack backend.Stack,
 	old *workspace.ProjectStack, new *workspace.ProjectStack,
 ) bool {
@@ -94,8 +127,12 @@ func needsSaveProjectStackAfterSecretManger(stack backend.Stack,
 }
 
 func validateSecretsProvider(typ string) error {
+	if age.IsAgeSecretsProvider(typ) {
+		return age.ValidateSecretsProvider(typ)
+	}
+
 	kind := strings.SplitN(typ, ":", 2)[0]
-	supportedKinds := []string{"default", "passphrase", "awskms", "azurekeyvault", "gcpkms", "hashivault"}
+	supportedKinds := []string{"default", "passphrase", "awskms", "azurekeyvault", "gcpkms", "hashivault", "age"}
 	for _, supportedKind := range supportedKinds {
 		if kind == supportedKind {
 			return nil
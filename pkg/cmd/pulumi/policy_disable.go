@@ -32,7 +32,9 @@ func newPolicyDisableCmd() *cobra.Command {
 		Use:   "disable <org-name>/<policy-pack-name>",
 		Args:  cmdutil.ExactArgs(1),
 		Short: "Disable a Policy Pack for a Pulumi organization",
-		Long:  "Disable a Policy Pack for a Pulumi organization",
+		Long: "Disable a Policy Pack for a Pulumi organization\n" +
+			"\n" +
+			"When using a self-managed backend, specify the path to the local Policy Pack to stop enforcing.",
 		Run: cmdutil.RunFunc(func(cmd *cobra.Command, cliArgs []string) error {
 			ctx := commandContext()
 			// Obtain current PolicyPack, tied to the Pulumi Cloud backend.
@@ -39,7 +39,11 @@ func newPolicyEnableCmd() *cobra.Command {
 		Args:  cmdutil.ExactArgs(2),
 		Short: "Enable a Policy Pack for a Pulumi organization",
 		Long: "Enable a Policy Pack for a Pulumi organization. " +
-			"Can specify latest to enable the latest version of the Policy Pack or a specific version number.",
+			"Can specify latest to enable the latest version of the Policy Pack or a specific version number.\n" +
+			"\n" +
+			"When using a self-managed backend, specify the path to a local Policy Pack and latest instead. " +
+			"The Policy Pack is copied to the backend, and enforced for every stack in it, or if --policy-group " +
+			"names a stack, for that stack only. Enable it again to use changes made to it since.",
 		Run: cmdutil.RunFunc(func(cmd *cobra.Command, cliArgs []string) error {
 			ctx := commandContext()
 			// Obtain current PolicyPack, tied to the Pulumi Cloud backend.
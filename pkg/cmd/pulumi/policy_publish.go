@@ -22,7 +22,9 @@ import (
 
 	"github.com/pulumi/pulumi/pkg/v3/backend"
 	"github.com/pulumi/pulumi/pkg/v3/backend/display"
+	"github.com/pulumi/pulumi/pkg/v3/backend/filestate"
 	"github.com/pulumi/pulumi/pkg/v3/backend/httpstate"
+	"github.com/pulumi/pulumi/pkg/v3/backend/sqlstate"
 	"github.com/pulumi/pulumi/pkg/v3/engine"
 	"github.com/pulumi/pulumi/sdk/v3/go/common/resource/plugin"
 	"github.com/pulumi/pulumi/sdk/v3/go/common/util/cmdutil"
@@ -126,8 +128,16 @@ func requirePolicyPack(ctx context.Context, policyPack string) (backend.PolicyPa
 		Color: cmdutil.GetGlobalColorization(),
 	}
 
-	b, err := httpstate.NewLoginManager().Login(ctx, cmdutil.Diag(), cloudURL, project,
-		workspace.GetCloudInsecure(cloudURL), displayOptions)
+	var b backend.Backend
+	if filestate.IsFileStateBackendURL(cloudURL) {
+		// File state backends manage local Policy Packs, referred to by their path on disk.
+		b, err = filestate.New(ctx, cmdutil.Diag(), cloudURL, project)
+	} else if sqlstate.IsSQLStateBackendURL(cloudURL) {
+		b, err = sqlstate.New(ctx, cmdutil.Diag(), cloudURL, project)
+	} else {
+		b, err = httpstate.NewLoginManager().Login(ctx, cmdutil.Diag(), cloudURL, project,
+			workspace.GetCloudInsecure(cloudURL), displayOptions)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -181,6 +181,10 @@ func newPreviewCmd() *cobra.Command {
 				return result.FromError(fmt.Errorf("getting stack decrypter: %w", err))
 			}
 
+			if err := checkStackConfig(proj, s, cfg.Config, decrypter); err != nil {
+				return result.FromError(fmt.Errorf("validating stack config: %w", err))
+			}
+
 			stackName := s.Ref().Name().String()
 			configErr := workspace.ValidateStackConfigAndApplyProjectConfig(stackName, proj, cfg.Config, decrypter)
 			if configErr != nil {
@@ -414,7 +414,12 @@ func getCLIVersionInfo(ctx context.Context) (semver.Version, semver.Version, err
 		return latest, oldest, err
 	}
 
-	client := client.NewClient(httpstate.DefaultURL(), "", false, cmdutil.Diag())
+	// The current backend may be self-managed, in which case we ask the Pulumi Cloud instead.
+	cloudURL := httpstate.DefaultURL()
+	if isSelfManagedBackendURL(cloudURL) {
+		cloudURL = httpstate.PulumiCloudURL
+	}
+	client := client.NewClient(cloudURL, "", false, cmdutil.Diag())
 	latest, oldest, err = client.GetCLIVersionInfo(ctx)
 	if err != nil {
 		return semver.Version{}, semver.Version{}, err
@@ -165,7 +165,7 @@ func loadEvents(path string) ([]engine.Event, error) {
 	}
 	defer contract.IgnoreClose(f)
 
-	var events []engine.Event
+	var jsonEvents []apitype.EngineEvent
 	dec := json.NewDecoder(f)
 	for {
 		var jsonEvent apitype.EngineEvent
@@ -175,7 +175,16 @@ func loadEvents(path string) ([]engine.Event, error) {
 			}
 			return nil, fmt.Errorf("decoding event: %w", err)
 		}
+		jsonEvents = append(jsonEvents, jsonEvent)
+	}
+
+	return convertJSONEvents(jsonEvents)
+}
 
+// convertJSONEvents converts recorded engine events back into the events that the display renders.
+func convertJSONEvents(jsonEvents []apitype.EngineEvent) ([]engine.Event, error) {
+	events := make([]engine.Event, 0, len(jsonEvents)+1)
+	for _, jsonEvent := range jsonEvents {
 		event, err := display.ConvertJSONEvent(jsonEvent)
 		if err != nil {
 			return nil, fmt.Errorf("decoding event: %w", err)
@@ -21,6 +21,7 @@ import (
 
 	"github.com/pulumi/pulumi/pkg/v3/backend"
 	"github.com/pulumi/pulumi/pkg/v3/backend/display"
+	"github.com/pulumi/pulumi/pkg/v3/backend/filestate"
 	"github.com/pulumi/pulumi/pkg/v3/resource/stack"
 	"github.com/pulumi/pulumi/sdk/v3/go/common/apitype"
 	"github.com/pulumi/pulumi/sdk/v3/go/common/resource/config"
@@ -37,7 +38,8 @@ func newStackChangeSecretsProviderCmd() *cobra.Command {
 		Args:  cmdutil.ExactArgs(1),
 		Short: "Change the secrets provider for a stack",
 		Long: "Change the secrets provider for a stack. " +
-			"Valid secret providers types are `default`, `passphrase`, `awskms`, `azurekeyvault`, `gcpkms`, `hashivault`.\n\n" +
+			"Valid secret providers types are `default`, `passphrase`, `awskms`, `azurekeyvault`, `gcpkms`, `hashivault`,\n" +
+			"`age`.\n\n" +
 			"To change to using the Pulumi Default Secrets Provider, use the following:\n" +
 			"\n" +
 			"pulumi stack change-secrets-provider default" +
@@ -53,7 +55,11 @@ func newStackChangeSecretsProviderCmd() *cobra.Command {
 			"\"azurekeyvault://mykeyvaultname.vault.azure.net/keys/mykeyname\"`\n" +
 			"* `pulumi stack change-secrets-provider " +
 			"\"gcpkms://projects/<p>/locations/<l>/keyRings/<r>/cryptoKeys/<k>\"`\n" +
-			"* `pulumi stack change-secrets-provider \"hashivault://mykey\"`",
+			"* `pulumi stack change-secrets-provider \"hashivault://mykey\"`\n" +
+			"\n" +
+			"To change the stack to encrypt secrets to one or more age recipients, use:\n" +
+			"\n" +
+			"* `pulumi stack change-secrets-provider \"age://age1...,age1...\"`",
 		Run: cmdutil.RunFunc(func(cmd *cobra.Command, args []string) error {
 			ctx := commandContext()
 			opts := display.Options{
@@ -187,5 +193,13 @@ func migrateOldConfigAndCheckpointToNewSecretsProvider(ctx context.Context,
 	}
 
 	// Import the newly changes Deployment
-	return currentStack.ImportDeployment(ctx, &dep)
+	if err := currentStack.ImportDeployment(ctx, &dep); err != nil {
+		return err
+	}
+
+	// Self-managed backends may encrypt the history and backups of the stack too.
+	if fb, ok := currentStack.Backend().(filestate.Backend); ok {
+		return fb.ReencryptStack(ctx, currentStack.Ref())
+	}
+	return nil
 }
@@ -15,38 +15,28 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"sort"
 	"strings"
 
+	"github.com/pulumi/pulumi/pkg/v3/backend"
 	"github.com/pulumi/pulumi/pkg/v3/backend/display"
 	"github.com/pulumi/pulumi/pkg/v3/graph"
 	"github.com/pulumi/pulumi/pkg/v3/graph/dotconv"
-	"github.com/pulumi/pulumi/pkg/v3/resource/deploy"
+	"github.com/pulumi/pulumi/pkg/v3/graph/mermaidconv"
 	"github.com/pulumi/pulumi/pkg/v3/resource/stack"
 	"github.com/pulumi/pulumi/sdk/v3/go/common/resource"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/tokens"
 	"github.com/pulumi/pulumi/sdk/v3/go/common/util/cmdutil"
 	"github.com/spf13/cobra"
 )
 
-// Whether or not we should ignore parent edges when building up our graph.
-var ignoreParentEdges bool
-
-// Whether or not we should ignore dependency edges when building up our graph.
-var ignoreDependencyEdges bool
-
-// The color of dependency edges in the graph. Defaults to #246C60, a blush-green.
-var dependencyEdgeColor string
-
-// The color of parent edges in the graph. Defaults to #AA6639, an orange.
-var parentEdgeColor string
-
-// Whether or not to return resource name as the node label for each node of the graph.
-var shortNodeName bool
-
 func newStackGraphCmd() *cobra.Command {
-	var stackName string
-
+	var sgcmd stackGraphCmd
 	cmd := &cobra.Command{
 		Use:   "graph [filename]",
 		Args:  cmdutil.ExactArgs(1),
@@ -54,62 +44,280 @@ func newStackGraphCmd() *cobra.Command {
 		Long: "Export a stack's dependency graph to a file.\n" +
 			"\n" +
 			"This command can be used to view the dependency graph that a Pulumi program\n" +
-			"emitted when it was run. This graph is output in the DOT format. This command operates\n" +
-			"on your stack's most recent deployment.",
+			"emitted when it was run. This command operates on your stack's most recent deployment.\n" +
+			"If the filename is '-', the graph is written to standard output.\n" +
+			"\n" +
+			"Use --format to choose how the graph is written:\n" +
+			"\n" +
+			"  - dot: the Graphviz DOT format (the default)\n" +
+			"  - mermaid: a Mermaid flowchart, which can be embedded in Markdown\n" +
+			"  - json: an object with a list of nodes and a list of edges\n" +
+			"\n" +
+			"In every format, dependency edges point from a resource to the resources that depend on it,\n" +
+			"and parent edges point from a child resource to its parent.\n" +
+			"\n" +
+			"Large graphs can be narrowed down with filters. --subtree selects a resource and all of its\n" +
+			"descendants. --dependents-of and --dependencies-of select a resource and the resources that\n" +
+			"depend on it, or that it depends on, following the edges that are in the graph, for as many\n" +
+			"steps as --depth allows. --type selects only resources of the given types, and is applied after\n" +
+			"the other filters. For example, to see what would be affected by deleting a resource:\n" +
+			"\n" +
+			"    $ pulumi stack graph --dependents-of <urn> --format mermaid -",
 		Run: cmdutil.RunFunc(func(cmd *cobra.Command, args []string) error {
-			ctx := commandContext()
-			opts := display.Options{
-				Color: cmdutil.GetGlobalColorization(),
+			return sgcmd.Run(commandContext(), args)
+		}),
+	}
+	cmd.PersistentFlags().StringVarP(
+		&sgcmd.stackName, "stack", "s", "", "The name of the stack to operate on. Defaults to the current stack")
+	cmd.PersistentFlags().StringVar(&sgcmd.format, "format", "dot",
+		"The format to write the graph in: dot, mermaid or json")
+	cmd.PersistentFlags().BoolVar(&sgcmd.ignoreParentEdges, "ignore-parent-edges", false,
+		"Ignores edges introduced by parent/child resource relationships")
+	cmd.PersistentFlags().BoolVar(&sgcmd.ignoreDependencyEdges, "ignore-dependency-edges", false,
+		"Ignores edges introduced by dependency resource relationships")
+	cmd.PersistentFlags().StringVar(&sgcmd.dependencyEdgeColor, "dependency-edge-color", "#246C60",
+		"Sets the color of dependency edges in the graph")
+	cmd.PersistentFlags().StringVar(&sgcmd.parentEdgeColor, "parent-edge-color", "#AA6639",
+		"Sets the color of parent edges in the graph")
+	cmd.PersistentFlags().BoolVar(&sgcmd.shortNodeName, "short-node-name", false,
+		"Sets the resource name as the node label for each node of the graph")
+	cmd.PersistentFlags().StringVar(&sgcmd.subtree, "subtree", "",
+		"Only include the resource with the given URN and its descendants")
+	cmd.PersistentFlags().StringVar(&sgcmd.dependentsOf, "dependents-of", "",
+		"Only include the resource with the given URN and the resources that depend on it")
+	cmd.PersistentFlags().StringVar(&sgcmd.dependenciesOf, "dependencies-of", "",
+		"Only include the resource with the given URN and the resources it depends on")
+	cmd.PersistentFlags().IntVar(&sgcmd.depth, "depth", 0,
+		"The number of edges to follow from --dependents-of and --dependencies-of. Defaults to no limit")
+	cmd.PersistentFlags().StringArrayVar(&sgcmd.types, "type", nil,
+		"Only include resources of the given type. May be given more than once")
+	return cmd
+}
+
+type stackGraphCmd struct {
+	dependencyGraphOptions
+
+	stackName      string
+	format         string
+	subtree        string
+	dependentsOf   string
+	dependenciesOf string
+	depth          int
+	types          []string
+
+	// requireStack is a reference to the top-level requireStack function.
+	// This is a field on stackGraphCmd so that we can replace it
+	// from tests.
+	requireStack func(ctx context.Context, name string, lopt stackLoadOption, opts display.Options) (backend.Stack, error)
+
+	Stdout io.Writer // defaults to os.Stdout
+	Stderr io.Writer // defaults to os.Stderr
+}
+
+func (cmd *stackGraphCmd) Run(ctx context.Context, args []string) error {
+	opts := display.Options{
+		Color: cmdutil.GetGlobalColorization(),
+	}
+
+	requireStack := requireStack
+	if cmd.requireStack != nil {
+		requireStack = cmd.requireStack
+	}
+
+	stdout := io.Writer(os.Stdout)
+	if cmd.Stdout != nil {
+		stdout = cmd.Stdout
+	}
+	stderr := io.Writer(os.Stderr)
+	if cmd.Stderr != nil {
+		stderr = cmd.Stderr
+	}
+
+	var printGraph func(dg *dependencyGraph, w io.Writer) error
+	switch cmd.format {
+	case "", "dot":
+		printGraph = func(dg *dependencyGraph, w io.Writer) error { return dotconv.Print(dg, w) }
+	case "mermaid":
+		printGraph = func(dg *dependencyGraph, w io.Writer) error { return mermaidconv.Print(dg, w) }
+	case "json":
+		printGraph = func(dg *dependencyGraph, w io.Writer) error { return fprintJSON(w, dg.toJSON()) }
+	default:
+		return fmt.Errorf("unknown graph format %q; the supported formats are dot, mermaid and json", cmd.format)
+	}
+	if cmd.depth < 0 {
+		return errors.New("--depth must not be negative")
+	}
+	if cmd.depth > 0 && cmd.dependentsOf == "" && cmd.dependenciesOf == "" {
+		return errors.New("--depth may only be used with --dependents-of or --dependencies-of")
+	}
+
+	s, err := requireStack(ctx, cmd.stackName, stackLoadOnly, opts)
+	if err != nil {
+		return err
+	}
+	snap, err := s.Snapshot(ctx, stack.DefaultSecretsProvider)
+	if err != nil {
+		return err
+	}
+
+	// This will prevent a panic when trying to assemble a dependencyGraph when no snapshot is found
+	if snap == nil {
+		return fmt.Errorf("unable to find snapshot for stack %q", cmd.stackName)
+	}
+
+	resources, err := cmd.selectResources(snap.Resources)
+	if err != nil {
+		return err
+	}
+	dg := makeDependencyGraph(resources, cmd.dependencyGraphOptions)
+
+	if args[0] == "-" {
+		return printGraph(dg, stdout)
+	}
+
+	file, err := os.Create(args[0])
+	if err != nil {
+		return err
+	}
+	if err := printGraph(dg, file); err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	fmt.Fprintf(stderr, "%sWrote stack dependency graph to `%s`\n", cmdutil.EmojiOr("🔍 ", ""), args[0])
+	return file.Close()
+}
+
+// selectResources applies the command's filters to the resources of a snapshot, returning the resources that should
+// be in the graph in the order they appear in the snapshot.
+func (cmd *stackGraphCmd) selectResources(resources []*resource.State) ([]*resource.State, error) {
+	byURN := make(map[resource.URN]*resource.State, len(resources))
+	children := make(map[resource.URN][]resource.URN)
+	dependents := make(map[resource.URN][]resource.URN)
+	for _, res := range resources {
+		byURN[res.URN] = res
+		if res.Parent != "" {
+			children[res.Parent] = append(children[res.Parent], res.URN)
+		}
+		for _, dep := range res.Dependencies {
+			dependents[dep] = append(dependents[dep], res.URN)
+		}
+	}
+
+	for _, f := range []struct{ flag, urn string }{
+		{"subtree", cmd.subtree},
+		{"dependents-of", cmd.dependentsOf},
+		{"dependencies-of", cmd.dependenciesOf},
+	} {
+		if _, has := byURN[resource.URN(f.urn)]; f.urn != "" && !has {
+			return nil, fmt.Errorf("the resource %q given to --%s is not in the stack", f.urn, f.flag)
+		}
+	}
+
+	// selected is nil until a filter has been applied, meaning that every resource is selected.
+	var selected map[resource.URN]bool
+	restrict := func(urns map[resource.URN]bool) {
+		if selected == nil {
+			selected = urns
+			return
+		}
+		for urn := range selected {
+			if !urns[urn] {
+				delete(selected, urn)
 			}
+		}
+	}
+
+	if cmd.subtree != "" {
+		restrict(walkGraph(resource.URN(cmd.subtree), 0, func(urn resource.URN) []resource.URN {
+			return children[urn]
+		}))
+	}
 
-			s, err := requireStack(ctx, stackName, stackLoadOnly, opts)
-			if err != nil {
-				return err
+	if cmd.dependentsOf != "" || cmd.dependenciesOf != "" {
+		related := make(map[resource.URN]bool)
+		if cmd.dependentsOf != "" {
+			for urn := range walkGraph(resource.URN(cmd.dependentsOf), cmd.depth, func(urn resource.URN) []resource.URN {
+				var next []resource.URN
+				if !cmd.ignoreDependencyEdges {
+					next = append(next, dependents[urn]...)
+				}
+				if !cmd.ignoreParentEdges {
+					next = append(next, children[urn]...)
+				}
+				return next
+			}) {
+				related[urn] = true
 			}
-			snap, err := s.Snapshot(ctx, stack.DefaultSecretsProvider)
-			if err != nil {
-				return err
+		}
+		if cmd.dependenciesOf != "" {
+			for urn := range walkGraph(resource.URN(cmd.dependenciesOf), cmd.depth, func(urn resource.URN) []resource.URN {
+				var next []resource.URN
+				if res, has := byURN[urn]; has {
+					if !cmd.ignoreDependencyEdges {
+						next = append(next, res.Dependencies...)
+					}
+					if !cmd.ignoreParentEdges && res.Parent != "" {
+						next = append(next, res.Parent)
+					}
+				}
+				return next
+			}) {
+				related[urn] = true
 			}
+		}
+		restrict(related)
+	}
 
-			// This will prevent a panic when trying to assemble a dependencyGraph when no snapshot is found
-			if snap == nil {
-				return fmt.Errorf("unable to find snapshot for stack %q", stackName)
+	if len(cmd.types) > 0 {
+		types := make(map[tokens.Type]bool, len(cmd.types))
+		for _, t := range cmd.types {
+			types[tokens.Type(t)] = true
+		}
+		ofType := make(map[resource.URN]bool)
+		for _, res := range resources {
+			if types[res.Type] {
+				ofType[res.URN] = true
 			}
+		}
+		restrict(ofType)
+	}
 
-			dg := makeDependencyGraph(snap)
-			file, err := os.Create(args[0])
-			if err != nil {
-				return err
-			}
+	if selected == nil {
+		return resources, nil
+	}
+	result := make([]*resource.State, 0, len(selected))
+	for _, res := range resources {
+		if selected[res.URN] {
+			result = append(result, res)
+		}
+	}
+	return result, nil
+}
 
-			if err := dotconv.Print(dg, file); err != nil {
-				_ = file.Close()
-				return err
+// walkGraph returns the URNs reachable from start by following next at most depth times, or any number of times if
+// depth is zero. The result includes start.
+func walkGraph(start resource.URN, depth int, next func(resource.URN) []resource.URN) map[resource.URN]bool {
+	seen := map[resource.URN]bool{start: true}
+	frontier := []resource.URN{start}
+	for step := 0; len(frontier) > 0 && (depth == 0 || step < depth); step++ {
+		var nextFrontier []resource.URN
+		for _, urn := range frontier {
+			for _, n := range next(urn) {
+				if !seen[n] {
+					seen[n] = true
+					nextFrontier = append(nextFrontier, n)
+				}
 			}
-
-			cmd.Printf("%sWrote stack dependency graph to `%s`", cmdutil.EmojiOr("🔍 ", ""), args[0])
-			cmd.Println()
-			return file.Close()
-		}),
+		}
+		frontier = nextFrontier
 	}
-	cmd.PersistentFlags().StringVarP(
-		&stackName, "stack", "s", "", "The name of the stack to operate on. Defaults to the current stack")
-	cmd.PersistentFlags().BoolVar(&ignoreParentEdges, "ignore-parent-edges", false,
-		"Ignores edges introduced by parent/child resource relationships")
-	cmd.PersistentFlags().BoolVar(&ignoreDependencyEdges, "ignore-dependency-edges", false,
-		"Ignores edges introduced by dependency resource relationships")
-	cmd.PersistentFlags().StringVar(&dependencyEdgeColor, "dependency-edge-color", "#246C60",
-		"Sets the color of dependency edges in the graph")
-	cmd.PersistentFlags().StringVar(&parentEdgeColor, "parent-edge-color", "#AA6639",
-		"Sets the color of parent edges in the graph")
-	cmd.PersistentFlags().BoolVar(&shortNodeName, "short-node-name", false,
-		"Sets the resource name as the node label for each node of the graph")
-	return cmd
+	return seen
 }
 
 // All of the types and code within this file are to provide implementations of the interfaces
-// in the `graph` package, so that we can use the `dotconv` package to output our graph in the
-// DOT format.
+// in the `graph` package, so that we can use the `dotconv` and `mermaidconv` packages to output
+// our graph in the DOT and Mermaid formats.
 //
 // `dependencyEdge` implements graph.Edge, `dependencyVertex` implements graph.Vertex, and
 // `dependencyGraph` implements `graph.Graph`.
@@ -137,7 +345,7 @@ func (edge *dependencyEdge) From() graph.Vertex {
 }
 
 func (edge *dependencyEdge) Color() string {
-	return dependencyEdgeColor
+	return edge.to.graph.opts.dependencyEdgeColor
 }
 
 // parentEdges represent edges in the parent-child graph, which
@@ -166,7 +374,7 @@ func (edge *parentEdge) From() graph.Vertex {
 }
 
 func (edge *parentEdge) Color() string {
-	return parentEdgeColor
+	return edge.from.graph.opts.parentEdgeColor
 }
 
 // A dependencyVertex contains a reference to the graph to which it belongs
@@ -184,7 +392,7 @@ func (vertex *dependencyVertex) Data() interface{} {
 }
 
 func (vertex *dependencyVertex) Label() string {
-	if shortNodeName {
+	if vertex.graph.opts.shortNodeName {
 		return string(vertex.resource.URN.Name())
 	}
 	return string(vertex.resource.URN)
@@ -201,17 +409,34 @@ func (vertex *dependencyVertex) Outs() []graph.Edge {
 	return vertex.outgoingEdges
 }
 
+// dependencyGraphOptions control which edges are in a dependencyGraph, and how it's displayed.
+type dependencyGraphOptions struct {
+	// Whether or not we should ignore parent edges when building up our graph.
+	ignoreParentEdges bool
+	// Whether or not we should ignore dependency edges when building up our graph.
+	ignoreDependencyEdges bool
+	// The color of dependency edges in the graph. Defaults to #246C60, a blush-green.
+	dependencyEdgeColor string
+	// The color of parent edges in the graph. Defaults to #AA6639, an orange.
+	parentEdgeColor string
+	// Whether or not to return resource name as the node label for each node of the graph.
+	shortNodeName bool
+}
+
 // A dependencyGraph is a thin wrapper around a map of URNs to vertices in
-// the graph. It is constructed directly from a snapshot.
+// the graph. It is constructed directly from the resources of a snapshot.
 type dependencyGraph struct {
 	vertices map[resource.URN]*dependencyVertex
+	// The vertices in the order of the resources they were made from, so that the graph prints deterministically.
+	order []*dependencyVertex
+	opts  dependencyGraphOptions
 }
 
 // Roots are edges that point to the root set of our graph. In our case,
 // for simplicity, we define the root set of our dependency graph to be everything.
 func (dg *dependencyGraph) Roots() []graph.Edge {
 	rootEdges := []graph.Edge{}
-	for _, vertex := range dg.vertices {
+	for _, vertex := range dg.order {
 		edge := &dependencyEdge{
 			to:   vertex,
 			from: nil,
@@ -223,24 +448,26 @@ func (dg *dependencyGraph) Roots() []graph.Edge {
 	return rootEdges
 }
 
-// Makes a dependency graph from a deployment snapshot, allocating a vertex
-// for every resource in the graph.
-func makeDependencyGraph(snapshot *deploy.Snapshot) *dependencyGraph {
+// Makes a dependency graph from the resources of a deployment snapshot, allocating a vertex
+// for every resource. Edges to resources that aren't in the list are left out.
+func makeDependencyGraph(resources []*resource.State, opts dependencyGraphOptions) *dependencyGraph {
 	dg := &dependencyGraph{
 		vertices: make(map[resource.URN]*dependencyVertex),
+		opts:     opts,
 	}
 
-	for _, resource := range snapshot.Resources {
+	for _, resource := range resources {
 		vertex := &dependencyVertex{
 			graph:    dg,
 			resource: resource,
 		}
 
 		dg.vertices[resource.URN] = vertex
+		dg.order = append(dg.order, vertex)
 	}
 
-	for _, vertex := range dg.vertices {
-		if !ignoreDependencyEdges {
+	for _, vertex := range dg.order {
+		if !opts.ignoreDependencyEdges {
 			// If we have per-property dependency information, annotate the dependency edges
 			// we generate with the names of the properties associated with each dependency.
 			depBlame := make(map[resource.URN][]string)
@@ -249,11 +476,17 @@ func makeDependencyGraph(snapshot *deploy.Snapshot) *dependencyGraph {
 					depBlame[dep] = append(depBlame[dep], string(k))
 				}
 			}
+			for _, props := range depBlame {
+				sort.Strings(props)
+			}
 
 			// Incoming edges are directly stored within the checkpoint file; they represent
 			// resources on which this vertex immediately depends upon.
 			for _, dep := range vertex.resource.Dependencies {
-				vertexWeDependOn := vertex.graph.vertices[dep]
+				vertexWeDependOn, has := dg.vertices[dep]
+				if !has {
+					continue
+				}
 				edge := &dependencyEdge{to: vertex, from: vertexWeDependOn, labels: depBlame[dep]}
 				vertex.incomingEdges = append(vertex.incomingEdges, edge)
 				vertexWeDependOn.outgoingEdges = append(vertexWeDependOn.outgoingEdges, edge)
@@ -263,9 +496,8 @@ func makeDependencyGraph(snapshot *deploy.Snapshot) *dependencyGraph {
 		// alongside the dependency graph sits the resource parentage graph, which
 		// is also displayed as part of this graph, although with different colored
 		// edges.
-		if !ignoreParentEdges {
-			if parent := vertex.resource.Parent; parent != resource.URN("") {
-				parentVertex := dg.vertices[parent]
+		if !opts.ignoreParentEdges {
+			if parentVertex, has := dg.vertices[vertex.resource.Parent]; has {
 				vertex.outgoingEdges = append(vertex.outgoingEdges, &parentEdge{
 					to:   parentVertex,
 					from: vertex,
@@ -276,3 +508,59 @@ func makeDependencyGraph(snapshot *deploy.Snapshot) *dependencyGraph {
 
 	return dg
 }
+
+// dependencyGraphJSON is the JSON form of a dependencyGraph, written by `pulumi stack graph --format json`.
+type dependencyGraphJSON struct {
+	Nodes []dependencyNodeJSON `json:"nodes"`
+	Edges []dependencyEdgeJSON `json:"edges"`
+}
+
+type dependencyNodeJSON struct {
+	URN    resource.URN `json:"urn"`
+	Type   tokens.Type  `json:"type"`
+	Name   string       `json:"name"`
+	Parent resource.URN `json:"parent,omitempty"`
+}
+
+type dependencyEdgeJSON struct {
+	From resource.URN `json:"from"`
+	To   resource.URN `json:"to"`
+	// Kind is either "dependency" or "parent".
+	Kind string `json:"kind"`
+	// Properties are the names of the properties of the dependent resource that depend on the other resource.
+	Properties []string `json:"properties,omitempty"`
+}
+
+func (dg *dependencyGraph) toJSON() dependencyGraphJSON {
+	result := dependencyGraphJSON{
+		Nodes: []dependencyNodeJSON{},
+		Edges: []dependencyEdgeJSON{},
+	}
+	for _, vertex := range dg.order {
+		res := vertex.resource
+		node := dependencyNodeJSON{URN: res.URN, Type: res.Type, Name: string(res.URN.Name())}
+		if _, has := dg.vertices[res.Parent]; has {
+			node.Parent = res.Parent
+		}
+		result.Nodes = append(result.Nodes, node)
+
+		for _, out := range vertex.outgoingEdges {
+			switch edge := out.(type) {
+			case *dependencyEdge:
+				result.Edges = append(result.Edges, dependencyEdgeJSON{
+					From:       edge.from.resource.URN,
+					To:         edge.to.resource.URN,
+					Kind:       "dependency",
+					Properties: edge.labels,
+				})
+			case *parentEdge:
+				result.Edges = append(result.Edges, dependencyEdgeJSON{
+					From: edge.from.resource.URN,
+					To:   edge.to.resource.URN,
+					Kind: "parent",
+				})
+			}
+		}
+	}
+	return result
+}
@@ -0,0 +1,318 @@
+// Copyright 2016-2023, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	"github.com/pulumi/pulumi/pkg/v3/backend"
+	"github.com/pulumi/pulumi/pkg/v3/backend/display"
+	"github.com/pulumi/pulumi/pkg/v3/resource/deploy"
+	"github.com/pulumi/pulumi/pkg/v3/secrets"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/resource"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/tokens"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newStackGraphTestResources returns the resources of a small stack:
+//
+//	stack
+//	├── comp
+//	│   ├── bucket
+//	│   └── object (depends on bucket)
+//	└── policy (depends on object)
+func newStackGraphTestResources() (map[string]resource.URN, []*resource.State) {
+	urn := func(parent tokens.Type, t tokens.Type, name string) resource.URN {
+		return resource.NewURN("dev", "proj", parent, t, tokens.QName(name))
+	}
+	stackURN := urn("", resource.RootStackType, "stack")
+	compURN := urn("", "my:index:Component", "comp")
+	bucketURN := urn("my:index:Component", "aws:s3/bucket:Bucket", "bucket")
+	objectURN := urn("my:index:Component", "aws:s3/bucketObject:BucketObject", "object")
+	policyURN := urn("", "aws:s3/bucketPolicy:BucketPolicy", "policy")
+
+	urns := map[string]resource.URN{
+		"stack":  stackURN,
+		"comp":   compURN,
+		"bucket": bucketURN,
+		"object": objectURN,
+		"policy": policyURN,
+	}
+	return urns, []*resource.State{
+		{URN: stackURN, Type: resource.RootStackType},
+		{URN: compURN, Type: "my:index:Component", Parent: stackURN},
+		{URN: bucketURN, Type: "aws:s3/bucket:Bucket", Parent: compURN, Custom: true},
+		{
+			URN:          objectURN,
+			Type:         "aws:s3/bucketObject:BucketObject",
+			Parent:       compURN,
+			Custom:       true,
+			Dependencies: []resource.URN{bucketURN},
+			PropertyDependencies: map[resource.PropertyKey][]resource.URN{
+				"bucket": {bucketURN},
+				"acl":    {bucketURN},
+			},
+		},
+		{
+			URN:          policyURN,
+			Type:         "aws:s3/bucketPolicy:BucketPolicy",
+			Parent:       stackURN,
+			Custom:       true,
+			Dependencies: []resource.URN{objectURN},
+		},
+	}
+}
+
+func newStackGraphTestCmd(resources []*resource.State, stdout *bytes.Buffer) *stackGraphCmd {
+	return &stackGraphCmd{
+		dependencyGraphOptions: dependencyGraphOptions{
+			dependencyEdgeColor: "#246C60",
+			parentEdgeColor:     "#AA6639",
+		},
+		format: "dot",
+		requireStack: func(context.Context, string, stackLoadOption, display.Options) (backend.Stack, error) {
+			return &backend.MockStack{
+				SnapshotF: func(_ context.Context, _ secrets.Provider) (*deploy.Snapshot, error) {
+					return &deploy.Snapshot{Resources: resources}, nil
+				},
+			}, nil
+		},
+		Stdout: stdout,
+		Stderr: &bytes.Buffer{},
+	}
+}
+
+func TestStackGraphCmd_mermaid(t *testing.T) {
+	t.Parallel()
+
+	_, resources := newStackGraphTestResources()
+	var stdout bytes.Buffer
+	cmd := newStackGraphTestCmd(resources[2:4], &stdout)
+	cmd.format = "mermaid"
+	cmd.shortNodeName = true
+	require.NoError(t, cmd.Run(context.Background(), []string{"-"}))
+
+	assert.Equal(t, `flowchart LR
+    Resource0["bucket"]
+    Resource0 -->|"acl, bucket"| Resource1
+    Resource1["object"]
+    linkStyle 0 stroke:#246C60
+`, stdout.String())
+}
+
+func TestStackGraphCmd_json(t *testing.T) {
+	t.Parallel()
+
+	urns, resources := newStackGraphTestResources()
+	var stdout bytes.Buffer
+	cmd := newStackGraphTestCmd(resources, &stdout)
+	cmd.format = "json"
+	require.NoError(t, cmd.Run(context.Background(), []string{"-"}))
+
+	var graph dependencyGraphJSON
+	require.NoError(t, json.Unmarshal(stdout.Bytes(), &graph))
+	require.Len(t, graph.Nodes, 5)
+	assert.Equal(t, dependencyNodeJSON{
+		URN:    urns["object"],
+		Type:   "aws:s3/bucketObject:BucketObject",
+		Name:   "object",
+		Parent: urns["comp"],
+	}, graph.Nodes[3])
+	assert.Equal(t, []dependencyEdgeJSON{
+		{From: urns["comp"], To: urns["stack"], Kind: "parent"},
+		{From: urns["bucket"], To: urns["comp"], Kind: "parent"},
+		{From: urns["bucket"], To: urns["object"], Kind: "dependency", Properties: []string{"acl", "bucket"}},
+		{From: urns["object"], To: urns["comp"], Kind: "parent"},
+		{From: urns["object"], To: urns["policy"], Kind: "dependency"},
+		{From: urns["policy"], To: urns["stack"], Kind: "parent"},
+	}, graph.Edges)
+}
+
+func TestStackGraphCmd_file(t *testing.T) {
+	t.Parallel()
+
+	_, resources := newStackGraphTestResources()
+	var stdout bytes.Buffer
+	cmd := newStackGraphTestCmd(resources, &stdout)
+	path := filepath.Join(t.TempDir(), "graph.dot")
+	require.NoError(t, cmd.Run(context.Background(), []string{path}))
+
+	assert.Empty(t, stdout.String())
+	assert.FileExists(t, path)
+}
+
+func TestStackGraphCmd_filters(t *testing.T) {
+	t.Parallel()
+
+	urns, resources := newStackGraphTestResources()
+
+	tests := []struct {
+		desc      string
+		configure func(cmd *stackGraphCmd)
+		want      []string
+	}{
+		{
+			desc:      "no filters",
+			configure: func(cmd *stackGraphCmd) {},
+			want:      []string{"stack", "comp", "bucket", "object", "policy"},
+		},
+		{
+			desc:      "subtree",
+			configure: func(cmd *stackGraphCmd) { cmd.subtree = string(urns["comp"]) },
+			want:      []string{"comp", "bucket", "object"},
+		},
+		{
+			desc:      "dependents",
+			configure: func(cmd *stackGraphCmd) { cmd.dependentsOf = string(urns["bucket"]) },
+			want:      []string{"bucket", "object", "policy"},
+		},
+		{
+			desc: "dependents with depth",
+			configure: func(cmd *stackGraphCmd) {
+				cmd.dependentsOf = string(urns["bucket"])
+				cmd.depth = 1
+			},
+			want: []string{"bucket", "object"},
+		},
+		{
+			desc:      "dependents include children",
+			configure: func(cmd *stackGraphCmd) { cmd.dependentsOf = string(urns["comp"]) },
+			want:      []string{"comp", "bucket", "object", "policy"},
+		},
+		{
+			desc: "dependents without parent edges",
+			configure: func(cmd *stackGraphCmd) {
+				cmd.dependentsOf = string(urns["comp"])
+				cmd.ignoreParentEdges = true
+			},
+			want: []string{"comp"},
+		},
+		{
+			desc:      "dependencies",
+			configure: func(cmd *stackGraphCmd) { cmd.dependenciesOf = string(urns["policy"]) },
+			want:      []string{"stack", "comp", "bucket", "object", "policy"},
+		},
+		{
+			desc: "dependencies without parent edges",
+			configure: func(cmd *stackGraphCmd) {
+				cmd.dependenciesOf = string(urns["policy"])
+				cmd.ignoreParentEdges = true
+			},
+			want: []string{"bucket", "object", "policy"},
+		},
+		{
+			desc: "dependents and dependencies",
+			configure: func(cmd *stackGraphCmd) {
+				cmd.dependentsOf = string(urns["object"])
+				cmd.dependenciesOf = string(urns["object"])
+				cmd.depth = 1
+			},
+			want: []string{"comp", "bucket", "object", "policy"},
+		},
+		{
+			desc:      "types",
+			configure: func(cmd *stackGraphCmd) { cmd.types = []string{"aws:s3/bucket:Bucket", "my:index:Component"} },
+			want:      []string{"comp", "bucket"},
+		},
+		{
+			desc: "types within a subtree",
+			configure: func(cmd *stackGraphCmd) {
+				cmd.subtree = string(urns["comp"])
+				cmd.types = []string{"aws:s3/bucketObject:BucketObject", "aws:s3/bucketPolicy:BucketPolicy"}
+			},
+			want: []string{"object"},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.desc, func(t *testing.T) {
+			t.Parallel()
+
+			var stdout bytes.Buffer
+			cmd := newStackGraphTestCmd(resources, &stdout)
+			cmd.format = "json"
+			tt.configure(cmd)
+			require.NoError(t, cmd.Run(context.Background(), []string{"-"}))
+
+			var graph dependencyGraphJSON
+			require.NoError(t, json.Unmarshal(stdout.Bytes(), &graph))
+			var got []string
+			for _, node := range graph.Nodes {
+				got = append(got, node.Name)
+			}
+			assert.Equal(t, tt.want, got)
+
+			// Edges are only kept between the selected resources.
+			for _, edge := range graph.Edges {
+				assert.Contains(t, got, string(edge.From.Name()))
+				assert.Contains(t, got, string(edge.To.Name()))
+			}
+		})
+	}
+}
+
+func TestStackGraphCmd_errors(t *testing.T) {
+	t.Parallel()
+
+	_, resources := newStackGraphTestResources()
+
+	tests := []struct {
+		desc      string
+		configure func(cmd *stackGraphCmd)
+		wantErr   string
+	}{
+		{
+			desc:      "unknown format",
+			configure: func(cmd *stackGraphCmd) { cmd.format = "svg" },
+			wantErr:   `unknown graph format "svg"; the supported formats are dot, mermaid and json`,
+		},
+		{
+			desc:      "unknown resource",
+			configure: func(cmd *stackGraphCmd) { cmd.dependentsOf = "urn:pulumi:dev::proj::aws:s3/bucket:Bucket::nope" },
+			wantErr: `the resource "urn:pulumi:dev::proj::aws:s3/bucket:Bucket::nope" ` +
+				`given to --dependents-of is not in the stack`,
+		},
+		{
+			desc:      "depth without a resource",
+			configure: func(cmd *stackGraphCmd) { cmd.depth = 2 },
+			wantErr:   "--depth may only be used with --dependents-of or --dependencies-of",
+		},
+		{
+			desc: "negative depth",
+			configure: func(cmd *stackGraphCmd) {
+				cmd.dependenciesOf = "urn"
+				cmd.depth = -1
+			},
+			wantErr: "--depth must not be negative",
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.desc, func(t *testing.T) {
+			t.Parallel()
+
+			cmd := newStackGraphTestCmd(resources, &bytes.Buffer{})
+			tt.configure(cmd)
+			assert.EqualError(t, cmd.Run(context.Background(), []string{"-"}), tt.wantErr)
+		})
+	}
+}
@@ -1,9 +1,11 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -13,8 +15,11 @@ import (
 
 	"github.com/pulumi/pulumi/pkg/v3/backend"
 	"github.com/pulumi/pulumi/pkg/v3/backend/display"
+	"github.com/pulumi/pulumi/pkg/v3/engine"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/apitype"
 	"github.com/pulumi/pulumi/sdk/v3/go/common/diag/colors"
 	"github.com/pulumi/pulumi/sdk/v3/go/common/resource/config"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/tokens"
 	"github.com/pulumi/pulumi/sdk/v3/go/common/util/cmdutil"
 )
 
@@ -27,6 +32,7 @@ func newStackHistoryCmd() *cobra.Command {
 	var pageSize int
 	var page int
 	var showFullDates bool
+	var showEvents string
 
 	cmd := &cobra.Command{
 		Use:        "history",
@@ -35,7 +41,10 @@ func newStackHistoryCmd() *cobra.Command {
 		Short:      "Display history for a stack",
 		Long: `Display history for a stack
 
-This command displays data about previous updates for a stack.`,
+This command displays data about previous updates for a stack.
+
+With --show-events, the events emitted by the given version of the stack are displayed again, as they
+were when it was updated. Only some backends record the events of updates.`,
 		Run: cmdutil.RunFunc(func(cmd *cobra.Command, args []string) error {
 			ctx := commandContext()
 			opts := display.Options{
@@ -45,6 +54,9 @@ This command displays data about previous updates for a stack.`,
 			if err != nil {
 				return err
 			}
+			if showEvents != "" {
+				return showUpdateEvents(ctx, s, showEvents, jsonOut, opts)
+			}
 			b := s.Backend()
 			updates, err := b.GetHistory(ctx, s.Ref(), pageSize, page)
 			if err != nil {
@@ -98,6 +110,12 @@ This command displays data about previous updates for a stack.`,
 		&pageSize, "page-size", 10, "Used with 'page' to control number of results returned")
 	cmd.PersistentFlags().IntVar(
 		&page, "page", 1, "Used with 'page-size' to paginate results")
+	cmd.Flags().StringVar(
+		&showEvents, "show-events", "",
+		"Display the events recorded for the given version of the stack, instead of listing its updates")
+
+	cmd.AddCommand(newStackHistoryDiffCmd(&stack, &jsonOut))
+	cmd.AddCommand(newStackHistoryPruneCmd(&stack, &jsonOut))
 	return cmd
 }
 
@@ -235,3 +253,52 @@ func displayUpdatesConsole(updates []backend.UpdateInfo, page int, opts display.
 
 	return nil
 }
+
+// showUpdateEvents displays the engine events recorded for the given version of a stack, the same way that
+// `pulumi replay-events` displays the events in an event log, or prints them as JSON.
+func showUpdateEvents(ctx context.Context, s backend.Stack, version string, jsonOut bool, opts display.Options) error {
+	be := s.Backend()
+	exporter, ok := be.(backend.UpdateEventsExporter)
+	if !ok {
+		return fmt.Errorf("the current backend (%s) does not provide the ability to export the events of updates",
+			be.Name())
+	}
+
+	jsonEvents, err := exporter.ExportUpdateEvents(ctx, s, version)
+	if err != nil {
+		return fmt.Errorf("loading events for version %s: %w", version, err)
+	}
+	if jsonOut {
+		return printJSON(jsonEvents)
+	}
+
+	events, err := convertJSONEvents(jsonEvents)
+	if err != nil {
+		return fmt.Errorf("loading events for version %s: %w", version, err)
+	}
+
+	// The events are displayed as they would be for the kind of update that emitted them.
+	kind := apitype.UpdateUpdate
+	updates, err := be.GetHistory(ctx, s.Ref(), 0 /*pageSize*/, 0 /*page*/)
+	if err != nil {
+		return fmt.Errorf("getting history: %w", err)
+	}
+	for _, update := range updates {
+		if strconv.Itoa(update.Version) == version {
+			kind = update.Kind
+			break
+		}
+	}
+
+	opts.Type = display.DisplayProgress
+	proj, _ := s.Ref().Project()
+	eventChannel, doneChannel := make(chan engine.Event), make(chan bool)
+	go display.ShowEvents(
+		strings.ToLower(backend.ActionLabel(kind, false /*dryRun*/)), kind, s.Ref().Name(), tokens.PackageName(proj),
+		"", eventChannel, doneChannel, opts, false /*isPreview*/)
+	for _, e := range events {
+		eventChannel <- e
+	}
+	<-doneChannel
+	return nil
+}
@@ -0,0 +1,280 @@
+// Copyright 2016-2023, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/pulumi/pulumi/pkg/v3/backend"
+	"github.com/pulumi/pulumi/pkg/v3/backend/display"
+	"github.com/pulumi/pulumi/pkg/v3/resource/deploy"
+	"github.com/pulumi/pulumi/pkg/v3/resource/stack"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/diag/colors"
+	sdkDisplay "github.com/pulumi/pulumi/sdk/v3/go/common/display"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/resource"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/tokens"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/cmdutil"
+)
+
+// propertyDiffJSON is the shape of a changed property in the --json output of `pulumi stack history diff`. Old is
+// omitted for added properties, and New for deleted ones.
+type propertyDiffJSON struct {
+	Old interface{} `json:"old,omitempty"`
+	New interface{} `json:"new,omitempty"`
+}
+
+// resourceStateDiff describes how a single resource's state changed between two checkpoints.
+type resourceStateDiff struct {
+	URN  resource.URN      `json:"urn"`
+	Type tokens.Type       `json:"type"`
+	Op   sdkDisplay.StepOp `json:"op"`
+	Old  *resource.State   `json:"-"`
+	New  *resource.State   `json:"-"`
+
+	Inputs              map[string]propertyDiffJSON `json:"inputs,omitempty"`
+	Outputs             map[string]propertyDiffJSON `json:"outputs,omitempty"`
+	AddedDependencies   []resource.URN              `json:"addedDependencies,omitempty"`
+	RemovedDependencies []resource.URN              `json:"removedDependencies,omitempty"`
+}
+
+// historyDiffJSON is the shape of the --json output of `pulumi stack history diff`. While we can add fields to this
+// structure in the future, we should not change existing fields.
+type historyDiffJSON struct {
+	From      string              `json:"from"`
+	To        string              `json:"to"`
+	Resources []resourceStateDiff `json:"resources"`
+}
+
+// propertyDiffs flattens the top-level changes between two property maps for JSON output.
+func propertyDiffs(olds, news resource.PropertyMap, showSecrets bool) map[string]propertyDiffJSON {
+	diff := olds.Diff(news, resource.IsInternalPropertyKey)
+	if diff == nil {
+		return nil
+	}
+
+	mappable := func(v resource.PropertyValue) interface{} {
+		return display.MassageSecrets(resource.PropertyMap{"v": v}, showSecrets).Mappable()["v"]
+	}
+	result := make(map[string]propertyDiffJSON)
+	for k, v := range diff.Adds {
+		result[string(k)] = propertyDiffJSON{New: mappable(v)}
+	}
+	for k, v := range diff.Deletes {
+		result[string(k)] = propertyDiffJSON{Old: mappable(v)}
+	}
+	for k, v := range diff.Updates {
+		result[string(k)] = propertyDiffJSON{Old: mappable(v.Old), New: mappable(v.New)}
+	}
+	return result
+}
+
+// dependencyDiffs returns the dependencies that were added and removed between two versions of a resource.
+func dependencyDiffs(olds, news []resource.URN) ([]resource.URN, []resource.URN) {
+	oldSet := make(map[resource.URN]bool, len(olds))
+	for _, urn := range olds {
+		oldSet[urn] = true
+	}
+	newSet := make(map[resource.URN]bool, len(news))
+	for _, urn := range news {
+		newSet[urn] = true
+	}
+
+	var added, removed []resource.URN
+	for _, urn := range news {
+		if !oldSet[urn] {
+			added = append(added, urn)
+		}
+	}
+	for _, urn := range olds {
+		if !newSet[urn] {
+			removed = append(removed, urn)
+		}
+	}
+	return added, removed
+}
+
+// diffSnapshots compares the resources in two snapshots by URN. Resources that share a URN (because all but one of
+// them are pending deletion) are compared in the order in which they appear. Created and updated resources are
+// returned in the order of the new snapshot, followed by deleted resources in the order of the old snapshot.
+func diffSnapshots(before, after *deploy.Snapshot, showSecrets bool) []resourceStateDiff {
+	type key struct {
+		urn resource.URN
+		n   int
+	}
+	index := func(snap *deploy.Snapshot) (map[key]*resource.State, []key) {
+		if snap == nil {
+			return nil, nil
+		}
+		seen := make(map[resource.URN]int)
+		m := make(map[key]*resource.State, len(snap.Resources))
+		keys := make([]key, 0, len(snap.Resources))
+		for _, res := range snap.Resources {
+			k := key{urn: res.URN, n: seen[res.URN]}
+			seen[res.URN]++
+			m[k] = res
+			keys = append(keys, k)
+		}
+		return m, keys
+	}
+
+	olds, oldKeys := index(before)
+	news, newKeys := index(after)
+
+	var diffs []resourceStateDiff
+	for _, k := range newKeys {
+		n := news[k]
+		o, has := olds[k]
+		if !has {
+			diffs = append(diffs, resourceStateDiff{URN: n.URN, Type: n.Type, Op: deploy.OpCreate, New: n})
+			continue
+		}
+
+		added, removed := dependencyDiffs(o.Dependencies, n.Dependencies)
+		d := resourceStateDiff{
+			URN:                 n.URN,
+			Type:                n.Type,
+			Op:                  deploy.OpUpdate,
+			Old:                 o,
+			New:                 n,
+			Inputs:              propertyDiffs(o.Inputs, n.Inputs, showSecrets),
+			Outputs:             propertyDiffs(o.Outputs, n.Outputs, showSecrets),
+			AddedDependencies:   added,
+			RemovedDependencies: removed,
+		}
+		if d.Inputs != nil || d.Outputs != nil || added != nil || removed != nil {
+			diffs = append(diffs, d)
+		}
+	}
+	for _, k := range oldKeys {
+		if _, has := news[k]; !has {
+			o := olds[k]
+			diffs = append(diffs, resourceStateDiff{URN: o.URN, Type: o.Type, Op: deploy.OpDelete, Old: o})
+		}
+	}
+	return diffs
+}
+
+// loadStackVersion loads the checkpoint for the given version of a stack from its backend.
+func loadStackVersion(ctx context.Context, s backend.Stack, version string) (*deploy.Snapshot, error) {
+	be := s.Backend()
+	exporter, ok := be.(backend.SpecificDeploymentExporter)
+	if !ok {
+		return nil, fmt.Errorf("the current backend (%s) does not provide the ability to export previous deployments",
+			be.Name())
+	}
+
+	deployment, err := exporter.ExportDeploymentForVersion(ctx, s, version)
+	if err != nil {
+		return nil, fmt.Errorf("loading version %s: %w", version, err)
+	}
+	snap, err := stack.DeserializeUntypedDeployment(ctx, deployment, stack.DefaultSecretsProvider)
+	if err != nil {
+		return nil, checkDeploymentVersionError(err, s.Ref().Name().String())
+	}
+	return snap, nil
+}
+
+func printHistoryDiffConsole(diffs []resourceStateDiff, opts display.Options) {
+	if len(diffs) == 0 {
+		fmt.Println("No resources changed")
+		return
+	}
+
+	const indent = 2
+	for _, d := range diffs {
+		var b bytes.Buffer
+		fmt.Fprintf(&b, "%s%s%s%s\n", deploy.Color(d.Op), deploy.RawPrefix(d.Op), d.URN, colors.Reset)
+
+		if d.Op == deploy.OpUpdate {
+			if diff := d.Old.Inputs.Diff(d.New.Inputs, resource.IsInternalPropertyKey); diff != nil {
+				fmt.Fprintf(&b, "    inputs:\n")
+				display.PrintObjectDiff(&b, *diff, nil, false, indent, false, false, false)
+			}
+			if diff := d.Old.Outputs.Diff(d.New.Outputs, resource.IsInternalPropertyKey); diff != nil {
+				fmt.Fprintf(&b, "    outputs:\n")
+				display.PrintObjectDiff(&b, *diff, nil, false, indent, false, false, false)
+			}
+			if len(d.AddedDependencies) > 0 || len(d.RemovedDependencies) > 0 {
+				fmt.Fprintf(&b, "    dependencies:\n")
+				for _, urn := range d.AddedDependencies {
+					fmt.Fprintf(&b, "    %s+ %s%s\n", colors.SpecCreate, urn, colors.Reset)
+				}
+				for _, urn := range d.RemovedDependencies {
+					fmt.Fprintf(&b, "    %s- %s%s\n", colors.SpecDelete, urn, colors.Reset)
+				}
+			}
+		}
+
+		fmt.Print(opts.Color.Colorize(b.String()))
+	}
+}
+
+func newStackHistoryDiffCmd(stackName *string, jsonOut *bool) *cobra.Command {
+	var showSecrets bool
+
+	cmd := &cobra.Command{
+		Use:   "diff <from-version> <to-version>",
+		Short: "Show the changes to a stack's state between two versions",
+		Long: `Show the changes to a stack's state between two versions
+
+This command compares the checkpoints saved by two previous updates of a stack, as numbered by
+'pulumi stack history', and displays the resources that were added or removed, and for the
+resources that changed, their changed inputs, outputs and dependencies.`,
+		Args: cmdutil.ExactArgs(2),
+		Run: cmdutil.RunFunc(func(cmd *cobra.Command, args []string) error {
+			ctx := commandContext()
+			opts := display.Options{
+				Color: cmdutil.GetGlobalColorization(),
+			}
+			s, err := requireStack(ctx, *stackName, stackLoadOnly, opts)
+			if err != nil {
+				return err
+			}
+
+			before, err := loadStackVersion(ctx, s, args[0])
+			if err != nil {
+				return err
+			}
+			after, err := loadStackVersion(ctx, s, args[1])
+			if err != nil {
+				return err
+			}
+
+			if showSecrets {
+				log3rdPartySecretsProviderDecryptionEvent(ctx, s, "", "pulumi stack history diff")
+			}
+
+			diffs := diffSnapshots(before, after, showSecrets)
+			if *jsonOut {
+				if diffs == nil {
+					diffs = []resourceStateDiff{}
+				}
+				return printJSON(historyDiffJSON{From: args[0], To: args[1], Resources: diffs})
+			}
+
+			printHistoryDiffConsole(diffs, opts)
+			return nil
+		}),
+	}
+
+	cmd.Flags().BoolVar(
+		&showSecrets, "show-secrets", false,
+		"Show secret values in the JSON output instead of displaying blinded values")
+	return cmd
+}
@@ -0,0 +1,81 @@
+// Copyright 2016-2023, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/pulumi/pulumi/pkg/v3/resource/deploy"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/resource"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/tokens"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiffSnapshots(t *testing.T) {
+	t.Parallel()
+
+	newRes := func(name string, outputs resource.PropertyMap, deps ...resource.URN) *resource.State {
+		return &resource.State{
+			Type:         "a:b:c",
+			URN:          resource.NewURN("stack", "proj", "", "a:b:c", tokens.QName(name)),
+			Inputs:       resource.PropertyMap{},
+			Outputs:      outputs,
+			Dependencies: deps,
+		}
+	}
+
+	same := newRes("same", resource.PropertyMap{"x": resource.NewNumberProperty(1)})
+	gone := newRes("gone", resource.PropertyMap{})
+	changedBefore := newRes("changed", resource.PropertyMap{
+		"x":      resource.NewStringProperty("old"),
+		"secret": resource.MakeSecret(resource.NewStringProperty("old")),
+	}, gone.URN)
+	changedAfter := newRes("changed", resource.PropertyMap{
+		"x":      resource.NewStringProperty("new"),
+		"secret": resource.MakeSecret(resource.NewStringProperty("new")),
+	}, same.URN)
+	added := newRes("added", resource.PropertyMap{})
+
+	before := deploy.NewSnapshot(deploy.Manifest{}, nil, []*resource.State{same, gone, changedBefore}, nil)
+	after := deploy.NewSnapshot(deploy.Manifest{}, nil, []*resource.State{same, changedAfter, added}, nil)
+
+	diffs := diffSnapshots(before, after, false /* showSecrets */)
+	require.Len(t, diffs, 3)
+
+	assert.Equal(t, changedAfter.URN, diffs[0].URN)
+	assert.Equal(t, deploy.OpUpdate, diffs[0].Op)
+	assert.Empty(t, diffs[0].Inputs)
+	assert.Equal(t, map[string]propertyDiffJSON{
+		"x":      {Old: "old", New: "new"},
+		"secret": {Old: "[secret]", New: "[secret]"},
+	}, diffs[0].Outputs)
+	assert.Equal(t, []resource.URN{same.URN}, diffs[0].AddedDependencies)
+	assert.Equal(t, []resource.URN{gone.URN}, diffs[0].RemovedDependencies)
+
+	assert.Equal(t, added.URN, diffs[1].URN)
+	assert.Equal(t, deploy.OpCreate, diffs[1].Op)
+	assert.Equal(t, gone.URN, diffs[2].URN)
+	assert.Equal(t, deploy.OpDelete, diffs[2].Op)
+
+	// The states themselves aren't part of the JSON output.
+	data, err := json.Marshal(diffs[1])
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"urn":"urn:pulumi:stack::proj::a:b:c::added","type":"a:b:c","op":"create"}`, string(data))
+
+	diffs = diffSnapshots(before, after, true /* showSecrets */)
+	assert.Equal(t, propertyDiffJSON{Old: "old", New: "new"}, diffs[0].Outputs["secret"])
+}
@@ -0,0 +1,174 @@
+// Copyright 2016-2023, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/pulumi/pulumi/pkg/v3/backend"
+	"github.com/pulumi/pulumi/pkg/v3/backend/display"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/cmdutil"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/result"
+)
+
+// historyPruneJSON is the shape of the --json output of `pulumi stack history prune`. While we can add fields to
+// this structure in the future, we should not change existing fields.
+type historyPruneJSON struct {
+	DryRun   bool  `json:"dryRun"`
+	Versions []int `json:"versions"`
+}
+
+func printPrunedUpdates(updates []backend.UpdateInfo) {
+	for _, update := range updates {
+		end := update.EndTime
+		if end == 0 {
+			end = update.StartTime
+		}
+		fmt.Printf("    Version %d: %s, finished %s\n",
+			update.Version, update.Kind, time.Unix(end, 0).Format(time.RFC3339))
+	}
+}
+
+func newStackHistoryPruneCmd(stackName *string, jsonOut *bool) *cobra.Command {
+	var keep int
+	var keepFor time.Duration
+	var dryRun bool
+	var save bool
+	var yes bool
+
+	cmd := &cobra.Command{
+		Use:   "prune",
+		Short: "Remove old updates from a stack's history",
+		Long: `Remove old updates from a stack's history
+
+This command removes the updates that a retention policy doesn't keep from the stack's history,
+along with the checkpoints saved by them. An update is kept if it is one of the most recent
+--keep updates, or if it finished less than --keep-for ago. If neither is specified, the
+retention policy configured for the backend is used.
+
+Only the oldest updates are removed, and the versions of the remaining updates don't change.
+The update whose checkpoint matches the stack's current state is never removed, and neither
+are any updates after it.
+
+Use --save to configure the policy for the backend, which then enforces it after each update.`,
+		Args: cmdutil.NoArgs,
+		Run: cmdutil.RunResultFunc(func(cmd *cobra.Command, args []string) result.Result {
+			ctx := commandContext()
+			opts := display.Options{
+				Color: cmdutil.GetGlobalColorization(),
+			}
+			yes = yes || skipConfirmations()
+
+			if keep < 0 || keepFor < 0 {
+				return result.FromError(errors.New("--keep and --keep-for must not be negative"))
+			}
+			if save && dryRun {
+				return result.FromError(errors.New("--save cannot be used with --dry-run"))
+			}
+
+			s, err := requireStack(ctx, *stackName, stackLoadOnly, opts)
+			if err != nil {
+				return result.FromError(err)
+			}
+			be := s.Backend()
+			pruner, ok := be.(backend.HistoryPruner)
+			if !ok {
+				return result.Errorf("the current backend (%s) does not provide the ability to prune stack history",
+					be.Name())
+			}
+
+			policy := backend.HistoryRetention{KeepUpdates: keep, KeepFor: keepFor}
+			if policy.IsZero() {
+				if save {
+					return result.FromError(errors.New("--save requires --keep or --keep-for"))
+				}
+				if policy, err = pruner.HistoryRetention(ctx); err != nil {
+					return result.FromError(err)
+				}
+				if policy.IsZero() {
+					return result.FromError(errors.New(
+						"no history retention policy is configured for the backend; specify --keep or --keep-for"))
+				}
+			}
+
+			pruned, err := pruner.PruneHistory(ctx, s, policy, true /*dryRun*/)
+			if err != nil {
+				return result.FromError(fmt.Errorf("pruning history: %w", err))
+			}
+
+			if !dryRun && len(pruned) > 0 && !yes && cmdutil.Interactive() {
+				fmt.Printf("The following updates will be removed from the history of stack %s:\n", s.Ref())
+				printPrunedUpdates(pruned)
+				fmt.Println()
+
+				prompt := fmt.Sprintf("This command will remove %d update(s) from the history of stack %s. Confirm?",
+					len(pruned), s.Ref())
+				if !confirmStateEdit(opts, prompt) {
+					fmt.Println("confirmation declined")
+					return result.Bail()
+				}
+			}
+
+			if save {
+				if err := pruner.SetHistoryRetention(ctx, policy); err != nil {
+					return result.FromError(fmt.Errorf("saving retention policy: %w", err))
+				}
+			}
+			if !dryRun && len(pruned) > 0 {
+				if pruned, err = pruner.PruneHistory(ctx, s, policy, false /*dryRun*/); err != nil {
+					return result.FromError(fmt.Errorf("pruning history: %w", err))
+				}
+			}
+
+			if *jsonOut {
+				versions := make([]int, len(pruned))
+				for i, update := range pruned {
+					versions[i] = update.Version
+				}
+				return result.WrapIfNonNil(printJSON(historyPruneJSON{DryRun: dryRun, Versions: versions}))
+			}
+
+			switch {
+			case len(pruned) == 0:
+				fmt.Printf("No updates to remove from the history of stack %s\n", s.Ref())
+			case dryRun:
+				fmt.Printf("The following updates would be removed from the history of stack %s:\n", s.Ref())
+				printPrunedUpdates(pruned)
+			default:
+				fmt.Printf("Removed %d update(s) from the history of stack %s\n", len(pruned), s.Ref())
+			}
+			if save {
+				fmt.Println("Saved the retention policy for the backend")
+			}
+			return nil
+		}),
+	}
+
+	cmd.Flags().IntVar(
+		&keep, "keep", 0, "The number of most recent updates to keep")
+	cmd.Flags().DurationVar(
+		&keepFor, "keep-for", 0, "Keep updates that finished less than this long ago, e.g. 720h")
+	cmd.Flags().BoolVar(
+		&dryRun, "dry-run", false, "Show the updates that would be removed, without removing them")
+	cmd.Flags().BoolVar(
+		&save, "save", false, "Save the retention policy for the backend to enforce after each update")
+	cmd.Flags().BoolVarP(
+		&yes, "yes", "y", false, "Skip confirmation prompts")
+	return cmd
+}
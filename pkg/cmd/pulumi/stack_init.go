@@ -30,7 +30,7 @@ import (
 
 const (
 	possibleSecretsProviderChoices = "The type of the provider that should be used to encrypt and decrypt secrets\n" +
-		"(possible choices: default, passphrase, awskms, azurekeyvault, gcpkms, hashivault)"
+		"(possible choices: default, passphrase, awskms, azurekeyvault, gcpkms, hashivault, age)"
 )
 
 func newStackInitCmd() *cobra.Command {
@@ -64,6 +64,11 @@ func newStackInitCmd() *cobra.Command {
 			"* `pulumi stack init --secrets-provider=\"gcpkms://projects/<p>/locations/<l>/keyRings/<r>/cryptoKeys/<k>\"`\n" +
 			"* `pulumi stack init --secrets-provider=\"hashivault://mykey\"\n`" +
 			"\n" +
+			"To encrypt secrets to one or more age recipients, list them in an `age://` URL. Secrets are decrypted\n" +
+			"with the age identity file named by PULUMI_AGE_IDENTITY, or otherwise ~/.config/pulumi/age:\n" +
+			"\n" +
+			"* `pulumi stack init --secrets-provider=\"age://age1...,age1...\"`\n" +
+			"\n" +
 			"A stack can be created based on the configuration of an existing stack by passing the\n" +
 			"`--copy-config-from` flag.\n" +
 			"* `pulumi stack init --copy-config-from dev`",
@@ -0,0 +1,707 @@
+// Copyright 2016-2023, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/pulumi/pulumi/pkg/v3/backend"
+	"github.com/pulumi/pulumi/pkg/v3/backend/display"
+	"github.com/pulumi/pulumi/pkg/v3/backend/filestate"
+	"github.com/pulumi/pulumi/pkg/v3/backend/httpstate"
+	"github.com/pulumi/pulumi/pkg/v3/backend/sqlstate"
+	"github.com/pulumi/pulumi/pkg/v3/resource/deploy"
+	"github.com/pulumi/pulumi/pkg/v3/resource/stack"
+	"github.com/pulumi/pulumi/pkg/v3/secrets"
+	"github.com/pulumi/pulumi/pkg/v3/secrets/service"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/apitype"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/diag"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/resource/config"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/cmdutil"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/contract"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/result"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/workspace"
+)
+
+// migrateProgressRecord is a line of the progress log written by `pulumi stack migrate`. The first line of the log
+// records the backends being migrated between, and each of the following lines records a step in the migration of
+// a stack, identified by its fully qualified name in the source backend.
+type migrateProgressRecord struct {
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+
+	Stack string `json:"stack,omitempty"`
+	// Created is set once the stack has been created in the target backend.
+	Created bool `json:"created,omitempty"`
+	// Config is set once the stack's configuration has been re-encrypted for the target backend and saved to its
+	// migrated configuration file.
+	Config bool `json:"config,omitempty"`
+	// Version is the version of the stack's history that has been copied, if non-zero.
+	Version int `json:"version,omitempty"`
+	// Done is set once the stack has been copied and verified.
+	Done bool `json:"done,omitempty"`
+}
+
+// migrateProgress tracks the progress of a migration in its log, so that an interrupted migration can be resumed.
+type migrateProgress struct {
+	file    *os.File
+	started map[string]bool
+	config  map[string]bool
+	history map[string]map[int]bool
+	done    map[string]bool
+}
+
+// defaultMigrateProgressPath returns the path of the progress log for a migration between the given backends.
+func defaultMigrateProgressPath(from, to string) (string, error) {
+	sum := sha256.Sum256([]byte(from + "\n" + to))
+	return workspace.GetPulumiPath("migrations", fmt.Sprintf("%x.log", sum[:8]))
+}
+
+// openMigrateProgress opens the progress log at the given path, creating it if it doesn't exist, and loads the
+// progress recorded in it. The log must be for a migration between the same backends.
+func openMigrateProgress(logPath, from, to string) (*migrateProgress, error) {
+	if err := os.MkdirAll(filepath.Dir(logPath), 0o700); err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(logPath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("reading progress log: %w", err)
+	}
+
+	p := &migrateProgress{
+		started: make(map[string]bool),
+		config:  make(map[string]bool),
+		history: make(map[string]map[int]bool),
+		done:    make(map[string]bool),
+	}
+
+	var header bool
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		var rec migrateProgressRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			// The last line may have been cut short if the migration was interrupted while writing it.
+			continue
+		}
+		if !header {
+			if rec.From != from || rec.To != to {
+				return nil, fmt.Errorf("progress log %s is for a migration from %s to %s", logPath, rec.From, rec.To)
+			}
+			header = true
+			continue
+		}
+		p.apply(rec)
+	}
+
+	if p.file, err = os.OpenFile(logPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600); err != nil {
+		return nil, fmt.Errorf("opening progress log: %w", err)
+	}
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		// Start a new line after one that was cut short.
+		if _, err := p.file.Write([]byte("\n")); err != nil {
+			contract.IgnoreClose(p.file)
+			return nil, fmt.Errorf("writing progress log: %w", err)
+		}
+	}
+	if !header {
+		if err := p.record(migrateProgressRecord{From: from, To: to}); err != nil {
+			contract.IgnoreClose(p.file)
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+func (p *migrateProgress) apply(rec migrateProgressRecord) {
+	if rec.Stack == "" {
+		return
+	}
+	p.started[rec.Stack] = true
+	if rec.Config {
+		p.config[rec.Stack] = true
+	}
+	if rec.Version != 0 {
+		if p.history[rec.Stack] == nil {
+			p.history[rec.Stack] = make(map[int]bool)
+		}
+		p.history[rec.Stack][rec.Version] = true
+	}
+	if rec.Done {
+		p.done[rec.Stack] = true
+	}
+}
+
+// record appends the given record to the log, and syncs it to disk before returning.
+func (p *migrateProgress) record(rec migrateProgressRecord) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if _, err := p.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("writing progress log: %w", err)
+	}
+	if err := p.file.Sync(); err != nil {
+		return fmt.Errorf("writing progress log: %w", err)
+	}
+	p.apply(rec)
+	return nil
+}
+
+func (p *migrateProgress) Close() error {
+	return p.file.Close()
+}
+
+// migrateBackend returns the backend at the given URL. Unlike currentBackend, it never logs in or changes the
+// current backend, so the Pulumi Cloud backends must have been logged into already.
+func migrateBackend(ctx context.Context, url string, project *workspace.Project) (backend.Backend, error) {
+	if filestate.IsMemBackendURL(url) {
+		return nil, filestate.ErrMemBackendLogin
+	}
+	if filestate.IsFileStateBackendURL(url) {
+		return filestate.New(ctx, cmdutil.Diag(), url, project)
+	}
+	if sqlstate.IsSQLStateBackendURL(url) {
+		return sqlstate.New(ctx, cmdutil.Diag(), url, project)
+	}
+
+	account, err := workspace.GetAccount(httpstate.ValueOrDefaultURL(url))
+	if err != nil {
+		return nil, fmt.Errorf("getting stored credentials: %w", err)
+	}
+	if account.AccessToken == "" {
+		return nil, fmt.Errorf("not logged in to %s; run 'pulumi login %s' first", url, url)
+	}
+	return httpstate.New(cmdutil.Diag(), url, project, workspace.GetCloudInsecure(url))
+}
+
+// stackMigrator copies stacks from one backend to another.
+type stackMigrator struct {
+	src, dst  backend.Backend
+	targetOrg string
+	// project is the current project, if any. The configuration files of its stacks are re-encrypted along with
+	// their state when the stacks need a new secrets provider.
+	project  *workspace.Project
+	progress *migrateProgress
+}
+
+// selectStacks returns the stacks in the source backend whose names match any of the given globs, or all of them if
+// there are no globs.
+func (m *stackMigrator) selectStacks(ctx context.Context, globs []string) ([]backend.StackReference, error) {
+	for _, glob := range globs {
+		if _, err := path.Match(glob, ""); err != nil {
+			return nil, fmt.Errorf("invalid stack glob %q: %w", glob, err)
+		}
+	}
+
+	var refs []backend.StackReference
+	var token backend.ContinuationToken
+	for {
+		summaries, next, err := m.src.ListStacks(ctx, backend.ListStacksFilter{}, token)
+		if err != nil {
+			return nil, fmt.Errorf("listing stacks: %w", err)
+		}
+		for _, summary := range summaries {
+			ref := summary.Name()
+			matched := len(globs) == 0
+			for _, glob := range globs {
+				short, _ := path.Match(glob, ref.String())
+				full, _ := path.Match(glob, ref.FullyQualifiedName().String())
+				if short || full {
+					matched = true
+					break
+				}
+			}
+			if matched {
+				refs = append(refs, ref)
+			}
+		}
+		if next == nil {
+			break
+		}
+		token = next
+	}
+
+	sort.Slice(refs, func(i, j int) bool {
+		return refs[i].FullyQualifiedName() < refs[j].FullyQualifiedName()
+	})
+	return refs, nil
+}
+
+// targetName returns the name of the stack in the target backend that the given source stack is copied to.
+func (m *stackMigrator) targetName(ref backend.StackReference) string {
+	project, ok := ref.Project()
+	if !ok {
+		return ref.Name().String()
+	}
+	return fmt.Sprintf("%s/%s/%s", m.targetOrg, project, ref.Name())
+}
+
+// needsNewSecretsManager returns true if the given secrets manager can't be used by a stack in another backend.
+// Secrets encrypted by the Pulumi Cloud can only be decrypted by the stack that they belong to.
+func needsNewSecretsManager(sm secrets.Manager) bool {
+	return sm != nil && sm.Type() == service.Type
+}
+
+// migrateStack copies the given stack's state, history, tags and configuration to the target backend, skipping any
+// steps that the progress log records as done, and verifies the copy.
+func (m *stackMigrator) migrateStack(ctx context.Context, srcRef backend.StackReference) error {
+	key := srcRef.FullyQualifiedName().String()
+
+	srcStack, err := m.src.GetStack(ctx, srcRef)
+	if err != nil {
+		return err
+	}
+	if srcStack == nil {
+		return fmt.Errorf("stack %s not found", srcRef)
+	}
+
+	dstRef, err := m.dst.ParseStackReference(m.targetName(srcRef))
+	if err != nil {
+		return err
+	}
+	dstStack, err := m.dst.GetStack(ctx, dstRef)
+	if err != nil {
+		return err
+	}
+	if dstStack == nil {
+		if dstStack, err = m.dst.CreateStack(ctx, dstRef, "", nil); err != nil {
+			return fmt.Errorf("creating stack %s: %w", dstRef, err)
+		}
+		if err := m.progress.record(migrateProgressRecord{Stack: key, Created: true}); err != nil {
+			return err
+		}
+	} else if !m.progress.started[key] {
+		// Never overwrite a stack that this migration didn't create.
+		return fmt.Errorf("stack %s already exists in %s", dstRef, m.dst.Name())
+	}
+
+	if m.dst.SupportsTags() && len(srcStack.Tags()) > 0 {
+		if err := m.dst.UpdateStackTags(ctx, dstStack, srcStack.Tags()); err != nil {
+			return fmt.Errorf("copying tags: %w", err)
+		}
+	}
+
+	current, err := srcStack.ExportDeployment(ctx)
+	if err != nil {
+		return fmt.Errorf("exporting state: %w", err)
+	}
+	snap, err := stack.DeserializeUntypedDeployment(ctx, current, stack.DefaultSecretsProvider)
+	if err != nil {
+		return checkDeploymentVersionError(err, srcRef.Name().String())
+	}
+
+	// Work out whether the stack's secrets need to be re-encrypted for the target backend, and if so, with what.
+	var srcDec config.Decrypter = config.NewPanicCrypter()
+	var dstSM secrets.Manager
+	if needsNewSecretsManager(snap.SecretsManager) {
+		if srcDec, err = snap.SecretsManager.Decrypter(); err != nil {
+			return err
+		}
+		if dstSM, err = m.reencryptConfig(srcRef, key, srcDec, dstStack); err != nil {
+			return err
+		}
+	}
+
+	if err := m.migrateHistory(ctx, key, srcStack, dstStack, srcDec, dstSM); err != nil {
+		return err
+	}
+
+	if dstSM != nil {
+		if current, err = reserializeDeployment(snap, dstSM); err != nil {
+			return err
+		}
+	}
+	if err := m.dst.ImportDeployment(ctx, dstStack, current); err != nil {
+		return fmt.Errorf("importing state: %w", err)
+	}
+
+	if err := m.verify(ctx, srcStack, dstStack, snap); err != nil {
+		return fmt.Errorf("verifying copy of stack %s: %w", srcRef, err)
+	}
+	return m.progress.record(migrateProgressRecord{Stack: key, Done: true})
+}
+
+// migratedConfigPath returns the path of the file that the re-encrypted configuration of a stack is saved to, next to
+// its configuration file at the given path. The stack's configuration file is left as it is, since the stack in the
+// source backend still needs it.
+func migratedConfigPath(psPath string) string {
+	ext := filepath.Ext(psPath)
+	return strings.TrimSuffix(psPath, ext) + ".migrated" + ext
+}
+
+// reencryptConfig creates a secrets manager for the target stack, and re-encrypts the stack's configuration with it,
+// saving it to the stack's migrated configuration file, if the stack belongs to the current project and has a
+// configuration file.
+func (m *stackMigrator) reencryptConfig(
+	srcRef backend.StackReference, key string, srcDec config.Decrypter, dstStack backend.Stack,
+) (secrets.Manager, error) {
+	var ps *workspace.ProjectStack
+	var psPath string
+	if project, ok := srcRef.Project(); ok && m.project != nil && m.project.Name.String() == project.String() {
+		_, p, err := workspace.DetectProjectStackPath(srcRef.Name().Q())
+		if err != nil {
+			return nil, err
+		}
+		if _, err := os.Stat(p); err == nil {
+			if ps, err = workspace.LoadProjectStack(m.project, p); err != nil {
+				return nil, err
+			}
+			psPath = p
+		}
+	}
+
+	if ps == nil {
+		cmdutil.Diag().Warningf(diag.Message("", "The configuration of stack %s isn't in the current project, "+
+			"so any secrets in it must be re-encrypted with 'pulumi stack change-secrets-provider'"), srcRef)
+		ps = &workspace.ProjectStack{}
+	} else if m.progress.config[key] {
+		// The configuration has already been re-encrypted, and its migrated file records the target's secrets
+		// provider.
+		migrated, err := workspace.LoadProjectStack(m.project, migratedConfigPath(psPath))
+		if err != nil {
+			return nil, err
+		}
+		sm, _, err := getStackSecretsManager(dstStack, migrated)
+		return sm, err
+	}
+
+	// Start the target stack off with its backend's default secrets provider.
+	newPS := *ps
+	newPS.SecretsProvider, newPS.EncryptedKey, newPS.EncryptionSalt = "", "", ""
+	sm, err := dstStack.DefaultSecretManager(&newPS)
+	if err != nil {
+		return nil, fmt.Errorf("creating secrets manager: %w", err)
+	}
+	if psPath == "" {
+		return sm, nil
+	}
+
+	enc, err := sm.Encrypter()
+	if err != nil {
+		return nil, err
+	}
+	if newPS.Config, err = ps.Config.Copy(srcDec, enc); err != nil {
+		return nil, fmt.Errorf("re-encrypting configuration: %w", err)
+	}
+	migratedPath := migratedConfigPath(psPath)
+	if err := newPS.Save(migratedPath); err != nil {
+		return nil, fmt.Errorf("saving configuration: %w", err)
+	}
+	fmt.Printf("Saved the re-encrypted configuration of stack %s to %s; replace %s with it once you use the "+
+		"stack in the new backend\n", srcRef, migratedPath, filepath.Base(psPath))
+	return sm, m.progress.record(migrateProgressRecord{Stack: key, Config: true})
+}
+
+// reserializeDeployment serializes the given snapshot with the given secrets manager.
+func reserializeDeployment(snap *deploy.Snapshot, sm secrets.Manager) (*apitype.UntypedDeployment, error) {
+	deployment, err := stack.SerializeDeployment(snap, sm, false /* showSecrets */)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(deployment)
+	if err != nil {
+		return nil, err
+	}
+	return &apitype.UntypedDeployment{Version: apitype.DeploymentSchemaVersionCurrent, Deployment: data}, nil
+}
+
+// migrateHistory copies the stack's update history to the target stack, oldest update first, if both backends
+// support it. If dstSM is non-nil, the history's secrets are re-encrypted with it.
+func (m *stackMigrator) migrateHistory(
+	ctx context.Context, key string, srcStack, dstStack backend.Stack,
+	srcDec config.Decrypter, dstSM secrets.Manager,
+) error {
+	exporter, canExport := m.src.(backend.SpecificDeploymentExporter)
+	importer, canImport := m.dst.(backend.HistoryImporter)
+	if !canExport || !canImport {
+		cmdutil.Diag().Warningf(diag.Message("", "The history of stack %s can't be copied from %s to %s"),
+			srcStack.Ref(), m.src.Name(), m.dst.Name())
+		return nil
+	}
+
+	history, err := m.src.GetHistory(ctx, srcStack.Ref(), 0 /*pageSize*/, 0 /*page*/)
+	if err != nil {
+		return fmt.Errorf("getting history: %w", err)
+	}
+
+	var dstEnc config.Encrypter
+	if dstSM != nil {
+		if dstEnc, err = dstSM.Encrypter(); err != nil {
+			return err
+		}
+	}
+
+	// A migration that was interrupted after importing a version, but before recording it in the progress log,
+	// would otherwise import it again when it's resumed.
+	dstHistory, err := m.dst.GetHistory(ctx, dstStack.Ref(), 0 /*pageSize*/, 0 /*page*/)
+	if err != nil {
+		return fmt.Errorf("getting history of stack %s: %w", dstStack.Ref(), err)
+	}
+	imported := make(map[int]bool, len(dstHistory))
+	for _, update := range dstHistory {
+		imported[update.Version] = true
+	}
+
+	for i := len(history) - 1; i >= 0; i-- {
+		update := history[i]
+		if m.progress.history[key][update.Version] {
+			continue
+		}
+		if imported[update.Version] {
+			if err := m.progress.record(migrateProgressRecord{Stack: key, Version: update.Version}); err != nil {
+				return err
+			}
+			continue
+		}
+
+		deployment, err := exporter.ExportDeploymentForVersion(ctx, srcStack, strconv.Itoa(update.Version))
+		if err != nil {
+			return fmt.Errorf("exporting version %d: %w", update.Version, err)
+		}
+		if dstSM != nil {
+			snap, err := stack.DeserializeUntypedDeployment(ctx, deployment, stack.DefaultSecretsProvider)
+			if err != nil {
+				return fmt.Errorf("loading version %d: %w", update.Version, err)
+			}
+			if deployment, err = reserializeDeployment(snap, dstSM); err != nil {
+				return err
+			}
+
+			dec := srcDec
+			if snap.SecretsManager != nil {
+				if dec, err = snap.SecretsManager.Decrypter(); err != nil {
+					return err
+				}
+			}
+			if update.Config, err = update.Config.Copy(dec, dstEnc); err != nil {
+				return fmt.Errorf("re-encrypting configuration of version %d: %w", update.Version, err)
+			}
+		}
+
+		if err := importer.ImportUpdate(ctx, dstStack, update, deployment); err != nil {
+			return fmt.Errorf("importing version %d: %w", update.Version, err)
+		}
+		if err := m.progress.record(migrateProgressRecord{Stack: key, Version: update.Version}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// verify checks that the target stack has the same state, tags and history as the source stack.
+func (m *stackMigrator) verify(ctx context.Context, srcStack, dstStack backend.Stack, want *deploy.Snapshot) error {
+	exported, err := dstStack.ExportDeployment(ctx)
+	if err != nil {
+		return err
+	}
+	got, err := stack.DeserializeUntypedDeployment(ctx, exported, stack.DefaultSecretsProvider)
+	if err != nil {
+		return err
+	}
+
+	// Compare the decrypted states, since they may have been encrypted differently.
+	plaintext := func(snap *deploy.Snapshot) ([]byte, error) {
+		deployment, err := stack.SerializeDeployment(snap, nil, true /* showSecrets */)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal([]interface{}{deployment.Resources, deployment.PendingOperations})
+	}
+	wantBytes, err := plaintext(want)
+	if err != nil {
+		return err
+	}
+	gotBytes, err := plaintext(got)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(wantBytes, gotBytes) {
+		return errors.New("the copied state doesn't match")
+	}
+
+	if m.dst.SupportsTags() {
+		dstStack, err = m.dst.GetStack(ctx, dstStack.Ref())
+		if err != nil {
+			return err
+		}
+		for k, v := range srcStack.Tags() {
+			if dstStack.Tags()[k] != v {
+				return fmt.Errorf("the copied tag %s doesn't match", k)
+			}
+		}
+	}
+
+	if _, ok := m.dst.(backend.HistoryImporter); ok {
+		srcHistory, err := m.src.GetHistory(ctx, srcStack.Ref(), 0 /*pageSize*/, 0 /*page*/)
+		if err != nil {
+			return err
+		}
+		dstHistory, err := m.dst.GetHistory(ctx, dstStack.Ref(), 0 /*pageSize*/, 0 /*page*/)
+		if err != nil {
+			return err
+		}
+		if len(srcHistory) != len(dstHistory) {
+			return fmt.Errorf("the copied history has %d updates rather than %d", len(dstHistory), len(srcHistory))
+		}
+		for i := range srcHistory {
+			if srcHistory[i].Version != dstHistory[i].Version ||
+				!reflect.DeepEqual(srcHistory[i].ResourceChanges, dstHistory[i].ResourceChanges) {
+				return fmt.Errorf("the copied version %d doesn't match", srcHistory[i].Version)
+			}
+		}
+	}
+	return nil
+}
+
+func newStackMigrateCmd() *cobra.Command {
+	var from string
+	var to string
+	var targetOrg string
+	var progressPath string
+	var yes bool
+
+	cmd := &cobra.Command{
+		Use:   "migrate [stack-glob...]",
+		Short: "Copy stacks, along with their history, from one backend to another",
+		Long: `Copy stacks, along with their history, from one backend to another
+
+This command copies each stack in the --from backend whose name matches any of the given globs,
+or every stack if there are none, to the --to backend. The stack's current state, update history,
+tags and configuration are copied, and the copy is verified against the original. The stacks in
+the --from backend are left unchanged.
+
+Stacks that use the Pulumi Cloud to encrypt their secrets are given the target backend's default
+secrets provider, and their secrets are re-encrypted with it. For stacks of the current project,
+this includes the secrets in their configuration files. The re-encrypted configuration is saved
+to Pulumi.<stack>.migrated.yaml, and Pulumi.<stack>.yaml is left unchanged for the stack in the
+--from backend; replace it with the migrated file once you use the stack in the --to backend.
+
+Progress is written to a log, so that a migration that's interrupted can be resumed by running
+the command again. The Pulumi Cloud backends must have been logged into with 'pulumi login'.`,
+		Args: cmdutil.ArgsFunc(cobra.ArbitraryArgs),
+		Run: cmdutil.RunResultFunc(func(cmd *cobra.Command, args []string) result.Result {
+			ctx := commandContext()
+			opts := display.Options{
+				Color: cmdutil.GetGlobalColorization(),
+			}
+			yes = yes || skipConfirmations()
+
+			if from == "" || to == "" {
+				return result.FromError(errors.New("--from and --to must be specified"))
+			}
+			if from == to {
+				return result.FromError(errors.New("--from and --to must be different backends"))
+			}
+
+			project, _, err := readProject()
+			if err != nil && !errors.Is(err, workspace.ErrProjectNotFound) {
+				return result.FromError(err)
+			}
+			src, err := migrateBackend(ctx, from, project)
+			if err != nil {
+				return result.FromError(err)
+			}
+			dst, err := migrateBackend(ctx, to, project)
+			if err != nil {
+				return result.FromError(err)
+			}
+
+			if targetOrg == "" {
+				if !dst.SupportsOrganizations() {
+					targetOrg = "organization"
+				} else if targetOrg, _, err = dst.CurrentUser(); err != nil {
+					return result.FromError(err)
+				}
+			}
+
+			if progressPath == "" {
+				if progressPath, err = defaultMigrateProgressPath(from, to); err != nil {
+					return result.FromError(err)
+				}
+			}
+			progress, err := openMigrateProgress(progressPath, from, to)
+			if err != nil {
+				return result.FromError(err)
+			}
+			defer contract.IgnoreClose(progress)
+
+			m := &stackMigrator{src: src, dst: dst, targetOrg: targetOrg, project: project, progress: progress}
+			refs, err := m.selectStacks(ctx, args)
+			if err != nil {
+				return result.FromError(err)
+			}
+			if len(refs) == 0 {
+				fmt.Println("No stacks to migrate")
+				return nil
+			}
+
+			if !yes && cmdutil.Interactive() {
+				prompt := fmt.Sprintf("This command will copy %d stack(s) from %s to %s. Confirm?",
+					len(refs), src.Name(), dst.Name())
+				if !confirmPrompt(prompt, "yes", opts) {
+					fmt.Println("confirmation declined")
+					return result.Bail()
+				}
+			}
+
+			var failed int
+			for _, ref := range refs {
+				if progress.done[ref.FullyQualifiedName().String()] {
+					fmt.Printf("Stack %s has already been migrated\n", ref)
+					continue
+				}
+				if err := m.migrateStack(ctx, ref); err != nil {
+					cmdutil.Diag().Errorf(diag.Message("", "Could not migrate stack %s: %v"), ref, err)
+					failed++
+					continue
+				}
+				fmt.Printf("Migrated stack %s to %s\n", ref, m.targetName(ref))
+			}
+
+			if failed > 0 {
+				return result.Errorf("%d of %d stack(s) could not be migrated; progress has been saved to %s, "+
+					"run the command again to resume", failed, len(refs), progressPath)
+			}
+			return nil
+		}),
+	}
+
+	cmd.Flags().StringVar(
+		&from, "from", "", "The URL of the backend to copy stacks from")
+	cmd.Flags().StringVar(
+		&to, "to", "", "The URL of the backend to copy stacks to")
+	cmd.Flags().StringVar(
+		&targetOrg, "target-org", "",
+		"The organization to copy stacks to, for backends with organizations. Defaults to the current user")
+	cmd.Flags().StringVar(
+		&progressPath, "progress-file", "",
+		"The path of the progress log. Defaults to a file under ~/.pulumi/migrations for the two backends")
+	cmd.Flags().BoolVarP(
+		&yes, "yes", "y", false, "Skip confirmation prompts")
+	return cmd
+}
@@ -0,0 +1,179 @@
+// Copyright 2016-2023, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pulumi/pulumi/pkg/v3/backend"
+	"github.com/pulumi/pulumi/pkg/v3/backend/filestate"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/apitype"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/diag"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/diag/colors"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/resource"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/tokens"
+)
+
+func newMigrateTestBackend(t *testing.T) backend.Backend {
+	t.Helper()
+
+	sink := diag.DefaultSink(os.Stdout, os.Stderr, diag.FormatOptions{Color: colors.Never})
+	b, err := filestate.New(context.Background(), sink, "file://"+filepath.ToSlash(t.TempDir()), nil)
+	require.NoError(t, err)
+	return b
+}
+
+func newMigrateTestDeployment(t *testing.T, names ...string) *apitype.UntypedDeployment {
+	t.Helper()
+
+	var resources []apitype.ResourceV3
+	for _, name := range names {
+		resources = append(resources, apitype.ResourceV3{
+			URN:  resource.NewURN("dev", "proj", "", "a:b:c", tokens.QName(name)),
+			Type: "a:b:c",
+		})
+	}
+	data, err := json.Marshal(apitype.DeploymentV3{Resources: resources})
+	require.NoError(t, err)
+	return &apitype.UntypedDeployment{Version: 3, Deployment: data}
+}
+
+func TestStackMigrate(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	src, dst := newMigrateTestBackend(t), newMigrateTestBackend(t)
+
+	// Set up a stack with some history and tags in the source backend.
+	for _, name := range []string{"organization/proj/dev", "organization/other/dev"} {
+		ref, err := src.ParseStackReference(name)
+		require.NoError(t, err)
+		_, err = src.CreateStack(ctx, ref, "", nil)
+		require.NoError(t, err)
+	}
+	srcRef, err := src.ParseStackReference("organization/proj/dev")
+	require.NoError(t, err)
+	srcStack, err := src.GetStack(ctx, srcRef)
+	require.NoError(t, err)
+	require.NoError(t, src.UpdateStackTags(ctx, srcStack, map[apitype.StackTagName]string{"team": "infra"}))
+	for i, names := range [][]string{{"a"}, {"a", "b"}} {
+		update := backend.UpdateInfo{Kind: apitype.UpdateUpdate, Version: i + 1, Result: backend.SucceededResult}
+		require.NoError(t, src.(backend.HistoryImporter).ImportUpdate(
+			ctx, srcStack, update, newMigrateTestDeployment(t, names...)))
+	}
+	require.NoError(t, src.ImportDeployment(ctx, srcStack, newMigrateTestDeployment(t, "a", "b")))
+
+	progressPath := filepath.Join(t.TempDir(), "progress.log")
+	progress, err := openMigrateProgress(progressPath, "from", "to")
+	require.NoError(t, err)
+	m := &stackMigrator{src: src, dst: dst, targetOrg: "organization", progress: progress}
+
+	refs, err := m.selectStacks(ctx, []string{"organization/proj/*"})
+	require.NoError(t, err)
+	require.Len(t, refs, 1)
+	assert.Equal(t, srcRef.FullyQualifiedName(), refs[0].FullyQualifiedName())
+
+	require.NoError(t, m.migrateStack(ctx, refs[0]))
+	require.NoError(t, progress.Close())
+
+	dstRef, err := dst.ParseStackReference("organization/proj/dev")
+	require.NoError(t, err)
+	dstStack, err := dst.GetStack(ctx, dstRef)
+	require.NoError(t, err)
+	require.NotNil(t, dstStack)
+	assert.Equal(t, "infra", dstStack.Tags()["team"])
+
+	history, err := dst.GetHistory(ctx, dstRef, 0, 0)
+	require.NoError(t, err)
+	require.Len(t, history, 2)
+	assert.Equal(t, 2, history[0].Version)
+	assert.Equal(t, 1, history[1].Version)
+
+	udep, err := dst.ExportDeployment(ctx, dstStack)
+	require.NoError(t, err)
+	var dep apitype.DeploymentV3
+	require.NoError(t, json.Unmarshal(udep.Deployment, &dep))
+	assert.Len(t, dep.Resources, 2)
+
+	// The progress log records the stack as done, so a resumed migration skips it.
+	progress, err = openMigrateProgress(progressPath, "from", "to")
+	require.NoError(t, err)
+	defer progress.Close()
+	assert.True(t, progress.done[srcRef.FullyQualifiedName().String()])
+	assert.True(t, progress.history[srcRef.FullyQualifiedName().String()][2])
+
+	// A migration interrupted after importing the history, but before recording it, doesn't import it again.
+	m = &stackMigrator{src: src, dst: dst, targetOrg: "organization", progress: &migrateProgress{
+		file:    progress.file,
+		started: map[string]bool{srcRef.FullyQualifiedName().String(): true}, config: map[string]bool{},
+		history: map[string]map[int]bool{}, done: map[string]bool{},
+	}}
+	require.NoError(t, m.migrateStack(ctx, srcRef))
+	history, err = dst.GetHistory(ctx, dstRef, 0, 0)
+	require.NoError(t, err)
+	assert.Len(t, history, 2)
+
+	// Stacks that the migration didn't create are never overwritten.
+	m = &stackMigrator{src: src, dst: dst, targetOrg: "organization", progress: &migrateProgress{
+		started: map[string]bool{}, config: map[string]bool{}, history: map[string]map[int]bool{},
+		done: map[string]bool{},
+	}}
+	err = m.migrateStack(ctx, srcRef)
+	assert.ErrorContains(t, err, "already exists")
+}
+
+func TestOpenMigrateProgress(t *testing.T) {
+	t.Parallel()
+
+	progressPath := filepath.Join(t.TempDir(), "progress.log")
+	progress, err := openMigrateProgress(progressPath, "from", "to")
+	require.NoError(t, err)
+	require.NoError(t, progress.record(migrateProgressRecord{Stack: "a", Version: 1}))
+	require.NoError(t, progress.record(migrateProgressRecord{Stack: "b", Done: true}))
+	require.NoError(t, progress.Close())
+
+	// A line cut short by an interrupted migration is ignored.
+	f, err := os.OpenFile(progressPath, os.O_APPEND|os.O_WRONLY, 0o600)
+	require.NoError(t, err)
+	_, err = f.WriteString(`{"stack":"a","vers`)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	progress, err = openMigrateProgress(progressPath, "from", "to")
+	require.NoError(t, err)
+	assert.True(t, progress.started["a"])
+	assert.True(t, progress.history["a"][1])
+	assert.False(t, progress.done["a"])
+	assert.True(t, progress.done["b"])
+
+	// And the next record starts on a new line.
+	require.NoError(t, progress.record(migrateProgressRecord{Stack: "a", Version: 2}))
+	require.NoError(t, progress.Close())
+	progress, err = openMigrateProgress(progressPath, "from", "to")
+	require.NoError(t, err)
+	assert.True(t, progress.history["a"][2])
+	require.NoError(t, progress.Close())
+
+	// The log can't be used for a migration between other backends.
+	_, err = openMigrateProgress(progressPath, "from", "elsewhere")
+	assert.ErrorContains(t, err, "is for a migration from from to to")
+}
@@ -24,6 +24,7 @@ import (
 	"sort"
 	"strings"
 
+	"github.com/gofrs/uuid"
 	"github.com/kballard/go-shellquote"
 	"github.com/spf13/cobra"
 
@@ -31,6 +32,7 @@ import (
 	"github.com/pulumi/pulumi/pkg/v3/backend/display"
 	"github.com/pulumi/pulumi/pkg/v3/resource/deploy"
 	"github.com/pulumi/pulumi/pkg/v3/resource/stack"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/encoding"
 	"github.com/pulumi/pulumi/sdk/v3/go/common/resource/config"
 	"github.com/pulumi/pulumi/sdk/v3/go/common/util/cmdutil"
 )
@@ -44,7 +46,23 @@ func newStackOutputCmd() *cobra.Command {
 		Long: "Show a stack's output properties.\n" +
 			"\n" +
 			"By default, this command lists all output properties exported from a stack.\n" +
-			"If a specific property-name is supplied, just that property's value is shown.",
+			"If a specific property-name is supplied, just that property's value is shown.\n" +
+			"\n" +
+			"Use --format to emit the outputs in a form other programs can consume:\n" +
+			"\n" +
+			"  - json: a JSON object, the same as --json\n" +
+			"  - yaml: a YAML document\n" +
+			"  - dotenv: the lines of a .env file\n" +
+			"  - shell: shell statements that export environment variables;\n" +
+			"    PowerShell statements on Windows\n" +
+			"  - github-actions: lines to append to the file named by $GITHUB_OUTPUT\n" +
+			"\n" +
+			"The dotenv, shell and github-actions formats set one variable per value. Nested objects and arrays\n" +
+			"are flattened, with the variable for each value named by the path to it, joined with underscores.\n" +
+			"For example, an output 'db' with the value {\"hosts\": [\"a\"]} is emitted as 'db_hosts_0=a'.\n" +
+			"Characters other than letters, digits and underscores are replaced with underscores in the names.\n" +
+			"\n" +
+			"Secret outputs are shown as [secret] unless --show-secrets is passed.",
 		Run: cmdutil.RunFunc(func(cmd *cobra.Command, args []string) error {
 			return socmd.Run(commandContext(), args)
 		}),
@@ -54,6 +72,9 @@ func newStackOutputCmd() *cobra.Command {
 		&socmd.jsonOut, "json", "j", false, "Emit output as JSON")
 	cmd.PersistentFlags().BoolVar(
 		&socmd.shellOut, "shell", false, "Emit output as a shell script")
+	cmd.PersistentFlags().StringVar(
+		&socmd.format, "format", "",
+		"Emit output in the given format: json, yaml, dotenv, shell or github-actions")
 	cmd.PersistentFlags().StringVarP(
 		&socmd.stackName, "stack", "s", "", "The name of the stack to operate on. Defaults to the current stack")
 	cmd.PersistentFlags().BoolVar(
@@ -67,6 +88,7 @@ type stackOutputCmd struct {
 	showSecrets bool
 	jsonOut     bool
 	shellOut    bool
+	format      string
 
 	OS string // defaults to runtime.GOOS
 
@@ -101,10 +123,17 @@ func (cmd *stackOutputCmd) Run(ctx context.Context, args []string) error {
 	var outw stackOutputWriter
 	if cmd.shellOut && cmd.jsonOut {
 		return errors.New("only one of --json and --shell may be set")
+	} else if cmd.format != "" && (cmd.jsonOut || cmd.shellOut) {
+		return errors.New("--format may not be used with --json or --shell")
 	} else if cmd.jsonOut {
 		outw = &jsonStackOutputWriter{W: stdout}
 	} else if cmd.shellOut {
 		outw = newShellStackOutputWriter(stdout, osys)
+	} else if cmd.format != "" {
+		var err error
+		if outw, err = newFormattedStackOutputWriter(stdout, cmd.format, osys); err != nil {
+			return err
+		}
 	} else {
 		outw = &consoleStackOutputWriter{W: stdout}
 	}
@@ -259,6 +288,210 @@ func (w *powershellStackOutputWriter) WriteMany(outputs map[string]interface{})
 	return nil
 }
 
+// newFormattedStackOutputWriter builds the stackOutputWriter for the given --format.
+func newFormattedStackOutputWriter(w io.Writer, format, os string) (stackOutputWriter, error) {
+	switch format {
+	case "json":
+		return &jsonStackOutputWriter{W: w}, nil
+	case "yaml":
+		return &yamlStackOutputWriter{W: w}, nil
+	case "dotenv":
+		return &variableStackOutputWriter{W: w, format: formatDotenvVariable}, nil
+	case "shell":
+		if os == "windows" {
+			return &variableStackOutputWriter{W: w, format: formatPowershellEnvVariable}, nil
+		}
+		return &variableStackOutputWriter{W: w, format: formatBashEnvVariable}, nil
+	case "github-actions":
+		return &variableStackOutputWriter{W: w, format: formatGitHubActionsOutput}, nil
+	default:
+		return nil, fmt.Errorf("unknown output format %q; "+
+			"the supported formats are json, yaml, dotenv, shell and github-actions", format)
+	}
+}
+
+// yamlStackOutputWriter writes stack outputs as a YAML document.
+type yamlStackOutputWriter struct {
+	W io.Writer
+}
+
+var _ stackOutputWriter = (*yamlStackOutputWriter)(nil)
+
+func (w *yamlStackOutputWriter) WriteOne(_ string, v interface{}) error {
+	return w.write(v)
+}
+
+func (w *yamlStackOutputWriter) WriteMany(outputs map[string]interface{}) error {
+	return w.write(outputs)
+}
+
+func (w *yamlStackOutputWriter) write(v interface{}) error {
+	b, err := encoding.YAML.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = w.W.Write(b)
+	return err
+}
+
+// variableStackOutputWriter writes stack outputs as variables, one per line (or more, for values that span lines),
+// flattening nested objects and arrays into a variable for each of their values.
+type variableStackOutputWriter struct {
+	W io.Writer
+
+	// format formats the assignment of a value to a variable, including the trailing newline.
+	format func(name, value string) (string, error)
+}
+
+var _ stackOutputWriter = (*variableStackOutputWriter)(nil)
+
+func (w *variableStackOutputWriter) WriteOne(k string, v interface{}) error {
+	return w.WriteMany(map[string]interface{}{k: v})
+}
+
+func (w *variableStackOutputWriter) WriteMany(outputs map[string]interface{}) error {
+	vars, err := flattenStackOutputs(outputs)
+	if err != nil {
+		return err
+	}
+	for _, v := range vars {
+		s, err := w.format(v.name, v.value)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(w.W, s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stackOutputVariable is a variable holding one of the values of the stack's outputs.
+type stackOutputVariable struct {
+	name  string
+	value string
+}
+
+// flattenStackOutputs turns stack outputs into variables, ordered by name. Nested objects and arrays are flattened,
+// with the variable for each value named by the path to it, joined with underscores. Characters other than letters,
+// digits and underscores are replaced with underscores, so that the names are valid environment variable names.
+func flattenStackOutputs(outputs map[string]interface{}) ([]stackOutputVariable, error) {
+	values := make(map[string]string)
+	paths := make(map[string]string) // the paths of the values, to report collisions.
+
+	var flatten func(name, path string, v interface{}) error
+	flatten = func(name, path string, v interface{}) error {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			if len(v) > 0 {
+				for k, e := range v {
+					if err := flatten(name+"_"+sanitizeVariableName(k), path+"."+k, e); err != nil {
+						return err
+					}
+				}
+				return nil
+			}
+		case []interface{}:
+			if len(v) > 0 {
+				for i, e := range v {
+					if err := flatten(fmt.Sprintf("%s_%d", name, i), fmt.Sprintf("%s[%d]", path, i), e); err != nil {
+						return err
+					}
+				}
+				return nil
+			}
+		}
+
+		if other, has := paths[name]; has {
+			return fmt.Errorf("outputs %q and %q would both be written to the variable %q", other, path, name)
+		}
+		paths[name] = path
+		if v == nil {
+			values[name] = ""
+		} else {
+			values[name] = stringifyOutput(v)
+		}
+		return nil
+	}
+
+	for k, v := range outputs {
+		name := sanitizeVariableName(k)
+		if len(name) > 0 && name[0] >= '0' && name[0] <= '9' {
+			name = "_" + name
+		}
+		if err := flatten(name, k, v); err != nil {
+			return nil, err
+		}
+	}
+
+	vars := make([]stackOutputVariable, 0, len(values))
+	for name, value := range values {
+		vars = append(vars, stackOutputVariable{name: name, value: value})
+	}
+	sort.Slice(vars, func(i, j int) bool { return vars[i].name < vars[j].name })
+	return vars, nil
+}
+
+// sanitizeVariableName replaces the characters of a name that can't appear in environment variable names.
+func sanitizeVariableName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// formatDotenvVariable formats a variable as a line of a .env file. Values are quoted only when they need to be, with
+// single quotes if possible, as the contents of single quotes are taken verbatim. Values with single quotes or line
+// breaks are double quoted, escaping backslashes, double quotes and line breaks.
+func formatDotenvVariable(name, value string) (string, error) {
+	plain := strings.IndexFunc(value, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("_-.,:/@+", r))
+	}) < 0
+	switch {
+	case plain:
+		return fmt.Sprintf("%s=%s\n", name, value), nil
+	case !strings.ContainsAny(value, "'\r\n"):
+		return fmt.Sprintf("%s='%s'\n", name, value), nil
+	default:
+		value = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`).Replace(value)
+		return fmt.Sprintf("%s=\"%s\"\n", name, value), nil
+	}
+}
+
+// formatBashEnvVariable formats a variable as a shell statement that exports it to the environment.
+func formatBashEnvVariable(name, value string) (string, error) {
+	return fmt.Sprintf("export %s=%s\n", name, shellquote.Join(value)), nil
+}
+
+// formatPowershellEnvVariable formats a variable as a PowerShell statement that sets an environment variable.
+func formatPowershellEnvVariable(name, value string) (string, error) {
+	// In Powershell, single-quoted strings are taken verbatim.
+	// The only escaping necessary is to ' itself:
+	// replace each instance with two to escape.
+	return fmt.Sprintf("$env:%s = '%s'\n", name, strings.ReplaceAll(value, "'", "''")), nil
+}
+
+// formatGitHubActionsOutput formats a variable as an output of a GitHub Actions step, to be appended to the file
+// named by $GITHUB_OUTPUT. Values that span lines are delimited by a random string that doesn't appear in them.
+func formatGitHubActionsOutput(name, value string) (string, error) {
+	if !strings.ContainsAny(value, "\r\n") {
+		return fmt.Sprintf("%s=%s\n", name, value), nil
+	}
+
+	for {
+		id, err := uuid.NewV4()
+		if err != nil {
+			return "", err
+		}
+		delimiter := "ghadelimiter_" + id.String()
+		if !strings.Contains(value, delimiter) {
+			return fmt.Sprintf("%s<<%s\n%s\n%s\n", name, delimiter, value, delimiter), nil
+		}
+	}
+}
+
 func getStackOutputs(snap *deploy.Snapshot, showSecrets bool) (map[string]interface{}, error) {
 	state, err := stack.GetRootStackResource(snap)
 	if err != nil {
@@ -485,3 +485,255 @@ func TestShellStackOutputWriter_quoting(t *testing.T) {
 		})
 	}
 }
+
+// Tests the output of 'pulumi stack output --format'
+// under different conditions.
+func TestStackOutputCmd_format(t *testing.T) {
+	t.Parallel()
+
+	outputs := resource.PropertyMap{
+		"bucketName": resource.NewStringProperty("mybucket-1234"),
+		"password": resource.NewSecretProperty(&resource.Secret{
+			Element: resource.NewStringProperty("hunter2"),
+		}),
+		"db": resource.NewObjectProperty(resource.PropertyMap{
+			"hosts": resource.NewArrayProperty([]resource.PropertyValue{
+				resource.NewStringProperty("a.example.com"),
+				resource.NewStringProperty("b.example.com"),
+			}),
+			"port": resource.NewNumberProperty(5432),
+		}),
+	}
+
+	tests := []struct {
+		desc string
+
+		format      string
+		showSecrets bool
+		os          string
+		args        []string
+
+		want string
+	}{
+		{
+			desc:   "yaml",
+			format: "yaml",
+			want: "bucketName: mybucket-1234\n" +
+				"db:\n" +
+				"  hosts:\n" +
+				"    - a.example.com\n" +
+				"    - b.example.com\n" +
+				"  port: 5432\n" +
+				"password: '[secret]'\n",
+		},
+		{
+			desc:   "yaml single property",
+			format: "yaml",
+			args:   []string{"bucketName"},
+			want:   "mybucket-1234\n",
+		},
+		{
+			desc:   "dotenv",
+			format: "dotenv",
+			want: "bucketName=mybucket-1234\n" +
+				"db_hosts_0=a.example.com\n" +
+				"db_hosts_1=b.example.com\n" +
+				"db_port=5432\n" +
+				"password='[secret]'\n",
+		},
+		{
+			desc:        "dotenv with show-secrets",
+			format:      "dotenv",
+			showSecrets: true,
+			args:        []string{"password"},
+			want:        "password=hunter2\n",
+		},
+		{
+			desc:   "shell",
+			format: "shell",
+			args:   []string{"db"},
+			want: "export db_hosts_0=a.example.com\n" +
+				"export db_hosts_1=b.example.com\n" +
+				"export db_port=5432\n",
+		},
+		{
+			desc:   "shell on windows",
+			format: "shell",
+			os:     "windows",
+			args:   []string{"password"},
+			want:   "$env:password = '[secret]'\n",
+		},
+		{
+			desc:   "github-actions",
+			format: "github-actions",
+			args:   []string{"db"},
+			want: "db_hosts_0=a.example.com\n" +
+				"db_hosts_1=b.example.com\n" +
+				"db_port=5432\n",
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.desc, func(t *testing.T) {
+			t.Parallel()
+
+			snap := deploy.Snapshot{
+				Resources: []*resource.State{
+					{
+						Type:    resource.RootStackType,
+						Outputs: outputs,
+					},
+				},
+			}
+			requireStack := func(context.Context,
+				string, stackLoadOption, display.Options,
+			) (backend.Stack, error) {
+				return &backend.MockStack{
+					SnapshotF: func(_ context.Context, _ secrets.Provider) (*deploy.Snapshot, error) {
+						return &snap, nil
+					},
+				}, nil
+			}
+
+			osys := tt.os
+			if len(osys) == 0 {
+				osys = "linux"
+			}
+
+			var stdoutBuff bytes.Buffer
+			cmd := stackOutputCmd{
+				requireStack: requireStack,
+				showSecrets:  tt.showSecrets,
+				format:       tt.format,
+				OS:           osys,
+				Stdout:       &stdoutBuff,
+			}
+			require.NoError(t, cmd.Run(context.Background(), tt.args))
+			assert.Equal(t, tt.want, stdoutBuff.String())
+		})
+	}
+}
+
+func TestStackOutputCmd_formatErrors(t *testing.T) {
+	t.Parallel()
+
+	requireStack := func(context.Context, string, stackLoadOption, display.Options) (backend.Stack, error) {
+		t.Fatal("This function should not be called")
+		return nil, errors.New("should not be called")
+	}
+
+	cmd := stackOutputCmd{requireStack: requireStack, format: "yaml", jsonOut: true}
+	err := cmd.Run(context.Background(), nil)
+	assert.ErrorContains(t, err, "--format may not be used with --json or --shell")
+
+	cmd = stackOutputCmd{requireStack: requireStack, format: "toml"}
+	err = cmd.Run(context.Background(), nil)
+	assert.ErrorContains(t, err, `unknown output format "toml"`)
+}
+
+func TestFlattenStackOutputs(t *testing.T) {
+	t.Parallel()
+
+	vars, err := flattenStackOutputs(map[string]interface{}{
+		"site-url": "https://example.com",
+		"1st":      true,
+		"empty":    map[string]interface{}{},
+		"missing":  nil,
+		"nested": map[string]interface{}{
+			"list": []interface{}{
+				map[string]interface{}{"a.b": 1},
+			},
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []stackOutputVariable{
+		{name: "_1st", value: "true"},
+		{name: "empty", value: "{}"},
+		{name: "missing", value: ""},
+		{name: "nested_list_0_a_b", value: "1"},
+		{name: "site_url", value: "https://example.com"},
+	}, vars)
+
+	_, err = flattenStackOutputs(map[string]interface{}{
+		"a_b": 1,
+		"a":   map[string]interface{}{"b": 2},
+	})
+	assert.ErrorContains(t, err, `would both be written to the variable "a_b"`)
+}
+
+func TestVariableStackOutputWriter_quoting(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		desc       string
+		give       string
+		wantDotenv string
+		wantShell  string
+	}{
+		{
+			desc:       "plain",
+			give:       "foo-1.2",
+			wantDotenv: "foo-1.2",
+			wantShell:  "foo-1.2",
+		},
+		{
+			desc:       "empty",
+			give:       "",
+			wantDotenv: "",
+			wantShell:  "''",
+		},
+		{
+			desc:       "spaces and double quotes",
+			give:       `foo "bar" $baz`,
+			wantDotenv: `'foo "bar" $baz'`,
+			wantShell:  `'foo "bar" $baz'`,
+		},
+		{
+			desc:       "single quotes",
+			give:       `it's`,
+			wantDotenv: `"it's"`,
+			wantShell:  `it\'s`,
+		},
+		{
+			desc:       "newlines",
+			give:       "line 1\nline \"2\" \\",
+			wantDotenv: `"line 1\nline \"2\" \\"`,
+			wantShell:  "'line 1\nline \"2\" \\'",
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.desc, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := formatDotenvVariable("myoutput", tt.give)
+			require.NoError(t, err)
+			assert.Equal(t, "myoutput="+tt.wantDotenv+"\n", got)
+
+			got, err = formatBashEnvVariable("myoutput", tt.give)
+			require.NoError(t, err)
+			assert.Equal(t, "export myoutput="+tt.wantShell+"\n", got)
+		})
+	}
+}
+
+func TestFormatGitHubActionsOutput(t *testing.T) {
+	t.Parallel()
+
+	got, err := formatGitHubActionsOutput("myoutput", "a=b")
+	require.NoError(t, err)
+	assert.Equal(t, "myoutput=a=b\n", got)
+
+	// Values that span lines are written with a delimiter that doesn't appear in them.
+	got, err = formatGitHubActionsOutput("myoutput", "line 1\nline 2")
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSuffix(got, "\n"), "\n")
+	require.Len(t, lines, 4)
+	name, delimiter, ok := strings.Cut(lines[0], "<<")
+	require.True(t, ok)
+	assert.Equal(t, "myoutput", name)
+	assert.True(t, strings.HasPrefix(delimiter, "ghadelimiter_"))
+	assert.Equal(t, []string{"line 1", "line 2", delimiter}, lines[1:])
+}
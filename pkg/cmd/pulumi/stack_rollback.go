@@ -0,0 +1,111 @@
+// Copyright 2016-2023, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/pulumi/pulumi/pkg/v3/backend"
+	"github.com/pulumi/pulumi/pkg/v3/backend/display"
+	"github.com/pulumi/pulumi/pkg/v3/resource/stack"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/cmdutil"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/result"
+)
+
+func newStackRollbackCmd() *cobra.Command {
+	var stackName string
+	var version string
+	var yes bool
+
+	cmd := &cobra.Command{
+		Use:   "rollback",
+		Short: "Restore a stack's state to that of a previous version",
+		Long: `Restore a stack's state to that of a previous version
+
+This command replaces the stack's current state with the state recorded after a previous update,
+as numbered by 'pulumi stack history'. The changes to the stack's state are shown before they're
+made, and the rollback is recorded as a new entry in the stack's history.
+
+Only the stack's state is restored; the stack's resources are not changed. Run 'pulumi refresh'
+or 'pulumi up' afterwards to bring the resources in line with the restored state.`,
+		Args: cmdutil.NoArgs,
+		Run: cmdutil.RunResultFunc(func(cmd *cobra.Command, args []string) result.Result {
+			ctx := commandContext()
+			opts := display.Options{
+				Color: cmdutil.GetGlobalColorization(),
+			}
+			yes = yes || skipConfirmations()
+
+			if version == "" {
+				return result.FromError(errors.New("--version must be specified"))
+			}
+
+			s, err := requireStack(ctx, stackName, stackLoadOnly, opts)
+			if err != nil {
+				return result.FromError(err)
+			}
+			be := s.Backend()
+			rollbacker, ok := be.(backend.DeploymentRollbacker)
+			if !ok {
+				return result.Errorf("the current backend (%s) does not provide the ability to roll back stacks",
+					be.Name())
+			}
+
+			target, err := loadStackVersion(ctx, s, version)
+			if err != nil {
+				return result.FromError(err)
+			}
+			current, err := s.Snapshot(ctx, stack.DefaultSecretsProvider)
+			if err != nil {
+				return result.FromError(err)
+			}
+
+			diffs := diffSnapshots(current, target, false /* showSecrets */)
+			if len(diffs) == 0 {
+				fmt.Printf("The state of stack %s already matches version %s\n", s.Ref(), version)
+				return nil
+			}
+			printHistoryDiffConsole(diffs, opts)
+			fmt.Println()
+
+			if !yes && cmdutil.Interactive() {
+				prompt := fmt.Sprintf("This command will replace the state of stack %s with version %s. Confirm?",
+					s.Ref(), version)
+				if !confirmStateEdit(opts, prompt) {
+					fmt.Println("confirmation declined")
+					return result.Bail()
+				}
+			}
+
+			if err := rollbacker.RollbackDeployment(ctx, s, version); err != nil {
+				return result.FromError(fmt.Errorf("rolling back stack: %w", err))
+			}
+			fmt.Printf("Rolled back stack %s to version %s\n", s.Ref(), version)
+			return nil
+		}),
+	}
+
+	cmd.PersistentFlags().StringVarP(
+		&stackName, "stack", "s", "",
+		"The name of the stack to operate on. Defaults to the current stack")
+	cmd.Flags().StringVar(
+		&version, "version", "", "The version of the stack's history to restore")
+	cmd.Flags().BoolVarP(
+		&yes, "yes", "y", false, "Skip confirmation prompts")
+	return cmd
+}
@@ -0,0 +1,223 @@
+// Copyright 2016-2023, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/pulumi/pulumi/pkg/v3/backend"
+	"github.com/pulumi/pulumi/pkg/v3/backend/display"
+	"github.com/pulumi/pulumi/pkg/v3/backend/filestate"
+	"github.com/pulumi/pulumi/pkg/v3/secrets"
+	"github.com/pulumi/pulumi/pkg/v3/secrets/multi"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/apitype"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/diag"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/cmdutil"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/workspace"
+)
+
+func newStackSecretsCmd() *cobra.Command {
+	var stack string
+
+	cmd := &cobra.Command{
+		Use:   "secrets",
+		Short: "Manage the recipients of a stack's secrets",
+		Long: "Manage the recipients of a stack's secrets\n" +
+			"\n" +
+			"A stack's secrets are encrypted with a data key, which can be wrapped separately for each of\n" +
+			"several recipients, any one of which can decrypt it. Recipients can be passphrases, age\n" +
+			"recipients or cloud keys, given in the same way as to `--secrets-provider`. Use the\n" +
+			"`add-recipient` and `remove-recipient` commands to change them without encrypting the\n" +
+			"stack's secrets again.\n",
+		Args: cmdutil.NoArgs,
+	}
+
+	cmd.PersistentFlags().StringVarP(
+		&stack, "stack", "s", "", "The name of the stack to operate on. Defaults to the current stack")
+
+	cmd.AddCommand(newStackSecretsAddRecipientCmd(&stack))
+	cmd.AddCommand(newStackSecretsRemoveRecipientCmd(&stack))
+
+	return cmd
+}
+
+func newStackSecretsAddRecipientCmd(stack *string) *cobra.Command {
+	return &cobra.Command{
+		Use:   "add-recipient <secrets-provider>",
+		Short: "Let another passphrase, age recipient or cloud key decrypt a stack's secrets",
+		Long: "Let another passphrase, age recipient or cloud key decrypt a stack's secrets\n" +
+			"\n" +
+			"The stack's data key is unlocked with one of its current recipients and wrapped for the new\n" +
+			"one. A stack that doesn't have recipients yet keeps its data key, and its current secrets\n" +
+			"provider becomes its first recipient. A stack can have several passphrases if they're given\n" +
+			"names, such as `passphrase://alice`.\n" +
+			"\n" +
+			"* `pulumi stack secrets add-recipient \"age://age1...\"`\n" +
+			"* `pulumi stack secrets add-recipient \"awskms://alias/ExampleAlias?region=us-east-1\"`\n" +
+			"* `pulumi stack secrets add-recipient passphrase://alice`",
+		Args: cmdutil.ExactArgs(1),
+		Run: cmdutil.RunFunc(func(cmd *cobra.Command, args []string) error {
+			ctx := commandContext()
+			provider := args[0]
+			if err := validateSecretsRecipient(provider); err != nil {
+				return err
+			}
+
+			return changeStackSecretsRecipients(ctx, *stack, func(s backend.Stack, ps *workspace.ProjectStack) (
+				secrets.Manager, error,
+			) {
+				// A stack whose secrets provider isn't set up yet gets its backend's default one, which it then shares.
+				if ps.SecretsProvider == "" && ps.EncryptionSalt == "" {
+					if _, _, err := getStackSecretsManager(s, ps); err != nil {
+						return nil, err
+					}
+				}
+
+				sm, err := multi.AddRecipient(ctx, ps, provider)
+				if err != nil {
+					return nil, err
+				}
+				fmt.Printf("Added recipient '%s' to stack '%s'\n", provider, s.Ref())
+				return sm, nil
+			})
+		}),
+	}
+}
+
+func newStackSecretsRemoveRecipientCmd(stack *string) *cobra.Command {
+	return &cobra.Command{
+		Use:   "remove-recipient <secrets-provider>",
+		Short: "Stop a passphrase, age recipient or cloud key from decrypting a stack's secrets",
+		Long: "Stop a passphrase, age recipient or cloud key from decrypting a stack's secrets\n" +
+			"\n" +
+			"The stack's data key doesn't change, and the copies of the stack's checkpoint kept in its\n" +
+			"history and backups still hold the key wrapped for the removed recipient. Anyone with the\n" +
+			"removed passphrase, age identity or cloud key can therefore still unwrap the key from those\n" +
+			"copies and decrypt the stack's secrets, as can anyone who has already decrypted it. To stop\n" +
+			"them, use `pulumi stack change-secrets-provider` afterwards, which replaces the data key.",
+		Args: cmdutil.ExactArgs(1),
+		Run: cmdutil.RunFunc(func(cmd *cobra.Command, args []string) error {
+			ctx := commandContext()
+			provider := args[0]
+
+			return changeStackSecretsRecipients(ctx, *stack, func(s backend.Stack, ps *workspace.ProjectStack) (
+				secrets.Manager, error,
+			) {
+				sm, err := multi.RemoveRecipient(ctx, ps, provider)
+				if err != nil {
+					return nil, err
+				}
+				fmt.Printf("Removed recipient '%s' from stack '%s'\n", provider, s.Ref())
+				cmdutil.Diag().Warningf(diag.RawMessage("" /*urn*/, fmt.Sprintf(
+					"the stack's data key hasn't changed, and is still wrapped for '%s' in the stack's history "+
+						"and backups; run `pulumi stack change-secrets-provider` to replace it", provider)))
+				return sm, nil
+			})
+		}),
+	}
+}
+
+// validateSecretsRecipient checks that a secrets provider can be one of the recipients of a stack's data key.
+func validateSecretsRecipient(provider string) error {
+	if provider == "default" {
+		return fmt.Errorf("the default secrets provider can't be a recipient; " +
+			"use passphrase, age recipients or the URL of a cloud key")
+	}
+	return validateSecretsProvider(provider)
+}
+
+// changeStackSecretsRecipients changes the recipients of a stack's data key in its config, and then records the new
+// secrets manager in the stack's checkpoint.
+func changeStackSecretsRecipients(
+	ctx context.Context, stackName string,
+	change func(s backend.Stack, ps *workspace.ProjectStack) (secrets.Manager, error),
+) error {
+	opts := display.Options{
+		Color: cmdutil.GetGlobalColorization(),
+	}
+
+	project, _, err := readProject()
+	if err != nil {
+		return err
+	}
+	s, err := requireStack(ctx, stackName, stackLoadOnly, opts)
+	if err != nil {
+		return err
+	}
+	ps, err := loadProjectStack(project, s)
+	if err != nil {
+		return err
+	}
+
+	sm, err := change(s, ps)
+	if err != nil {
+		return err
+	}
+	if err := saveProjectStack(s, ps); err != nil {
+		return fmt.Errorf("saving stack config: %w", err)
+	}
+	return saveCheckpointSecretsManager(ctx, s, sm)
+}
+
+// saveCheckpointSecretsManager records a new secrets manager in a stack's checkpoint, leaving the secrets in it as
+// they are. It's for secrets managers that encrypt with the same data key as the one they replace, so that the
+// checkpoint's secrets can be decrypted by whoever the new secrets manager lets decrypt them.
+func saveCheckpointSecretsManager(ctx context.Context, s backend.Stack, sm secrets.Manager) error {
+	checkpoint, err := s.ExportDeployment(ctx)
+	if err != nil {
+		return err
+	}
+	if checkpoint.Version != apitype.DeploymentSchemaVersionCurrent {
+		// Older checkpoints are upgraded with the stack's secrets manager on its next update.
+		return nil
+	}
+
+	var deployment apitype.DeploymentV3
+	if err := json.Unmarshal(checkpoint.Deployment, &deployment); err != nil {
+		return err
+	}
+	if deployment.SecretsProviders == nil {
+		// The checkpoint has never had any secrets.
+		return nil
+	}
+
+	state, err := json.Marshal(sm.State())
+	if err != nil {
+		return err
+	}
+	deployment.SecretsProviders = &apitype.SecretsProvidersV1{Type: sm.Type(), State: state}
+	bytes, err := json.Marshal(deployment)
+	if err != nil {
+		return err
+	}
+
+	dep := apitype.UntypedDeployment{
+		Version:    checkpoint.Version,
+		Deployment: bytes,
+	}
+	if err := s.ImportDeployment(ctx, &dep); err != nil {
+		return err
+	}
+
+	// Self-managed backends may encrypt the history and backups of the stack too.
+	if fb, ok := s.Backend().(filestate.Backend); ok {
+		return fb.ReencryptStack(ctx, s.Ref())
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+// Copyright 2016-2023, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pulumi/pulumi/pkg/v3/backend"
+	"github.com/pulumi/pulumi/pkg/v3/secrets/b64"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/apitype"
+)
+
+func TestSaveCheckpointSecretsManager(t *testing.T) {
+	t.Parallel()
+
+	resources := []apitype.ResourceV3{{
+		URN:  "urn:pulumi:dev::proj::pulumi:pulumi:Stack::proj-dev",
+		Type: "pulumi:pulumi:Stack",
+		Outputs: map[string]interface{}{
+			"password": map[string]interface{}{
+				"4dabf18193072939515e22adb298388d": "1b47061264138c4ac30d75fd1eb44270",
+				"ciphertext":                       "v1:AAAA:BBBB",
+			},
+		},
+	}}
+	newStack := func(deployment apitype.DeploymentV3, imported *apitype.UntypedDeployment) backend.Stack {
+		return &backend.MockStack{
+			BackendF: func() backend.Backend { return &backend.MockBackend{} },
+			ExportDeploymentF: func(context.Context) (*apitype.UntypedDeployment, error) {
+				bytes, err := json.Marshal(deployment)
+				require.NoError(t, err)
+				return &apitype.UntypedDeployment{Version: 3, Deployment: bytes}, nil
+			},
+			ImportDeploymentF: func(_ context.Context, dep *apitype.UntypedDeployment) error {
+				*imported = *dep
+				return nil
+			},
+		}
+	}
+
+	// The secrets manager is replaced, but the secrets are left as they are.
+	var imported apitype.UntypedDeployment
+	s := newStack(apitype.DeploymentV3{
+		SecretsProviders: &apitype.SecretsProvidersV1{Type: "passphrase", State: json.RawMessage(`{"salt":"v1:abc"}`)},
+		Resources:        resources,
+	}, &imported)
+	require.NoError(t, saveCheckpointSecretsManager(context.Background(), s, b64.NewBase64SecretsManager()))
+
+	assert.Equal(t, 3, imported.Version)
+	var deployment apitype.DeploymentV3
+	require.NoError(t, json.Unmarshal(imported.Deployment, &deployment))
+	assert.Equal(t, &apitype.SecretsProvidersV1{Type: b64.Type, State: json.RawMessage(`{}`)},
+		deployment.SecretsProviders)
+	assert.Equal(t, resources, deployment.Resources)
+
+	// A checkpoint without secrets is left alone.
+	imported = apitype.UntypedDeployment{}
+	s = newStack(apitype.DeploymentV3{}, &imported)
+	require.NoError(t, saveCheckpointSecretsManager(context.Background(), s, b64.NewBase64SecretsManager()))
+	assert.Nil(t, imported.Deployment)
+}
+
+func TestValidateSecretsRecipient(t *testing.T) {
+	t.Parallel()
+
+	assert.NoError(t, validateSecretsRecipient("passphrase"))
+	assert.NoError(t, validateSecretsRecipient("passphrase://alice"))
+	assert.NoError(t, validateSecretsRecipient("awskms://alias/key"))
+	assert.ErrorContains(t, validateSecretsRecipient("default"), "the default secrets provider can't be a recipient")
+	assert.ErrorContains(t, validateSecretsRecipient("multi"), "unknown secrets provider type 'multi'")
+	assert.ErrorContains(t, validateSecretsRecipient("age://age1nope"), `invalid age recipient "age1nope"`)
+}
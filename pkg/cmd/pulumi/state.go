@@ -27,6 +27,7 @@ import (
 	"github.com/pulumi/pulumi/pkg/v3/resource/deploy"
 	"github.com/pulumi/pulumi/pkg/v3/resource/edit"
 	"github.com/pulumi/pulumi/pkg/v3/resource/stack"
+	"github.com/pulumi/pulumi/pkg/v3/secrets"
 	"github.com/pulumi/pulumi/sdk/v3/go/common/apitype"
 	"github.com/pulumi/pulumi/sdk/v3/go/common/diag/colors"
 	"github.com/pulumi/pulumi/sdk/v3/go/common/resource"
@@ -50,6 +51,9 @@ troubleshooting a stack or when performing specific edits that otherwise would r
 	cmd.AddCommand(newStateDeleteCommand())
 	cmd.AddCommand(newStateUnprotectCommand())
 	cmd.AddCommand(newStateRenameCommand())
+	cmd.AddCommand(newStateMoveCommand())
+	cmd.AddCommand(newStateEditCommand())
+	cmd.AddCommand(newStateRepairCommand())
 	cmd.AddCommand(newStateUpgradeCommand())
 	return cmd
 }
@@ -110,6 +114,20 @@ func locateStackResource(opts display.Options, snap *deploy.Snapshot, urn resour
 	return optionMap[option], nil
 }
 
+// confirmStateEdit asks the user to confirm a direct edit of stack state, returning true if they agreed.
+func confirmStateEdit(opts display.Options, message string) bool {
+	confirm := false
+	surveycore.DisableColor = true
+	prompt := opts.Color.Colorize(colors.Yellow + "warning" + colors.Reset + ": ")
+	prompt += message
+	if err := survey.AskOne(&survey.Confirm{
+		Message: prompt,
+	}, &confirm, surveyIcons(opts.Color)); err != nil {
+		return false
+	}
+	return confirm
+}
+
 // runStateEdit runs the given state edit function on a resource with the given URN in a given stack.
 func runStateEdit(
 	ctx context.Context, stackName string, showPrompt bool,
@@ -152,13 +170,7 @@ func totalStateEdit(ctx context.Context, s backend.Stack, showPrompt bool, opts
 	}
 
 	if showPrompt && cmdutil.Interactive() {
-		confirm := false
-		surveycore.DisableColor = true
-		prompt := opts.Color.Colorize(colors.Yellow + "warning" + colors.Reset + ": ")
-		prompt += "This command will edit your stack's state directly. Confirm?"
-		if err = survey.AskOne(&survey.Confirm{
-			Message: prompt,
-		}, &confirm, surveyIcons(opts.Color)); err != nil || !confirm {
+		if !confirmStateEdit(opts, "This command will edit your stack's state directly. Confirm?") {
 			fmt.Println("confirmation declined")
 			return result.Bail()
 		}
@@ -177,19 +189,25 @@ func totalStateEdit(ctx context.Context, s backend.Stack, showPrompt bool, opts
 		contract.AssertNoErrorf(snap.VerifyIntegrity(), "state edit produced an invalid snapshot")
 	}
 
-	sdep, err := stack.SerializeDeployment(snap, snap.SecretsManager, false /* showSecrets */)
+	// Once we've mutated the snapshot, import it back into the backend so that it can be persisted.
+	return result.WrapIfNonNil(saveSnapshot(ctx, s, snap, snap.SecretsManager))
+}
+
+// saveSnapshot serializes the given snapshot using the given secrets manager and imports it into the given stack,
+// replacing its current checkpoint.
+func saveSnapshot(ctx context.Context, s backend.Stack, snap *deploy.Snapshot, sm secrets.Manager) error {
+	sdep, err := stack.SerializeDeployment(snap, sm, false /* showSecrets */)
 	if err != nil {
-		return result.FromError(fmt.Errorf("serializing deployment: %w", err))
+		return fmt.Errorf("serializing deployment: %w", err)
 	}
 
-	// Once we've mutated the snapshot, import it back into the backend so that it can be persisted.
 	bytes, err := json.Marshal(sdep)
 	if err != nil {
-		return result.FromError(err)
+		return err
 	}
 	dep := apitype.UntypedDeployment{
 		Version:    apitype.DeploymentSchemaVersionCurrent,
 		Deployment: bytes,
 	}
-	return result.WrapIfNonNil(s.ImportDeployment(ctx, &dep))
+	return s.ImportDeployment(ctx, &dep)
 }
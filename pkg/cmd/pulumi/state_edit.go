@@ -0,0 +1,282 @@
+// Copyright 2016-2023, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/pulumi/pulumi/pkg/v3/backend/display"
+	"github.com/pulumi/pulumi/pkg/v3/resource/deploy"
+	"github.com/pulumi/pulumi/pkg/v3/resource/stack"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/apitype"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/diag/colors"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/resource"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/cmdutil"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/contract"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/result"
+
+	"github.com/spf13/cobra"
+)
+
+// stateEditorCommand returns the command line used to open a file for editing, honoring $VISUAL and $EDITOR.
+func stateEditorCommand(getenv func(string) string) []string {
+	for _, name := range []string{"VISUAL", "EDITOR"} {
+		if editor := strings.Fields(getenv(name)); len(editor) > 0 {
+			return editor
+		}
+	}
+	if runtime.GOOS == "windows" {
+		return []string{"notepad"}
+	}
+	return []string{"vi"}
+}
+
+// openInEditor opens the given file in the user's editor and waits for it to exit.
+func openInEditor(path string) error {
+	editor := stateEditorCommand(os.Getenv)
+	//nolint:gosec // the editor is chosen by the user running the command
+	cmd := exec.Command(editor[0], append(editor[1:], path)...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("running editor %q: %w", strings.Join(editor, " "), err)
+	}
+	return nil
+}
+
+// snapshotChanges summarizes the resource-level differences between two snapshots.
+type snapshotChanges struct {
+	Added   []resource.URN
+	Removed []resource.URN
+	Changed []resource.URN
+}
+
+// Empty returns true if there are no resource-level differences.
+func (c snapshotChanges) Empty() bool {
+	return len(c.Added) == 0 && len(c.Removed) == 0 && len(c.Changed) == 0
+}
+
+// diffDeployments compares the resources of two serialized deployments by URN. Resources that share a URN (because
+// all but one of them are pending deletion) are compared in the order in which they appear.
+func diffDeployments(before, after *apitype.DeploymentV3) snapshotChanges {
+	type key struct {
+		urn resource.URN
+		n   int
+	}
+	index := func(resources []apitype.ResourceV3) (map[key]apitype.ResourceV3, []key) {
+		seen := make(map[resource.URN]int)
+		m := make(map[key]apitype.ResourceV3, len(resources))
+		keys := make([]key, 0, len(resources))
+		for _, res := range resources {
+			k := key{urn: res.URN, n: seen[res.URN]}
+			seen[res.URN]++
+			m[k] = res
+			keys = append(keys, k)
+		}
+		return m, keys
+	}
+
+	olds, oldKeys := index(before.Resources)
+	news, newKeys := index(after.Resources)
+
+	var changes snapshotChanges
+	for _, k := range oldKeys {
+		if n, has := news[k]; !has {
+			changes.Removed = append(changes.Removed, k.urn)
+		} else if !reflect.DeepEqual(olds[k], n) {
+			changes.Changed = append(changes.Changed, k.urn)
+		}
+	}
+	for _, k := range newKeys {
+		if _, has := olds[k]; !has {
+			changes.Added = append(changes.Added, k.urn)
+		}
+	}
+	return changes
+}
+
+func printSnapshotChanges(opts display.Options, changes snapshotChanges) {
+	print := func(color, sign string, urns []resource.URN) {
+		sorted := make([]string, len(urns))
+		for i, urn := range urns {
+			sorted[i] = string(urn)
+		}
+		sort.Strings(sorted)
+		for _, urn := range sorted {
+			fmt.Println(opts.Color.Colorize(fmt.Sprintf("    %s%s %s%s", color, sign, urn, colors.Reset)))
+		}
+	}
+
+	fmt.Println("Resources:")
+	print(colors.SpecCreate, "+", changes.Added)
+	print(colors.SpecDelete, "-", changes.Removed)
+	print(colors.SpecUpdate, "~", changes.Changed)
+}
+
+// editDeployment writes the given deployment to a temporary file, lets the user edit it, and returns the edited
+// contents.
+func editDeployment(contents []byte) ([]byte, error) {
+	f, err := os.CreateTemp("", "pulumi-state-*.json")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		contract.IgnoreError(os.Remove(f.Name()))
+	}()
+
+	if _, err := f.Write(contents); err != nil {
+		contract.IgnoreClose(f)
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+
+	if err := openInEditor(f.Name()); err != nil {
+		return nil, err
+	}
+	return os.ReadFile(f.Name())
+}
+
+// parseEditedDeployment turns the text produced by the user back into a snapshot, refusing snapshots that fail
+// integrity checks.
+func parseEditedDeployment(ctx context.Context, edited []byte) (*apitype.DeploymentV3, *deploy.Snapshot, error) {
+	var dep apitype.DeploymentV3
+	if err := json.Unmarshal(edited, &dep); err != nil {
+		return nil, nil, fmt.Errorf("parsing edited state: %w", err)
+	}
+
+	snap, err := stack.DeserializeDeploymentV3(ctx, dep, stack.DefaultSecretsProvider)
+	if err != nil {
+		return nil, nil, fmt.Errorf("loading edited state: %w", err)
+	}
+	if err := snap.VerifyIntegrity(); err != nil {
+		return nil, nil, fmt.Errorf("edited state is invalid: %w", err)
+	}
+	return &dep, snap, nil
+}
+
+func runStateEditor(ctx context.Context, stackName string, showPrompt bool) result.Result {
+	opts := display.Options{
+		Color: cmdutil.GetGlobalColorization(),
+	}
+	s, err := requireStack(ctx, stackName, stackLoadOnly, opts)
+	if err != nil {
+		return result.FromError(err)
+	}
+
+	snap, err := s.Snapshot(ctx, stack.DefaultSecretsProvider)
+	if err != nil {
+		return result.FromError(err)
+	} else if snap == nil {
+		return result.Errorf("stack %s has no state to edit", s.Ref())
+	}
+	sm := snap.SecretsManager
+
+	// Secrets are shown in plaintext while editing, and are re-encrypted when the edited state is loaded back in.
+	before, err := stack.SerializeDeployment(snap, sm, true /* showSecrets */)
+	if err != nil {
+		return result.FromError(fmt.Errorf("serializing deployment: %w", err))
+	}
+	original, err := json.MarshalIndent(before, "", "    ")
+	if err != nil {
+		return result.FromError(err)
+	}
+
+	contents := original
+	var after *apitype.DeploymentV3
+	var edited *deploy.Snapshot
+	for {
+		contents, err = editDeployment(contents)
+		if err != nil {
+			return result.FromError(err)
+		}
+		if bytes.Equal(bytes.TrimSpace(contents), bytes.TrimSpace(original)) {
+			fmt.Println("No changes were made to the state")
+			return nil
+		}
+
+		after, edited, err = parseEditedDeployment(ctx, contents)
+		if err == nil {
+			break
+		}
+
+		// Give the user a chance to fix their mistake rather than losing their edits.
+		if !cmdutil.Interactive() || !confirmStateEdit(opts, fmt.Sprintf("%v. Edit again?", err)) {
+			return result.FromError(err)
+		}
+	}
+
+	changes := diffDeployments(before, after)
+	if changes.Empty() {
+		fmt.Println("No resources were changed")
+	} else {
+		printSnapshotChanges(opts, changes)
+	}
+	fmt.Println()
+
+	if showPrompt && cmdutil.Interactive() {
+		if !confirmStateEdit(opts, "This command will replace your stack's state with the edited state. Confirm?") {
+			fmt.Println("confirmation declined")
+			return result.Bail()
+		}
+	}
+
+	if err := saveSnapshot(ctx, s, edited, sm); err != nil {
+		return result.FromError(err)
+	}
+	fmt.Println("State updated")
+	return nil
+}
+
+func newStateEditCommand() *cobra.Command {
+	var stack string
+	var yes bool
+
+	cmd := &cobra.Command{
+		Use:   "edit",
+		Short: "Edit the current stack's state in your editor",
+		Long: `Edit the current stack's state in your editor
+
+This command opens the stack's current state, with secrets decrypted, in the editor named by the
+VISUAL or EDITOR environment variables. Once the editor exits, the edited state is checked for
+integrity problems (such as resources that refer to missing parents, providers or dependencies, or
+that appear before their dependencies), a summary of the changed resources is shown, and the state
+is written back after confirmation. Secrets are re-encrypted with the stack's secrets provider.
+`,
+		Args: cmdutil.NoArgs,
+		Run: cmdutil.RunResultFunc(func(cmd *cobra.Command, args []string) result.Result {
+			ctx := commandContext()
+			yes = yes || skipConfirmations()
+			return runStateEditor(ctx, stack, !yes)
+		}),
+	}
+
+	cmd.PersistentFlags().StringVarP(
+		&stack, "stack", "s", "",
+		"The name of the stack to operate on. Defaults to the current stack")
+	cmd.Flags().BoolVarP(&yes, "yes", "y", false, "Skip confirmation prompts")
+	return cmd
+}
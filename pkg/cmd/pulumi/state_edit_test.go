@@ -0,0 +1,71 @@
+// Copyright 2016-2023, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/apitype"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/resource"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStateEditorCommand(t *testing.T) {
+	t.Parallel()
+
+	env := map[string]string{"EDITOR": "code --wait"}
+	assert.Equal(t, []string{"code", "--wait"}, stateEditorCommand(func(k string) string { return env[k] }))
+
+	env["VISUAL"] = "nano"
+	assert.Equal(t, []string{"nano"}, stateEditorCommand(func(k string) string { return env[k] }))
+}
+
+func TestDiffDeployments(t *testing.T) {
+	t.Parallel()
+
+	a := apitype.ResourceV3{URN: "urn:pulumi:dev::proj::a:b:c::a", Type: "a:b:c"}
+	b := apitype.ResourceV3{URN: "urn:pulumi:dev::proj::a:b:c::b", Type: "a:b:c"}
+	c := apitype.ResourceV3{URN: "urn:pulumi:dev::proj::a:b:c::c", Type: "a:b:c"}
+	b2 := b
+	b2.Protect = true
+
+	changes := diffDeployments(
+		&apitype.DeploymentV3{Resources: []apitype.ResourceV3{a, b}},
+		&apitype.DeploymentV3{Resources: []apitype.ResourceV3{b2, c}})
+
+	assert.Equal(t, []resource.URN{a.URN}, changes.Removed)
+	assert.Equal(t, []resource.URN{b.URN}, changes.Changed)
+	assert.Equal(t, []resource.URN{c.URN}, changes.Added)
+	assert.False(t, changes.Empty())
+}
+
+func TestParseEditedDeploymentRejectsInvalidState(t *testing.T) {
+	t.Parallel()
+
+	_, _, err := parseEditedDeployment(context.Background(), []byte(`{"resources": [`))
+	assert.ErrorContains(t, err, "parsing edited state")
+
+	// A child that refers to a parent that doesn't exist must be rejected.
+	_, _, err = parseEditedDeployment(context.Background(), []byte(`{
+		"manifest": {"magic": "", "version": ""},
+		"resources": [{
+			"urn": "urn:pulumi:dev::proj::a:b:c::child",
+			"type": "a:b:c",
+			"parent": "urn:pulumi:dev::proj::a:b:c::missing"
+		}]
+	}`))
+	assert.ErrorContains(t, err, "refers to missing parent")
+}
@@ -0,0 +1,521 @@
+// Copyright 2016-2023, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pulumi/pulumi/pkg/v3/backend"
+	"github.com/pulumi/pulumi/pkg/v3/backend/display"
+	"github.com/pulumi/pulumi/pkg/v3/resource/deploy"
+	"github.com/pulumi/pulumi/pkg/v3/resource/deploy/providers"
+	"github.com/pulumi/pulumi/pkg/v3/resource/stack"
+	"github.com/pulumi/pulumi/pkg/v3/secrets"
+	"github.com/pulumi/pulumi/pkg/v3/version"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/diag/colors"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/resource"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/tokens"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/cmdutil"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/result"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/workspace"
+
+	"github.com/spf13/cobra"
+)
+
+// brokenDependency records a dependency edge that had to be dropped because the two ends of it ended up in different
+// stacks after a move.
+type brokenDependency struct {
+	Dependent  resource.URN
+	Dependency resource.URN
+}
+
+// stateMoveResult describes the outcome of a stateMoveOperation.
+type stateMoveResult struct {
+	// Moved maps the URN of every resource that was moved to the destination to its new URN.
+	Moved map[resource.URN]resource.URN
+	// Copied lists the providers that were copied to the destination but kept in the source, because resources
+	// remaining in the source still refer to them.
+	Copied []resource.URN
+	// Broken lists the dependencies that were dropped from either snapshot.
+	Broken []brokenDependency
+}
+
+// compileURNPatterns turns the given URNs or URN globs into regular expressions. The only wildcard supported is `*`,
+// which matches any sequence of characters, including URN delimiters.
+func compileURNPatterns(patterns []string) ([]*regexp.Regexp, error) {
+	matchers := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		parts := strings.Split(pattern, "*")
+		for i, part := range parts {
+			parts[i] = regexp.QuoteMeta(part)
+		}
+		re, err := regexp.Compile("^" + strings.Join(parts, ".*") + "$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid URN pattern %q: %w", pattern, err)
+		}
+		matchers = append(matchers, re)
+	}
+	return matchers, nil
+}
+
+// stateMoveOperation moves the resources in source that match the given URN patterns into dest, rewriting their URNs
+// to belong to the given stack and project. Both snapshots are mutated in-place. Providers required by the moved
+// resources are moved along with them, or copied if resources left in the source still need them. Children of the
+// moved resources are only moved if includeChildren is set; otherwise their presence is an error.
+func stateMoveOperation(
+	source, dest *deploy.Snapshot, destStack tokens.QName, destProject tokens.PackageName,
+	patterns []string, includeChildren bool,
+) (*stateMoveResult, error) {
+	matchers, err := compileURNPatterns(patterns)
+	if err != nil {
+		return nil, err
+	}
+
+	// Select the resources that were asked for.
+	selected := make(map[resource.URN]bool)
+	for i, re := range matchers {
+		matched := false
+		for _, res := range source.Resources {
+			if !re.MatchString(string(res.URN)) {
+				continue
+			}
+			if res.Type == resource.RootStackType {
+				if patterns[i] == string(res.URN) {
+					return nil, fmt.Errorf("the root stack resource %s cannot be moved", res.URN)
+				}
+				continue
+			}
+			selected[res.URN] = true
+			matched = true
+		}
+		if !matched {
+			return nil, fmt.Errorf("no resources matching %q exist in the source stack", patterns[i])
+		}
+	}
+
+	// Resources are stored in dependency order, so a single pass is enough to pick up all descendants.
+	for _, res := range source.Resources {
+		if res.Parent == "" || !selected[res.Parent] || selected[res.URN] {
+			continue
+		}
+		if !includeChildren {
+			return nil, fmt.Errorf("resource %s is a child of %s; move it as well or pass --include-children",
+				res.URN, res.Parent)
+		}
+		selected[res.URN] = true
+	}
+
+	for _, op := range source.PendingOperations {
+		if selected[op.Resource.URN] {
+			return nil, fmt.Errorf("resource %s has a pending %s operation; run `pulumi refresh` first",
+				op.Resource.URN, op.Type)
+		}
+	}
+
+	// Find the providers that the selected resources need, and decide which of them can leave the source.
+	needed := make(map[resource.URN]bool)
+	for _, res := range source.Resources {
+		if !selected[res.URN] || res.Provider == "" {
+			continue
+		}
+		ref, err := providers.ParseReference(res.Provider)
+		if err != nil {
+			return nil, err
+		}
+		needed[ref.URN()] = true
+	}
+	stillUsed := make(map[resource.URN]bool)
+	for _, res := range source.Resources {
+		if selected[res.URN] || res.Provider == "" {
+			continue
+		}
+		ref, err := providers.ParseReference(res.Provider)
+		if err != nil {
+			return nil, err
+		}
+		stillUsed[ref.URN()] = true
+	}
+
+	result := &stateMoveResult{Moved: make(map[resource.URN]resource.URN)}
+
+	var moving []*resource.State
+	remove := make(map[resource.URN]bool)
+	for _, res := range source.Resources {
+		if !selected[res.URN] && !needed[res.URN] {
+			continue
+		}
+		moving = append(moving, res)
+		if stillUsed[res.URN] {
+			result.Copied = append(result.Copied, res.URN)
+			continue
+		}
+		remove[res.URN] = true
+	}
+
+	// Locate (or create) the root stack resource of the destination so that moved resources whose parent stays
+	// behind have somewhere to live.
+	var destRoot *resource.State
+	existing := make(map[resource.URN]*resource.State)
+	for _, res := range dest.Resources {
+		if res.Type == resource.RootStackType && res.Parent == "" {
+			destRoot = res
+		}
+		if !res.Delete {
+			existing[res.URN] = res
+		}
+	}
+	var newRoot *resource.State
+	rootURN := func() resource.URN {
+		if destRoot == nil {
+			urn := resource.DefaultRootStackURN(destStack, destProject)
+			newRoot = &resource.State{
+				Type:    resource.RootStackType,
+				URN:     urn,
+				Inputs:  resource.PropertyMap{},
+				Outputs: resource.PropertyMap{},
+			}
+			destRoot = newRoot
+		}
+		return destRoot.URN
+	}
+
+	// Compute the new URNs. Parents always precede their children, so a parent's new URN is known by the time we
+	// reach any of its children.
+	for _, res := range moving {
+		parent := res.Parent
+		if parent != "" {
+			if newParent, has := result.Moved[parent]; has {
+				parent = newParent
+			} else {
+				parent = rootURN()
+			}
+		}
+
+		var parentType tokens.Type
+		if parent != "" && parent.Type() != resource.RootStackType {
+			parentType = parent.QualifiedType()
+		}
+		result.Moved[res.URN] = resource.NewURN(destStack, destProject, parentType, res.Type, res.URN.Name())
+	}
+
+	rewriteDeps := func(
+		urn resource.URN, deps []resource.URN, known func(resource.URN) (resource.URN, bool),
+	) []resource.URN {
+		var rewritten []resource.URN
+		for _, dep := range deps {
+			if newDep, ok := known(dep); ok {
+				rewritten = append(rewritten, newDep)
+			} else {
+				result.Broken = append(result.Broken, brokenDependency{Dependent: urn, Dependency: dep})
+			}
+		}
+		return rewritten
+	}
+	inDest := func(urn resource.URN) (resource.URN, bool) {
+		if newURN, has := result.Moved[urn]; has {
+			return newURN, true
+		}
+		_, has := existing[urn]
+		return urn, has
+	}
+
+	var added []*resource.State
+	for _, res := range moving {
+		newURN := result.Moved[res.URN]
+		if other, has := existing[newURN]; has {
+			// Providers that are already present in the destination with the same identity can simply be shared.
+			if providers.IsProviderType(res.Type) && other.ID == res.ID && !remove[res.URN] {
+				continue
+			}
+			return nil, fmt.Errorf("a resource named %s already exists in the destination stack", newURN)
+		}
+
+		moved := *res
+		moved.URN = newURN
+		moved.Aliases = nil
+		if res.Parent != "" {
+			if newParent, has := result.Moved[res.Parent]; has {
+				moved.Parent = newParent
+			} else {
+				moved.Parent = rootURN()
+			}
+		}
+		moved.Dependencies = rewriteDeps(newURN, res.Dependencies, inDest)
+		if res.PropertyDependencies != nil {
+			moved.PropertyDependencies = make(map[resource.PropertyKey][]resource.URN, len(res.PropertyDependencies))
+			for k, deps := range res.PropertyDependencies {
+				moved.PropertyDependencies[k] = rewriteDeps(newURN, deps, inDest)
+			}
+		}
+		if res.DeletedWith != "" {
+			if newDep, ok := inDest(res.DeletedWith); ok {
+				moved.DeletedWith = newDep
+			} else {
+				result.Broken = append(result.Broken, brokenDependency{Dependent: newURN, Dependency: res.DeletedWith})
+				moved.DeletedWith = ""
+			}
+		}
+		if res.Provider != "" {
+			ref, err := providers.ParseReference(res.Provider)
+			if err != nil {
+				return nil, err
+			}
+			newRef, err := providers.NewReference(result.Moved[ref.URN()], ref.ID())
+			if err != nil {
+				return nil, err
+			}
+			moved.Provider = newRef.String()
+		}
+		added = append(added, &moved)
+	}
+
+	// Drop the moved resources from the source and cut any edges that now point across stacks.
+	inSource := func(urn resource.URN) (resource.URN, bool) {
+		return urn, !remove[urn]
+	}
+	remaining := make([]*resource.State, 0, len(source.Resources))
+	for _, res := range source.Resources {
+		if remove[res.URN] {
+			continue
+		}
+		res.Dependencies = rewriteDeps(res.URN, res.Dependencies, inSource)
+		for k, deps := range res.PropertyDependencies {
+			res.PropertyDependencies[k] = rewriteDeps(res.URN, deps, inSource)
+		}
+		if res.DeletedWith != "" && remove[res.DeletedWith] {
+			result.Broken = append(result.Broken, brokenDependency{Dependent: res.URN, Dependency: res.DeletedWith})
+			res.DeletedWith = ""
+		}
+		remaining = append(remaining, res)
+	}
+	source.Resources = remaining
+
+	if newRoot != nil {
+		dest.Resources = append([]*resource.State{newRoot}, dest.Resources...)
+	}
+	dest.Resources = append(dest.Resources, added...)
+
+	return result, nil
+}
+
+// stateMoveDestination works out the project that resources moved into the given stack should belong to.
+func stateMoveDestination(dest backend.Stack, destSnap *deploy.Snapshot, fallback tokens.PackageName) tokens.PackageName {
+	if destSnap != nil {
+		for _, res := range destSnap.Resources {
+			return res.URN.Project()
+		}
+	}
+	if project, has := dest.Ref().Project(); has {
+		return tokens.PackageName(project)
+	}
+	return fallback
+}
+
+// stateMoveSecretsManager returns the secrets manager used to encrypt the destination stack's state.
+func stateMoveSecretsManager(dest backend.Stack, destSnap *deploy.Snapshot) (secrets.Manager, error) {
+	if destSnap != nil && destSnap.SecretsManager != nil {
+		return destSnap.SecretsManager, nil
+	}
+
+	ps, err := workspace.DetectProjectStack(dest.Ref().Name().Q())
+	if err != nil {
+		return nil, fmt.Errorf("loading configuration for destination stack %s: %w", dest.Ref(), err)
+	}
+	sm, needsSave, err := getStackSecretsManager(dest, ps)
+	if err != nil {
+		return nil, fmt.Errorf("getting secrets manager for destination stack %s: %w", dest.Ref(), err)
+	}
+	if needsSave {
+		if err = saveProjectStack(dest, ps); err != nil {
+			return nil, fmt.Errorf("saving stack config: %w", err)
+		}
+	}
+	return sm, nil
+}
+
+func runStateMove(
+	ctx context.Context, sourceName, destName string, patterns []string,
+	includeChildren bool, showPrompt bool,
+) result.Result {
+	opts := display.Options{
+		Color: cmdutil.GetGlobalColorization(),
+	}
+
+	source, err := requireStack(ctx, sourceName, stackLoadOnly, opts)
+	if err != nil {
+		return result.FromError(err)
+	}
+	dest, err := requireStack(ctx, destName, stackLoadOnly, opts)
+	if err != nil {
+		return result.FromError(err)
+	}
+	if source.Ref().FullyQualifiedName() == dest.Ref().FullyQualifiedName() {
+		return result.Error("the source and destination stacks must be different")
+	}
+
+	sourceSnap, err := source.Snapshot(ctx, stack.DefaultSecretsProvider)
+	if err != nil {
+		return result.FromError(err)
+	}
+	if sourceSnap == nil || len(sourceSnap.Resources) == 0 {
+		return result.Errorf("the source stack %s has no resources", source.Ref())
+	}
+	if err := sourceSnap.VerifyIntegrity(); err != nil {
+		return result.FromError(fmt.Errorf("the source stack's state is invalid: %w", err))
+	}
+
+	destSnap, err := dest.Snapshot(ctx, stack.DefaultSecretsProvider)
+	if err != nil {
+		return result.FromError(err)
+	}
+	if err := destSnap.VerifyIntegrity(); err != nil {
+		return result.FromError(fmt.Errorf("the destination stack's state is invalid: %w", err))
+	}
+
+	destSM, err := stateMoveSecretsManager(dest, destSnap)
+	if err != nil {
+		return result.FromError(err)
+	}
+	if destSnap == nil {
+		manifest := deploy.Manifest{
+			Time:    time.Now(),
+			Version: version.Version,
+		}
+		manifest.Magic = manifest.NewMagic()
+		destSnap = deploy.NewSnapshot(manifest, destSM, nil, nil)
+	}
+
+	destProject := stateMoveDestination(dest, destSnap, sourceSnap.Resources[0].URN.Project())
+	res, err := stateMoveOperation(sourceSnap, destSnap, dest.Ref().Name().Q(), destProject,
+		patterns, includeChildren)
+	if err != nil {
+		return result.FromError(err)
+	}
+
+	// Validate both halves of the move before persisting either of them.
+	if err := sourceSnap.VerifyIntegrity(); err != nil {
+		return result.FromError(fmt.Errorf("moving resources would corrupt the source stack: %w", err))
+	}
+	if err := destSnap.VerifyIntegrity(); err != nil {
+		return result.FromError(fmt.Errorf("moving resources would corrupt the destination stack: %w", err))
+	}
+
+	fmt.Printf("Planning to move the following resources from %s to %s:\n", source.Ref(), dest.Ref())
+	copied := make(map[resource.URN]bool, len(res.Copied))
+	for _, urn := range res.Copied {
+		copied[urn] = true
+	}
+	moved := make([]resource.URN, 0, len(res.Moved))
+	for urn := range res.Moved {
+		if !copied[urn] {
+			moved = append(moved, urn)
+		}
+	}
+	sort.Slice(moved, func(i, j int) bool { return moved[i] < moved[j] })
+	for _, urn := range moved {
+		fmt.Printf("  - %s => %s\n", urn, res.Moved[urn])
+	}
+	if len(res.Copied) > 0 {
+		fmt.Println("\nThe following providers are still in use and will be copied rather than moved:")
+		for _, urn := range res.Copied {
+			fmt.Printf("  - %s\n", urn)
+		}
+	}
+	if len(res.Broken) > 0 {
+		fmt.Println(opts.Color.Colorize(
+			"\n" + colors.SpecWarning + "The following dependencies will be removed:" + colors.Reset))
+		for _, dep := range res.Broken {
+			fmt.Printf("  - %s depends on %s\n", dep.Dependent, dep.Dependency)
+		}
+	}
+	fmt.Println()
+
+	if showPrompt && cmdutil.Interactive() {
+		if !confirmStateEdit(opts, "This command will edit the state of both stacks directly. Confirm?") {
+			fmt.Println("confirmation declined")
+			return result.Bail()
+		}
+	}
+
+	// Write the destination first so that a failure part way through never loses resources.
+	if err := saveSnapshot(ctx, dest, destSnap, destSM); err != nil {
+		return result.FromError(fmt.Errorf("saving destination stack: %w", err))
+	}
+	if err := saveSnapshot(ctx, source, sourceSnap, sourceSnap.SecretsManager); err != nil {
+		return result.FromError(fmt.Errorf("saving source stack (the resources have already been added "+
+			"to the destination stack): %w", err))
+	}
+
+	fmt.Printf("Successfully moved %d resource(s) to %s\n", len(moved), dest.Ref())
+	return nil
+}
+
+func newStateMoveCommand() *cobra.Command {
+	var source string
+	var dest string
+	var includeChildren bool
+	var yes bool
+
+	cmd := &cobra.Command{
+		Use:   "move --dest <stack> <resource URN>...",
+		Short: "Moves resources from one stack to another",
+		Long: `Moves resources from one stack to another
+
+This command moves resources from the state of one stack to the state of another. The resources are specified
+by their Pulumi URNs (use ` + "`pulumi stack --show-urns`" + ` to get them), and '*' may be used as a wildcard.
+
+URNs, parents and dependencies are rewritten to belong to the destination stack, and secrets are re-encrypted
+with the destination stack's secrets provider. Providers used by the moved resources are moved along with
+them, or copied if resources remaining in the source stack still use them. Dependencies between moved and
+remaining resources are removed.
+
+Both stacks' states are validated before either of them is written.
+
+Make sure that URNs are single-quoted to avoid having characters unexpectedly interpreted by the shell.
+
+Example:
+pulumi state move --source dev --dest organization/other-project/dev 'urn:pulumi:dev::demo::aws:s3/bucket:Bucket::*'
+`,
+		Args: cmdutil.MinimumNArgs(1),
+		Run: cmdutil.RunResultFunc(func(cmd *cobra.Command, args []string) result.Result {
+			ctx := commandContext()
+			yes = yes || skipConfirmations()
+			if dest == "" {
+				return result.FromError(errors.New("a destination stack must be specified with --dest"))
+			}
+			for _, arg := range args {
+				if !strings.Contains(arg, "*") && !resource.URN(arg).IsValid() {
+					return result.Errorf("%q is not a valid URN", arg)
+				}
+			}
+
+			return runStateMove(ctx, source, dest, args, includeChildren, !yes)
+		}),
+	}
+
+	cmd.Flags().StringVar(&source, "source", "",
+		"The name of the stack to move resources from. Defaults to the current stack")
+	cmd.Flags().StringVar(&dest, "dest", "", "The name of the stack to move resources to")
+	cmd.Flags().BoolVar(&includeChildren, "include-children", false,
+		"Also move the children of the selected resources")
+	cmd.Flags().BoolVarP(&yes, "yes", "y", false, "Skip confirmation prompts")
+	return cmd
+}
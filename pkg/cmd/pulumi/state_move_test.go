@@ -0,0 +1,144 @@
+// Copyright 2016-2023, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/pulumi/pulumi/pkg/v3/resource/deploy"
+	"github.com/pulumi/pulumi/pkg/v3/resource/deploy/providers"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/resource"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/tokens"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newMoveTestSnapshot(t *testing.T, resources ...*resource.State) *deploy.Snapshot {
+	t.Helper()
+
+	var manifest deploy.Manifest
+	manifest.Magic = manifest.NewMagic()
+	snap := deploy.NewSnapshot(manifest, nil, resources, nil)
+	require.NoError(t, snap.VerifyIntegrity())
+	return snap
+}
+
+func newMoveTestResource(
+	stack, name string, typ tokens.Type, parent resource.URN, prov *resource.State, deps ...resource.URN,
+) *resource.State {
+	var parentType tokens.Type
+	if parent != "" && parent.Type() != resource.RootStackType {
+		parentType = parent.QualifiedType()
+	}
+	res := &resource.State{
+		Type:         typ,
+		URN:          resource.NewURN(tokens.QName(stack), "proj", parentType, typ, tokens.QName(name)),
+		Custom:       true,
+		ID:           resource.ID(name + "-id"),
+		Parent:       parent,
+		Dependencies: deps,
+		Inputs:       resource.PropertyMap{},
+		Outputs:      resource.PropertyMap{},
+	}
+	if prov != nil {
+		ref, err := providers.NewReference(prov.URN, prov.ID)
+		if err != nil {
+			panic(err)
+		}
+		res.Provider = ref.String()
+	}
+	return res
+}
+
+func TestStateMoveCopiesSharedProvider(t *testing.T) {
+	t.Parallel()
+
+	root := newMoveTestResource("src", "proj-src", resource.RootStackType, "", nil)
+	root.Custom, root.ID = false, ""
+	prov := newMoveTestResource("src", "prov", "pulumi:providers:random", "", nil)
+	a := newMoveTestResource("src", "a", "random:index:Pet", root.URN, prov)
+	b := newMoveTestResource("src", "b", "random:index:Pet", root.URN, prov, a.URN)
+	source := newMoveTestSnapshot(t, root, prov, a, b)
+	dest := newMoveTestSnapshot(t)
+
+	res, err := stateMoveOperation(source, dest, "dst", "proj", []string{string(a.URN)}, false)
+	require.NoError(t, err)
+
+	// The provider is still used by b, so it is copied rather than moved.
+	assert.Equal(t, []resource.URN{prov.URN}, res.Copied)
+	// b depended on a, and that edge can't cross stacks.
+	assert.Equal(t, []brokenDependency{{Dependent: b.URN, Dependency: a.URN}}, res.Broken)
+
+	assert.NoError(t, source.VerifyIntegrity())
+	assert.NoError(t, dest.VerifyIntegrity())
+
+	require.Len(t, source.Resources, 3)
+	require.Len(t, dest.Resources, 3)
+	assert.Equal(t, resource.DefaultRootStackURN("dst", "proj"), dest.Resources[0].URN)
+	assert.Equal(t, resource.URN("urn:pulumi:dst::proj::pulumi:providers:random::prov"), dest.Resources[1].URN)
+	moved := dest.Resources[2]
+	assert.Equal(t, resource.URN("urn:pulumi:dst::proj::random:index:Pet::a"), moved.URN)
+	assert.Equal(t, dest.Resources[0].URN, moved.Parent)
+	assert.Equal(t, "urn:pulumi:dst::proj::pulumi:providers:random::prov::prov-id", moved.Provider)
+
+	// The source's resources must not have been touched by rewriting the destination copies.
+	assert.Equal(t, resource.URN("urn:pulumi:src::proj::random:index:Pet::a"), a.URN)
+}
+
+func TestStateMoveChildren(t *testing.T) {
+	t.Parallel()
+
+	prov := newMoveTestResource("src", "prov", "pulumi:providers:random", "", nil)
+	comp := newMoveTestResource("src", "comp", "my:index:Component", "", nil)
+	comp.Custom, comp.ID = false, ""
+	child := newMoveTestResource("src", "child", "random:index:Pet", comp.URN, prov)
+	source := newMoveTestSnapshot(t, prov, comp, child)
+
+	// Without --include-children, moving a parent without its children is refused.
+	_, err := stateMoveOperation(source, newMoveTestSnapshot(t), "dst", "proj",
+		[]string{string(comp.URN)}, false)
+	assert.ErrorContains(t, err, "--include-children")
+
+	dest := newMoveTestSnapshot(t)
+	res, err := stateMoveOperation(source, dest, "dst", "other",
+		[]string{"urn:pulumi:src::proj::my:index:Component::*"}, true)
+	require.NoError(t, err)
+	assert.Empty(t, res.Copied)
+	assert.Empty(t, res.Broken)
+
+	// Everything, including the provider, has left the source.
+	assert.Empty(t, source.Resources)
+	assert.NoError(t, dest.VerifyIntegrity())
+
+	require.Len(t, dest.Resources, 3)
+	assert.Equal(t, resource.URN("urn:pulumi:dst::other::my:index:Component::comp"), dest.Resources[1].URN)
+	assert.Equal(t,
+		resource.URN("urn:pulumi:dst::other::my:index:Component$random:index:Pet::child"), dest.Resources[2].URN)
+	assert.Equal(t, dest.Resources[1].URN, dest.Resources[2].Parent)
+}
+
+func TestStateMoveConflict(t *testing.T) {
+	t.Parallel()
+
+	a := newMoveTestResource("src", "a", "random:index:Pet", "", nil)
+	source := newMoveTestSnapshot(t, a)
+	dest := newMoveTestSnapshot(t, newMoveTestResource("dst", "a", "random:index:Pet", "", nil))
+
+	_, err := stateMoveOperation(source, dest, "dst", "proj", []string{string(a.URN)}, false)
+	assert.ErrorContains(t, err, "already exists in the destination stack")
+
+	_, err = stateMoveOperation(source, dest, "dst", "proj", []string{"urn:pulumi:src::proj::nope*"}, false)
+	assert.ErrorContains(t, err, "no resources matching")
+}
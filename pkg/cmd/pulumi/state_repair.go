@@ -0,0 +1,186 @@
+// Copyright 2016-2023, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pulumi/pulumi/pkg/v3/backend/display"
+	"github.com/pulumi/pulumi/pkg/v3/backend/filestate"
+	"github.com/pulumi/pulumi/pkg/v3/resource/edit"
+	"github.com/pulumi/pulumi/pkg/v3/resource/stack"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/diag/colors"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/cmdutil"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/result"
+
+	"github.com/spf13/cobra"
+)
+
+// stateRepairReport is the machine-readable result of `pulumi state repair --json`.
+type stateRepairReport struct {
+	Stack   string        `json:"stack"`
+	Repairs []edit.Repair `json:"repairs"`
+	// Valid is true if the snapshot passes integrity checks once the repairs have been applied.
+	Valid bool `json:"valid"`
+	// Applied is true if the repaired snapshot was written back to the stack.
+	Applied bool `json:"applied"`
+	// Error describes the integrity problem that remains after repair, if any.
+	Error string `json:"error,omitempty"`
+}
+
+func printStateRepairs(opts display.Options, repairs []edit.Repair) {
+	for _, r := range repairs {
+		color, status := colors.SpecUpdate, "fix"
+		if !r.Fixed {
+			color, status = colors.SpecDelete, "unfixable"
+		}
+		line := fmt.Sprintf("    %s%s%s [%s] %s", color, status, colors.Reset, r.Kind, r.Description)
+		if r.URN != "" {
+			line += fmt.Sprintf(" (%s)", r.URN)
+		}
+		fmt.Println(opts.Color.Colorize(line))
+	}
+}
+
+func runStateRepair(ctx context.Context, stackName string, showPrompt, dryRun, jsonOut bool) result.Result {
+	opts := display.Options{
+		Color: cmdutil.GetGlobalColorization(),
+	}
+
+	// The whole point of this command is to load snapshots that fail integrity checks.
+	filestate.DisableIntegrityChecking = true
+
+	s, err := requireStack(ctx, stackName, stackLoadOnly, opts)
+	if err != nil {
+		return result.FromError(err)
+	}
+	snap, err := s.Snapshot(ctx, stack.DefaultSecretsProvider)
+	if err != nil {
+		return result.FromError(err)
+	} else if snap == nil {
+		return result.Errorf("stack %s has no state to repair", s.Ref())
+	}
+
+	report := stateRepairReport{
+		Stack:   s.Ref().String(),
+		Repairs: edit.RepairSnapshot(snap),
+	}
+	integrityErr := snap.VerifyIntegrity()
+	report.Valid = integrityErr == nil
+	if integrityErr != nil {
+		report.Error = integrityErr.Error()
+	}
+
+	finish := func() result.Result {
+		if jsonOut {
+			if report.Repairs == nil {
+				report.Repairs = []edit.Repair{}
+			}
+			if err := printJSON(report); err != nil {
+				return result.FromError(err)
+			}
+		}
+		// A dry run that finds problems fails, so that it can be used as a health check.
+		if dryRun && len(report.Repairs) > 0 {
+			return result.Bail()
+		}
+		return nil
+	}
+
+	if !jsonOut {
+		if len(report.Repairs) == 0 {
+			fmt.Println("No integrity problems were found")
+		} else {
+			fmt.Println("The following problems were found:")
+			printStateRepairs(opts, report.Repairs)
+			fmt.Println()
+			if integrityErr != nil {
+				fmt.Println(opts.Color.Colorize(fmt.Sprintf(
+					"%swarning%s: the state will still be invalid after these repairs: %v",
+					colors.Yellow, colors.Reset, integrityErr)))
+			}
+		}
+	}
+
+	if len(report.Repairs) == 0 || dryRun {
+		return finish()
+	}
+	if integrityErr != nil {
+		// Writing back a partially repaired snapshot would leave the stack just as unusable, so leave the
+		// remaining problems for `pulumi state edit`.
+		if res := finish(); res != nil {
+			return res
+		}
+		return result.Errorf("the state could not be fully repaired; use `pulumi state edit` to fix it manually")
+	}
+
+	if showPrompt && cmdutil.Interactive() {
+		if !confirmStateEdit(opts, "This command will apply the fixes above to your stack's state. Confirm?") {
+			fmt.Println("confirmation declined")
+			return result.Bail()
+		}
+	}
+
+	if err := saveSnapshot(ctx, s, snap, snap.SecretsManager); err != nil {
+		return result.FromError(err)
+	}
+	report.Applied = true
+	if !jsonOut {
+		fmt.Println("State repaired")
+	}
+	return finish()
+}
+
+func newStateRepairCommand() *cobra.Command {
+	var stack string
+	var yes bool
+	var dryRun bool
+	var jsonOut bool
+
+	cmd := &cobra.Command{
+		Use:   "repair",
+		Short: "Repair integrity problems in the current stack's state",
+		Long: `Repair integrity problems in the current stack's state
+
+This command checks the stack's state for the problems that an interrupted update can leave behind,
+and fixes the ones it can: resources listed before the resources they depend on are re-sorted,
+dependencies on resources that no longer exist are dropped, resources whose parent no longer exists
+are re-parented to the stack, and pending operations are cleared. Problems that can't be fixed
+automatically, such as missing providers, are reported so they can be fixed with 'pulumi state edit'.
+
+With --dry-run the problems are only reported, and the command exits with a non-zero status if any
+were found. Combined with --json, this can be used as a health check in CI.
+`,
+		Args: cmdutil.NoArgs,
+		Run: cmdutil.RunResultFunc(func(cmd *cobra.Command, args []string) result.Result {
+			ctx := commandContext()
+			yes = yes || skipConfirmations()
+			if jsonOut && !yes && !dryRun {
+				// There's nowhere to show a confirmation prompt alongside the JSON report.
+				return result.Errorf("--yes or --dry-run must be passed with --json")
+			}
+			return runStateRepair(ctx, stack, !yes, dryRun, jsonOut)
+		}),
+	}
+
+	cmd.PersistentFlags().StringVarP(
+		&stack, "stack", "s", "",
+		"The name of the stack to operate on. Defaults to the current stack")
+	cmd.Flags().BoolVarP(&yes, "yes", "y", false, "Skip confirmation prompts")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Only report problems, without changing the state")
+	cmd.Flags().BoolVarP(&jsonOut, "json", "j", false, "Emit the report as JSON")
+	return cmd
+}
@@ -32,21 +32,40 @@ import (
 
 func newStateUpgradeCommand() *cobra.Command {
 	var sucmd stateUpgradeCmd
+	var gzip, encrypt bool
 	cmd := &cobra.Command{
 		Use:   "upgrade",
 		Short: "Migrates the current backend to the latest supported version",
 		Long: `Migrates the current backend to the latest supported version
 
 This only has an effect on self-managed backends.
+
+Pass --gzip=true or --gzip=false to also choose whether the backend stores checkpoints, history and
+backups with gzip compression, and to convert the files of all existing stacks to match.
+
+Pass --encrypt=true or --encrypt=false to also choose whether the backend encrypts checkpoints, history
+and backups with the secrets provider of their stack, and to convert the files of all existing stacks
+to match. Encrypted state is decrypted transparently by every command that reads it.
 `,
 		Args: cmdutil.NoArgs,
 		Run: cmdutil.RunResultFunc(func(cmd *cobra.Command, args []string) result.Result {
+			if cmd.Flags().Changed("gzip") {
+				sucmd.gzip = &gzip
+			}
+			if cmd.Flags().Changed("encrypt") {
+				sucmd.encrypt = &encrypt
+			}
 			if err := sucmd.Run(commandContext()); err != nil {
 				return result.FromError(err)
 			}
 			return nil
 		}),
 	}
+
+	cmd.Flags().BoolVar(&gzip, "gzip", false,
+		"Whether to store checkpoints with gzip compression, converting those of existing stacks")
+	cmd.Flags().BoolVar(&encrypt, "encrypt", false,
+		"Whether to encrypt checkpoints with the secrets provider of their stack, converting those of existing stacks")
 	return cmd
 }
 
@@ -55,6 +74,11 @@ type stateUpgradeCmd struct {
 	Stdin  io.Reader // defaults to os.Stdin
 	Stdout io.Writer // defaults to os.Stdout
 
+	// If set, whether to convert the backend to gzip compressed checkpoints or back.
+	gzip *bool
+	// If set, whether to convert the backend to encrypted checkpoints or back.
+	encrypt *bool
+
 	// Used to mock out the currentBackend function for testing.
 	// Defaults to currentBackend function.
 	currentBackend func(context.Context, *workspace.Project, display.Options) (backend.Backend, error)
@@ -94,12 +118,37 @@ func (cmd *stateUpgradeCmd) Run(ctx context.Context) error {
 	}
 
 	prompt := "This will upgrade the current backend to the latest supported version.\n" +
-		"Older versions of Pulumi will not be able to read the new format.\n" +
-		"Are you sure you want to proceed?"
+		"Older versions of Pulumi will not be able to read the new format.\n"
+	if cmd.gzip != nil {
+		if *cmd.gzip {
+			prompt += "The checkpoints of all stacks will be converted to gzip compressed files.\n"
+		} else {
+			prompt += "The checkpoints of all stacks will be converted to uncompressed files.\n"
+		}
+	}
+	if cmd.encrypt != nil {
+		if *cmd.encrypt {
+			prompt += "The checkpoints of all stacks will be encrypted with the secrets provider of each stack.\n"
+		} else {
+			prompt += "The checkpoints of all stacks will be decrypted.\n"
+		}
+	}
+	prompt += "Are you sure you want to proceed?"
 	if !confirmPrompt(prompt, "yes", dopts) {
 		fmt.Fprintln(cmd.Stdout, "Upgrade cancelled")
 		return nil
 	}
 
-	return lb.Upgrade(ctx)
+	if err := lb.Upgrade(ctx); err != nil {
+		return err
+	}
+	if cmd.gzip != nil {
+		if err := lb.SetCompression(ctx, *cmd.gzip); err != nil {
+			return err
+		}
+	}
+	if cmd.encrypt != nil {
+		return lb.SetEncryption(ctx, *cmd.encrypt)
+	}
+	return nil
 }
@@ -104,6 +104,70 @@ func TestStateUpgradeCommand_Run_upgrade(t *testing.T) {
 	assert.True(t, called, "Upgrade was never called")
 }
 
+func TestStateUpgradeCommand_Run_gzip(t *testing.T) {
+	t.Parallel()
+
+	var upgraded bool
+	var compression *bool
+	gzip := true
+	cmd := stateUpgradeCmd{
+		currentBackend: func(context.Context, *workspace.Project, display.Options) (backend.Backend, error) {
+			return &stubFileBackend{
+				UpgradeF: func(context.Context) error {
+					upgraded = true
+					return nil
+				},
+				SetCompressionF: func(_ context.Context, gzip bool) error {
+					assert.True(t, upgraded, "SetCompression called before Upgrade")
+					compression = &gzip
+					return nil
+				},
+			}, nil
+		},
+		Stdin:  strings.NewReader("yes\n"),
+		Stdout: io.Discard,
+		gzip:   &gzip,
+	}
+
+	err := cmd.Run(context.Background())
+	require.NoError(t, err)
+
+	require.NotNil(t, compression, "SetCompression was never called")
+	assert.True(t, *compression)
+}
+
+func TestStateUpgradeCommand_Run_encrypt(t *testing.T) {
+	t.Parallel()
+
+	var upgraded bool
+	var encryption *bool
+	encrypt := true
+	cmd := stateUpgradeCmd{
+		currentBackend: func(context.Context, *workspace.Project, display.Options) (backend.Backend, error) {
+			return &stubFileBackend{
+				UpgradeF: func(context.Context) error {
+					upgraded = true
+					return nil
+				},
+				SetEncryptionF: func(_ context.Context, encrypt bool) error {
+					assert.True(t, upgraded, "SetEncryption called before Upgrade")
+					encryption = &encrypt
+					return nil
+				},
+			}, nil
+		},
+		Stdin:   strings.NewReader("yes\n"),
+		Stdout:  io.Discard,
+		encrypt: &encrypt,
+	}
+
+	err := cmd.Run(context.Background())
+	require.NoError(t, err)
+
+	require.NotNil(t, encryption, "SetEncryption was never called")
+	assert.True(t, *encryption)
+}
+
 func TestStateUpgradeCommand_Run_upgradeRejected(t *testing.T) {
 	t.Parallel()
 
@@ -158,9 +222,19 @@ func TestStateUpgradeCmd_Run_backendError(t *testing.T) {
 type stubFileBackend struct {
 	filestate.Backend
 
-	UpgradeF func(context.Context) error
+	UpgradeF        func(context.Context) error
+	SetCompressionF func(context.Context, bool) error
+	SetEncryptionF  func(context.Context, bool) error
 }
 
 func (f *stubFileBackend) Upgrade(ctx context.Context) error {
 	return f.UpgradeF(ctx)
 }
+
+func (f *stubFileBackend) SetCompression(ctx context.Context, gzip bool) error {
+	return f.SetCompressionF(ctx, gzip)
+}
+
+func (f *stubFileBackend) SetEncryption(ctx context.Context, encrypt bool) error {
+	return f.SetEncryptionF(ctx, encrypt)
+}
@@ -41,9 +41,11 @@ import (
 	"github.com/pulumi/pulumi/pkg/v3/backend/display"
 	"github.com/pulumi/pulumi/pkg/v3/backend/filestate"
 	"github.com/pulumi/pulumi/pkg/v3/backend/httpstate"
+	"github.com/pulumi/pulumi/pkg/v3/backend/sqlstate"
 	"github.com/pulumi/pulumi/pkg/v3/backend/state"
 	"github.com/pulumi/pulumi/pkg/v3/resource/deploy"
 	"github.com/pulumi/pulumi/pkg/v3/resource/stack"
+	"github.com/pulumi/pulumi/pkg/v3/secrets/age"
 	"github.com/pulumi/pulumi/pkg/v3/secrets/cloud"
 	"github.com/pulumi/pulumi/pkg/v3/secrets/passphrase"
 	"github.com/pulumi/pulumi/pkg/v3/util/tracing"
@@ -116,7 +118,13 @@ func isFilestateBackend(opts display.Options) (bool, error) {
 		return false, fmt.Errorf("could not get cloud url: %w", err)
 	}
 
-	return filestate.IsFileStateBackendURL(url), nil
+	return isSelfManagedBackendURL(url), nil
+}
+
+// isSelfManagedBackendURL returns true if the URL is that of a self-managed backend, such as a bucket or a SQL
+// database, rather than of the Pulumi Cloud.
+func isSelfManagedBackendURL(url string) bool {
+	return filestate.IsFileStateBackendURL(url) || sqlstate.IsSQLStateBackendURL(url)
 }
 
 func nonInteractiveCurrentBackend(ctx context.Context, project *workspace.Project) (backend.Backend, error) {
@@ -129,9 +137,15 @@ func nonInteractiveCurrentBackend(ctx context.Context, project *workspace.Projec
 		return nil, fmt.Errorf("could not get cloud url: %w", err)
 	}
 
+	if filestate.IsMemBackendURL(url) {
+		return nil, filestate.ErrMemBackendLogin
+	}
 	if filestate.IsFileStateBackendURL(url) {
 		return filestate.New(ctx, cmdutil.Diag(), url, project)
 	}
+	if sqlstate.IsSQLStateBackendURL(url) {
+		return sqlstate.New(ctx, cmdutil.Diag(), url, project)
+	}
 	return httpstate.NewLoginManager().Current(ctx, cmdutil.Diag(), url, project, workspace.GetCloudInsecure(url))
 }
 
@@ -145,9 +159,15 @@ func currentBackend(ctx context.Context, project *workspace.Project, opts displa
 		return nil, fmt.Errorf("could not get cloud url: %w", err)
 	}
 
+	if filestate.IsMemBackendURL(url) {
+		return nil, filestate.ErrMemBackendLogin
+	}
 	if filestate.IsFileStateBackendURL(url) {
 		return filestate.New(ctx, cmdutil.Diag(), url, project)
 	}
+	if sqlstate.IsSQLStateBackendURL(url) {
+		return sqlstate.New(ctx, cmdutil.Diag(), url, project)
+	}
 	return httpstate.NewLoginManager().Login(ctx, cmdutil.Diag(), url, project, workspace.GetCloudInsecure(url), opts)
 }
 
@@ -204,6 +224,8 @@ func createSecretsManager(
 		_, err = stack.DefaultSecretManager(ps)
 	} else if secretsProvider == passphrase.Type {
 		_, err = passphrase.NewPromptingPassphraseSecretsManager(ps, rotateSecretsProvider)
+	} else if age.IsAgeSecretsProvider(secretsProvider) {
+		_, err = age.NewAgeSecretsManager(ps, secretsProvider, rotateSecretsProvider)
 	} else {
 		// All other non-default secrets providers are handled by the cloud secrets provider which
 		// uses a URL schema to identify the provider
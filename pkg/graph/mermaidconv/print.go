@@ -0,0 +1,115 @@
+// Copyright 2016-2023, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package mermaidconv converts a resource graph into a Mermaid flowchart.  Mermaid diagrams can be embedded in
+// Markdown documents, and are rendered by GitHub, GitLab and many other tools.  Please see
+// https://mermaid.js.org/syntax/flowchart.html for a specification of the flowchart syntax.
+package mermaidconv
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/pulumi/pulumi/pkg/v3/graph"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/contract"
+)
+
+// Print prints a resource graph as a Mermaid flowchart.  Vertices are visited in the same breadth-first order as the
+// DOT printer, so graphs whose roots are ordered deterministically print deterministically.
+func Print(g graph.Graph, w io.Writer) error {
+	// As in dotconv, we ignore write errors until the end, when flushing the buffer reports the first of them.
+	b := bufio.NewWriter(w)
+	fmt.Fprintf(b, "flowchart LR\n")
+
+	// Initialize the frontier with unvisited graph vertices.
+	queued := make(map[graph.Vertex]bool)
+	frontier := make([]graph.Vertex, 0, len(g.Roots()))
+	for _, root := range g.Roots() {
+		to := root.To()
+		if !queued[to] {
+			queued[to] = true
+			frontier = append(frontier, to)
+		}
+	}
+
+	// Mermaid node IDs can't contain most of the characters in a URN, so we auto-generate them and show the vertex
+	// labels instead.
+	ids := make(map[graph.Vertex]string)
+	getID := func(v graph.Vertex) string {
+		if id, has := ids[v]; has {
+			return id
+		}
+		id := "Resource" + strconv.Itoa(len(ids))
+		ids[v] = id
+		return id
+	}
+
+	// Links are styled after they're all declared, by their index in the order they were declared.
+	var linkStyles []string
+	links := 0
+
+	indent := "    "
+	emitted := make(map[graph.Vertex]bool)
+	for len(frontier) > 0 {
+		v := frontier[0]
+		frontier = frontier[1:]
+		contract.Assertf(!emitted[v], "vertex was emitted twice")
+		emitted[v] = true
+
+		id := getID(v)
+		if label := v.Label(); label != "" {
+			fmt.Fprintf(b, "%s%s[\"%s\"]\n", indent, id, escape(label))
+		} else {
+			fmt.Fprintf(b, "%s%s\n", indent, id)
+		}
+
+		for _, out := range v.Outs() {
+			to := out.To()
+			if label := out.Label(); label != "" {
+				fmt.Fprintf(b, "%s%s -->|\"%s\"| %s\n", indent, id, escape(label), getID(to))
+			} else {
+				fmt.Fprintf(b, "%s%s --> %s\n", indent, id, getID(to))
+			}
+
+			if color := out.Color(); color != "" {
+				linkStyles = append(linkStyles, fmt.Sprintf("%slinkStyle %d stroke:%s", indent, links, color))
+			}
+			links++
+
+			if !queued[to] {
+				queued[to] = true
+				frontier = append(frontier, to)
+			}
+		}
+	}
+
+	for _, style := range linkStyles {
+		fmt.Fprintf(b, "%s\n", style)
+	}
+	return b.Flush()
+}
+
+// escape replaces the characters that can't appear in quoted Mermaid text with their entity codes.
+func escape(s string) string {
+	return strings.NewReplacer(
+		"#", "#35;",
+		"\"", "#quot;",
+		"<", "#lt;",
+		">", "#gt;",
+		"\n", " ",
+	).Replace(s)
+}
@@ -989,6 +989,7 @@ func (s *ImportStep) Type() tokens.Type                            { return s.ne
 func (s *ImportStep) Provider() string                             { return s.new.Provider }
 func (s *ImportStep) URN() resource.URN                            { return s.new.URN }
 func (s *ImportStep) Old() *resource.State                         { return s.old }
+func (s *ImportStep) Original() *resource.State                    { return s.original }
 func (s *ImportStep) New() *resource.State                         { return s.new }
 func (s *ImportStep) Res() *resource.State                         { return s.new }
 func (s *ImportStep) Logical() bool                                { return !s.replacing }
@@ -0,0 +1,246 @@
+// Copyright 2016-2023, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package edit
+
+import (
+	"fmt"
+
+	"github.com/pulumi/pulumi/pkg/v3/resource/deploy"
+	"github.com/pulumi/pulumi/pkg/v3/resource/deploy/providers"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/resource"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/util/contract"
+)
+
+// RepairKind identifies a class of snapshot integrity problem.
+type RepairKind string
+
+const (
+	// RepairMagic fixes a manifest whose magic cookie doesn't match its version.
+	RepairMagic RepairKind = "magic"
+	// RepairPendingOperation clears a pending operation left behind by an interrupted update.
+	RepairPendingOperation RepairKind = "pending-operation"
+	// RepairOrphan re-parents a resource whose parent no longer exists to the root stack resource.
+	RepairOrphan RepairKind = "orphan"
+	// RepairDanglingDependency drops a dependency on a resource that no longer exists.
+	RepairDanglingDependency RepairKind = "dangling-dependency"
+	// RepairOrder moves a resource after the resources that it depends on.
+	RepairOrder RepairKind = "order"
+	// RepairMissingProvider reports a resource whose provider no longer exists. This can't be repaired automatically.
+	RepairMissingProvider RepairKind = "missing-provider"
+	// RepairDuplicateURN reports several live resources sharing a URN. This can't be repaired automatically.
+	RepairDuplicateURN RepairKind = "duplicate-urn"
+	// RepairCycle reports resources that depend on each other. This can't be repaired automatically.
+	RepairCycle RepairKind = "cycle"
+)
+
+// Repair describes a single problem found in a snapshot, and the fix applied for it, if any.
+type Repair struct {
+	Kind        RepairKind   `json:"kind"`
+	URN         resource.URN `json:"urn,omitempty"`
+	Description string       `json:"description"`
+	// Fixed is false for problems that can't be repaired automatically.
+	Fixed bool `json:"fixed"`
+}
+
+// RepairSnapshot finds integrity problems in the given snapshot and fixes the ones it can in-place: it recomputes the
+// manifest's magic cookie, clears pending operations, drops dependencies on missing resources, re-parents orphans to
+// the root stack resource (or to no parent if there isn't one), and topologically re-sorts the resources. The
+// returned list describes every problem found, in the order they were handled. Problems that can't be fixed are
+// included with Fixed set to false, and leave the snapshot failing VerifyIntegrity.
+func RepairSnapshot(snap *deploy.Snapshot) []Repair {
+	contract.Requiref(snap != nil, "snap", "must not be nil")
+
+	var repairs []Repair
+	fixed := func(kind RepairKind, urn resource.URN, format string, args ...interface{}) {
+		repairs = append(repairs, Repair{Kind: kind, URN: urn, Description: fmt.Sprintf(format, args...), Fixed: true})
+	}
+	unfixable := func(kind RepairKind, urn resource.URN, format string, args ...interface{}) {
+		repairs = append(repairs, Repair{Kind: kind, URN: urn, Description: fmt.Sprintf(format, args...)})
+	}
+
+	if magic := snap.Manifest.NewMagic(); snap.Manifest.Magic != magic {
+		fixed(RepairMagic, "", "recompute the manifest's magic cookie")
+		snap.Manifest.Magic = magic
+	}
+
+	for _, op := range snap.PendingOperations {
+		fixed(RepairPendingOperation, op.Resource.URN, "clear pending %s operation", op.Type)
+	}
+	snap.PendingOperations = nil
+
+	// Index the resources that exist, so that we can find dangling references regardless of ordering.
+	byURN := make(map[resource.URN][]*resource.State)
+	var root resource.URN
+	for _, res := range snap.Resources {
+		byURN[res.URN] = append(byURN[res.URN], res)
+		if res.Type == resource.RootStackType && res.Parent == "" && root == "" {
+			root = res.URN
+		}
+	}
+
+	live := make(map[resource.URN]bool)
+	for _, res := range snap.Resources {
+		if !res.Delete {
+			if live[res.URN] {
+				unfixable(RepairDuplicateURN, res.URN, "multiple resources share this URN and are not pending deletion")
+			}
+			live[res.URN] = true
+		}
+	}
+
+	dropMissing := func(res *resource.State, deps []resource.URN) []resource.URN {
+		var kept []resource.URN
+		for _, dep := range deps {
+			if _, has := byURN[dep]; has {
+				kept = append(kept, dep)
+			} else {
+				fixed(RepairDanglingDependency, res.URN, "drop dependency on missing resource %s", dep)
+			}
+		}
+		return kept
+	}
+
+	for _, res := range snap.Resources {
+		if res.Parent != "" {
+			if _, has := byURN[res.Parent]; !has {
+				newParent := root
+				if newParent == res.URN {
+					newParent = ""
+				}
+				if newParent == "" {
+					fixed(RepairOrphan, res.URN, "remove missing parent %s", res.Parent)
+				} else {
+					fixed(RepairOrphan, res.URN, "re-parent from missing parent %s to %s", res.Parent, newParent)
+				}
+				res.Parent = newParent
+			}
+		}
+
+		res.Dependencies = dropMissing(res, res.Dependencies)
+		for k, deps := range res.PropertyDependencies {
+			res.PropertyDependencies[k] = dropMissing(res, deps)
+		}
+		if res.DeletedWith != "" {
+			if _, has := byURN[res.DeletedWith]; !has {
+				fixed(RepairDanglingDependency, res.URN, "drop deleted-with reference to missing resource %s",
+					res.DeletedWith)
+				res.DeletedWith = ""
+			}
+		}
+
+		if res.Provider != "" {
+			ref, err := providers.ParseReference(res.Provider)
+			if err != nil {
+				unfixable(RepairMissingProvider, res.URN, "invalid provider reference %q: %v", res.Provider, err)
+				continue
+			}
+			found := false
+			for _, prov := range byURN[ref.URN()] {
+				if prov.ID == ref.ID() {
+					found = true
+					break
+				}
+			}
+			if !found {
+				unfixable(RepairMissingProvider, res.URN, "provider %s does not exist", ref)
+			}
+		}
+	}
+
+	sorted, moved, cycle := sortResources(snap.Resources, byURN)
+	if cycle != nil {
+		unfixable(RepairCycle, cycle.URN, "resource is part of a dependency cycle")
+	} else {
+		for _, res := range moved {
+			fixed(RepairOrder, res.URN, "move after the resources it depends on")
+		}
+		snap.Resources = sorted
+	}
+
+	return repairs
+}
+
+// sortResources topologically sorts resources so that each resource comes after its parent, provider and
+// dependencies, keeping the existing order wherever possible. It returns the sorted resources and the resources
+// that had to move, or a resource that is part of a cycle if there is no valid order.
+func sortResources(
+	resources []*resource.State, byURN map[resource.URN][]*resource.State,
+) ([]*resource.State, []*resource.State, *resource.State) {
+	prereqs := func(res *resource.State) []resource.URN {
+		urns := make([]resource.URN, 0, len(res.Dependencies)+2)
+		if res.Parent != "" {
+			urns = append(urns, res.Parent)
+		}
+		if res.Provider != "" {
+			if ref, err := providers.ParseReference(res.Provider); err == nil {
+				urns = append(urns, ref.URN())
+			}
+		}
+		urns = append(urns, res.Dependencies...)
+		for _, deps := range res.PropertyDependencies {
+			urns = append(urns, deps...)
+		}
+		if res.DeletedWith != "" {
+			urns = append(urns, res.DeletedWith)
+		}
+		return urns
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	state := make(map[*resource.State]int, len(resources))
+	sorted := make([]*resource.State, 0, len(resources))
+	var moved []*resource.State
+	isMoved := make(map[*resource.State]bool)
+
+	var visit func(res *resource.State) *resource.State
+	visit = func(res *resource.State) *resource.State {
+		switch state[res] {
+		case done:
+			return nil
+		case visiting:
+			return res
+		}
+		state[res] = visiting
+		for _, urn := range prereqs(res) {
+			for _, dep := range byURN[urn] {
+				if dep == res {
+					continue
+				}
+				if state[dep] == unvisited && !isMoved[res] {
+					// A prerequisite that hasn't been emitted yet means this resource was out of order.
+					isMoved[res] = true
+					moved = append(moved, res)
+				}
+				if cycle := visit(dep); cycle != nil {
+					return cycle
+				}
+			}
+		}
+		state[res] = done
+		sorted = append(sorted, res)
+		return nil
+	}
+
+	for _, res := range resources {
+		if cycle := visit(res); cycle != nil {
+			return nil, nil, cycle
+		}
+	}
+	return sorted, moved, nil
+}
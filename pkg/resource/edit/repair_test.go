@@ -0,0 +1,130 @@
+// Copyright 2016-2023, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package edit
+
+import (
+	"testing"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/resource"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/tokens"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func repairKinds(repairs []Repair) []RepairKind {
+	kinds := make([]RepairKind, len(repairs))
+	for i, r := range repairs {
+		kinds[i] = r.Kind
+	}
+	return kinds
+}
+
+func TestRepairValidSnapshot(t *testing.T) {
+	t.Parallel()
+
+	pA := NewProviderResource("a", "p1", "0")
+	a := NewResource("a", pA)
+	b := NewResource("b", pA, a.URN)
+	snap := NewSnapshot([]*resource.State{pA, a, b})
+	require.NoError(t, snap.VerifyIntegrity())
+
+	assert.Empty(t, RepairSnapshot(snap))
+	assert.Equal(t, []*resource.State{pA, a, b}, snap.Resources)
+}
+
+func TestRepairOrdering(t *testing.T) {
+	t.Parallel()
+
+	pA := NewProviderResource("a", "p1", "0")
+	a := NewResource("a", pA)
+	b := NewResource("b", pA, a.URN)
+	c := NewResource("c", pA, b.URN)
+	snap := NewSnapshot([]*resource.State{c, b, pA, a})
+	require.Error(t, snap.VerifyIntegrity())
+
+	repairs := RepairSnapshot(snap)
+	assert.Equal(t, []RepairKind{RepairOrder, RepairOrder}, repairKinds(repairs))
+	assert.Equal(t, []*resource.State{pA, a, b, c}, snap.Resources)
+	assert.NoError(t, snap.VerifyIntegrity())
+}
+
+func TestRepairDanglingReferences(t *testing.T) {
+	t.Parallel()
+
+	pA := NewProviderResource("a", "p1", "0")
+	stackType := resource.RootStackType
+	root := &resource.State{
+		Type: stackType,
+		URN:  resource.NewURN("test", "test", "", stackType, tokens.QName("test-test")),
+	}
+	a := NewResource("a", pA, "urn:pulumi:test::test::a:b:c::gone")
+	a.Parent = "urn:pulumi:test::test::a:b:c::missing-parent"
+	a.PropertyDependencies = map[resource.PropertyKey][]resource.URN{
+		"foo": {"urn:pulumi:test::test::a:b:c::gone"},
+	}
+	snap := NewSnapshot([]*resource.State{root, pA, a})
+	snap.PendingOperations = []resource.Operation{resource.NewOperation(a, resource.OperationTypeUpdating)}
+	require.Error(t, snap.VerifyIntegrity())
+
+	repairs := RepairSnapshot(snap)
+	assert.Equal(t, []RepairKind{
+		RepairPendingOperation,
+		RepairOrphan,
+		RepairDanglingDependency,
+		RepairDanglingDependency,
+	}, repairKinds(repairs))
+	for _, r := range repairs {
+		assert.True(t, r.Fixed)
+	}
+
+	assert.Equal(t, root.URN, a.Parent)
+	assert.Empty(t, a.Dependencies)
+	assert.Empty(t, a.PropertyDependencies["foo"])
+	assert.Empty(t, snap.PendingOperations)
+	assert.NoError(t, snap.VerifyIntegrity())
+}
+
+func TestRepairUnfixable(t *testing.T) {
+	t.Parallel()
+
+	pA := NewProviderResource("a", "p1", "0")
+	a := NewResource("a", pA)
+	a2 := NewResource("a", pA)
+	b := NewResource("b", NewProviderResource("b", "missing", "1"))
+	snap := NewSnapshot([]*resource.State{pA, a, a2, b})
+
+	repairs := RepairSnapshot(snap)
+	assert.Equal(t, []RepairKind{RepairDuplicateURN, RepairMissingProvider}, repairKinds(repairs))
+	for _, r := range repairs {
+		assert.False(t, r.Fixed)
+	}
+	assert.Error(t, snap.VerifyIntegrity())
+}
+
+func TestRepairCycle(t *testing.T) {
+	t.Parallel()
+
+	a := NewResource("a", nil, "urn:pulumi:test::test::a:b:c::b")
+	b := NewResource("b", nil, a.URN)
+	snap := NewSnapshot([]*resource.State{a, b})
+
+	repairs := RepairSnapshot(snap)
+	require.Len(t, repairs, 1)
+	assert.Equal(t, RepairCycle, repairs[0].Kind)
+	assert.False(t, repairs[0].Fixed)
+	// The original order is kept when there's no valid order to sort into.
+	assert.Equal(t, []*resource.State{a, b}, snap.Resources)
+}
@@ -0,0 +1,268 @@
+// Copyright 2016-2023, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package age implements a secrets manager that encrypts the stack's data key to age X25519 recipients.
+//
+// The recipients are listed in the secrets provider URL, as in age://age1...,age1..., and the data key is decrypted
+// with the identities in the file named by PULUMI_AGE_IDENTITY, or otherwise in ~/.config/pulumi/age.
+package age
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"filippo.io/age"
+
+	"github.com/pulumi/pulumi/pkg/v3/secrets"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/resource/config"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/workspace"
+)
+
+// Type is the type of secrets managed by this secrets provider
+const Type = "age"
+
+// IdentityEnvVar names the environment variable that holds the path of the age identity file to decrypt with.
+const IdentityEnvVar = "PULUMI_AGE_IDENTITY"
+
+type ageSecretsManagerState struct {
+	URL          string `json:"url"`
+	EncryptedKey []byte `json:"encryptedkey"`
+}
+
+// IsAgeSecretsProvider returns true if a secrets provider URL names age recipients.
+func IsAgeSecretsProvider(url string) bool {
+	return strings.HasPrefix(url, Type+"://")
+}
+
+// parseRecipients returns the recipients listed in an age://age1...,age1... secrets provider URL.
+func parseRecipients(url string) ([]age.Recipient, error) {
+	if !IsAgeSecretsProvider(url) {
+		return nil, fmt.Errorf("the secrets provider URL %q must start with %s://", url, Type)
+	}
+
+	var recipients []age.Recipient
+	for _, s := range strings.Split(strings.TrimPrefix(url, Type+"://"), ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		r, err := age.ParseX25519Recipient(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid age recipient %q: %w", s, err)
+		}
+		recipients = append(recipients, r)
+	}
+	if len(recipients) == 0 {
+		return nil, fmt.Errorf("the secrets provider URL %q has no recipients; list them as %s://age1...,age1...",
+			url, Type)
+	}
+	return recipients, nil
+}
+
+// ValidateSecretsProvider checks that an age secrets provider URL lists valid recipients.
+func ValidateSecretsProvider(url string) error {
+	_, err := parseRecipients(url)
+	return err
+}
+
+// identityPath returns the path of the age identity file: the one named by PULUMI_AGE_IDENTITY, or otherwise
+// ~/.config/pulumi/age.
+func identityPath() (string, error) {
+	if path := os.Getenv(IdentityEnvVar); path != "" {
+		return path, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("getting the home directory: %w", err)
+	}
+	return filepath.Join(home, ".config", "pulumi", "age"), nil
+}
+
+// readIdentities reads the age identities in the identity file.
+func readIdentities() ([]age.Identity, error) {
+	path, err := identityPath()
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("no age identity file found at %s; set %s to the path of an identity file "+
+				"for one of the stack's recipients", path, IdentityEnvVar)
+		}
+		return nil, fmt.Errorf("opening the age identity file: %w", err)
+	}
+	defer f.Close()
+
+	identities, err := age.ParseIdentities(f)
+	if err != nil {
+		return nil, fmt.Errorf("reading the age identity file %s: %w", path, err)
+	}
+	return identities, nil
+}
+
+// generateNewDataKey generates a fresh random 32-byte data key, and returns it along with its encryption to the given
+// recipients.
+func generateNewDataKey(recipients []age.Recipient) ([]byte, []byte, error) {
+	plaintextDataKey := make([]byte, 32)
+	if _, err := rand.Read(plaintextDataKey); err != nil {
+		return nil, nil, err
+	}
+	encryptedDataKey, err := encryptDataKey(plaintextDataKey, recipients)
+	if err != nil {
+		return nil, nil, err
+	}
+	return plaintextDataKey, encryptedDataKey, nil
+}
+
+// encryptDataKey encrypts a data key to the given recipients.
+func encryptDataKey(plaintextDataKey []byte, recipients []age.Recipient) ([]byte, error) {
+	var encrypted bytes.Buffer
+	w, err := age.Encrypt(&encrypted, recipients...)
+	if err != nil {
+		return nil, fmt.Errorf("encrypting the data key: %w", err)
+	}
+	if _, err = w.Write(plaintextDataKey); err != nil {
+		return nil, fmt.Errorf("encrypting the data key: %w", err)
+	}
+	if err = w.Close(); err != nil {
+		return nil, fmt.Errorf("encrypting the data key: %w", err)
+	}
+	return encrypted.Bytes(), nil
+}
+
+// decryptDataKey decrypts a data key with whichever of the given identities it was encrypted to.
+func decryptDataKey(encryptedDataKey []byte, identities []age.Identity) ([]byte, error) {
+	r, err := age.Decrypt(bytes.NewReader(encryptedDataKey), identities...)
+	if err != nil {
+		var noMatch *age.NoIdentityMatchError
+		if errors.As(err, &noMatch) {
+			return nil, errors.New("none of the age identities can decrypt the stack's data key; " +
+				"the stack's secrets provider must list the recipient of one of them")
+		}
+		return nil, fmt.Errorf("decrypting the data key: %w", err)
+	}
+	plaintextDataKey, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("decrypting the data key: %w", err)
+	}
+	return plaintextDataKey, nil
+}
+
+// WrapDataKey encrypts an existing data key to the age recipients in url, for a stack whose data key is wrapped
+// separately for each of several recipients.
+func WrapDataKey(url string, plaintextDataKey []byte) ([]byte, error) {
+	recipients, err := parseRecipients(url)
+	if err != nil {
+		return nil, err
+	}
+	return encryptDataKey(plaintextDataKey, recipients)
+}
+
+// UnwrapDataKey decrypts a data key encrypted by WrapDataKey, with the age identity file.
+func UnwrapDataKey(encryptedDataKey []byte) ([]byte, error) {
+	identities, err := readIdentities()
+	if err != nil {
+		return nil, err
+	}
+	return decryptDataKey(encryptedDataKey, identities)
+}
+
+// newAgeSecretsManager returns a secrets manager that uses a data key, encrypted to the age recipients in url, for
+// envelope encryption of secrets values.
+func newAgeSecretsManager(url string, encryptedDataKey, plaintextDataKey []byte) *Manager {
+	return &Manager{
+		crypter: config.NewSymmetricCrypter(plaintextDataKey),
+		state: ageSecretsManagerState{
+			URL:          url,
+			EncryptedKey: encryptedDataKey,
+		},
+	}
+}
+
+// Manager is the secrets.Manager implementation for age recipients
+type Manager struct {
+	state   ageSecretsManagerState
+	crypter config.Crypter
+}
+
+func (m *Manager) Type() string                         { return Type }
+func (m *Manager) State() interface{}                   { return m.state }
+func (m *Manager) Encrypter() (config.Encrypter, error) { return m.crypter, nil }
+func (m *Manager) Decrypter() (config.Decrypter, error) { return m.crypter, nil }
+func (m *Manager) EncryptedKey() []byte                 { return m.state.EncryptedKey }
+
+// NewAgeSecretsManagerFromState deserializes configuration from state and returns a secrets manager whose data key is
+// decrypted with the age identity file.
+func NewAgeSecretsManagerFromState(state json.RawMessage) (secrets.Manager, error) {
+	var s ageSecretsManagerState
+	if err := json.Unmarshal(state, &s); err != nil {
+		return nil, fmt.Errorf("unmarshalling state: %w", err)
+	}
+
+	plaintextDataKey, err := UnwrapDataKey(s.EncryptedKey)
+	if err != nil {
+		return nil, err
+	}
+	return newAgeSecretsManager(s.URL, s.EncryptedKey, plaintextDataKey), nil
+}
+
+// NewAgeSecretsManager returns a secrets manager for the age recipients in secretsProvider. A new data key is
+// generated, and encrypted to the recipients, if the stack doesn't have one yet, its secrets provider is changing, or
+// rotateSecretsProvider is set; otherwise the stack's data key is decrypted with the age identity file.
+func NewAgeSecretsManager(info *workspace.ProjectStack,
+	secretsProvider string, rotateSecretsProvider bool,
+) (secrets.Manager, error) {
+	recipients, err := parseRecipients(secretsProvider)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only a passphrase provider has an encryption salt, and only the multi-recipient provider has recipients, so
+	// remove any left over from them.
+	info.EncryptionSalt = ""
+	info.SecretsRecipients = nil
+
+	if rotateSecretsProvider {
+		info.EncryptedKey = ""
+	}
+
+	if info.EncryptedKey == "" || info.SecretsProvider != secretsProvider {
+		plaintextDataKey, encryptedDataKey, err := generateNewDataKey(recipients)
+		if err != nil {
+			return nil, err
+		}
+		info.EncryptedKey = base64.StdEncoding.EncodeToString(encryptedDataKey)
+		info.SecretsProvider = secretsProvider
+		return newAgeSecretsManager(secretsProvider, encryptedDataKey, plaintextDataKey), nil
+	}
+
+	encryptedDataKey, err := base64.StdEncoding.DecodeString(info.EncryptedKey)
+	if err != nil {
+		return nil, err
+	}
+	plaintextDataKey, err := UnwrapDataKey(encryptedDataKey)
+	if err != nil {
+		return nil, err
+	}
+	return newAgeSecretsManager(secretsProvider, encryptedDataKey, plaintextDataKey), nil
+}
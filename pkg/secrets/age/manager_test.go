@@ -0,0 +1,126 @@
+// Copyright 2016-2023, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package age
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"filippo.io/age"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/workspace"
+)
+
+func generateIdentity(t *testing.T) *age.X25519Identity {
+	id, err := age.GenerateX25519Identity()
+	require.NoError(t, err)
+	return id
+}
+
+// writeIdentityFile writes an identity file, in the format that age-keygen writes, and points PULUMI_AGE_IDENTITY at
+// it.
+func writeIdentityFile(t *testing.T, ids ...*age.X25519Identity) {
+	content := "# created: 2023-05-16T00:00:00Z\n"
+	for _, id := range ids {
+		content += "# public key: " + id.Recipient().String() + "\n" + id.String() + "\n"
+	}
+	path := filepath.Join(t.TempDir(), "age")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	t.Setenv(IdentityEnvVar, path)
+}
+
+func TestParseRecipients(t *testing.T) {
+	t.Parallel()
+
+	alice, bob := generateIdentity(t).Recipient(), generateIdentity(t).Recipient()
+
+	recipients, err := parseRecipients("age://" + alice.String() + ", " + bob.String())
+	require.NoError(t, err)
+	assert.Equal(t, []age.Recipient{alice, bob}, recipients)
+
+	_, err = parseRecipients("age://")
+	assert.ErrorContains(t, err, "has no recipients")
+	_, err = parseRecipients("age://age1notarecipient")
+	assert.ErrorContains(t, err, `invalid age recipient "age1notarecipient"`)
+	_, err = parseRecipients("awskms://alias/key")
+	assert.ErrorContains(t, err, "must start with age://")
+}
+
+//nolint:paralleltest // mutates environment variables
+func TestNewAgeSecretsManager(t *testing.T) {
+	ctx := context.Background()
+	alice, bob, eve := generateIdentity(t), generateIdentity(t), generateIdentity(t)
+	url := "age://" + alice.Recipient().String() + "," + bob.Recipient().String()
+
+	// Creating the stack's data key only needs the recipients, not an identity.
+	t.Setenv(IdentityEnvVar, filepath.Join(t.TempDir(), "missing"))
+	ps := &workspace.ProjectStack{EncryptionSalt: "v1:left-over"}
+	sm, err := NewAgeSecretsManager(ps, url, false /* rotateSecretsProvider */)
+	require.NoError(t, err)
+	assert.Equal(t, Type, sm.Type())
+	assert.Equal(t, url, ps.SecretsProvider)
+	assert.Empty(t, ps.EncryptionSalt)
+	assert.NotEmpty(t, ps.EncryptedKey)
+
+	enc, err := sm.Encrypter()
+	require.NoError(t, err)
+	ciphertext, err := enc.EncryptValue(ctx, "hunter2")
+	require.NoError(t, err)
+
+	// Reading it back needs an identity for one of the recipients.
+	_, err = NewAgeSecretsManager(ps, url, false /* rotateSecretsProvider */)
+	assert.ErrorContains(t, err, "no age identity file found")
+
+	writeIdentityFile(t, eve)
+	_, err = NewAgeSecretsManager(ps, url, false /* rotateSecretsProvider */)
+	assert.ErrorContains(t, err, "none of the age identities can decrypt the stack's data key")
+
+	writeIdentityFile(t, eve, bob)
+	sm, err = NewAgeSecretsManager(ps, url, false /* rotateSecretsProvider */)
+	require.NoError(t, err)
+	dec, err := sm.Decrypter()
+	require.NoError(t, err)
+	plaintext, err := dec.DecryptValue(ctx, ciphertext)
+	require.NoError(t, err)
+	assert.Equal(t, "hunter2", plaintext)
+
+	// The state saved with a deployment is enough to rebuild the secrets manager.
+	state, err := json.Marshal(sm.State())
+	require.NoError(t, err)
+	sm, err = NewAgeSecretsManagerFromState(state)
+	require.NoError(t, err)
+	dec, err = sm.Decrypter()
+	require.NoError(t, err)
+	plaintext, err = dec.DecryptValue(ctx, ciphertext)
+	require.NoError(t, err)
+	assert.Equal(t, "hunter2", plaintext)
+
+	// Rotating or changing the recipients generates a new data key.
+	key := ps.EncryptedKey
+	_, err = NewAgeSecretsManager(ps, url, true /* rotateSecretsProvider */)
+	require.NoError(t, err)
+	assert.NotEqual(t, key, ps.EncryptedKey)
+
+	key = ps.EncryptedKey
+	_, err = NewAgeSecretsManager(ps, "age://"+eve.Recipient().String(), false /* rotateSecretsProvider */)
+	require.NoError(t, err)
+	assert.NotEqual(t, key, ps.EncryptedKey)
+	assert.Equal(t, "age://"+eve.Recipient().String(), ps.SecretsProvider)
+}
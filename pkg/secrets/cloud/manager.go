@@ -81,21 +81,32 @@ func generateNewDataKey(url string) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	keeper, err := openKeeper(context.Background(), url)
+	return WrapDataKey(context.Background(), url, plaintextDataKey)
+}
+
+// WrapDataKey encrypts an existing data key using the target cloud key management service, for a stack whose data
+// key is wrapped separately for each of several recipients.
+func WrapDataKey(ctx context.Context, url string, plaintextDataKey []byte) ([]byte, error) {
+	keeper, err := openKeeper(ctx, url)
 	if err != nil {
 		return nil, err
 	}
-	return keeper.Encrypt(context.Background(), plaintextDataKey)
+	return keeper.Encrypt(ctx, plaintextDataKey)
 }
 
-// newCloudSecretsManager returns a secrets manager that uses the target cloud key management
-// service to encrypt/decrypt a data key used for envelope encryption of secrets values.
-func newCloudSecretsManager(url string, encryptedDataKey []byte) (*Manager, error) {
-	keeper, err := openKeeper(context.Background(), url)
+// UnwrapDataKey decrypts a data key using the target cloud key management service.
+func UnwrapDataKey(ctx context.Context, url string, encryptedDataKey []byte) ([]byte, error) {
+	keeper, err := openKeeper(ctx, url)
 	if err != nil {
 		return nil, err
 	}
-	plaintextDataKey, err := keeper.Decrypt(context.Background(), encryptedDataKey)
+	return keeper.Decrypt(ctx, encryptedDataKey)
+}
+
+// newCloudSecretsManager returns a secrets manager that uses the target cloud key management
+// service to encrypt/decrypt a data key used for envelope encryption of secrets values.
+func newCloudSecretsManager(url string, encryptedDataKey []byte) (*Manager, error) {
+	plaintextDataKey, err := UnwrapDataKey(context.Background(), url, encryptedDataKey)
 	if err != nil {
 		return nil, err
 	}
@@ -140,6 +151,8 @@ func NewCloudSecretsManager(info *workspace.ProjectStack,
 	// from passphrase to a cloud secrets provider should ensure that we remove the enryptionsalt
 	// as it's a legacy artifact and needs to be removed
 	info.EncryptionSalt = ""
+	// Nor does a cloud secrets provider wrap its data key for other recipients.
+	info.SecretsRecipients = nil
 
 	var secretsManager *Manager
 
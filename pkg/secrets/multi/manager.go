@@ -0,0 +1,350 @@
+// Copyright 2016-2023, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package multi implements a secrets manager whose data key is wrapped separately for each of several recipients,
+// such as passphrases, age recipients and cloud keys, any one of which can decrypt it.
+//
+// Recipients can be added and removed by wrapping the data key again, without re-encrypting any secrets.
+package multi
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/pulumi/pulumi/pkg/v3/secrets"
+	"github.com/pulumi/pulumi/pkg/v3/secrets/age"
+	"github.com/pulumi/pulumi/pkg/v3/secrets/cloud"
+	"github.com/pulumi/pulumi/pkg/v3/secrets/passphrase"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/resource/config"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/workspace"
+)
+
+// Type is the type of secrets managed by this secrets provider
+const Type = "multi"
+
+type multiSecretsManagerState struct {
+	Recipients []workspace.SecretsRecipient `json:"recipients"`
+}
+
+// Manager is the secrets.Manager implementation for data keys wrapped for several recipients
+type Manager struct {
+	state   multiSecretsManagerState
+	crypter config.Crypter
+}
+
+func (m *Manager) Type() string                         { return Type }
+func (m *Manager) State() interface{}                   { return m.state }
+func (m *Manager) Encrypter() (config.Encrypter, error) { return m.crypter, nil }
+func (m *Manager) Decrypter() (config.Decrypter, error) { return m.crypter, nil }
+
+// Recipients returns the recipients that the data key is wrapped for.
+func (m *Manager) Recipients() []workspace.SecretsRecipient { return m.state.Recipients }
+
+func newMultiSecretsManager(recipients []workspace.SecretsRecipient, plaintextDataKey []byte) *Manager {
+	setCachedDataKey(recipients, plaintextDataKey)
+	return &Manager{
+		crypter: config.NewSymmetricCrypter(plaintextDataKey),
+		state:   multiSecretsManagerState{Recipients: recipients},
+	}
+}
+
+// isPassphraseRecipient returns true if a recipient is a passphrase. A stack can have several passphrases if they're
+// given names, as in passphrase://alice.
+func isPassphraseRecipient(provider string) bool {
+	return provider == passphrase.Type || strings.HasPrefix(provider, passphrase.Type+"://")
+}
+
+// wrapDataKey wraps a data key for a recipient. The passphrase of a passphrase recipient is prompted for.
+func wrapDataKey(ctx context.Context, provider string, plaintextDataKey []byte) (string, error) {
+	switch {
+	case isPassphraseRecipient(provider):
+		phrase, err := passphrase.ReadNewPassphrase()
+		if err != nil {
+			return "", err
+		}
+		return passphrase.WrapDataKey(phrase, plaintextDataKey)
+	case age.IsAgeSecretsProvider(provider):
+		wrapped, err := age.WrapDataKey(provider, plaintextDataKey)
+		if err != nil {
+			return "", err
+		}
+		return base64.StdEncoding.EncodeToString(wrapped), nil
+	default:
+		wrapped, err := cloud.WrapDataKey(ctx, provider, plaintextDataKey)
+		if err != nil {
+			return "", err
+		}
+		return base64.StdEncoding.EncodeToString(wrapped), nil
+	}
+}
+
+var (
+	lock  sync.Mutex
+	cache map[string][]byte
+)
+
+// cacheKey returns the key that the data key for a set of recipients is cached by.
+func cacheKey(recipients []workspace.SecretsRecipient) string {
+	keys := make([]string, len(recipients))
+	for i, r := range recipients {
+		keys[i] = r.EncryptedKey
+	}
+	return strings.Join(keys, "\n")
+}
+
+// clearCachedDataKeys is used to clear the cache, for tests.
+func clearCachedDataKeys() {
+	lock.Lock()
+	defer lock.Unlock()
+	cache = nil
+}
+
+// getCachedDataKey returns the cached data key for a set of recipients and true, or nil and false if not in the cache.
+func getCachedDataKey(recipients []workspace.SecretsRecipient) ([]byte, bool) {
+	lock.Lock()
+	defer lock.Unlock()
+	key, ok := cache[cacheKey(recipients)]
+	return key, ok
+}
+
+// setCachedDataKey saves the data key for a set of recipients in the cache.
+func setCachedDataKey(recipients []workspace.SecretsRecipient, key []byte) {
+	lock.Lock()
+	defer lock.Unlock()
+	if cache == nil {
+		cache = make(map[string][]byte)
+	}
+	cache[cacheKey(recipients)] = key
+}
+
+// unlockDataKey returns the data key for a set of recipients, unwrapping it if it isn't cached. Both a stack's config
+// and its checkpoint need the data key, so caching it means that passphrases are only prompted for once.
+func unlockDataKey(ctx context.Context, recipients []workspace.SecretsRecipient) ([]byte, error) {
+	if key, ok := getCachedDataKey(recipients); ok {
+		return key, nil
+	}
+	return unwrapDataKey(ctx, recipients)
+}
+
+// unwrapDataKey unwraps the data key with whichever of the recipients can. Age and cloud recipients are tried first,
+// as they don't need a passphrase, and then the passphrase recipients are tried with the one passphrase.
+func unwrapDataKey(ctx context.Context, recipients []workspace.SecretsRecipient) ([]byte, error) {
+	var problems []string
+	var passphrases []string
+	for _, r := range recipients {
+		if isPassphraseRecipient(r.Provider) {
+			passphrases = append(passphrases, r.EncryptedKey)
+			continue
+		}
+
+		wrapped, err := base64.StdEncoding.DecodeString(r.EncryptedKey)
+		if err != nil {
+			return nil, fmt.Errorf("the data key for recipient '%s' is malformed: %w", r.Provider, err)
+		}
+		var key []byte
+		if age.IsAgeSecretsProvider(r.Provider) {
+			key, err = age.UnwrapDataKey(wrapped)
+		} else {
+			key, err = cloud.UnwrapDataKey(ctx, r.Provider, wrapped)
+		}
+		if err == nil {
+			return key, nil
+		}
+		problems = append(problems, fmt.Sprintf("%s: %v", r.Provider, err))
+	}
+
+	if len(passphrases) > 0 {
+		key, err := passphrase.UnlockDataKey(func(phrase string) ([]byte, error) {
+			for _, wrapped := range passphrases {
+				key, err := passphrase.UnwrapDataKey(phrase, wrapped)
+				if !errors.Is(err, passphrase.ErrIncorrectPassphrase) {
+					return key, err
+				}
+			}
+			return nil, passphrase.ErrIncorrectPassphrase
+		})
+		if err == nil {
+			return key, nil
+		}
+		problems = append(problems, fmt.Sprintf("%s: %v", passphrase.Type, err))
+	}
+
+	return nil, fmt.Errorf("none of the stack's recipients can decrypt its data key:\n    %s",
+		strings.Join(problems, "\n    "))
+}
+
+// NewMultiSecretsManagerFromState deserializes configuration from state and returns a secrets manager whose data key
+// is unwrapped by whichever of its recipients can.
+func NewMultiSecretsManagerFromState(state json.RawMessage) (secrets.Manager, error) {
+	var s multiSecretsManagerState
+	if err := json.Unmarshal(state, &s); err != nil {
+		return nil, fmt.Errorf("unmarshalling state: %w", err)
+	}
+
+	key, err := unlockDataKey(context.Background(), s.Recipients)
+	if err != nil {
+		return nil, err
+	}
+	return newMultiSecretsManager(s.Recipients, key), nil
+}
+
+// NewMultiSecretsManager returns the secrets manager for a stack that uses the multi-recipient secrets provider.
+func NewMultiSecretsManager(info *workspace.ProjectStack) (secrets.Manager, error) {
+	if len(info.SecretsRecipients) == 0 {
+		return nil, errors.New("the stack uses the multi-recipient secrets provider, but has no recipients")
+	}
+
+	key, err := unlockDataKey(context.Background(), info.SecretsRecipients)
+	if err != nil {
+		return nil, err
+	}
+	return newMultiSecretsManager(info.SecretsRecipients, key), nil
+}
+
+// currentRecipients returns the data key of a stack and the recipients that it's wrapped for. A stack that uses a
+// passphrase, age or cloud secrets provider has a single recipient, which keeps the stack's data key so that its
+// secrets don't need to be encrypted again.
+func currentRecipients(
+	ctx context.Context, info *workspace.ProjectStack,
+) ([]byte, []workspace.SecretsRecipient, error) {
+	var key []byte
+	var err error
+	switch {
+	case info.SecretsProvider == Type:
+		key, err = unlockDataKey(ctx, info.SecretsRecipients)
+		return key, info.SecretsRecipients, err
+	case info.EncryptionSalt != "":
+		// The data key of a passphrase stack is derived from the passphrase, so it's wrapped with the same passphrase.
+		var phrase string
+		key, err = passphrase.UnlockDataKey(func(p string) ([]byte, error) {
+			phrase = p
+			return passphrase.DataKeyFromState(p, info.EncryptionSalt)
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		wrapped, err := passphrase.WrapDataKey(phrase, key)
+		if err != nil {
+			return nil, nil, err
+		}
+		return key, []workspace.SecretsRecipient{{Provider: passphrase.Type, EncryptedKey: wrapped}}, nil
+	case info.SecretsProvider != "" && info.SecretsProvider != "default" && info.EncryptedKey != "":
+		wrapped, err := base64.StdEncoding.DecodeString(info.EncryptedKey)
+		if err != nil {
+			return nil, nil, err
+		}
+		if age.IsAgeSecretsProvider(info.SecretsProvider) {
+			key, err = age.UnwrapDataKey(wrapped)
+		} else {
+			key, err = cloud.UnwrapDataKey(ctx, info.SecretsProvider, wrapped)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		recipient := workspace.SecretsRecipient{Provider: info.SecretsProvider, EncryptedKey: info.EncryptedKey}
+		return key, []workspace.SecretsRecipient{recipient}, nil
+	default:
+		return nil, nil, errors.New("the stack's secrets are encrypted by the Pulumi Cloud, which can't share them " +
+			"with other recipients; change the stack's secrets provider to passphrase, age or a cloud key first")
+	}
+}
+
+// recipientProviders returns the providers of a stack's recipients. The secrets provider of a stack that doesn't use
+// the multi-recipient secrets provider is its only recipient.
+func recipientProviders(info *workspace.ProjectStack) []string {
+	switch {
+	case info.SecretsProvider == Type:
+		providers := make([]string, len(info.SecretsRecipients))
+		for i, r := range info.SecretsRecipients {
+			providers[i] = r.Provider
+		}
+		return providers
+	case info.EncryptionSalt != "":
+		return []string{passphrase.Type}
+	default:
+		return []string{info.SecretsProvider}
+	}
+}
+
+// setRecipients switches a stack to the multi-recipient secrets provider with the given recipients.
+func setRecipients(info *workspace.ProjectStack, recipients []workspace.SecretsRecipient) {
+	info.SecretsProvider = Type
+	info.EncryptedKey = ""
+	info.EncryptionSalt = ""
+	info.SecretsRecipients = recipients
+}
+
+// AddRecipient wraps a stack's data key for another recipient, given as a secrets provider such as passphrase, an
+// age://age1... URL or the URL of a cloud key. A stack that doesn't use the multi-recipient secrets provider yet is
+// switched to it, with its current secrets provider as its first recipient, keeping its data key.
+func AddRecipient(ctx context.Context, info *workspace.ProjectStack, provider string) (secrets.Manager, error) {
+	for _, existing := range recipientProviders(info) {
+		if existing == provider {
+			if provider == passphrase.Type {
+				return nil, fmt.Errorf("'%s' is already a recipient of the stack; "+
+					"give other passphrases names, such as passphrase://alice", provider)
+			}
+			return nil, fmt.Errorf("'%s' is already a recipient of the stack", provider)
+		}
+	}
+
+	key, recipients, err := currentRecipients(ctx, info)
+	if err != nil {
+		return nil, err
+	}
+	wrapped, err := wrapDataKey(ctx, provider, key)
+	if err != nil {
+		return nil, err
+	}
+
+	recipients = append(append([]workspace.SecretsRecipient(nil), recipients...),
+		workspace.SecretsRecipient{Provider: provider, EncryptedKey: wrapped})
+	setRecipients(info, recipients)
+	return newMultiSecretsManager(recipients, key), nil
+}
+
+// RemoveRecipient removes one of the recipients that a stack's data key is wrapped for. The data key doesn't change,
+// and the copies of the stack's checkpoint in its history and backups still hold the key wrapped for the removed
+// recipient, so it can still decrypt the stack's secrets until its secrets provider is changed.
+func RemoveRecipient(ctx context.Context, info *workspace.ProjectStack, provider string) (secrets.Manager, error) {
+	if info.SecretsProvider != Type {
+		return nil, errors.New("the stack doesn't have recipients; add them with `pulumi stack secrets add-recipient`")
+	}
+
+	var recipients []workspace.SecretsRecipient
+	for _, r := range info.SecretsRecipients {
+		if r.Provider != provider {
+			recipients = append(recipients, r)
+		}
+	}
+	if len(recipients) == len(info.SecretsRecipients) {
+		return nil, fmt.Errorf("'%s' is not a recipient of the stack", provider)
+	}
+	if len(recipients) == 0 {
+		return nil, fmt.Errorf("'%s' is the stack's only recipient; "+
+			"use `pulumi stack change-secrets-provider` to change its secrets provider instead", provider)
+	}
+
+	key, err := unlockDataKey(ctx, info.SecretsRecipients)
+	if err != nil {
+		return nil, err
+	}
+	setRecipients(info, recipients)
+	return newMultiSecretsManager(recipients, key), nil
+}
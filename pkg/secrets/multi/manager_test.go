@@ -0,0 +1,166 @@
+// Copyright 2016-2023, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package multi
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	filippoage "filippo.io/age"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pulumi/pulumi/pkg/v3/secrets"
+	"github.com/pulumi/pulumi/pkg/v3/secrets/age"
+	"github.com/pulumi/pulumi/pkg/v3/secrets/passphrase"
+	"github.com/pulumi/pulumi/sdk/v3/go/common/workspace"
+)
+
+func generateIdentity(t *testing.T) *filippoage.X25519Identity {
+	id, err := filippoage.GenerateX25519Identity()
+	require.NoError(t, err)
+	return id
+}
+
+// useIdentity writes an age identity file and points PULUMI_AGE_IDENTITY at it.
+func useIdentity(t *testing.T, id *filippoage.X25519Identity) {
+	path := filepath.Join(t.TempDir(), "age")
+	require.NoError(t, os.WriteFile(path, []byte(id.String()+"\n"), 0o600))
+	t.Setenv(age.IdentityEnvVar, path)
+}
+
+func encrypt(t *testing.T, sm secrets.Manager, plaintext string) string {
+	enc, err := sm.Encrypter()
+	require.NoError(t, err)
+	ciphertext, err := enc.EncryptValue(context.Background(), plaintext)
+	require.NoError(t, err)
+	return ciphertext
+}
+
+func decrypt(t *testing.T, sm secrets.Manager, ciphertext string) string {
+	dec, err := sm.Decrypter()
+	require.NoError(t, err)
+	plaintext, err := dec.DecryptValue(context.Background(), ciphertext)
+	require.NoError(t, err)
+	return plaintext
+}
+
+//nolint:paralleltest // mutates environment variables
+func TestAddRecipient_age(t *testing.T) {
+	ctx := context.Background()
+	alice, bob := generateIdentity(t), generateIdentity(t)
+	aliceURL, bobURL := "age://"+alice.Recipient().String(), "age://"+bob.Recipient().String()
+
+	// The stack starts with a single age recipient.
+	ps := &workspace.ProjectStack{}
+	sm, err := age.NewAgeSecretsManager(ps, aliceURL, false /* rotateSecretsProvider */)
+	require.NoError(t, err)
+	ciphertext := encrypt(t, sm, "hunter2")
+	aliceKey := ps.EncryptedKey
+
+	// Adding bob keeps the data key, so the existing secret can still be decrypted, by either of them.
+	useIdentity(t, alice)
+	sm, err = AddRecipient(ctx, ps, bobURL)
+	require.NoError(t, err)
+	assert.Equal(t, Type, ps.SecretsProvider)
+	assert.Empty(t, ps.EncryptedKey)
+	require.Len(t, ps.SecretsRecipients, 2)
+	assert.Equal(t, workspace.SecretsRecipient{Provider: aliceURL, EncryptedKey: aliceKey}, ps.SecretsRecipients[0])
+	assert.Equal(t, bobURL, ps.SecretsRecipients[1].Provider)
+	assert.Equal(t, "hunter2", decrypt(t, sm, ciphertext))
+
+	clearCachedDataKeys()
+	useIdentity(t, bob)
+	sm, err = NewMultiSecretsManager(ps)
+	require.NoError(t, err)
+	assert.Equal(t, "hunter2", decrypt(t, sm, ciphertext))
+
+	// The state saved with a deployment is enough to rebuild the secrets manager.
+	clearCachedDataKeys()
+	state, err := json.Marshal(sm.State())
+	require.NoError(t, err)
+	sm, err = NewMultiSecretsManagerFromState(state)
+	require.NoError(t, err)
+	assert.Equal(t, "hunter2", decrypt(t, sm, ciphertext))
+
+	_, err = AddRecipient(ctx, ps, bobURL)
+	assert.EqualError(t, err, "'"+bobURL+"' is already a recipient of the stack")
+
+	// Once alice is removed, she can't unwrap the data key any more.
+	sm, err = RemoveRecipient(ctx, ps, aliceURL)
+	require.NoError(t, err)
+	assert.Equal(t, ps.SecretsRecipients, sm.(*Manager).Recipients())
+	require.Len(t, ps.SecretsRecipients, 1)
+
+	clearCachedDataKeys()
+	useIdentity(t, alice)
+	_, err = NewMultiSecretsManager(ps)
+	assert.ErrorContains(t, err, "none of the stack's recipients can decrypt its data key")
+
+	_, err = RemoveRecipient(ctx, ps, aliceURL)
+	assert.EqualError(t, err, "'"+aliceURL+"' is not a recipient of the stack")
+	_, err = RemoveRecipient(ctx, ps, bobURL)
+	assert.ErrorContains(t, err, "is the stack's only recipient")
+}
+
+//nolint:paralleltest // mutates environment variables
+func TestAddRecipient_passphrase(t *testing.T) {
+	ctx := context.Background()
+	alice := generateIdentity(t)
+	aliceURL := "age://" + alice.Recipient().String()
+
+	// The stack starts with a passphrase, whose derived key becomes the data key.
+	t.Setenv("PULUMI_CONFIG_PASSPHRASE", "password")
+	t.Setenv("PULUMI_CONFIG_PASSPHRASE_FILE", "")
+	ps := &workspace.ProjectStack{}
+	sm, err := passphrase.NewPromptingPassphraseSecretsManager(ps, false /* rotateSecretsProvider */)
+	require.NoError(t, err)
+	ciphertext := encrypt(t, sm, "hunter2")
+
+	_, err = AddRecipient(ctx, ps, passphrase.Type)
+	assert.ErrorContains(t, err, "give other passphrases names, such as passphrase://alice")
+
+	sm, err = AddRecipient(ctx, ps, aliceURL)
+	require.NoError(t, err)
+	assert.Empty(t, ps.EncryptionSalt)
+	require.Len(t, ps.SecretsRecipients, 2)
+	assert.Equal(t, passphrase.Type, ps.SecretsRecipients[0].Provider)
+	assert.Equal(t, "hunter2", decrypt(t, sm, ciphertext))
+
+	// The passphrase still decrypts the stack's secrets, without an age identity.
+	clearCachedDataKeys()
+	t.Setenv(age.IdentityEnvVar, filepath.Join(t.TempDir(), "missing"))
+	sm, err = NewMultiSecretsManager(ps)
+	require.NoError(t, err)
+	assert.Equal(t, "hunter2", decrypt(t, sm, ciphertext))
+
+	// As does alice's age identity, without the passphrase.
+	clearCachedDataKeys()
+	useIdentity(t, alice)
+	t.Setenv("PULUMI_CONFIG_PASSPHRASE", "password123")
+	sm, err = NewMultiSecretsManager(ps)
+	require.NoError(t, err)
+	assert.Equal(t, "hunter2", decrypt(t, sm, ciphertext))
+}
+
+func TestRemoveRecipient_noRecipients(t *testing.T) {
+	t.Parallel()
+
+	_, err := RemoveRecipient(context.Background(), &workspace.ProjectStack{EncryptionSalt: "v1:salt"}, "passphrase")
+	assert.ErrorContains(t, err, "the stack doesn't have recipients")
+}
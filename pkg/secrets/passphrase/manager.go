@@ -45,6 +45,16 @@ var ErrIncorrectPassphrase = errors.New("incorrect passphrase")
 // we support (`v1`) which is AES-256-GCM using a key derived from a passphrase using 1,000,000 iterations of PDKDF2
 // using SHA256.
 func symmetricCrypterFromPhraseAndState(phrase string, state string) (config.Crypter, error) {
+	key, err := DataKeyFromState(phrase, state)
+	if err != nil {
+		return nil, err
+	}
+	return config.NewSymmetricCrypter(key), nil
+}
+
+// DataKeyFromState returns the key that a passphrase and an encryption state, as stored in a stack's encryptionsalt,
+// derive for encrypting the stack's secrets.
+func DataKeyFromState(phrase string, state string) ([]byte, error) {
 	splits := strings.SplitN(state, ":", 3)
 	if len(splits) != 3 {
 		return nil, errors.New("malformed state value")
@@ -59,15 +69,82 @@ func symmetricCrypterFromPhraseAndState(phrase string, state string) (config.Cry
 		return nil, err
 	}
 
-	decrypter := config.NewSymmetricCrypterFromPassphrase(phrase, salt)
+	key := config.KeyFromPassphrase(phrase, salt)
 	// symmetricCrypter does not use ctx, safe to pass context.Background()
 	ignoredCtx := context.Background()
-	decrypted, err := decrypter.DecryptValue(ignoredCtx, state[indexN(state, ":", 2)+1:])
+	decrypted, err := config.NewSymmetricCrypter(key).DecryptValue(ignoredCtx, state[indexN(state, ":", 2)+1:])
 	if err != nil || decrypted != "pulumi" {
 		return nil, ErrIncorrectPassphrase
 	}
 
-	return decrypter, nil
+	return key, nil
+}
+
+// WrapDataKey encrypts a data key with a key derived from a passphrase, for a stack whose data key is wrapped
+// separately for each of several recipients. The result has the same form as the encryption state of a passphrase
+// stack, a version tag followed by the salt and the ciphertext.
+func WrapDataKey(phrase string, dataKey []byte) (string, error) {
+	salt := make([]byte, 8)
+	if _, err := cryptorand.Read(salt); err != nil {
+		return "", err
+	}
+
+	// symmetricCrypter does not use ctx, safe to use context.Background()
+	ignoredCtx := context.Background()
+	crypter := config.NewSymmetricCrypterFromPassphrase(phrase, salt)
+	msg, err := crypter.EncryptValue(ignoredCtx, base64.StdEncoding.EncodeToString(dataKey))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("v1:%s:%s", base64.StdEncoding.EncodeToString(salt), msg), nil
+}
+
+// UnwrapDataKey decrypts a data key encrypted by WrapDataKey. It returns ErrIncorrectPassphrase if the passphrase is
+// not the one the key was wrapped with.
+func UnwrapDataKey(phrase string, wrapped string) ([]byte, error) {
+	splits := strings.SplitN(wrapped, ":", 3)
+	if len(splits) != 3 || splits[0] != "v1" {
+		return nil, errors.New("malformed wrapped data key")
+	}
+	salt, err := base64.StdEncoding.DecodeString(splits[1])
+	if err != nil {
+		return nil, fmt.Errorf("malformed wrapped data key: %w", err)
+	}
+
+	// symmetricCrypter does not use ctx, safe to use context.Background()
+	ignoredCtx := context.Background()
+	decrypted, err := config.NewSymmetricCrypterFromPassphrase(phrase, salt).DecryptValue(ignoredCtx, splits[2])
+	if err != nil {
+		return nil, ErrIncorrectPassphrase
+	}
+	return base64.StdEncoding.DecodeString(decrypted)
+}
+
+// UnlockDataKey calls unlock with the passphrase found in PULUMI_CONFIG_PASSPHRASE, the file specified by
+// PULUMI_CONFIG_PASSPHRASE_FILE, or otherwise prompted for if interactive, and returns the data key that it unlocks.
+// If unlock returns ErrIncorrectPassphrase and the passphrase was prompted for, it's prompted for again.
+func UnlockDataKey(unlock func(phrase string) ([]byte, error)) ([]byte, error) {
+	const prompt = "Enter your passphrase to unlock config/secrets\n" +
+		"    (set PULUMI_CONFIG_PASSPHRASE or PULUMI_CONFIG_PASSPHRASE_FILE to remember)"
+	for {
+		phrase, interactive, phraseErr := readPassphrase(prompt, true /*useEnv*/)
+		if phraseErr != nil {
+			return nil, phraseErr
+		}
+
+		key, err := unlock(phrase)
+		if interactive && err == ErrIncorrectPassphrase {
+			cmdutil.Diag().Errorf(diag.Message("", "incorrect passphrase"))
+			continue
+		}
+		return key, err
+	}
+}
+
+// ReadNewPassphrase prompts for a new passphrase, and for it again to confirm it. If not interactive, it reads the
+// passphrase from a line of standard input instead.
+func ReadNewPassphrase() (string, error) {
+	return readNewPassphrase(true /*rotate*/)
 }
 
 func indexN(s string, substr string, n int) int {
@@ -225,9 +302,10 @@ func NewPromptingPassphraseSecretsManager(info *workspace.ProjectStack,
 	}
 
 	// If there are any other secrets providers set in the config, remove them, as the passphrase
-	// provider deals only with EncryptionSalt, not EncryptedKey or SecretsProvider.
+	// provider deals only with EncryptionSalt, not EncryptedKey, SecretsProvider or SecretsRecipients.
 	info.EncryptedKey = ""
 	info.SecretsProvider = ""
+	info.SecretsRecipients = nil
 
 	// If we have a salt, we can just use it.
 	if info.EncryptionSalt != "" {
@@ -249,6 +327,39 @@ func NewPromptingPassphraseSecretsManager(info *workspace.ProjectStack,
 
 // promptForNewPassphrase prompts for a new passphrase, and returns the state and the secrets manager.
 func promptForNewPassphrase(rotate bool) (string, secrets.Manager, error) {
+	phrase, err := readNewPassphrase(rotate)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// Produce a new salt.
+	salt := make([]byte, 8)
+	_, err = cryptorand.Read(salt)
+	contract.AssertNoErrorf(err, "could not read from system random")
+
+	// Encrypt a message and store it with the salt so we can test if the password is correct later.
+	crypter := config.NewSymmetricCrypterFromPassphrase(phrase, salt)
+
+	// symmetricCrypter does not use ctx, safe to use context.Background()
+	ignoredCtx := context.Background()
+	msg, err := crypter.EncryptValue(ignoredCtx, "pulumi")
+	contract.AssertNoErrorf(err, "could not encrypt message")
+
+	// Encode the salt as the passphrase secrets manager state.
+	state := fmt.Sprintf("v1:%s:%s", base64.StdEncoding.EncodeToString(salt), msg)
+
+	// Create the secrets manager using the state.
+	sm, err := NewPassphraseSecretsManager(phrase, state)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// Return both the state and the secrets manager.
+	return state, sm, nil
+}
+
+// readNewPassphrase prompts for a new passphrase, ensuring that it's entered the same way twice.
+func readNewPassphrase(rotate bool) (string, error) {
 	var phrase string
 
 	// Get a the passphrase from the user, ensuring that they match.
@@ -267,7 +378,7 @@ func promptForNewPassphrase(rotate bool) (string, secrets.Manager, error) {
 		// Here, the stack does not have an EncryptionSalt, so we will get a passphrase and create one
 		first, _, err := readPassphrase(firstMessage, !rotate)
 		if err != nil {
-			return "", nil, err
+			return "", err
 		}
 		secondMessage := "Re-enter your passphrase to confirm"
 		if rotate {
@@ -275,7 +386,7 @@ func promptForNewPassphrase(rotate bool) (string, secrets.Manager, error) {
 		}
 		second, _, err := readPassphrase(secondMessage, !rotate)
 		if err != nil {
-			return "", nil, err
+			return "", err
 		}
 
 		if first == second {
@@ -286,30 +397,7 @@ func promptForNewPassphrase(rotate bool) (string, secrets.Manager, error) {
 		cmdutil.Diag().Errorf(diag.Message("", "passphrases do not match"))
 	}
 
-	// Produce a new salt.
-	salt := make([]byte, 8)
-	_, err := cryptorand.Read(salt)
-	contract.AssertNoErrorf(err, "could not read from system random")
-
-	// Encrypt a message and store it with the salt so we can test if the password is correct later.
-	crypter := config.NewSymmetricCrypterFromPassphrase(phrase, salt)
-
-	// symmetricCrypter does not use ctx, safe to use context.Background()
-	ignoredCtx := context.Background()
-	msg, err := crypter.EncryptValue(ignoredCtx, "pulumi")
-	contract.AssertNoErrorf(err, "could not encrypt message")
-
-	// Encode the salt as the passphrase secrets manager state.
-	state := fmt.Sprintf("v1:%s:%s", base64.StdEncoding.EncodeToString(salt), msg)
-
-	// Create the secrets manager using the state.
-	sm, err := NewPassphraseSecretsManager(phrase, state)
-	if err != nil {
-		return "", nil, err
-	}
-
-	// Return both the state and the secrets manager.
-	return state, sm, nil
+	return phrase, nil
 }
 
 func readPassphrase(prompt string, useEnv bool) (phrase string, interactive bool, err error) {
@@ -6,6 +6,8 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+
+	"github.com/pulumi/pulumi/sdk/v3/go/common/resource/config"
 )
 
 const (
@@ -127,3 +129,47 @@ func TestPassphraseManagerEmptyPassfileReturnsError(t *testing.T) {
 	assert.NotNil(t, err, strings.Contains(err.Error(), "unable to find either `PULUMI_CONFIG_PASSPHRASE` nor "+
 		"`PULUMI_CONFIG_PASSPHRASE_FILE`"))
 }
+
+func TestWrapDataKey(t *testing.T) {
+	t.Parallel()
+
+	dataKey := make([]byte, 32)
+	for i := range dataKey {
+		dataKey[i] = byte(i)
+	}
+
+	wrapped, err := WrapDataKey("password", dataKey)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(wrapped, "v1:"))
+
+	unwrapped, err := UnwrapDataKey("password", wrapped)
+	assert.NoError(t, err)
+	assert.Equal(t, dataKey, unwrapped)
+
+	_, err = UnwrapDataKey("password123", wrapped)
+	assert.Equal(t, ErrIncorrectPassphrase, err)
+	_, err = UnwrapDataKey("password", "v2:nope")
+	assert.EqualError(t, err, "malformed wrapped data key")
+}
+
+//nolint:paralleltest // mutates environment variables
+func TestUnlockDataKey(t *testing.T) {
+	resetEnv := resetPassphraseTestEnvVars()
+	defer resetEnv()
+
+	os.Setenv("PULUMI_CONFIG_PASSPHRASE", "password")
+	os.Unsetenv("PULUMI_CONFIG_PASSPHRASE_FILE")
+
+	// The data key of a passphrase stack is the key that its passphrase derives.
+	salt := "v1:fozI5u6B030=:v1:F+6ZduKKd8G0/V7L:PGMFeIzwobWRKmEAzUdaQHqC5mMRIQ=="
+	key, err := UnlockDataKey(func(phrase string) ([]byte, error) { return DataKeyFromState(phrase, salt) })
+	assert.NoError(t, err)
+	crypter, err := symmetricCrypterFromPhraseAndState("password", salt)
+	assert.NoError(t, err)
+	assert.Equal(t, config.NewSymmetricCrypter(key), crypter)
+
+	// Without a prompt, an incorrect passphrase is an error.
+	os.Setenv("PULUMI_CONFIG_PASSPHRASE", "password123")
+	_, err = UnlockDataKey(func(phrase string) ([]byte, error) { return DataKeyFromState(phrase, salt) })
+	assert.Equal(t, ErrIncorrectPassphrase, err)
+}
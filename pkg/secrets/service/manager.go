@@ -138,6 +138,7 @@ func NewServiceSecretsManager(
 	info.EncryptionSalt = ""
 	info.SecretsProvider = ""
 	info.EncryptedKey = ""
+	info.SecretsRecipients = nil
 
 	return &serviceSecretsManager{
 		state: serviceSecretsManagerState{
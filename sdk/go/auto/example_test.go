@@ -25,6 +25,7 @@ import (
 	"path/filepath"
 
 	"github.com/pulumi/pulumi/sdk/v3/go/auto/optdestroy"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto/optoutput"
 	"github.com/pulumi/pulumi/sdk/v3/go/auto/optpreview"
 	"github.com/pulumi/pulumi/sdk/v3/go/auto/optrefresh"
 	"github.com/pulumi/pulumi/sdk/v3/go/auto/optup"
@@ -1065,6 +1066,15 @@ func ExampleStack_Outputs() {
 	fmt.Println(outs["key"].Secret)
 }
 
+func ExampleStack_FormatOutputs() {
+	ctx := context.Background()
+	stackName := FullyQualifiedStackName("org", "project", "stack")
+	stack, _ := SelectStackLocalSource(ctx, stackName, filepath.Join(".", "program"))
+	// write the outputs, including secrets, as the lines of a .env file
+	dotenv, _ := stack.FormatOutputs(ctx, OutputFormatDotenv, optoutput.ShowSecrets(true))
+	_ = os.WriteFile(".env", []byte(dotenv), 0o600)
+}
+
 func ExampleStack_RefreshConfig() {
 	ctx := context.Background()
 	stackName := FullyQualifiedStackName("org", "project", "stack")
@@ -36,6 +36,7 @@ import (
 	"github.com/pulumi/pulumi/sdk/v3/go/auto/debug"
 	"github.com/pulumi/pulumi/sdk/v3/go/auto/events"
 	"github.com/pulumi/pulumi/sdk/v3/go/auto/optdestroy"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto/optoutput"
 	"github.com/pulumi/pulumi/sdk/v3/go/auto/optpreview"
 	"github.com/pulumi/pulumi/sdk/v3/go/auto/optrefresh"
 	"github.com/pulumi/pulumi/sdk/v3/go/auto/optremove"
@@ -1952,6 +1953,40 @@ func TestSupportsStackOutputs(t *testing.T) {
 
 	assertOutputs(t, outputsAfterUp)
 
+	// -- pulumi stack output --format --
+	dotenv, err := s.FormatOutputs(ctx, OutputFormatDotenv)
+	if err != nil {
+		t.Errorf("failed to format outputs, err: %v", err)
+		t.FailNow()
+	}
+	assert.Equal(t, "exp_cfg=abc\n"+
+		"exp_secret='[secret]'\n"+
+		"exp_static=foo\n"+
+		"nested_obj='[secret]'\n", dotenv)
+
+	dotenv, err = s.FormatOutputs(ctx, OutputFormatDotenv, optoutput.ShowSecrets(true))
+	if err != nil {
+		t.Errorf("failed to format outputs with secrets, err: %v", err)
+		t.FailNow()
+	}
+	assert.Equal(t, "exp_cfg=abc\n"+
+		"exp_secret=secret\n"+
+		"exp_static=foo\n"+
+		"nested_obj_is_a_secret=iamsecret\n"+
+		"nested_obj_not_a_secret=foo\n", dotenv)
+
+	jsonOutputs, err := s.FormatOutputs(ctx, OutputFormatJSON)
+	if err != nil {
+		t.Errorf("failed to format outputs as json, err: %v", err)
+		t.FailNow()
+	}
+	assert.JSONEq(t, `{
+		"exp_cfg": "abc",
+		"exp_secret": "[secret]",
+		"exp_static": "foo",
+		"nested_obj": "[secret]"
+	}`, jsonOutputs)
+
 	// -- pulumi destroy --
 	dRes, err := s.Destroy(ctx)
 	if err != nil {
@@ -0,0 +1,44 @@
+// Copyright 2016-2023, Pulumi Corporation.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package optoutput contains functional options to be used with stack output operations
+// github.com/sdk/v3/go/x/auto Stack.FormatOutputs(ctx, format, ...optoutput.Option)
+package optoutput
+
+// ShowSecrets configures whether to show secret outputs in plaintext, rather than as [secret].
+func ShowSecrets(show bool) Option {
+	return optionFunc(func(opts *Options) {
+		opts.ShowSecrets = show
+	})
+}
+
+// ---------------------------------- implementation details ----------------------------------
+
+// Options is an implementation detail
+type Options struct {
+	// Show secret outputs in plaintext.
+	ShowSecrets bool
+}
+
+// Option is a parameter to be applied to a Stack.FormatOutputs() operation
+type Option interface {
+	ApplyOption(*Options)
+}
+
+type optionFunc func(*Options)
+
+// ApplyOption is an implementation detail
+func (o optionFunc) ApplyOption(opts *Options) {
+	o(opts)
+}
@@ -116,6 +116,7 @@ import (
 	"github.com/pulumi/pulumi/sdk/v3/go/auto/events"
 	"github.com/pulumi/pulumi/sdk/v3/go/auto/optdestroy"
 	"github.com/pulumi/pulumi/sdk/v3/go/auto/opthistory"
+	"github.com/pulumi/pulumi/sdk/v3/go/auto/optoutput"
 	"github.com/pulumi/pulumi/sdk/v3/go/auto/optpreview"
 	"github.com/pulumi/pulumi/sdk/v3/go/auto/optrefresh"
 	"github.com/pulumi/pulumi/sdk/v3/go/auto/optup"
@@ -622,6 +623,30 @@ func (s *Stack) Outputs(ctx context.Context) (OutputMap, error) {
 	return s.Workspace().StackOutputs(ctx, s.Name())
 }
 
+// FormatOutputs gets the current set of Stack outputs from the last Stack.Up(), formatted as with
+// `pulumi stack output --format`. Secret outputs are shown as [secret] unless optoutput.ShowSecrets(true) is passed.
+func (s *Stack) FormatOutputs(ctx context.Context, format OutputFormat, opts ...optoutput.Option) (string, error) {
+	var options optoutput.Options
+	for _, opt := range opts {
+		opt.ApplyOption(&options)
+	}
+	args := []string{"stack", "output", "--format", string(format)}
+	if options.ShowSecrets {
+		args = append(args, "--show-secrets")
+	}
+
+	stdout, stderr, errCode, err := s.runPulumiCmdSync(
+		ctx,
+		nil, /* additionalOutputs */
+		nil, /* additionalErrorOutputs */
+		args...,
+	)
+	if err != nil {
+		return "", newAutoError(fmt.Errorf("could not get outputs: %w", err), stdout, stderr, errCode)
+	}
+	return stdout, nil
+}
+
 // History returns a list summarizing all previous and current results from Stack lifecycle operations
 // (up/preview/refresh/destroy).
 func (s *Stack) History(ctx context.Context,
@@ -859,6 +884,24 @@ func GetPermalink(stdout string) (string, error) {
 // OutputMap is the output result of running a Pulumi program
 type OutputMap map[string]OutputValue
 
+// OutputFormat is a format in which Stack.FormatOutputs returns a stack's outputs.
+type OutputFormat string
+
+const (
+	// OutputFormatJSON formats outputs as a JSON object.
+	OutputFormatJSON OutputFormat = "json"
+	// OutputFormatYAML formats outputs as a YAML document.
+	OutputFormatYAML OutputFormat = "yaml"
+	// OutputFormatDotenv formats outputs as the lines of a .env file, flattening nested values.
+	OutputFormatDotenv OutputFormat = "dotenv"
+	// OutputFormatShell formats outputs as shell statements that export environment variables,
+	// flattening nested values. PowerShell statements are used on Windows.
+	OutputFormatShell OutputFormat = "shell"
+	// OutputFormatGitHubActions formats outputs as lines to append to the file named by $GITHUB_OUTPUT,
+	// flattening nested values.
+	OutputFormatGitHubActions OutputFormat = "github-actions"
+)
+
 // PreviewStep is a summary of the expected state transition of a given resource based on running the current program.
 type PreviewStep struct {
 	// Op is the kind of operation being performed.
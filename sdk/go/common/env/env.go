@@ -76,4 +76,30 @@ var (
 
 	SelfManagedStateLegacyLayout = env.Bool("SELF_MANAGED_STATE_LEGACY_LAYOUT",
 		"Uses the legacy layout for new buckets, which currently default to project-scoped stacks.")
+
+	SelfManagedStateLockLease = env.Int("SELF_MANAGED_STATE_LOCK_LEASE",
+		"The number of seconds a stack lock remains valid without being renewed by the process holding it, "+
+			"after which other processes may break it. Defaults to 300.")
+
+	SelfManagedStateJournal = env.Bool("SELF_MANAGED_STATE_JOURNAL",
+		"Persists the changes made by each step of an update to a journal instead of rewriting the whole "+
+			"checkpoint, compacting the journal into the checkpoint when the update completes.")
+
+	SelfManagedStateRESTUsername = env.String("SELF_MANAGED_STATE_REST_USERNAME",
+		"The username used for basic authentication with the server of a rest+http(s):// backend.")
+
+	SelfManagedStateRESTPassword = env.String("SELF_MANAGED_STATE_REST_PASSWORD",
+		"The password used for basic authentication with the server of a rest+http(s):// backend.")
+
+	SelfManagedStateRESTToken = env.String("SELF_MANAGED_STATE_REST_TOKEN",
+		"A bearer token used to authenticate with the server of a rest+http(s):// backend.")
+
+	SelfManagedStateRESTUpdateMethod = env.String("SELF_MANAGED_STATE_REST_UPDATE_METHOD",
+		"The HTTP method a rest+http(s):// backend uses to write objects. Defaults to PUT.")
+
+	SelfManagedStateRESTLockMethod = env.String("SELF_MANAGED_STATE_REST_LOCK_METHOD",
+		"The HTTP method a rest+http(s):// backend uses to lock stacks. Defaults to LOCK.")
+
+	SelfManagedStateRESTUnlockMethod = env.String("SELF_MANAGED_STATE_REST_UNLOCK_METHOD",
+		"The HTTP method a rest+http(s):// backend uses to unlock stacks. Defaults to UNLOCK.")
 )
@@ -156,10 +156,14 @@ func NewSymmetricCrypter(key []byte) Crypter {
 
 // NewSymmetricCrypterFromPassphrase uses a passphrase and salt to generate a key, and then returns a crypter using it.
 func NewSymmetricCrypterFromPassphrase(phrase string, salt []byte) Crypter {
+	return NewSymmetricCrypter(KeyFromPassphrase(phrase, salt))
+}
+
+// KeyFromPassphrase derives the key that NewSymmetricCrypterFromPassphrase uses from a passphrase and salt.
+func KeyFromPassphrase(phrase string, salt []byte) []byte {
 	// Generate a key using PBKDF2 to slow down attempts to crack it.  1,000,000 iterations was chosen because it
 	// took a little over a second on an i7-7700HQ Quad Core processor
-	key := pbkdf2.Key([]byte(phrase), salt, 1000000, SymmetricCrypterKeyBytes, sha256.New)
-	return NewSymmetricCrypter(key)
+	return pbkdf2.Key([]byte(phrase), salt, 1000000, SymmetricCrypterKeyBytes, sha256.New)
 }
 
 // SymmetricCrypterKeyBytes is the required key size in bytes.
@@ -7,6 +7,7 @@ import (
 	"strings"
 
 	"github.com/pulumi/pulumi/sdk/v3/go/common/resource/config"
+	"gopkg.in/yaml.v3"
 )
 
 func formatMissingKeys(missingKeys []string) string {
@@ -92,6 +93,10 @@ func DefaultStackConfigValidator(
 		return validationError
 	}
 
+	if err := ValidateConfigConstraints(projectConfigType, content); err != nil {
+		return fmt.Errorf("Stack '%v' with configuration key '%v' %v", stackName, projectConfigKey, err)
+	}
+
 	return nil
 }
 
@@ -107,6 +112,19 @@ func NoopStackConfigValidator(
 	return nil
 }
 
+// parseProjectConfigKey parses a key of the config declared in a project file, which is namespaced by the project
+// unless it says otherwise.
+func parseProjectConfigKey(projectName, projectConfigKey string) (config.Key, error) {
+	if strings.Contains(projectConfigKey, ":") {
+		// key is already namespaced
+		return config.ParseKey(projectConfigKey)
+	}
+
+	// key is not namespaced
+	// use the project as default namespace
+	return config.MustMakeKey(projectName, projectConfigKey), nil
+}
+
 func createConfigValue(rawValue interface{}) (config.Value, error) {
 	if isPrimitiveValue(rawValue) {
 		configValueContent := fmt.Sprintf("%v", rawValue)
@@ -134,19 +152,9 @@ func ValidateStackConfigAndMergeProjectConfig(
 	missingConfigurationKeys := make([]string, 0)
 	projectName := project.Name.String()
 	for projectConfigKey, projectConfigType := range project.Config {
-		var key config.Key
-		if strings.Contains(projectConfigKey, ":") {
-			// key is already namespaced
-			parsedKey, parseError := config.ParseKey(projectConfigKey)
-			if parseError != nil {
-				return parseError
-			}
-
-			key = parsedKey
-		} else {
-			// key is not namespaced
-			// use the project as default namespace
-			key = config.MustMakeKey(projectName, projectConfigKey)
+		key, err := parseProjectConfigKey(projectName, projectConfigKey)
+		if err != nil {
+			return err
 		}
 
 		stackValue, foundOnStack, err := stackConfig.Get(key, true)
@@ -240,3 +248,172 @@ func ApplyProjectConfig(stackName string, project *Project, stackConfig config.M
 	return ValidateStackConfigAndMergeProjectConfig(stackName, project, stackConfig,
 		emptyDecrypter, NoopStackConfigValidator)
 }
+
+// ConfigProblem is a problem with a stack's configuration, found by ValidateStackConfig.
+type ConfigProblem struct {
+	// Key is the configuration key that the problem is with.
+	Key string
+	// Warning is true if the problem doesn't stop the stack from being deployed.
+	Warning bool
+	// InProject is true if the problem is located at the key's declaration in the project file, rather than in the
+	// stack's config file.
+	InProject bool
+	// Line is the line of the problem in the file it's located in, or 0 if it isn't known.
+	Line int
+	// Message describes the problem.
+	Message string
+}
+
+// ValidateStackConfig checks a stack's configuration against the configuration declared by its project, like
+// ValidateStackConfigAndApplyProjectConfig does, but returns every problem it finds rather than failing on the first.
+// Keys in the project's namespace that the project doesn't declare are reported as warnings, as long as the project
+// declares any configuration at all.
+//
+// stackRaw is the content of the stack's config file, which is used to find the line each key is on, and may be nil.
+// Only the values of keys that declare a type are decrypted, and dec may be nil to skip checking secret values.
+func ValidateStackConfig(
+	project *Project,
+	stackConfig config.Map,
+	stackRaw []byte,
+	dec config.Decrypter,
+) ([]ConfigProblem, error) {
+	projectName := project.Name.String()
+	projectLines := configKeyLines(project.raw)
+	stackLines := configKeyLines(stackRaw)
+	stackLine := func(key config.Key) int {
+		return configKeyLine(stackLines, projectName, key)
+	}
+
+	projectConfigKeys := make([]string, 0, len(project.Config))
+	for projectConfigKey := range project.Config {
+		projectConfigKeys = append(projectConfigKeys, projectConfigKey)
+	}
+	sort.Strings(projectConfigKeys)
+
+	var problems []ConfigProblem
+	declared := make(map[config.Key]bool)
+	for _, projectConfigKey := range projectConfigKeys {
+		projectConfigType := project.Config[projectConfigKey]
+		key, err := parseProjectConfigKey(projectName, projectConfigKey)
+		if err != nil {
+			return nil, err
+		}
+		declared[key] = true
+
+		stackValue, foundOnStack, err := stackConfig.Get(key, true)
+		if err != nil {
+			return nil, fmt.Errorf("Error while getting stack config value for key '%v': %v", key.String(), err)
+		}
+		if !foundOnStack {
+			hasDefault := projectConfigType.Default != nil
+			hasValue := projectConfigType.Value != nil
+			if !hasDefault && !hasValue && key.Namespace() == projectName {
+				problems = append(problems, ConfigProblem{
+					Key:       key.String(),
+					InProject: true,
+					Line:      projectLines[projectConfigKey],
+					Message:   "is required, but the stack has no value for it",
+				})
+			}
+			continue
+		}
+
+		stackProblem := func(message string) {
+			problems = append(problems, ConfigProblem{
+				Key:     key.String(),
+				Line:    stackLine(key),
+				Message: message,
+			})
+		}
+
+		if projectConfigType.Secret && !stackValue.Secure() {
+			stackProblem("must be encrypted as it's secret; set it with `pulumi config set --secret`")
+		}
+
+		if !projectConfigType.IsExplicitlyTyped() || (stackValue.Secure() && dec == nil) {
+			continue
+		}
+
+		value, err := stackValue.Value(dec)
+		if err != nil {
+			return nil, fmt.Errorf("decrypting configuration key '%v': %w", key.String(), err)
+		}
+		// Content will be a JSON string if object is true, so marshal that back into an actual structure
+		var content interface{} = value
+		if stackValue.Object() {
+			if err = json.Unmarshal([]byte(value), &content); err != nil {
+				return nil, err
+			}
+		}
+
+		if !ValidateConfigValue(*projectConfigType.Type, projectConfigType.Items, content) {
+			typeName := InferFullTypeName(*projectConfigType.Type, projectConfigType.Items)
+			stackProblem(fmt.Sprintf("must be of type '%v'", typeName))
+			continue
+		}
+		if err := ValidateConfigConstraints(projectConfigType, content); err != nil {
+			stackProblem(err.Error())
+		}
+	}
+
+	if len(project.Config) > 0 {
+		stackKeys := make([]config.Key, 0, len(stackConfig))
+		for key := range stackConfig {
+			stackKeys = append(stackKeys, key)
+		}
+		sort.Slice(stackKeys, func(i, j int) bool { return stackKeys[i].String() < stackKeys[j].String() })
+
+		for _, key := range stackKeys {
+			if key.Namespace() == projectName && !declared[key] {
+				problems = append(problems, ConfigProblem{
+					Key:     key.String(),
+					Warning: true,
+					Line:    stackLine(key),
+					Message: "is not declared in the project's configuration",
+				})
+			}
+		}
+	}
+
+	return problems, nil
+}
+
+// ConfigKeyLine returns the line that a key is on in the top-level "config" map of a YAML or JSON document, such as a
+// stack's config file, or 0 if it isn't there.
+func ConfigKeyLine(raw []byte, projectName string, key config.Key) int {
+	return configKeyLine(configKeyLines(raw), projectName, key)
+}
+
+// configKeyLine looks up the line of a key in the result of configKeyLines. Keys in the project's namespace may be
+// written without it.
+func configKeyLine(lines map[string]int, projectName string, key config.Key) int {
+	if line, has := lines[key.String()]; has || key.Namespace() != projectName {
+		return line
+	}
+	return lines[key.Name()]
+}
+
+// configKeyLines returns the line that each key of the top-level "config" map of a YAML or JSON document is on. The
+// result is empty if the document can't be parsed.
+func configKeyLines(raw []byte) map[string]int {
+	lines := make(map[string]int)
+
+	var doc yaml.Node
+	if len(raw) == 0 || yaml.Unmarshal(raw, &doc) != nil || len(doc.Content) == 0 {
+		return lines
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return lines
+	}
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value != "config" || root.Content[i+1].Kind != yaml.MappingNode {
+			continue
+		}
+		configNode := root.Content[i+1]
+		for j := 0; j+1 < len(configNode.Content); j += 2 {
+			lines[configNode.Content[j].Value] = configNode.Content[j].Line
+		}
+	}
+	return lines
+}
@@ -17,6 +17,7 @@ package workspace
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 
 	"github.com/pulumi/pulumi/sdk/v3/go/common/resource/config"
@@ -202,6 +203,49 @@ func LoadProjectStack(project *Project, path string) (*ProjectStack, error) {
 	return &projectStack, nil
 }
 
+// ConfigLayer is a shared config file that a stack imports.
+type ConfigLayer struct {
+	// Import is the path of the file as the stack's imports give it.
+	Import string
+	// Path is the path of the file, resolved against the directory of the stack's config file.
+	Path string
+	// Stack holds the secrets provider and values of the file, which has the same shape as a stack's config file.
+	Stack *ProjectStack
+}
+
+// ConfigLayerPath resolves the path of a shared config file that a stack imports against the directory of the stack's
+// config file.
+func ConfigLayerPath(stackPath, imp string) string {
+	if filepath.IsAbs(imp) {
+		return imp
+	}
+	return filepath.Join(filepath.Dir(stackPath), imp)
+}
+
+// LoadConfigLayers reads the shared config files that a stack imports, in the order that it imports them.
+func LoadConfigLayers(project *Project, stackPath string, ps *ProjectStack) ([]ConfigLayer, error) {
+	layers := make([]ConfigLayer, 0, len(ps.Imports))
+	for _, imp := range ps.Imports {
+		path := ConfigLayerPath(stackPath, imp)
+		if _, err := os.Stat(path); err != nil {
+			if os.IsNotExist(err) {
+				return nil, fmt.Errorf("the shared config file '%s' does not exist", imp)
+			}
+			return nil, err
+		}
+
+		layer, err := LoadProjectStack(project, path)
+		if err != nil {
+			return nil, fmt.Errorf("could not read the shared config file '%s': %w", imp, err)
+		}
+		if len(layer.Imports) > 0 {
+			return nil, fmt.Errorf("the shared config file '%s' imports other files, but only stacks can import files", imp)
+		}
+		layers = append(layers, ConfigLayer{Import: imp, Path: path, Stack: layer})
+	}
+	return layers, nil
+}
+
 // LoadPluginProject reads a plugin project definition from a file.
 func LoadPluginProject(path string) (*PluginProject, error) {
 	contract.Requiref(path != "", "path", "must not be empty")
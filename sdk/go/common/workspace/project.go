@@ -23,6 +23,7 @@ import (
 	"math"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
 
@@ -117,6 +118,15 @@ type ProjectConfigType struct {
 	Default     interface{}             `json:"default,omitempty" yaml:"default,omitempty"`
 	Value       interface{}             `json:"value,omitempty" yaml:"value,omitempty"`
 	Secret      bool                    `json:"secret,omitempty" yaml:"secret,omitempty"`
+
+	// Enum, if set, is the list of values that the config value may take.
+	Enum []interface{} `json:"enum,omitempty" yaml:"enum,omitempty"`
+	// Pattern, if set, is a regular expression that string config values must match.
+	Pattern string `json:"pattern,omitempty" yaml:"pattern,omitempty"`
+	// Minimum, if set, is the smallest value that integer config values may take.
+	Minimum *float64 `json:"minimum,omitempty" yaml:"minimum,omitempty"`
+	// Maximum, if set, is the largest value that integer config values may take.
+	Maximum *float64 `json:"maximum,omitempty" yaml:"maximum,omitempty"`
 }
 
 // IsExplicitlyTyped returns whether the project config type is explicitly typed.
@@ -134,6 +144,12 @@ func (configType *ProjectConfigType) TypeName() string {
 	return ""
 }
 
+// HasConstraints returns whether the project config type constrains values beyond their type.
+func (configType *ProjectConfigType) HasConstraints() bool {
+	return len(configType.Enum) > 0 || configType.Pattern != "" ||
+		configType.Minimum != nil || configType.Maximum != nil
+}
+
 // Project is a Pulumi project manifest.
 //
 // We explicitly add yaml tags (instead of using the default behavior from https://github.com/ghodss/yaml which works
@@ -440,6 +456,67 @@ func ValidateConfigValue(typeName string, itemsType *ProjectConfigItemsType, val
 	return true
 }
 
+// ValidateConfigConstraints validates a config value, which is already known to be of the config type's type, against
+// the config type's enum, pattern, minimum and maximum constraints. The error describes the constraint that the value
+// doesn't satisfy, and is meant to follow the name of the config key, as in "'size' must be one of ...".
+func ValidateConfigConstraints(configType ProjectConfigType, value interface{}) error {
+	if len(configType.Enum) > 0 {
+		found := false
+		for _, allowed := range configType.Enum {
+			// Stack config values are strings unless they're objects, so we compare the text of the values, which
+			// lets the string "1" match the integer 1.
+			if fmt.Sprintf("%v", allowed) == fmt.Sprintf("%v", value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			allowed := make([]string, len(configType.Enum))
+			for i, v := range configType.Enum {
+				allowed[i] = fmt.Sprintf("'%v'", v)
+			}
+			return fmt.Errorf("must be one of %s", strings.Join(allowed, ", "))
+		}
+	}
+
+	if configType.Pattern != "" {
+		pattern, err := regexp.Compile(configType.Pattern)
+		if err != nil {
+			return fmt.Errorf("has an invalid pattern '%v': %w", configType.Pattern, err)
+		}
+		if s, ok := value.(string); ok && !pattern.MatchString(s) {
+			return fmt.Errorf("must match the pattern '%v'", configType.Pattern)
+		}
+	}
+
+	if configType.Minimum != nil || configType.Maximum != nil {
+		var number float64
+		switch v := value.(type) {
+		case int:
+			number = float64(v)
+		case float64:
+			number = v
+		case string:
+			n, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return errors.New("must be a number")
+			}
+			number = n
+		default:
+			return errors.New("must be a number")
+		}
+
+		if configType.Minimum != nil && number < *configType.Minimum {
+			return fmt.Errorf("must be at least %v", *configType.Minimum)
+		}
+		if configType.Maximum != nil && number > *configType.Maximum {
+			return fmt.Errorf("must be at most %v", *configType.Maximum)
+		}
+	}
+
+	return nil
+}
+
 func configKeyIsNamespacedByProject(projectName string, configKey string) bool {
 	return !strings.Contains(configKey, ":") || strings.HasPrefix(configKey, projectName+":")
 }
@@ -467,6 +544,12 @@ func (proj *Project) Validate() error {
 					"but does not specify the underlying type via the 'items' attribute", configKey)
 			}
 
+			if configType.HasConstraints() {
+				if err := validateConfigTypeConstraints(configKey, configType); err != nil {
+					return err
+				}
+			}
+
 			// when we have a config _type_ with a schema
 			if configType.IsExplicitlyTyped() && configType.Default != nil {
 				if !ValidateConfigValue(configTypeName, configType.Items, configType.Default) {
@@ -475,6 +558,10 @@ func (proj *Project) Validate() error {
 						configKey,
 						inferredTypeName)
 				}
+
+				if err := ValidateConfigConstraints(configType, configType.Default); err != nil {
+					return fmt.Errorf("The default value specified for configuration key '%v' %v", configKey, err)
+				}
 			}
 
 		} else {
@@ -484,6 +571,11 @@ func (proj *Project) Validate() error {
 					configKey)
 			}
 
+			if configType.HasConstraints() {
+				return fmt.Errorf("Configuration key '%v' is not namespaced by the project and should not define "+
+					"constraints", configKey)
+			}
+
 			// default values are part of a type schema
 			// when not namespaced by project, there is no type schema, only a value
 			if configType.Default != nil {
@@ -502,6 +594,31 @@ func (proj *Project) Validate() error {
 	return nil
 }
 
+// validateConfigTypeConstraints checks that the constraints of a config type make sense for its type.
+func validateConfigTypeConstraints(configKey string, configType ProjectConfigType) error {
+	typeName := configType.TypeName()
+	if typeName == "" {
+		return fmt.Errorf("The configuration key '%v' declares constraints but does not specify its type "+
+			"via the 'type' attribute", configKey)
+	}
+	if len(configType.Enum) > 0 && typeName == arrayTypeName {
+		return fmt.Errorf("The configuration key '%v' is an array and cannot declare 'enum' values", configKey)
+	}
+	if configType.Pattern != "" {
+		if typeName != stringTypeName {
+			return fmt.Errorf("The configuration key '%v' is not a string and cannot declare a 'pattern'", configKey)
+		}
+		if _, err := regexp.Compile(configType.Pattern); err != nil {
+			return fmt.Errorf("The configuration key '%v' declares an invalid pattern: %w", configKey, err)
+		}
+	}
+	if (configType.Minimum != nil || configType.Maximum != nil) && typeName != integerTypeName {
+		return fmt.Errorf("The configuration key '%v' is not an integer and cannot declare a 'minimum' or 'maximum'",
+			configKey)
+	}
+	return nil
+}
+
 // TrustResourceDependencies returns whether this project's runtime can be trusted to accurately report
 // dependencies. All languages supported by Pulumi today do this correctly. This option remains useful when bringing
 // up new Pulumi languages.
@@ -583,6 +700,12 @@ type ProjectStack struct {
 	// EncryptionSalt is this stack's base64 encoded encryption salt.  Only used for
 	// passphrase-based secrets providers.
 	EncryptionSalt string `json:"encryptionsalt,omitempty" yaml:"encryptionsalt,omitempty"`
+	// SecretsRecipients are the recipients that this stack's data key is wrapped for, each of which can decrypt it on
+	// its own. Only used for the multi-recipient secrets provider.
+	SecretsRecipients []SecretsRecipient `json:"secretsrecipients,omitempty" yaml:"secretsrecipients,omitempty"`
+	// Imports are the paths of shared config files whose values are merged, in order, under the stack's own values.
+	// Relative paths are relative to the directory of the stack's config file.
+	Imports []string `json:"imports,omitempty" yaml:"imports,omitempty"`
 	// Config is an optional config bag.
 	Config config.Map `json:"config,omitempty" yaml:"config,omitempty"`
 
@@ -590,6 +713,15 @@ type ProjectStack struct {
 	raw []byte
 }
 
+// SecretsRecipient is one of the recipients that a stack's data key is wrapped for.
+type SecretsRecipient struct {
+	// Provider is the secrets provider that wraps the data key for this recipient, such as `passphrase` or the URL of
+	// an age recipient or a cloud key.
+	Provider string `json:"provider" yaml:"provider"`
+	// EncryptedKey is the data key, wrapped by the provider.
+	EncryptedKey string `json:"encryptedkey" yaml:"encryptedkey"`
+}
+
 func (ps ProjectStack) RawValue() []byte {
 	return ps.raw
 }
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/pulumi/pulumi/sdk/v3/go/common/resource/config"
@@ -871,6 +872,272 @@ config:
 		"Stack 'dev' with configuration key 'importantNumber' must be encrypted as it's secret")
 }
 
+func TestProjectConfigConstraintsAreValidated(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		desc    string
+		config  string
+		wantErr string
+	}{
+		{
+			desc: "valid",
+			config: `
+  size:
+    type: string
+    enum: [small, large]
+    default: small
+  name:
+    type: string
+    pattern: ^[a-z]+$
+  count:
+    type: integer
+    minimum: 1
+    maximum: 10
+    default: 3`,
+		},
+		{
+			desc: "pattern on an integer",
+			config: `
+  count:
+    type: integer
+    pattern: ^[0-9]+$`,
+			wantErr: "The configuration key 'count' is not a string and cannot declare a 'pattern'",
+		},
+		{
+			desc: "invalid pattern",
+			config: `
+  name:
+    type: string
+    pattern: "[a-z"`,
+			wantErr: "The configuration key 'name' declares an invalid pattern",
+		},
+		{
+			desc: "minimum on a string",
+			config: `
+  name:
+    type: string
+    minimum: 1`,
+			wantErr: "The configuration key 'name' is not an integer and cannot declare a 'minimum' or 'maximum'",
+		},
+		{
+			desc: "default not in enum",
+			config: `
+  size:
+    type: string
+    enum: [small, large]
+    default: medium`,
+			wantErr: "The default value specified for configuration key 'size' must be one of 'small', 'large'",
+		},
+		{
+			desc: "default above maximum",
+			config: `
+  count:
+    type: integer
+    maximum: 10
+    default: 11`,
+			wantErr: "The default value specified for configuration key 'count' must be at most 10",
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.desc, func(t *testing.T) {
+			t.Parallel()
+
+			project, err := loadProjectFromText(t, "name: test\nruntime: dotnet\nconfig:"+tt.config)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				assert.NotNil(t, project)
+			} else {
+				assert.ErrorContains(t, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestStackConfigConstraintsAreValidated(t *testing.T) {
+	t.Parallel()
+	projectYaml := `
+name: test
+runtime: dotnet
+config:
+  size:
+    type: string
+    enum: [small, large]
+  name:
+    type: string
+    pattern: ^[a-z]+$
+  count:
+    type: integer
+    minimum: 1
+    maximum: 10
+  retries:
+    type: integer
+    enum: [1, 3]`
+
+	project, projectError := loadProjectFromText(t, projectYaml)
+	require.NoError(t, projectError, "Should be able to load the project")
+
+	tests := []struct {
+		stackConfig string
+		wantErr     string
+	}{
+		{
+			stackConfig: "{test:size: large, test:name: web, test:count: 10, test:retries: 3}",
+		},
+		{
+			stackConfig: "{test:size: medium, test:name: web, test:count: 10, test:retries: 3}",
+			wantErr:     "Stack 'dev' with configuration key 'size' must be one of 'small', 'large'",
+		},
+		{
+			stackConfig: "{test:size: large, test:name: Web1, test:count: 10, test:retries: 3}",
+			wantErr:     "Stack 'dev' with configuration key 'name' must match the pattern '^[a-z]+$'",
+		},
+		{
+			stackConfig: "{test:size: large, test:name: web, test:count: 0, test:retries: 3}",
+			wantErr:     "Stack 'dev' with configuration key 'count' must be at least 1",
+		},
+		{
+			stackConfig: "{test:size: large, test:name: web, test:count: 10, test:retries: 2}",
+			wantErr:     "Stack 'dev' with configuration key 'retries' must be one of '1', '3'",
+		},
+	}
+
+	for _, tt := range tests {
+		stack, stackError := loadProjectStackFromText(t, project, "config: "+tt.stackConfig)
+		require.NoError(t, stackError, "Should be able to read the stack")
+		configError := ValidateStackConfigAndApplyProjectConfig("dev", project, stack.Config, config.NewPanicCrypter())
+		if tt.wantErr == "" {
+			assert.NoError(t, configError)
+		} else {
+			assert.EqualError(t, configError, tt.wantErr)
+		}
+	}
+}
+
+func TestValidateStackConfig(t *testing.T) {
+	t.Parallel()
+	projectYaml := `name: test
+runtime: dotnet
+config:
+  size:
+    type: string
+    enum: [small, large]
+  count:
+    type: integer
+  region:
+    type: string
+  password:
+    secret: true
+  aws:region:
+    value: us-west-2
+`
+
+	crypter := config.Base64Crypter
+	encryptedValue, err := crypter.EncryptValue(context.Background(), "huge")
+	require.NoError(t, err)
+
+	stackYaml := fmt.Sprintf(`config:
+  test:count: many
+  test:password: hunter2
+  test:size:
+    secure: %s
+  test:typo: 1
+  aws:profile: dev
+`, encryptedValue)
+
+	project, projectError := loadProjectFromText(t, projectYaml)
+	require.NoError(t, projectError, "Should be able to load the project")
+	stack, stackError := loadProjectStackFromText(t, project, stackYaml)
+	require.NoError(t, stackError, "Should be able to read the stack")
+
+	problems, err := ValidateStackConfig(project, stack.Config, stack.RawValue(), crypter)
+	require.NoError(t, err)
+	assert.Equal(t, []ConfigProblem{
+		{Key: "test:count", Line: 2, Message: "must be of type 'integer'"},
+		{
+			Key:     "test:password",
+			Line:    3,
+			Message: "must be encrypted as it's secret; set it with `pulumi config set --secret`",
+		},
+		{Key: "test:region", InProject: true, Line: 9, Message: "is required, but the stack has no value for it"},
+		{Key: "test:size", Line: 4, Message: "must be one of 'small', 'large'"},
+		{Key: "test:typo", Warning: true, Line: 6, Message: "is not declared in the project's configuration"},
+	}, problems)
+
+	// Without a decrypter, secret values aren't checked.
+	problems, err = ValidateStackConfig(project, stack.Config, nil, nil)
+	require.NoError(t, err)
+	assert.Len(t, problems, 4)
+	for _, p := range problems {
+		assert.NotEqual(t, "test:size", p.Key)
+		if !p.InProject {
+			assert.Zero(t, p.Line)
+		}
+	}
+}
+
+func TestLoadConfigLayers(t *testing.T) {
+	t.Parallel()
+
+	project, err := loadProjectFromText(t, "name: test\nruntime: dotnet\n")
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	writeFile := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o700))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+		return path
+	}
+	commonPath := writeFile("config/common.yaml", "config:\n  region: us-east-1\n  aws:profile: shared\n")
+	regionPath := writeFile("config/us-east.yaml", "secretsprovider: awskms://alias/shared\nconfig:\n  zone: a\n")
+	stackPath := writeFile("Pulumi.dev.yaml", "imports:\n  - config/common.yaml\n  - "+regionPath+"\n")
+
+	ps, err := LoadProjectStack(project, stackPath)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"config/common.yaml", regionPath}, ps.Imports)
+
+	layers, err := LoadConfigLayers(project, stackPath, ps)
+	require.NoError(t, err)
+	require.Len(t, layers, 2)
+	assert.Equal(t, "config/common.yaml", layers[0].Import)
+	assert.Equal(t, commonPath, layers[0].Path)
+	assert.Equal(t, config.Map{
+		config.MustMakeKey("test", "region"): config.NewValue("us-east-1"),
+		config.MustMakeKey("aws", "profile"): config.NewValue("shared"),
+	}, layers[0].Stack.Config)
+	assert.Equal(t, regionPath, layers[1].Path)
+	assert.Equal(t, "awskms://alias/shared", layers[1].Stack.SecretsProvider)
+
+	ps.Imports = []string{"config/missing.yaml"}
+	_, err = LoadConfigLayers(project, stackPath, ps)
+	assert.EqualError(t, err, "the shared config file 'config/missing.yaml' does not exist")
+
+	writeFile("config/nested.yaml", "imports:\n  - common.yaml\n")
+	ps.Imports = []string{"config/nested.yaml"}
+	_, err = LoadConfigLayers(project, stackPath, ps)
+	assert.EqualError(t, err,
+		"the shared config file 'config/nested.yaml' imports other files, but only stacks can import files")
+}
+
+func TestConfigKeyLine(t *testing.T) {
+	t.Parallel()
+
+	raw := []byte(`secretsprovider: passphrase
+config:
+  region: us-east-1
+  test:size: small
+  aws:region: us-west-2
+`)
+	assert.Equal(t, 3, ConfigKeyLine(raw, "test", config.MustMakeKey("test", "region")))
+	assert.Equal(t, 4, ConfigKeyLine(raw, "test", config.MustMakeKey("test", "size")))
+	assert.Equal(t, 5, ConfigKeyLine(raw, "test", config.MustMakeKey("aws", "region")))
+	assert.Equal(t, 0, ConfigKeyLine(raw, "test", config.MustMakeKey("aws", "size")))
+	assert.Equal(t, 0, ConfigKeyLine(nil, "test", config.MustMakeKey("test", "size")))
+}
+
 func TestProjectLoadYAML(t *testing.T) {
 	t.Parallel()
 